@@ -100,7 +100,8 @@ class EntryClient:
             self._utf_out.write(resp_msg.content.decode('utf-8', 'replace'))
             self._utf_out.flush()
         elif (resp_msg.msgType == message_pb2.ResponseMessage.STDERR
-              or resp_msg.msgType == message_pb2.ResponseMessage.CLOSE):
+              or resp_msg.msgType == message_pb2.ResponseMessage.CLOSE
+              or resp_msg.msgType == message_pb2.ResponseMessage.NOTICE):
             self._utf_err.write(resp_msg.content.decode('utf-8', 'replace'))
             self._utf_err.flush()
         return is_close
@@ -19,7 +19,7 @@ DESCRIPTOR = _descriptor.FileDescriptor(
   name='message.proto',
   package='message',
   syntax='proto3',
-  serialized_pb=_b('\n\rmessage.proto\x12\x07message\"|\n\x0eRequestMessage\x12\x34\n\x07msgType\x18\x01 \x01(\x0e\x32#.message.RequestMessage.RequestType\x12\x0f\n\x07\x63ontent\x18\x02 \x01(\x0c\"#\n\x0bRequestType\x12\t\n\x05PLAIN\x10\x00\x12\t\n\x05WINCH\x10\x01\"\x97\x01\n\x0fResponseMessage\x12\x36\n\x07msgType\x18\x01 \x01(\x0e\x32%.message.ResponseMessage.ResponseType\x12\x0f\n\x07\x63ontent\x18\x02 \x01(\x0c\";\n\x0cResponseType\x12\n\n\x06STDOUT\x10\x00\x12\n\n\x06STDERR\x10\x01\x12\t\n\x05\x43LOSE\x10\x02\x12\x08\n\x04PING\x10\x03\x62\x06proto3')
+  serialized_pb=_b('\n\rmessage.proto\x12\x07message\"\xdd\x01\n\x0eRequestMessage\x12\x34\n\x07msgType\x18\x01 \x01(\x0e\x32#.message.RequestMessage.RequestType\x12\x0f\n\x07\x63ontent\x18\x02 \x01(\x0c\x12\x0b\n\x03tab\x18\x03 \x01(\r\"w\n\x0bRequestType\x12\t\n\x05PLAIN\x10\x00\x12\t\n\x05WINCH\x10\x01\x12\n\n\x06SWITCH\x10\x02\x12\x0b\n\x07\x43ONTROL\x10\x03\x12\x08\n\x04QUIT\x10\x04\x12\x08\n\x04PONG\x10\x05\x12\x08\n\x04\x43\x41PS\x10\x06\x12\x0c\n\x08OPEN_TAB\x10\x07\x12\r\n\tCLOSE_TAB\x10\x08\"\x85\x02\n\x0fResponseMessage\x12\x36\n\x07msgType\x18\x01 \x01(\x0e\x32%.message.ResponseMessage.ResponseType\x12\x0f\n\x07\x63ontent\x18\x02 \x01(\x0c\x12\x11\n\ttimestamp\x18\x03 \x01(\t\x12\x0e\n\x06reason\x18\x04 \x01(\t\x12\x10\n\x08\x65xitCode\x18\x05 \x01(\x05\x12\x0b\n\x03tab\x18\x06 \x01(\r\"g\n\x0cResponseType\x12\n\n\x06STDOUT\x10\x00\x12\n\n\x06STDERR\x10\x01\x12\t\n\x05\x43LOSE\x10\x02\x12\x08\n\x04PING\x10\x03\x12\n\n\x06NOTICE\x10\x04\x12\x0b\n\x07\x43ONTROL\x10\x05\x12\x07\n\x03RTT\x10\x06\x12\x08\n\x04\x43\x41PS\x10\x07\x62\x06proto3')
 )
 _sym_db.RegisterFileDescriptor(DESCRIPTOR)
 
@@ -39,11 +39,39 @@ _REQUESTMESSAGE_REQUESTTYPE = _descriptor.EnumDescriptor(
       name='WINCH', index=1, number=1,
       options=None,
       type=None),
+    _descriptor.EnumValueDescriptor(
+      name='SWITCH', index=2, number=2,
+      options=None,
+      type=None),
+    _descriptor.EnumValueDescriptor(
+      name='CONTROL', index=3, number=3,
+      options=None,
+      type=None),
+    _descriptor.EnumValueDescriptor(
+      name='QUIT', index=4, number=4,
+      options=None,
+      type=None),
+    _descriptor.EnumValueDescriptor(
+      name='PONG', index=5, number=5,
+      options=None,
+      type=None),
+    _descriptor.EnumValueDescriptor(
+      name='CAPS', index=6, number=6,
+      options=None,
+      type=None),
+    _descriptor.EnumValueDescriptor(
+      name='OPEN_TAB', index=7, number=7,
+      options=None,
+      type=None),
+    _descriptor.EnumValueDescriptor(
+      name='CLOSE_TAB', index=8, number=8,
+      options=None,
+      type=None),
   ],
   containing_type=None,
   options=None,
-  serialized_start=115,
-  serialized_end=150,
+  serialized_start=129,
+  serialized_end=248,
 )
 _sym_db.RegisterEnumDescriptor(_REQUESTMESSAGE_REQUESTTYPE)
 
@@ -69,11 +97,27 @@ _RESPONSEMESSAGE_RESPONSETYPE = _descriptor.EnumDescriptor(
       name='PING', index=3, number=3,
       options=None,
       type=None),
+    _descriptor.EnumValueDescriptor(
+      name='NOTICE', index=4, number=4,
+      options=None,
+      type=None),
+    _descriptor.EnumValueDescriptor(
+      name='CONTROL', index=5, number=5,
+      options=None,
+      type=None),
+    _descriptor.EnumValueDescriptor(
+      name='RTT', index=6, number=6,
+      options=None,
+      type=None),
+    _descriptor.EnumValueDescriptor(
+      name='CAPS', index=7, number=7,
+      options=None,
+      type=None),
   ],
   containing_type=None,
   options=None,
-  serialized_start=245,
-  serialized_end=304,
+  serialized_start=409,
+  serialized_end=512,
 )
 _sym_db.RegisterEnumDescriptor(_RESPONSEMESSAGE_RESPONSETYPE)
 
@@ -99,6 +143,13 @@ _REQUESTMESSAGE = _descriptor.Descriptor(
       message_type=None, enum_type=None, containing_type=None,
       is_extension=False, extension_scope=None,
       options=None),
+    _descriptor.FieldDescriptor(
+      name='tab', full_name='message.RequestMessage.tab', index=2,
+      number=3, type=13, cpp_type=3, label=1,
+      has_default_value=False, default_value=0,
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      options=None),
   ],
   extensions=[
   ],
@@ -112,8 +163,8 @@ _REQUESTMESSAGE = _descriptor.Descriptor(
   extension_ranges=[],
   oneofs=[
   ],
-  serialized_start=26,
-  serialized_end=150,
+  serialized_start=27,
+  serialized_end=248,
 )
 
 
@@ -138,6 +189,34 @@ _RESPONSEMESSAGE = _descriptor.Descriptor(
       message_type=None, enum_type=None, containing_type=None,
       is_extension=False, extension_scope=None,
       options=None),
+    _descriptor.FieldDescriptor(
+      name='timestamp', full_name='message.ResponseMessage.timestamp', index=2,
+      number=3, type=9, cpp_type=9, label=1,
+      has_default_value=False, default_value=_b("").decode('utf-8'),
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      options=None),
+    _descriptor.FieldDescriptor(
+      name='reason', full_name='message.ResponseMessage.reason', index=3,
+      number=4, type=9, cpp_type=9, label=1,
+      has_default_value=False, default_value=_b("").decode('utf-8'),
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      options=None),
+    _descriptor.FieldDescriptor(
+      name='exitCode', full_name='message.ResponseMessage.exitCode', index=4,
+      number=5, type=5, cpp_type=1, label=1,
+      has_default_value=False, default_value=0,
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      options=None),
+    _descriptor.FieldDescriptor(
+      name='tab', full_name='message.ResponseMessage.tab', index=5,
+      number=6, type=13, cpp_type=3, label=1,
+      has_default_value=False, default_value=0,
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      options=None),
   ],
   extensions=[
   ],
@@ -151,8 +230,8 @@ _RESPONSEMESSAGE = _descriptor.Descriptor(
   extension_ranges=[],
   oneofs=[
   ],
-  serialized_start=153,
-  serialized_end=304,
+  serialized_start=251,
+  serialized_end=512,
 )
 
 _REQUESTMESSAGE.fields_by_name['msgType'].enum_type = _REQUESTMESSAGE_REQUESTTYPE
@@ -0,0 +1,164 @@
+// Package log is a thin wrapper of github.com/mijia/sweb/log which drops the messages
+// below the configured level.
+package log
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	swebLog "github.com/mijia/sweb/log"
+)
+
+// Level is the severity of a log message.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+const callDepth = 3
+
+var (
+	level       = LevelInfo
+	levelNames  = []string{"DEBUG", "INFO", "WARN", "ERROR"}
+	exitOnFatal = false
+)
+
+// ParseLevel parses debug, info, warn or error case-insensitively,
+// quiet is an alias of error.
+func ParseLevel(name string) (Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error", "quiet":
+		return LevelError, nil
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q", name)
+}
+
+// SetLevel drops the messages below l from now on, the default level is LevelInfo.
+func SetLevel(l Level) {
+	level = l
+}
+
+// Enabled reports whether messages of l are written.
+func Enabled(l Level) bool {
+	return l >= level
+}
+
+func ExitOnFatal() {
+	exitOnFatal = true
+}
+
+func Debugf(format string, v ...interface{}) {
+	output(LevelDebug, fmt.Sprintf(format, v...))
+}
+
+func Infof(format string, v ...interface{}) {
+	output(LevelInfo, fmt.Sprintf(format, v...))
+}
+
+func Warnf(format string, v ...interface{}) {
+	output(LevelWarn, fmt.Sprintf(format, v...))
+}
+
+func Errorf(format string, v ...interface{}) {
+	output(LevelError, fmt.Sprintf(format, v...))
+}
+
+// Fatal and Fatalf are never dropped, they panic unless ExitOnFatal is called.
+func Fatal(v ...interface{}) {
+	fatal(fmt.Sprint(v...))
+}
+
+func Fatalf(format string, v ...interface{}) {
+	fatal(fmt.Sprintf(format, v...))
+}
+
+func fatal(msg string) {
+	msg = header("FATAL", msg)
+	swebLog.Logger().Output(callDepth, msg)
+	if exitOnFatal {
+		os.Exit(1)
+	}
+	panic(msg)
+}
+
+func output(l Level, msg string) {
+	if Enabled(l) {
+		swebLog.Logger().Output(callDepth, header(levelNames[l], msg))
+	}
+}
+
+// header formats the message like sweb/log does, with the position of the original caller.
+func header(name, msg string) string {
+	_, file, line, ok := runtime.Caller(callDepth)
+	if ok {
+		file = filepath.Base(file)
+	}
+	if len(file) == 0 {
+		file = "???"
+	}
+	if line < 0 {
+		line = 0
+	}
+	return fmt.Sprintf("%s %s:%d: %s", name, file, line, msg)
+}
+
+// Logger writes the messages with its fields, like "[app=hello proc=web] message", so
+// that the messages of a context are found together. The nil Logger has no fields.
+type Logger struct {
+	fields string
+}
+
+// With returns a Logger of the key value pairs in keyvals, empty values are left out.
+func With(keyvals ...string) *Logger {
+	return (*Logger)(nil).With(keyvals...)
+}
+
+// With returns a Logger of the fields of l followed by the key value pairs in keyvals.
+func (l *Logger) With(keyvals ...string) *Logger {
+	var fields []string
+	if l != nil && l.fields != "" {
+		fields = append(fields, l.fields)
+	}
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		if keyvals[i+1] != "" {
+			fields = append(fields, keyvals[i]+"="+keyvals[i+1])
+		}
+	}
+	return &Logger{fields: strings.Join(fields, " ")}
+}
+
+func (l *Logger) prefix() string {
+	if l == nil || l.fields == "" {
+		return ""
+	}
+	return "[" + l.fields + "] "
+}
+
+func (l *Logger) Debugf(format string, v ...interface{}) {
+	output(LevelDebug, l.prefix()+fmt.Sprintf(format, v...))
+}
+
+func (l *Logger) Infof(format string, v ...interface{}) {
+	output(LevelInfo, l.prefix()+fmt.Sprintf(format, v...))
+}
+
+func (l *Logger) Warnf(format string, v ...interface{}) {
+	output(LevelWarn, l.prefix()+fmt.Sprintf(format, v...))
+}
+
+func (l *Logger) Errorf(format string, v ...interface{}) {
+	output(LevelError, l.prefix()+fmt.Sprintf(format, v...))
+}
@@ -0,0 +1,50 @@
+package log
+
+import "testing"
+
+func TestParseLevel(t *testing.T) {
+	cases := []struct {
+		name     string
+		expected Level
+		valid    bool
+	}{
+		{"debug", LevelDebug, true},
+		{"INFO", LevelInfo, true},
+		{"warn", LevelWarn, true},
+		{"quiet", LevelError, true},
+		{"verbose", LevelInfo, false},
+	}
+	for i, c := range cases {
+		actual, err := ParseLevel(c.name)
+		if actual != c.expected || (err == nil) != c.valid {
+			t.Errorf("Case %d failed: actual is %d, %v", i+1, actual, err)
+		}
+	}
+}
+
+func TestEnabled(t *testing.T) {
+	defer SetLevel(LevelInfo)
+	SetLevel(LevelWarn)
+	if Enabled(LevelInfo) || !Enabled(LevelWarn) || !Enabled(LevelError) {
+		t.Errorf("Levels below warn should be dropped")
+	}
+}
+
+func TestLoggerFields(t *testing.T) {
+	cases := []struct {
+		logger   *Logger
+		expected string
+	}{
+		{nil, ""},
+		{With(), ""},
+		{With("app", "hello", "proc", "web"), "[app=hello proc=web] "},
+		{With("app", "hello", "proc", ""), "[app=hello] "},
+		{With("app", "hello").With("container", "c1"), "[app=hello container=c1] "},
+		{(*Logger)(nil).With("instance", "1"), "[instance=1] "},
+	}
+	for i, c := range cases {
+		if actual := c.logger.prefix(); actual != c.expected {
+			t.Errorf("Case %d failed: actual is %q", i+1, actual)
+		}
+	}
+}
@@ -1,13 +1,16 @@
 package main
 
 import (
-	"net"
 	"os"
 
+	"github.com/laincloud/entry/log"
 	"github.com/laincloud/entry/server"
 )
 
 func main() {
-	swarmPort := os.Getenv("SWARM_PORT")
-	server.StartServer("80", net.JoinHostPort("swarm.lain", swarmPort))
+	config, err := server.LoadConfig(os.Getenv)
+	if err != nil {
+		log.Fatalf("Load config error: %s", err.Error())
+	}
+	server.StartServer(config)
 }
@@ -30,17 +30,38 @@ const _ = proto.ProtoPackageIsVersion1
 type RequestMessage_RequestType int32
 
 const (
-	RequestMessage_PLAIN RequestMessage_RequestType = 0
-	RequestMessage_WINCH RequestMessage_RequestType = 1
+	RequestMessage_PLAIN     RequestMessage_RequestType = 0
+	RequestMessage_WINCH     RequestMessage_RequestType = 1
+	RequestMessage_SWITCH    RequestMessage_RequestType = 2
+	RequestMessage_CONTROL   RequestMessage_RequestType = 3
+	RequestMessage_QUIT      RequestMessage_RequestType = 4
+	RequestMessage_PONG      RequestMessage_RequestType = 5
+	RequestMessage_CAPS      RequestMessage_RequestType = 6
+	RequestMessage_OPEN_TAB  RequestMessage_RequestType = 7
+	RequestMessage_CLOSE_TAB RequestMessage_RequestType = 8
 )
 
 var RequestMessage_RequestType_name = map[int32]string{
 	0: "PLAIN",
 	1: "WINCH",
+	2: "SWITCH",
+	3: "CONTROL",
+	4: "QUIT",
+	5: "PONG",
+	6: "CAPS",
+	7: "OPEN_TAB",
+	8: "CLOSE_TAB",
 }
 var RequestMessage_RequestType_value = map[string]int32{
-	"PLAIN": 0,
-	"WINCH": 1,
+	"PLAIN":     0,
+	"WINCH":     1,
+	"SWITCH":    2,
+	"CONTROL":   3,
+	"QUIT":      4,
+	"PONG":      5,
+	"CAPS":      6,
+	"OPEN_TAB":  7,
+	"CLOSE_TAB": 8,
 }
 
 func (x RequestMessage_RequestType) String() string {
@@ -53,10 +74,14 @@ func (RequestMessage_RequestType) EnumDescriptor() ([]byte, []int) {
 type ResponseMessage_ResponseType int32
 
 const (
-	ResponseMessage_STDOUT ResponseMessage_ResponseType = 0
-	ResponseMessage_STDERR ResponseMessage_ResponseType = 1
-	ResponseMessage_CLOSE  ResponseMessage_ResponseType = 2
-	ResponseMessage_PING   ResponseMessage_ResponseType = 3
+	ResponseMessage_STDOUT  ResponseMessage_ResponseType = 0
+	ResponseMessage_STDERR  ResponseMessage_ResponseType = 1
+	ResponseMessage_CLOSE   ResponseMessage_ResponseType = 2
+	ResponseMessage_PING    ResponseMessage_ResponseType = 3
+	ResponseMessage_NOTICE  ResponseMessage_ResponseType = 4
+	ResponseMessage_CONTROL ResponseMessage_ResponseType = 5
+	ResponseMessage_RTT     ResponseMessage_ResponseType = 6
+	ResponseMessage_CAPS    ResponseMessage_ResponseType = 7
 )
 
 var ResponseMessage_ResponseType_name = map[int32]string{
@@ -64,12 +89,20 @@ var ResponseMessage_ResponseType_name = map[int32]string{
 	1: "STDERR",
 	2: "CLOSE",
 	3: "PING",
+	4: "NOTICE",
+	5: "CONTROL",
+	6: "RTT",
+	7: "CAPS",
 }
 var ResponseMessage_ResponseType_value = map[string]int32{
-	"STDOUT": 0,
-	"STDERR": 1,
-	"CLOSE":  2,
-	"PING":   3,
+	"STDOUT":  0,
+	"STDERR":  1,
+	"CLOSE":   2,
+	"PING":    3,
+	"NOTICE":  4,
+	"CONTROL": 5,
+	"RTT":     6,
+	"CAPS":    7,
 }
 
 func (x ResponseMessage_ResponseType) String() string {
@@ -82,6 +115,7 @@ func (ResponseMessage_ResponseType) EnumDescriptor() ([]byte, []int) {
 type RequestMessage struct {
 	MsgType RequestMessage_RequestType `protobuf:"varint,1,opt,name=msgType,enum=message.RequestMessage_RequestType" json:"msgType,omitempty"`
 	Content []byte                     `protobuf:"bytes,2,opt,name=content,proto3" json:"content,omitempty"`
+	Tab     uint32                     `protobuf:"varint,3,opt,name=tab" json:"tab,omitempty"`
 }
 
 func (m *RequestMessage) Reset()                    { *m = RequestMessage{} }
@@ -90,8 +124,12 @@ func (*RequestMessage) ProtoMessage()               {}
 func (*RequestMessage) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
 
 type ResponseMessage struct {
-	MsgType ResponseMessage_ResponseType `protobuf:"varint,1,opt,name=msgType,enum=message.ResponseMessage_ResponseType" json:"msgType,omitempty"`
-	Content []byte                       `protobuf:"bytes,2,opt,name=content,proto3" json:"content,omitempty"`
+	MsgType   ResponseMessage_ResponseType `protobuf:"varint,1,opt,name=msgType,enum=message.ResponseMessage_ResponseType" json:"msgType,omitempty"`
+	Content   []byte                       `protobuf:"bytes,2,opt,name=content,proto3" json:"content,omitempty"`
+	Timestamp string                       `protobuf:"bytes,3,opt,name=timestamp" json:"timestamp,omitempty"`
+	Reason    string                       `protobuf:"bytes,4,opt,name=reason" json:"reason,omitempty"`
+	ExitCode  int32                        `protobuf:"varint,5,opt,name=exitCode" json:"exitCode,omitempty"`
+	Tab       uint32                       `protobuf:"varint,6,opt,name=tab" json:"tab,omitempty"`
 }
 
 func (m *ResponseMessage) Reset()                    { *m = ResponseMessage{} }
@@ -107,19 +145,26 @@ func init() {
 }
 
 var fileDescriptor0 = []byte{
-	// 211 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0xe2, 0xe2, 0xcd, 0x4d, 0x2d, 0x2e,
-	0x4e, 0x4c, 0x4f, 0xd5, 0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x17, 0x62, 0x87, 0x72, 0x95, 0xfa, 0x18,
-	0xb9, 0xf8, 0x82, 0x52, 0x0b, 0x4b, 0x53, 0x8b, 0x4b, 0x7c, 0x21, 0x42, 0x42, 0xb6, 0x5c, 0xec,
-	0xb9, 0xc5, 0xe9, 0x21, 0x95, 0x05, 0xa9, 0x12, 0x8c, 0x0a, 0x8c, 0x1a, 0x7c, 0x46, 0xca, 0x7a,
-	0x30, 0xcd, 0xa8, 0x2a, 0x61, 0x5c, 0x90, 0xd2, 0x20, 0x98, 0x1e, 0x21, 0x09, 0x2e, 0xf6, 0xe4,
-	0xfc, 0xbc, 0x92, 0xd4, 0xbc, 0x12, 0x09, 0x26, 0xa0, 0x76, 0x9e, 0x20, 0x18, 0x57, 0x49, 0x99,
-	0x8b, 0x1b, 0x49, 0x87, 0x10, 0x27, 0x17, 0x6b, 0x80, 0x8f, 0xa3, 0xa7, 0x9f, 0x00, 0x03, 0x88,
-	0x19, 0xee, 0xe9, 0xe7, 0xec, 0x21, 0xc0, 0xa8, 0xb4, 0x92, 0x91, 0x8b, 0x3f, 0x28, 0xb5, 0xb8,
-	0x20, 0x3f, 0xaf, 0x38, 0x15, 0xe6, 0x22, 0x7b, 0x74, 0x17, 0xa9, 0x22, 0xb9, 0x08, 0x45, 0x29,
-	0x9c, 0x4f, 0xac, 0x9b, 0xac, 0xb9, 0x78, 0x90, 0xb5, 0x08, 0x71, 0x71, 0xb1, 0x05, 0x87, 0xb8,
-	0xf8, 0x87, 0x86, 0x00, 0x5d, 0x05, 0x61, 0xbb, 0x06, 0x05, 0x09, 0x30, 0x82, 0x5c, 0xe8, 0xec,
-	0xe3, 0x1f, 0xec, 0x2a, 0xc0, 0x24, 0xc4, 0xc1, 0xc5, 0x12, 0xe0, 0xe9, 0xe7, 0x2e, 0xc0, 0x9c,
-	0xc4, 0x06, 0x0e, 0x4c, 0x63, 0x40, 0x00, 0x00, 0x00, 0xff, 0xff, 0x8c, 0x8c, 0xcd, 0x07, 0x5d,
-	0x01, 0x00, 0x00,
+	// 336 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x6c, 0x91, 0xcb, 0x4e, 0xe3, 0x30,
+	0x18, 0x85, 0xeb, 0xdc, 0xf3, 0xf7, 0xf6, 0x8f, 0x57, 0x59, 0x46, 0x1d, 0x8d, 0x94, 0x55, 0x17,
+	0x33, 0x23, 0xf6, 0x25, 0x44, 0x6d, 0xa4, 0x92, 0x84, 0xd4, 0x55, 0x97, 0x28, 0x05, 0xab, 0xea,
+	0x22, 0x17, 0x6a, 0x23, 0xe0, 0x01, 0x78, 0x4c, 0xde, 0x05, 0xc5, 0x34, 0x55, 0x8b, 0xd8, 0x9d,
+	0x63, 0x1d, 0xcb, 0xfa, 0x3e, 0xc3, 0xb0, 0xe4, 0x42, 0x14, 0x3b, 0x3e, 0x6d, 0x0e, 0xb5, 0xac,
+	0xa9, 0x7d, 0xac, 0x93, 0x0f, 0x02, 0xa3, 0x9c, 0x3f, 0x3d, 0x73, 0x21, 0x6f, 0xbf, 0x8e, 0xe8,
+	0x7f, 0xb0, 0x4b, 0xb1, 0x63, 0x6f, 0x0d, 0xf7, 0x88, 0x4f, 0x82, 0xd1, 0xdf, 0xdf, 0xd3, 0xee,
+	0xf2, 0xe5, 0xb2, 0xab, 0xed, 0x94, 0x8e, 0xc1, 0x7e, 0xa8, 0x2b, 0xc9, 0x2b, 0xe9, 0x69, 0x3e,
+	0x09, 0x06, 0xb4, 0x0f, 0xba, 0x2c, 0xb6, 0x9e, 0xee, 0x93, 0x60, 0x38, 0x79, 0x81, 0xfe, 0xf9,
+	0xd8, 0x05, 0x33, 0x5b, 0xce, 0xe2, 0x04, 0x7b, 0x6d, 0xdc, 0xc4, 0x49, 0xb8, 0x40, 0x42, 0x01,
+	0xac, 0xd5, 0x26, 0x66, 0xe1, 0x02, 0x35, 0xda, 0x07, 0x3b, 0x4c, 0x13, 0x96, 0xa7, 0x4b, 0xd4,
+	0xa9, 0x03, 0xc6, 0xdd, 0x3a, 0x66, 0x68, 0xb4, 0x29, 0x4b, 0x93, 0x39, 0x9a, 0x6d, 0x0a, 0x67,
+	0xd9, 0x0a, 0x2d, 0x3a, 0x00, 0x27, 0xcd, 0xa2, 0xe4, 0x9e, 0xcd, 0xae, 0xd1, 0xa6, 0x43, 0x70,
+	0xc3, 0x65, 0xba, 0x8a, 0x54, 0x75, 0x26, 0xef, 0x1a, 0x8c, 0x73, 0x2e, 0x9a, 0xba, 0x12, 0xbc,
+	0x03, 0xbc, 0xfa, 0x0e, 0xf8, 0xe7, 0x0c, 0xf0, 0x62, 0x7a, 0xea, 0x3f, 0x23, 0xfe, 0x02, 0x57,
+	0xee, 0x4b, 0x2e, 0x64, 0x51, 0x36, 0x0a, 0xd4, 0xa5, 0x23, 0xb0, 0x0e, 0xbc, 0x10, 0x75, 0xe5,
+	0x19, 0xaa, 0x23, 0x38, 0xfc, 0x75, 0x2f, 0xc3, 0xfa, 0x91, 0x7b, 0xa6, 0x4f, 0x02, 0xb3, 0xf3,
+	0x62, 0x29, 0x2f, 0x3b, 0x18, 0x5c, 0x3c, 0xd1, 0x2a, 0x60, 0x37, 0xe9, 0x9a, 0x61, 0xef, 0x98,
+	0xa3, 0x3c, 0x47, 0xd2, 0x5a, 0x52, 0x54, 0xa8, 0x29, 0x05, 0x71, 0x32, 0x47, 0xbd, 0x1d, 0x24,
+	0x29, 0x8b, 0xc3, 0x08, 0x8d, 0x73, 0x5f, 0x26, 0xb5, 0x41, 0xcf, 0x19, 0x43, 0xeb, 0x24, 0xc9,
+	0xde, 0x5a, 0xea, 0xdf, 0xff, 0x7d, 0x0e, 0x00, 0x28, 0x42, 0x1a, 0x96, 0x08, 0x02, 0x00, 0x00,
 }
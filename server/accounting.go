@@ -0,0 +1,115 @@
+package server
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/laincloud/entry/log"
+)
+
+// statsTimeout bounds a sampling of container stats.
+const statsTimeout = 10 * time.Second
+
+// sessionUsage accounts the resources used by an enter session, for chargeback and abuse
+// detection. The CPU time of the containers is sampled when the session enters and leaves
+// them, so it's approximate as it includes what other processes of the containers use in
+// the meantime. Samplings run in background, never delaying the session.
+// A nil *sessionUsage accounts nothing.
+type sessionUsage struct {
+	server      *EntryServer
+	begin       time.Time
+	containerID string
+	cpuStart    <-chan uint64
+	// windows are the CPU time used while the session was in each container.
+	windows []<-chan time.Duration
+}
+
+// newUsage returns the usage of a new session, or nil if accounting is off.
+func (server *EntryServer) newUsage() *sessionUsage {
+	if !server.accounting {
+		return nil
+	}
+	return &sessionUsage{server: server, begin: time.Now()}
+}
+
+// enter starts accounting the session in containerID.
+func (u *sessionUsage) enter(containerID string) {
+	if u == nil {
+		return
+	}
+	u.containerID = containerID
+	u.cpuStart = u.server.sampleCPU(containerID)
+}
+
+// leave stops accounting the session in the container it entered last.
+func (u *sessionUsage) leave() {
+	if u == nil || u.cpuStart == nil {
+		return
+	}
+	start, containerID := u.cpuStart, u.containerID
+	window := make(chan time.Duration, 1)
+	go func() {
+		defer close(window)
+		// Sampled after the start, so that the window is never negative.
+		first, ok := <-start
+		if !ok {
+			return
+		}
+		if last, ok := <-u.server.sampleCPU(containerID); ok && last >= first {
+			window <- time.Duration(last - first)
+		}
+	}()
+	u.windows = append(u.windows, window)
+	u.cpuStart = nil
+}
+
+// cpu waits for the samples and returns the CPU time used while the session was in the
+// containers. The containers whose samplings failed are not counted.
+func (u *sessionUsage) cpu() time.Duration {
+	var total time.Duration
+	for _, window := range u.windows {
+		total += <-window
+	}
+	return total
+}
+
+// summary describes the usage of the session of info, which has ws as its connection.
+func (u *sessionUsage) summary(info sessionInfo, ws *safeConn) string {
+	return fmt.Sprintf("Usage of session %s[%s-%s] by %s: cpu=%.2fs in=%dB out=%dB duration=%s",
+		info.appName, info.procName, info.instanceNo, info.user, u.cpu().Seconds(),
+		atomic.LoadInt64(&ws.bytesIn), atomic.LoadInt64(&ws.bytesOut), time.Since(u.begin))
+}
+
+// report logs the usage of the session once the samplings complete.
+func (u *sessionUsage) report(info sessionInfo, ws *safeConn) {
+	if u == nil {
+		return
+	}
+	u.leave()
+	go func() { log.Infof("%s", u.summary(info, ws)) }()
+}
+
+// sampleCPU gets the total CPU time used by the container in background, in nanoseconds.
+// The channel is closed without a value if it can't be got.
+func (server *EntryServer) sampleCPU(containerID string) <-chan uint64 {
+	result := make(chan uint64, 1)
+	go func() {
+		defer close(result)
+		stats := make(chan *docker.Stats, 1)
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- server.dockerClient.Stats(docker.StatsOptions{ID: containerID, Stats: stats, Timeout: statsTimeout})
+		}()
+		if s, ok := <-stats; ok && s != nil {
+			result <- s.CPUStats.CPUUsage.TotalUsage
+		}
+		for range stats {
+		}
+		if err := <-errCh; err != nil {
+			log.Warnf("Sample stats of %s failed: %s", containerID, err.Error())
+		}
+	}()
+	return result
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestSessionUsage(t *testing.T) {
+	var lock sync.Mutex
+	usages := map[string]uint64{"c1": 1e9, "c2": 5e9}
+	fake := &fakeDocker{
+		stats: func(opts docker.StatsOptions) error {
+			defer close(opts.Stats)
+			lock.Lock()
+			defer lock.Unlock()
+			usage, ok := usages[opts.ID]
+			if !ok {
+				return errors.New("no such container")
+			}
+			stats := &docker.Stats{}
+			stats.CPUStats.CPUUsage.TotalUsage = usage
+			opts.Stats <- stats
+			// Every sampling sees 1.5 seconds more.
+			usages[opts.ID] = usage + 1.5e9
+			return nil
+		},
+	}
+	if usage := (&EntryServer{dockerClient: fake}).newUsage(); usage != nil {
+		t.Fatal("Usage is accounted with accounting off")
+	}
+	server := &EntryServer{dockerClient: fake, accounting: true}
+	usage := server.newUsage()
+	for _, containerID := range []string{"c1", "c2", "c3"} {
+		usage.enter(containerID)
+		usage.leave()
+	}
+	if cpu := usage.cpu(); cpu != 3*time.Second {
+		t.Errorf("CPU time is %s", cpu)
+	}
+
+	ws := &safeConn{bytesIn: 10, bytesOut: 2048}
+	info := sessionInfo{appName: "hello", procName: "web", instanceNo: "1", user: "u1"}
+	summary := server.newUsage().summary(info, ws)
+	if !strings.HasPrefix(summary, "Usage of session hello[web-1] by u1: cpu=0.00s in=10B out=2048B") {
+		t.Errorf("Summary is %q", summary)
+	}
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/laincloud/entry/log"
+)
+
+const (
+	// deniedAuditWindow is the window the denied access audits of a client are counted in.
+	deniedAuditWindow = time.Minute
+	// maxDeniedAudits bounds the denied access audits of a client in a window, scanners
+	// trying tokens one after another don't flood the log and the webhook beyond it.
+	maxDeniedAudits = 10
+	// maxDeniedAuditClients bounds the clients counted in a window, the others aren't
+	// audited until the next window.
+	maxDeniedAuditClients = 1024
+)
+
+// deniedAudits rate-limits the audits of denied access by client host. The denied attempts
+// over the limit are not audited one by one, but counted in the first audit of the next
+// window. A nil *deniedAudits limits nothing.
+type deniedAudits struct {
+	lock    sync.Mutex
+	start   time.Time
+	counts  map[string]int
+	dropped int
+}
+
+func newDeniedAudits() *deniedAudits {
+	return &deniedAudits{counts: make(map[string]int)}
+}
+
+// allow reports whether a denied attempt of remoteAddr is audited at now, and if so how many
+// attempts were not audited before.
+func (a *deniedAudits) allow(remoteAddr string, now time.Time) (bool, int) {
+	if a == nil {
+		return true, 0
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if now.Sub(a.start) >= deniedAuditWindow {
+		a.start = now
+		a.counts = make(map[string]int)
+	}
+	count, ok := a.counts[host]
+	if count >= maxDeniedAudits || (!ok && len(a.counts) >= maxDeniedAuditClients) {
+		a.dropped++
+		return false, 0
+	}
+	a.counts[host] = count + 1
+	dropped := a.dropped
+	a.dropped = 0
+	return true, dropped
+}
+
+// auditAuthFailure logs and posts an access denied to the client of info at remoteAddr,
+// asking containerRef if not empty. The client is identified by the fingerprint of its token.
+func (server *EntryServer) auditAuthFailure(info sessionInfo, containerRef, remoteAddr string, err error) {
+	ok, dropped := server.deniedAudits.allow(remoteAddr, time.Now())
+	if !ok {
+		return
+	}
+	if dropped > 0 {
+		log.Warnf("AUDIT: %d denied attempts were not audited, over %d of a client per %s", dropped, maxDeniedAudits, deniedAuditWindow)
+	}
+	log.Warnf("AUDIT: DENIED %s session by %q from %s on %s[%s-%s] container %q: %s",
+		info.kind, info.user, remoteAddr, info.appName, info.procName, info.instanceNo, containerRef, err.Error())
+	event := info.event(eventAuthFailure, err.Error())
+	event.Container, event.RemoteAddr = containerRef, remoteAddr
+	server.webhook.emit(event)
+}
+
+// auditRequestDenied audits the client of r denied on appName by the endpoint of kind, asking
+// containerRef if not empty, like the clients of sessions are, see auditAuthFailure.
+func (server *EntryServer) auditRequestDenied(r *http.Request, kind, appName, containerRef string, err error) {
+	info := sessionInfo{kind: kind, appName: appName, user: tokenFingerprint(r.Header.Get("access-token")), node: server.node}
+	server.auditAuthFailure(info, containerRef, r.RemoteAddr, err)
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeniedAudits(t *testing.T) {
+	a := newDeniedAudits()
+	now := time.Now()
+	for i := 0; i < maxDeniedAudits; i++ {
+		if ok, _ := a.allow("10.0.0.1:1234", now); !ok {
+			t.Fatalf("Attempt %d is not audited", i+1)
+		}
+	}
+	cases := []struct {
+		remoteAddr string
+		at         time.Duration
+		ok         bool
+		dropped    int
+	}{
+		{"10.0.0.1:5678", 0, false, 0},
+		{"10.0.0.1:5678", time.Second, false, 0},
+		// Other clients are audited meanwhile, and told the attempts not audited.
+		{"10.0.0.2:1234", time.Second, true, 2},
+		{"10.0.0.2:1234", time.Second, true, 0},
+		// The limit is per window.
+		{"10.0.0.1:1234", deniedAuditWindow, true, 0},
+	}
+	for i, c := range cases {
+		if ok, dropped := a.allow(c.remoteAddr, now.Add(c.at)); ok != c.ok || dropped != c.dropped {
+			t.Errorf("Case %d failed: actual is %t %d", i+1, ok, dropped)
+		}
+	}
+
+	var none *deniedAudits
+	if ok, _ := none.allow("10.0.0.1:1234", now); !ok {
+		t.Error("Nil audits must audit every denial")
+	}
+}
+
+func TestEnterAuditAuthFailure(t *testing.T) {
+	events := make(chan SessionEvent, 10)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event := SessionEvent{}
+		json.NewDecoder(r.Body).Decode(&event)
+		events <- event
+	}))
+	defer hook.Close()
+	server := &EntryServer{dockerClient: &fakeDocker{}, authorizer: &FakeAuthorizer{Allow: false}, resolver: StaticResolver{"hello/web/1": "c1"},
+		webhook: newWebhookEmitter(hook.URL, eventAuthFailure), deniedAudits: newDeniedAudits()}
+	ts := httptest.NewServer(http.HandlerFunc(server.enter))
+	defer ts.Close()
+
+	dial := func() {
+		header := http.Header{}
+		header.Set("access-token", "guess")
+		header.Set("container", "web")
+		ws := dialSession(t, ts, "", header)
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				break
+			}
+		}
+		ws.Close()
+		server.sessions.Wait()
+	}
+	for i := 0; i < maxDeniedAudits+2; i++ {
+		dial()
+	}
+	for i := 0; i < maxDeniedAudits; i++ {
+		select {
+		case event := <-events:
+			if event.Type != eventAuthFailure || event.App != "hello" || event.Container != "web" ||
+				event.User != tokenFingerprint("guess") || !strings.HasPrefix(event.RemoteAddr, "127.0.0.1:") {
+				t.Errorf("Unexpected event: %+v", event)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Denial %d is not audited", i+1)
+		}
+	}
+	// The denials over the limit are not posted.
+	select {
+	case event := <-events:
+		t.Errorf("Denial over the limit is audited: %+v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestAuditRequestDenied(t *testing.T) {
+	events := make(chan SessionEvent, 10)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event := SessionEvent{}
+		json.NewDecoder(r.Body).Decode(&event)
+		events <- event
+	}))
+	defer hook.Close()
+	server := &EntryServer{dockerClient: &fakeDocker{}, authorizer: &FakeAuthorizer{Allow: false}, stateApp: "entry",
+		webhook: newWebhookEmitter(hook.URL, eventAuthFailure), deniedAudits: newDeniedAudits()}
+	_, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	session := server.registry.add(sessionInfo{kind: "enter", appName: "hello", containerID: "c1", user: "alice"}, cancel)
+	defer server.registry.remove(session)
+
+	cases := []struct {
+		handler   http.HandlerFunc
+		path      string
+		kind      string
+		app       string
+		container string
+	}{
+		{server.containerInfo, "/container/c1/info", "container-info", "hello", "c1"},
+		{server.serveState, "/debug/state", "state", "entry", ""},
+		{server.mySessions, "/server/sessions/mine", "my-sessions", "hello", ""},
+	}
+	for i, c := range cases {
+		r := httptest.NewRequest(http.MethodGet, c.path, nil)
+		r.Header.Set("access-token", "guess")
+		r.Header.Set("app-name", "hello")
+		c.handler(httptest.NewRecorder(), r)
+		select {
+		case event := <-events:
+			if event.Type != eventAuthFailure || event.Kind != c.kind || event.App != c.app || event.Container != c.container ||
+				event.User != tokenFingerprint("guess") || event.RemoteAddr != r.RemoteAddr {
+				t.Errorf("Case %d failed: %+v", i+1, event)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("Case %d failed: the denial is not audited", i+1)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	lainlet "github.com/laincloud/lainlet/client"
+)
+
+// Authorizer decides whether the client holding token has the right to access the application.
+// A nil error means access is granted, and role is the role the client plays in the application.
+type Authorizer interface {
+	Authorize(token, appName string) (role string, err error)
+}
+
+type ConsoleAuthConf struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+type ConsoleRole struct {
+	Role string `json:"role"`
+}
+
+type ConsoleAuthResponse struct {
+	Message string      `json:"msg"`
+	URL     string      `json:"url"`
+	Role    ConsoleRole `json:"role"`
+}
+
+var (
+	errAuthFailed       = errors.New("authorize failed")
+	errAuthNotSupported = errors.New("entry only works on lain-sso authorization")
+)
+
+// consoleAuthTimeout bounds the authorization requests to the lain console by default.
+const consoleAuthTimeout = 4 * time.Second
+
+// LainAuthorizer authorizes clients against the lain console when lain-sso is configured.
+type LainAuthorizer struct {
+	lainletClient *lainlet.Client
+	lainDomain    string
+	httpClient    *http.Client
+}
+
+func (a *LainAuthorizer) Authorize(token, appName string) (string, error) {
+	var (
+		data []byte
+		err  error
+	)
+	if data, err = a.lainletClient.Get("/v2/configwatcher?target=auth/console", 2*time.Second); err != nil {
+		return "", err
+	}
+	authDataMap := make(map[string]string)
+	if err = json.Unmarshal(data, &authDataMap); err != nil {
+		return "", err
+	}
+	if authStr, exist := authDataMap["auth/console"]; exist {
+		c := ConsoleAuthConf{}
+		if err = json.Unmarshal([]byte(authStr), &c); err != nil {
+			return "", err
+		}
+		if c.Type == "lain-sso" {
+			authURL := fmt.Sprintf("http://console.%s/api/v1/repos/%s/roles/", a.lainDomain, appName)
+			return a.validateConsoleRole(authURL, token)
+		}
+		return "", errAuthNotSupported
+	}
+
+	return "", nil
+}
+
+func (a *LainAuthorizer) validateConsoleRole(authURL, token string) (string, error) {
+	var (
+		err       error
+		req       *http.Request
+		resp      *http.Response
+		respBytes []byte
+	)
+	if req, err = http.NewRequest("GET", authURL, nil); err != nil {
+		return "", err
+	}
+	req.Header.Set("access-token", token)
+	if resp, err = a.httpClient.Do(req); err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if respBytes, err = ioutil.ReadAll(resp.Body); err != nil {
+		return "", err
+	}
+	caResp := ConsoleAuthResponse{}
+	if err = json.Unmarshal(respBytes, &caResp); err != nil {
+		return "", err
+	}
+	if caResp.Role.Role == "" {
+		return "", errAuthFailed
+	}
+	return caResp.Role.Role, nil
+}
+
+// FakeAuthorizer answers every authorization from static settings without any backend.
+// It exists for tests and local development only and must NEVER be enabled in production.
+type FakeAuthorizer struct {
+	// Allow and Role are the result for every token when Tokens is nil.
+	Allow bool
+	Role  string
+	// Tokens maps the accepted tokens to their roles, any other token is denied.
+	Tokens map[string]string
+}
+
+// NewFakeAuthorizer creates a FakeAuthorizer which allows or denies everyone according to mode,
+// unless tokensFile names a JSON object file mapping tokens to roles.
+func NewFakeAuthorizer(mode, tokensFile string) (*FakeAuthorizer, error) {
+	a := &FakeAuthorizer{Role: "admin"}
+	switch mode {
+	case "allow":
+		a.Allow = true
+	case "deny":
+		a.Allow = false
+	default:
+		return nil, fmt.Errorf("unknown fake auth mode %q", mode)
+	}
+	if tokensFile != "" {
+		data, err := ioutil.ReadFile(tokensFile)
+		if err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(data, &a.Tokens); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+func (a *FakeAuthorizer) Authorize(token, appName string) (string, error) {
+	if a.Tokens != nil {
+		if role, exist := a.Tokens[token]; exist {
+			return role, nil
+		}
+		return "", errAuthFailed
+	}
+	if !a.Allow {
+		return "", errAuthFailed
+	}
+	return a.Role, nil
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/laincloud/entry/message"
+)
+
+func TestFakeAuthorizer(t *testing.T) {
+	a := &FakeAuthorizer{Allow: true, Role: "admin"}
+	if role, err := a.Authorize("any", "hello"); err != nil || role != "admin" {
+		t.Errorf("Case 1 failed: role is %q, %v", role, err)
+	}
+	a = &FakeAuthorizer{Allow: false}
+	if _, err := a.Authorize("any", "hello"); err != errAuthFailed {
+		t.Errorf("Case 2 failed: err is %v", err)
+	}
+	a = &FakeAuthorizer{Tokens: map[string]string{"good": "developer"}}
+	if role, err := a.Authorize("good", "hello"); err != nil || role != "developer" {
+		t.Errorf("Case 3 failed: role is %q, %v", role, err)
+	}
+	if _, err := a.Authorize("bad", "hello"); err != errAuthFailed {
+		t.Errorf("Case 4 failed: err is %v", err)
+	}
+	if _, err := NewFakeAuthorizer("maybe", ""); err == nil {
+		t.Errorf("Case 5 failed: unknown mode is accepted")
+	}
+}
+
+// consoleTransport answers the role requests of the lain console by their tokens.
+type consoleTransport map[string]string
+
+func (t consoleTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body := `{"msg": "no role"}`
+	if role, ok := t[req.Header.Get("access-token")]; ok {
+		body = `{"role": {"role": "` + role + `"}}`
+	}
+	return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(body)), Request: req}, nil
+}
+
+func TestValidateConsoleRole(t *testing.T) {
+	server, err := newEntryServer(Config{AuthHTTPClient: &http.Client{Transport: consoleTransport{"good": "developer"}}}, &fakeDocker{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := server.authorizer.(*LainAuthorizer)
+	if role, err := a.validateConsoleRole("http://console.lain.local/api/v1/repos/hello/roles/", "good"); err != nil || role != "developer" {
+		t.Errorf("Case 1 failed: role is %q, %v", role, err)
+	}
+	if _, err := a.validateConsoleRole("http://console.lain.local/api/v1/repos/hello/roles/", "bad"); err != errAuthFailed {
+		t.Errorf("Case 2 failed: err is %v", err)
+	}
+
+	if server, err = newEntryServer(Config{}, &fakeDocker{}); err != nil {
+		t.Fatal(err)
+	}
+	if client := server.authorizer.(*LainAuthorizer).httpClient; client == nil || client.Timeout != consoleAuthTimeout {
+		t.Errorf("Default auth client is %+v", client)
+	}
+}
+
+func TestEnterDeniedByAuthorizer(t *testing.T) {
+	server := &EntryServer{
+		authorizer: &FakeAuthorizer{Allow: false},
+		resolver:   StaticResolver{},
+	}
+	ts := httptest.NewServer(http.HandlerFunc(server.enter))
+	defer ts.Close()
+
+	header := http.Header{}
+	header.Set("access-token", "token")
+	ws := dialSession(t, ts, "", header)
+	defer ws.Close()
+	_, data, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := message.ResponseMessage{}
+	if err = protoUnmarshalFunc(data, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.MsgType != message.ResponseMessage_CLOSE || !strings.Contains(string(msg.Content), "Authorization failed") {
+		t.Errorf("Unexpected response: %v", msg)
+	}
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultAuthCacheTTL keeps authorizations short-lived, so that revoked access ends soon.
+	defaultAuthCacheTTL = 30 * time.Second
+	// authDenialTTL is the most a denial is cached, to absorb retry storms without delaying
+	// access just granted.
+	authDenialTTL = 5 * time.Second
+	// authCacheSize bounds the entries kept, the expired ones are dropped beyond it.
+	authCacheSize = 10000
+)
+
+type authEntry struct {
+	role    string
+	err     error
+	expires time.Time
+}
+
+// CachedAuthorizer caches the decisions of another Authorizer by token and application, so
+// that reconnects and instance switches don't cost a round-trip to the backend each. Grants
+// are cached for ttl and denials briefly, failures of the backend are never cached and drop
+// what was cached.
+type CachedAuthorizer struct {
+	authorizer Authorizer
+	ttl        time.Duration
+	now        func() time.Time
+
+	lock    sync.Mutex
+	entries map[string]authEntry
+}
+
+// NewCachedAuthorizer caches the decisions of authorizer for ttl.
+func NewCachedAuthorizer(authorizer Authorizer, ttl time.Duration) *CachedAuthorizer {
+	return &CachedAuthorizer{
+		authorizer: authorizer,
+		ttl:        ttl,
+		now:        time.Now,
+		entries:    make(map[string]authEntry),
+	}
+}
+
+func (a *CachedAuthorizer) Authorize(token, appName string) (string, error) {
+	// The key is a hash, the tokens are not kept in memory longer than needed.
+	sum := sha256.Sum256([]byte(token + "\x00" + appName))
+	key := hex.EncodeToString(sum[:])
+	now := a.now()
+	a.lock.Lock()
+	entry, ok := a.entries[key]
+	a.lock.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.role, entry.err
+	}
+
+	role, err := a.authorizer.Authorize(token, appName)
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	switch err {
+	case nil:
+		a.put(key, authEntry{role: role, expires: now.Add(a.ttl)}, now)
+	case errAuthFailed:
+		ttl := authDenialTTL
+		if a.ttl < ttl {
+			ttl = a.ttl
+		}
+		a.put(key, authEntry{err: err, expires: now.Add(ttl)}, now)
+	default:
+		delete(a.entries, key)
+	}
+	return role, err
+}
+
+// put caches entry by key, the lock must be held.
+func (a *CachedAuthorizer) put(key string, entry authEntry, now time.Time) {
+	if len(a.entries) >= authCacheSize {
+		for k, e := range a.entries {
+			if !now.Before(e.expires) {
+				delete(a.entries, k)
+			}
+		}
+		if len(a.entries) >= authCacheSize {
+			a.entries = make(map[string]authEntry)
+		}
+	}
+	a.entries[key] = entry
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// countingAuthorizer counts the authorizations reaching the backend.
+type countingAuthorizer struct {
+	calls int
+	role  string
+	err   error
+}
+
+func (a *countingAuthorizer) Authorize(token, appName string) (string, error) {
+	a.calls++
+	return a.role, a.err
+}
+
+func TestCachedAuthorizer(t *testing.T) {
+	backend := &countingAuthorizer{role: "developer"}
+	now := time.Unix(0, 0)
+	a := NewCachedAuthorizer(backend, time.Minute)
+	a.now = func() time.Time { return now }
+
+	cases := []struct {
+		advance time.Duration
+		token   string
+		app     string
+		// set changes the answer of the backend before the case.
+		set   *countingAuthorizer
+		role  string
+		err   error
+		calls int
+	}{
+		// Miss, then hit.
+		{0, "t1", "hello", nil, "developer", nil, 1},
+		{30 * time.Second, "t1", "hello", nil, "developer", nil, 1},
+		// Another token or app misses.
+		{0, "t2", "hello", nil, "developer", nil, 2},
+		{0, "t1", "world", nil, "developer", nil, 3},
+		// Expired, the access is revoked meanwhile.
+		{31 * time.Second, "t1", "hello", &countingAuthorizer{err: errAuthFailed}, "", errAuthFailed, 4},
+		// The denial is cached briefly only.
+		{time.Second, "t1", "hello", &countingAuthorizer{role: "admin"}, "", errAuthFailed, 4},
+		{authDenialTTL, "t1", "hello", nil, "admin", nil, 5},
+		// A backend failure is not cached, and drops the cached grant.
+		{time.Minute, "t1", "hello", &countingAuthorizer{err: errors.New("console is down")}, "", errors.New("console is down"), 6},
+		{0, "t1", "hello", &countingAuthorizer{role: "admin"}, "admin", nil, 7},
+	}
+	for i, c := range cases {
+		now = now.Add(c.advance)
+		if c.set != nil {
+			backend.role, backend.err = c.set.role, c.set.err
+		}
+		role, err := a.Authorize(c.token, c.app)
+		if role != c.role || (err == nil) != (c.err == nil) || (err != nil && err.Error() != c.err.Error()) || backend.calls != c.calls {
+			t.Errorf("Case %d failed: role is %q, err is %v, calls are %d", i+1, role, err, backend.calls)
+		}
+	}
+}
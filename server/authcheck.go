@@ -0,0 +1,44 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/laincloud/entry/log"
+)
+
+// AuthCheckResult is the authorization of a client on an application told by /authcheck.
+type AuthCheckResult struct {
+	App     string `json:"app"`
+	Allowed bool   `json:"allowed"`
+	Role    string `json:"role,omitempty"`
+	// Identity is the fingerprint of the token, or the identity of the client certificate.
+	Identity string `json:"identity,omitempty"`
+	// Error tells why the client is denied.
+	Error string `json:"error,omitempty"`
+}
+
+// authCheck serves GET /authcheck?app={app}, which authorizes the client by its access-token
+// header, or its client certificate, like sessions do but without touching docker.
+func (server *EntryServer) authCheck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method is not allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+	appName := r.URL.Query().Get("app")
+	if appName == "" {
+		http.Error(w, "app is required.", http.StatusBadRequest)
+		return
+	}
+	result := AuthCheckResult{App: appName}
+	if err := server.appFilter.check(appName); err != nil {
+		result.Error = err.Error()
+	} else if role, identity, err := server.authorize(r, r.Header.Get("access-token"), appName); err != nil {
+		result.Identity, result.Error = identity, err.Error()
+	} else {
+		result.Allowed, result.Role, result.Identity = true, role, identity
+	}
+	log.Infof("Auth check of %q on %s: allowed=%t role=%q error=%q", result.Identity, appName, result.Allowed, result.Role, result.Error)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
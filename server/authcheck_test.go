@@ -0,0 +1,40 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthCheck(t *testing.T) {
+	filter, _ := newAppFilter("", "secret")
+	server := &EntryServer{
+		authorizer: &FakeAuthorizer{Tokens: map[string]string{"dev": "developer"}},
+		appFilter:  filter,
+	}
+	cases := []struct {
+		method string
+		token  string
+		query  string
+		status int
+		result AuthCheckResult
+	}{
+		{"GET", "dev", "app=hello", http.StatusOK, AuthCheckResult{App: "hello", Allowed: true, Role: "developer", Identity: tokenFingerprint("dev")}},
+		{"GET", "nobody", "app=hello", http.StatusOK, AuthCheckResult{App: "hello", Identity: tokenFingerprint("nobody"), Error: errAuthFailed.Error()}},
+		{"GET", "dev", "app=secret", http.StatusOK, AuthCheckResult{App: "secret", Error: errAppNotAllowed.Error()}},
+		{"GET", "dev", "", http.StatusBadRequest, AuthCheckResult{}},
+		{"POST", "dev", "app=hello", http.StatusMethodNotAllowed, AuthCheckResult{}},
+	}
+	for i, c := range cases {
+		r := httptest.NewRequest(c.method, "/authcheck?"+c.query, nil)
+		r.Header.Set("access-token", c.token)
+		w := httptest.NewRecorder()
+		server.authCheck(w, r)
+		result := AuthCheckResult{}
+		json.Unmarshal(w.Body.Bytes(), &result)
+		if w.Code != c.status || result != c.result {
+			t.Errorf("Case %d failed: %d %+v", i+1, w.Code, result)
+		}
+	}
+}
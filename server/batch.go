@@ -0,0 +1,35 @@
+package server
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// batchSubprotocol is negotiated by clients which send several RequestMessages in one
+// frame, each marshaled message prefixed by its length as a varint.
+const batchSubprotocol = "entry.batch"
+
+var errInvalidBatch = errors.New("invalid message batch")
+
+// splitBatch splits a batch frame into the marshaled messages.
+func splitBatch(frame []byte) ([][]byte, error) {
+	var msgs [][]byte
+	for len(frame) > 0 {
+		size, n := binary.Uvarint(frame)
+		if n <= 0 || size > uint64(len(frame)-n) {
+			return nil, errInvalidBatch
+		}
+		frame = frame[n:]
+		msgs = append(msgs, frame[:size])
+		frame = frame[size:]
+	}
+	return msgs, nil
+}
+
+// appendBatch appends msg to the batch frame.
+func appendBatch(frame, msg []byte) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], uint64(len(msg)))
+	frame = append(frame, buf[:n]...)
+	return append(frame, msg...)
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gorilla/websocket"
+	"github.com/laincloud/entry/message"
+)
+
+func TestBatchRoundTrip(t *testing.T) {
+	msgs := [][]byte{[]byte("a"), {}, bytes.Repeat([]byte("b"), 300)}
+	var frame []byte
+	for _, msg := range msgs {
+		frame = appendBatch(frame, msg)
+	}
+	actual, err := splitBatch(frame)
+	if err != nil || len(actual) != len(msgs) {
+		t.Fatalf("Split batch failed: %d messages, %v", len(actual), err)
+	}
+	for i := range msgs {
+		if !bytes.Equal(actual[i], msgs[i]) {
+			t.Errorf("Message %d is %q", i+1, actual[i])
+		}
+	}
+	if _, err = splitBatch(frame[:len(frame)-1]); err != errInvalidBatch {
+		t.Errorf("Truncated batch is accepted: %v", err)
+	}
+}
+
+func TestEnterBatch(t *testing.T) {
+	input := make(chan []byte, 1)
+	resized := make(chan [2]int, 1)
+	fake := &fakeDocker{
+		startExec: func(id string, opts docker.StartExecOptions) (docker.CloseWaiter, error) {
+			w := &fakeWaiter{done: make(chan struct{})}
+			go func() {
+				buf := make([]byte, 2)
+				io.ReadFull(opts.InputStream, buf)
+				input <- buf
+				close(w.done)
+			}()
+			return w, nil
+		},
+		resizeExecTTY: func(id string, height, width int) error {
+			resized <- [2]int{width, height}
+			return nil
+		},
+	}
+	server := &EntryServer{dockerClient: fake, authorizer: &FakeAuthorizer{Allow: true}, resolver: StaticResolver{"hello/web/1": "c1"}}
+	ts := httptest.NewServer(http.HandlerFunc(server.enter))
+	defer ts.Close()
+
+	header := http.Header{}
+	header.Set("app-name", "hello")
+	header.Set("proc-name", "web")
+	header.Set("instance-no", "1")
+	dialer := websocket.Dialer{Subprotocols: []string{batchSubprotocol}}
+	ws, _, err := dialer.Dial(strings.Replace(ts.URL, "http", "ws", 1), header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	if ws.Subprotocol() != batchSubprotocol {
+		t.Fatalf("Subprotocol is %q", ws.Subprotocol())
+	}
+
+	var frame []byte
+	for _, msg := range []*message.RequestMessage{
+		{MsgType: message.RequestMessage_WINCH, Content: []byte("80 24")},
+		{MsgType: message.RequestMessage_PLAIN, Content: []byte("ls")},
+	} {
+		data, _ := protoMarshalFunc(msg)
+		frame = appendBatch(frame, data)
+	}
+	if err = ws.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case size := <-resized:
+		if size != [2]int{80, 24} {
+			t.Errorf("Resized to %v", size)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("WINCH in batch is not handled")
+	}
+	select {
+	case data := <-input:
+		if string(data) != "ls" {
+			t.Errorf("Input is %q", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("PLAIN in batch is not handled")
+	}
+}
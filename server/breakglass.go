@@ -0,0 +1,58 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/laincloud/entry/log"
+)
+
+const (
+	// eventBreakGlass is posted when a session is authorized by the break-glass token, it's
+	// never filtered out by the webhook events.
+	eventBreakGlass = "break_glass"
+	// breakGlassRole is the role of the clients holding the break-glass token.
+	breakGlassRole = "admin"
+)
+
+// breakGlass authorizes the holders of an emergency token on every application without the
+// lain console, e.g. when it's down during an incident. Only the SHA-256 of the token is
+// configured. Every break-glass session is audited and posted to the webhook, and flagged
+// in the logs, events and metrics of the session. The zero breakGlass is disabled.
+type breakGlass struct {
+	tokenHash []byte
+}
+
+// newBreakGlass parses the hex SHA-256 of the break-glass token, empty disables it.
+func newBreakGlass(tokenHash string) (breakGlass, error) {
+	if tokenHash == "" {
+		return breakGlass{}, nil
+	}
+	hash, err := hex.DecodeString(tokenHash)
+	if err != nil || len(hash) != sha256.Size {
+		return breakGlass{}, fmt.Errorf("break-glass token hash must be a hex SHA-256")
+	}
+	return breakGlass{tokenHash: hash}, nil
+}
+
+func (b breakGlass) enabled() bool {
+	return b.tokenHash != nil
+}
+
+// match reports whether token is the break-glass token.
+func (b breakGlass) match(token string) bool {
+	if !b.enabled() || token == "" {
+		return false
+	}
+	sum := sha256.Sum256([]byte(token))
+	return subtle.ConstantTimeCompare(sum[:], b.tokenHash) == 1
+}
+
+// auditBreakGlass logs and posts the session of info authorized by the break-glass token.
+func (server *EntryServer) auditBreakGlass(info sessionInfo, remoteAddr string) {
+	log.Warnf("AUDIT: BREAK-GLASS %s session by %s from %s on %s[%s-%s]",
+		info.kind, info.user, remoteAddr, info.appName, info.procName, info.instanceNo)
+	server.webhook.emit(info.event(eventBreakGlass, ""))
+}
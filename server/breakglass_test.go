@@ -0,0 +1,77 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBreakGlass(t *testing.T) {
+	sum := sha256.Sum256([]byte("emergency"))
+	b, err := newBreakGlass(hex.EncodeToString(sum[:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !b.enabled() || !b.match("emergency") || b.match("other") || b.match("") {
+		t.Errorf("Break-glass token is not matched")
+	}
+	if b, _ = newBreakGlass(""); b.enabled() || b.match("") {
+		t.Errorf("Break-glass is enabled without a token")
+	}
+	for i, hash := range []string{"emergency", "abcd"} {
+		if _, err = newBreakGlass(hash); err == nil {
+			t.Errorf("Case %d failed: %q is accepted", i+1, hash)
+		}
+	}
+}
+
+func TestEnterBreakGlass(t *testing.T) {
+	events := make(chan SessionEvent, 10)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event := SessionEvent{}
+		json.NewDecoder(r.Body).Decode(&event)
+		events <- event
+	}))
+	defer hook.Close()
+	sum := sha256.Sum256([]byte("emergency"))
+	bg, _ := newBreakGlass(hex.EncodeToString(sum[:]))
+	// The console denies everyone, as if it were down.
+	server := &EntryServer{dockerClient: &fakeDocker{}, authorizer: &FakeAuthorizer{Allow: false}, resolver: StaticResolver{"hello/web/1": "c1"},
+		breakGlass: bg, webhook: newWebhookEmitter(hook.URL, eventSessionEnd)}
+	ts := httptest.NewServer(http.HandlerFunc(server.enter))
+	defer ts.Close()
+
+	header := http.Header{}
+	header.Set("access-token", "emergency")
+	ws := dialSession(t, ts, "", header)
+	for {
+		if _, _, err := ws.ReadMessage(); err != nil {
+			break
+		}
+	}
+	ws.Close()
+	server.sessions.Wait()
+
+	// Every event of a break-glass session bypasses the filter of the webhook events, which only
+	// lets session ends through.
+	for _, expected := range []string{eventBreakGlass, eventSessionStart, eventSessionEnd} {
+		select {
+		case event := <-events:
+			if event.Type != expected || !event.BreakGlass || !strings.HasPrefix(event.User, "break-glass:") {
+				t.Errorf("Unexpected event: %+v", event)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s event is not posted", expected)
+		}
+	}
+	rec := httptest.NewRecorder()
+	server.metrics.serveHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), `entry_break_glass_sessions_total{app="hello"} 1`) {
+		t.Errorf("Break-glass session is not counted:\n%s", rec.Body.String())
+	}
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/websocket"
+	"github.com/laincloud/entry/log"
+	"github.com/laincloud/entry/message"
+)
+
+// serverCapabilities are the features of the protocol the server honors when a client
+// supports them, the requests of the others are refused, see refuseRequest:
+//
+//	notice   NOTICE messages, which are sent as STDOUT otherwise.
+//	rtt      RTT messages answering PONG requests, when enabled.
+//	control  CONTROL requests.
+//	switch   SWITCH requests.
+//	quit     QUIT requests.
+//	tabs     OPEN_TAB and CLOSE_TAB requests, see handleTabRequest.
+//
+// Pixel sizes of WINCH messages are not among them, as docker resizes ttys by cells only.
+var serverCapabilities = []string{"notice", "rtt", "control", "switch", "quit", "tabs"}
+
+// capabilities is the set of features negotiated with a client by a CAPS request. It's nil
+// for the clients which never negotiate, which get every feature like before negotiation.
+type capabilities map[string]bool
+
+// negotiateCapabilities returns the features of serverCapabilities among the comma
+// separated ones of a client, the others are ignored so that clients may list future ones.
+func negotiateCapabilities(content []byte) capabilities {
+	caps := make(capabilities)
+	for _, name := range splitPatterns(strings.ToLower(string(content))) {
+		for _, supported := range serverCapabilities {
+			if name == supported {
+				caps[name] = true
+			}
+		}
+	}
+	return caps
+}
+
+// has reports whether the feature name is negotiated.
+func (c capabilities) has(name string) bool {
+	return c == nil || c[name]
+}
+
+// requestCapability returns the feature a client must have negotiated for inMsg, empty if
+// the request is always served.
+func requestCapability(inMsg *message.RequestMessage) string {
+	if isTabRequest(inMsg) {
+		return "tabs"
+	}
+	switch inMsg.MsgType {
+	case message.RequestMessage_CONTROL:
+		return "control"
+	case message.RequestMessage_SWITCH:
+		return "switch"
+	case message.RequestMessage_QUIT:
+		return "quit"
+	}
+	return ""
+}
+
+// refuseRequest reports whether inMsg needs a feature the client of ws didn't negotiate, and
+// if so tells the client.
+func (server *EntryServer) refuseRequest(ws *safeConn, inMsg *message.RequestMessage, msgMarshaller Marshaler) bool {
+	name := requestCapability(inMsg)
+	if name == "" || ws.capabilities().has(name) {
+		return false
+	}
+	server.sendNoticeMessage(ws, fmt.Sprintf("%s requests are refused, %s is not negotiated.", inMsg.MsgType, name), msgMarshaller)
+	return true
+}
+
+func (c capabilities) String() string {
+	names := make([]string, 0, len(c))
+	for name := range c {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// handleCaps negotiates the capabilities of the client of ws by the content of a CAPS request,
+// and answers with the negotiated ones. A client may negotiate again, the last one holds.
+func (server *EntryServer) handleCaps(ws *safeConn, logger *log.Logger, content []byte, msgMarshaller Marshaler) {
+	caps := negotiateCapabilities(content)
+	ws.setCapabilities(caps)
+	logger.Debugf("Capabilities negotiated: %s", caps)
+	capsMsg := &message.ResponseMessage{
+		MsgType: message.ResponseMessage_CAPS,
+		Content: []byte(caps.String()),
+	}
+	if data, err := msgMarshaller(capsMsg); err != nil {
+		log.Errorf("Marshal caps message failed: %s", err.Error())
+	} else {
+		ws.WriteMessage(websocket.BinaryMessage, data)
+	}
+}
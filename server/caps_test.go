@@ -0,0 +1,112 @@
+package server
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gorilla/websocket"
+	"github.com/laincloud/entry/message"
+)
+
+func TestNegotiateCapabilities(t *testing.T) {
+	cases := []struct {
+		content  string
+		expected string
+	}{
+		{"", ""},
+		{"notice", "notice"},
+		{"RTT, notice,pixel,clipboard", "notice,rtt"},
+		{"quit,switch,control,truecolor", "control,quit,switch"},
+	}
+	for i, c := range cases {
+		if actual := negotiateCapabilities([]byte(c.content)).String(); actual != c.expected {
+			t.Errorf("Case %d failed: actual is %q", i+1, actual)
+		}
+	}
+	var none capabilities
+	if !none.has("notice") || negotiateCapabilities([]byte("rtt")).has("notice") {
+		t.Error("Capabilities not negotiated must all be honored, others only if negotiated")
+	}
+}
+
+func TestEnterCaps(t *testing.T) {
+	fake := &fakeDocker{
+		startExec: func(id string, opts docker.StartExecOptions) (docker.CloseWaiter, error) {
+			w := &fakeWaiter{done: make(chan struct{})}
+			go func() {
+				io.Copy(ioutil.Discard, opts.InputStream)
+				close(w.done)
+			}()
+			return w, nil
+		},
+	}
+	server := &EntryServer{dockerClient: fake, authorizer: &FakeAuthorizer{Allow: true, Role: "developer"},
+		resolver: StaticResolver{"hello/web/1": "c1"}}
+	ts := httptest.NewServer(http.HandlerFunc(server.enter))
+	defer ts.Close()
+
+	ws := dialSession(t, ts, "", nil)
+	defer ws.Close()
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	send := func(msgType message.RequestMessage_RequestType, content string) {
+		data, _ := protoMarshalFunc(&message.RequestMessage{MsgType: msgType, Content: []byte(content)})
+		if err := ws.WriteMessage(websocket.BinaryMessage, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func() *message.ResponseMessage {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := &message.ResponseMessage{}
+		protoUnmarshalFunc(data, msg)
+		return msg
+	}
+
+	send(message.RequestMessage_CAPS, "notice,pixel,clipboard")
+	if msg := read(); msg.MsgType != message.ResponseMessage_CAPS || string(msg.Content) != "notice" {
+		t.Fatalf("Case 1 failed: %v %q", msg.MsgType, msg.Content)
+	}
+	// The requests of features not negotiated are refused.
+	send(message.RequestMessage_SWITCH, "1")
+	if msg := read(); msg.MsgType != message.ResponseMessage_NOTICE || !strings.Contains(string(msg.Content), "SWITCH requests are refused") {
+		t.Fatalf("Case 2 failed: %v %q", msg.MsgType, msg.Content)
+	}
+	// Notices are output to the clients not supporting them.
+	send(message.RequestMessage_CAPS, "switch")
+	if msg := read(); msg.MsgType != message.ResponseMessage_CAPS || string(msg.Content) != "switch" {
+		t.Fatalf("Case 3 failed: %v %q", msg.MsgType, msg.Content)
+	}
+	send(message.RequestMessage_SWITCH, "1")
+	if msg := read(); msg.MsgType != message.ResponseMessage_STDOUT || !strings.Contains(string(msg.Content), "You are in it already") {
+		t.Fatalf("Case 4 failed: %v %q", msg.MsgType, msg.Content)
+	}
+}
+
+func TestRequestCapability(t *testing.T) {
+	cases := []struct {
+		msg  message.RequestMessage
+		name string
+	}{
+		{message.RequestMessage{MsgType: message.RequestMessage_PLAIN}, ""},
+		{message.RequestMessage{MsgType: message.RequestMessage_WINCH}, ""},
+		{message.RequestMessage{MsgType: message.RequestMessage_PONG}, ""},
+		{message.RequestMessage{MsgType: message.RequestMessage_CONTROL}, "control"},
+		{message.RequestMessage{MsgType: message.RequestMessage_SWITCH}, "switch"},
+		{message.RequestMessage{MsgType: message.RequestMessage_QUIT}, "quit"},
+		{message.RequestMessage{MsgType: message.RequestMessage_OPEN_TAB, Tab: 1}, "tabs"},
+		{message.RequestMessage{MsgType: message.RequestMessage_PLAIN, Tab: 1}, "tabs"},
+	}
+	for i, c := range cases {
+		if actual := requestCapability(&c.msg); actual != c.name {
+			t.Errorf("Case %d failed: actual is %q", i+1, actual)
+		}
+	}
+}
@@ -0,0 +1,523 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/laincloud/entry/log"
+)
+
+// Config is the settings of an EntryServer, see LoadConfig for the environment variables.
+type Config struct {
+	Port           string
+	DockerEndpoint string
+	// SSHKeyPath is the private key used when DockerEndpoint is an ssh:// endpoint.
+	SSHKeyPath string
+	// DockerNodes are the comma separated node=endpoint pairs of the docker daemons running
+	// the resolved containers, the others are reached through DockerEndpoint.
+	DockerNodes string
+	// DockerTimeout bounds the docker requests except the streams, zero leaves them unbounded.
+	DockerTimeout time.Duration
+	LainletPort   string
+	LainDomain    string
+	LogLevel      string
+	// Runtime is the container runtime serving the docker API, "docker" or "podman", whose
+	// differences are adapted, see podmanClient.
+	Runtime string
+
+	// MaxHeaderSize bounds the values of the headers read by sessions, larger ones are refused.
+	MaxHeaderSize int
+	// TCPKeepAlive is the period of TCP keepalives on client connections, zero disables them.
+	TCPKeepAlive time.Duration
+
+	// ExecPrefix wraps the shell of enter sessions, e.g. with a session recorder.
+	ExecPrefix  []string
+	ExecRetries int
+	// ExecPoolSize is how many execs are created ahead for each container entered lately, zero
+	// disables pooling, see execPool. Pooled execs not taken within ExecPoolTTL are dropped.
+	ExecPoolSize int
+	ExecPoolTTL  time.Duration
+	// ExitCommands is a JSON file of the commands run in the containers of the enter sessions
+	// per application when they end, see ExitRule.
+	ExitCommands string
+	// DetachKeys is the sequence detaching the client from its session in the format of
+	// docker, none by default as the sequence never reaches the shells then; "ctrl-p,ctrl-q"
+	// of docker attach is the one suggested. An enter session goes on without its client for
+	// DetachTimeout, zero disables detaching, until the client reattaches with the ID of the
+	// session in the reattach header.
+	DetachKeys    string
+	DetachTimeout time.Duration
+	// ResumeBuffer is how many bytes of the recent output of a detachable session are kept.
+	// A client reattaching with the resume-offset header, the bytes of output of the first
+	// shell it received, is replayed what it missed since. Zero disables it.
+	ResumeBuffer int
+	// LoginShell and InteractiveShell start the default shell with -l and -i, so that it
+	// sources the profiles of the container like the app does.
+	LoginShell       bool
+	InteractiveShell bool
+	// DetectShell probes the shells of the images lacking bash once, from the preferred
+	// ones and /etc/shells, so that their sessions start the shell they have instead.
+	DetectShell bool
+	// ShellProbe writes a newline to the shells not showing anything once started, and tells
+	// the client if they still show nothing after it, zero disables the probe.
+	ShellProbe time.Duration
+	// EarlyOutputWait holds the first output of enter sessions until the client sends its
+	// first message, so that the prompt isn't lost by a client not rendering yet, at most
+	// for so long. Zero sends the output at once.
+	EarlyOutputWait time.Duration
+	// PingInterval is the interval of alive detection pings, zero disables them.
+	PingInterval time.Duration
+	PingSequence bool
+	// PingRTT stamps the pings with their time, so that clients echoing them in PONG
+	// requests are told their round trip time in RTT messages.
+	PingRTT bool
+	// ResizeWindow coalesces the WINCH messages within it, zero resizes on every message.
+	ResizeWindow time.Duration
+	// MaxCols and MaxRows bound the terminal sizes asked by clients, larger ones are clamped.
+	MaxCols int
+	MaxRows int
+	// WriteTimeout is how long a client may keep a message unread before it's disconnected.
+	WriteTimeout time.Duration
+	// OutputLimit is the most bytes of output sent by a session before it's ended, zero is unlimited.
+	OutputLimit int
+	// OutputEncoding is the encoding of the container output transcoded to UTF-8, like gbk,
+	// unless the client asks another one, see outputEncodings. Empty is UTF-8.
+	OutputEncoding string
+	// ExecFields allows roles to give the fields of exec specs, like "admin=cmd,user;developer=env",
+	// see ExecSpec.
+	ExecFields string
+	// ExecEnvAllow and ExecEnvDeny are the patterns of the names of the variables exec specs
+	// may set, see envFilter. ExecEnvDeny is defaultExecEnvDeny by default, "LD_*" lets
+	// them set PATH.
+	ExecEnvAllow string
+	ExecEnvDeny  string
+	// RolePolicies is a JSON file of what each role may do, in place of ExecFields and of the
+	// features of admins, see RolePolicy.
+	RolePolicies string
+	// ReadonlyNotice tells the clients entering containers with a read-only root filesystem.
+	ReadonlyNotice bool
+	// NodeName identifies the host of this server in the logs and events of sessions, its
+	// hostname if empty. NodeBanner tells it to the clients as their sessions start.
+	NodeName   string
+	NodeBanner bool
+	// Accounting logs the CPU time and bytes used by every enter session when it ends.
+	Accounting bool
+	// RedactPattern is a regexp of the secrets masked in the output of sessions, even when
+	// they're split over several reads, see redactReader.
+	RedactPattern string
+	// OutputTransform rewrites the output of sessions after redaction, it can only be
+	// set by code embedding entry.
+	OutputTransform OutputTransform
+
+	// AuthHTTPClient is the client of the authorization requests to the lain console, e.g.
+	// with a proxy or custom CAs, it can only be set by code embedding entry. Nil is a plain
+	// client timing out after consoleAuthTimeout.
+	AuthHTTPClient *http.Client
+
+	// AuthCacheTTL is how long the authorizations of the lain console are cached, zero disables it.
+	AuthCacheTTL time.Duration
+	// AuthCheck serves /authcheck, which tells how a token is authorized on an application
+	// without opening a session, for debugging the authorization.
+	AuthCheck bool
+	// StateApp serves /debug/state to the roles with the state feature on this application,
+	// e.g. the one of entry itself, see serveState. Empty disables it.
+	StateApp string
+	// FakeAuth is "allow" or "deny" to replace the lain authorization, for tests only.
+	FakeAuth       string
+	FakeAuthTokens string
+	// BreakGlassTokenHash is the hex SHA-256 of an emergency token authorizing its holders
+	// as admins of every application without the lain console, see breakGlass. It needs
+	// WebhookURL, where every use of it is posted.
+	BreakGlassTokenHash string
+	// ContainerTokenKey is the key shared with the console to sign container tokens, which
+	// grant the container of a session, see containerTokens. ContainerTokenRequired refuses
+	// the sessions naming their container without one.
+	ContainerTokenKey      string
+	ContainerTokenRequired bool
+	// SafeMode disables the destructive features during incidents, like debug sessions,
+	// exec specs and break-glass access, see errSafeMode. It's off by default.
+	SafeMode bool
+	// Resolver resolves the containers by the lainlet with "lain", or by the labels of
+	// kubernetes pods on the docker node with "kubernetes", see KubernetesResolver.
+	Resolver string
+	// StaticResolver is a JSON file of containers which replaces the lainlet resolution.
+	StaticResolver string
+	AllowApps      string
+	DenyApps       string
+	// InstancePolicy limits the instances enterable per application, like "hello=1-3,!2",
+	// see newInstancePolicy.
+	InstancePolicy string
+	// ExclusiveApps are the patterns of the applications whose containers take one enter
+	// session at a time, see exclusivePolicy.
+	ExclusiveApps string
+	// ResolveCacheTTL is how long the resolved containers are cached, zero disables it. A
+	// cached container is checked to still run the instance before it's used.
+	ResolveCacheTTL time.Duration
+	// EnforceAppLabel refuses the containers whose lain labels show another application
+	// than the authorized one.
+	EnforceAppLabel bool
+	// LocalPodOnly confines the sessions to the containers of the pod PodName of
+	// PodNamespace, for entry deployed as a sidecar, see localPod.
+	LocalPodOnly bool
+	PodNamespace string
+	PodName      string
+
+	WebhookURL    string
+	WebhookEvents string
+	// TraceEndpoint is the OTLP/HTTP endpoint the spans of sessions are exported to in JSON,
+	// like "http://collector:4318/v1/traces", empty disables tracing.
+	TraceEndpoint string
+
+	CORSOrigins     string
+	CORSMethods     string
+	CORSHeaders     string
+	CORSCredentials bool
+
+	// TLSCert and TLSKey make the server listen with TLS.
+	TLSCert string
+	TLSKey  string
+	// TLSClientCA verifies client certificates, which are mapped to identities by the
+	// JSON rules file MTLSRules, see CertRule.
+	TLSClientCA  string
+	MTLSRequired bool
+	MTLSRules    string
+
+	// DebugImage enables debug sessions for admins, or the roles with the debug feature, in
+	// sidecars of the image with the comma separated DebugCapabilities added, or fully
+	// privileged with DebugPrivileged.
+	DebugImage        string
+	DebugCapabilities string
+	DebugPrivileged   bool
+}
+
+// DefaultConfig returns the settings used when nothing is configured.
+func DefaultConfig() Config {
+	return Config{
+		Port:           "80",
+		LogLevel:       "info",
+		Resolver:       resolverLain,
+		ExecRetries:    defaultExecRetries,
+		ExecPoolTTL:    defaultExecPoolTTL,
+		PingInterval:   aliveDecectionInterval,
+		ResizeWindow:   defaultResizeWindow,
+		MaxCols:        defaultMaxCols,
+		MaxRows:        defaultMaxRows,
+		WriteTimeout:   defaultWriteTimeout,
+		DockerTimeout:  defaultDockerTimeout,
+		Runtime:        runtimeDocker,
+		TCPKeepAlive:   defaultTCPKeepAlive,
+		ReadonlyNotice: true,
+		MaxHeaderSize:  defaultMaxHeaderSize,
+		DetachTimeout:  defaultDetachTimeout,
+		ResumeBuffer:   defaultResumeBuffer,
+		ExecEnvDeny:    defaultExecEnvDeny,
+		AuthCacheTTL:   defaultAuthCacheTTL,
+	}
+}
+
+// envLoader reads typed settings from environment variables, remembering the first invalid one.
+type envLoader struct {
+	getenv func(string) string
+	err    error
+}
+
+func (l *envLoader) string(name string, value *string) {
+	if v := l.getenv(name); v != "" {
+		*value = v
+	}
+}
+
+func (l *envLoader) int(name string, value *int) {
+	if v := l.getenv(name); v != "" {
+		i, err := strconv.Atoi(v)
+		if err != nil && l.err == nil {
+			l.err = fmt.Errorf("%s must be an integer: %q", name, v)
+		}
+		if err == nil {
+			*value = i
+		}
+	}
+}
+
+func (l *envLoader) bool(name string, value *bool) {
+	if v := l.getenv(name); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil && l.err == nil {
+			l.err = fmt.Errorf("%s must be a boolean: %q", name, v)
+		}
+		if err == nil {
+			*value = b
+		}
+	}
+}
+
+func (l *envLoader) milliseconds(name string, value *time.Duration) {
+	milliseconds := int(*value / time.Millisecond)
+	l.int(name, &milliseconds)
+	*value = time.Duration(milliseconds) * time.Millisecond
+}
+
+func (l *envLoader) seconds(name string, value *time.Duration) {
+	seconds := int(*value / time.Second)
+	l.int(name, &seconds)
+	*value = time.Duration(seconds) * time.Second
+}
+
+// LoadConfig reads the settings from the environment through getenv, usually os.Getenv,
+// on top of DefaultConfig, and validates them.
+func LoadConfig(getenv func(string) string) (Config, error) {
+	c := DefaultConfig()
+	l := &envLoader{getenv: getenv}
+	l.string("ENTRY_PORT", &c.Port)
+	// Entry talks to the swarm of the lain cluster unless told otherwise.
+	c.DockerEndpoint = net.JoinHostPort("swarm.lain", getenv("SWARM_PORT"))
+	l.string("ENTRY_DOCKER_ENDPOINT", &c.DockerEndpoint)
+	l.string("ENTRY_SSH_KEY", &c.SSHKeyPath)
+	l.string("ENTRY_DOCKER_NODES", &c.DockerNodes)
+	l.seconds("ENTRY_DOCKER_TIMEOUT", &c.DockerTimeout)
+	l.string("ENTRY_RUNTIME", &c.Runtime)
+	l.string("LAINLET_PORT", &c.LainletPort)
+	l.string("LAIN_DOMAIN", &c.LainDomain)
+	l.string("ENTRY_LOG_LEVEL", &c.LogLevel)
+	l.seconds("ENTRY_TCP_KEEPALIVE", &c.TCPKeepAlive)
+
+	c.ExecPrefix = strings.Fields(getenv("ENTRY_EXEC_PREFIX"))
+	l.int("ENTRY_EXEC_RETRIES", &c.ExecRetries)
+	l.int("ENTRY_EXEC_POOL_SIZE", &c.ExecPoolSize)
+	l.seconds("ENTRY_EXEC_POOL_TTL", &c.ExecPoolTTL)
+	l.string("ENTRY_EXIT_COMMANDS", &c.ExitCommands)
+	l.seconds("ENTRY_PING_INTERVAL", &c.PingInterval)
+	l.bool("ENTRY_PING_SEQUENCE", &c.PingSequence)
+	l.bool("ENTRY_PING_RTT", &c.PingRTT)
+	l.milliseconds("ENTRY_RESIZE_WINDOW_MS", &c.ResizeWindow)
+	l.int("ENTRY_MAX_COLS", &c.MaxCols)
+	l.int("ENTRY_MAX_ROWS", &c.MaxRows)
+	l.milliseconds("ENTRY_SHELL_PROBE_MS", &c.ShellProbe)
+	l.milliseconds("ENTRY_EARLY_OUTPUT_WAIT_MS", &c.EarlyOutputWait)
+	l.seconds("ENTRY_WRITE_TIMEOUT", &c.WriteTimeout)
+	l.int("ENTRY_OUTPUT_LIMIT", &c.OutputLimit)
+	l.int("ENTRY_MAX_HEADER_SIZE", &c.MaxHeaderSize)
+	l.string("ENTRY_DETACH_KEYS", &c.DetachKeys)
+	l.seconds("ENTRY_DETACH_TIMEOUT", &c.DetachTimeout)
+	l.int("ENTRY_RESUME_BUFFER", &c.ResumeBuffer)
+	l.bool("ENTRY_LOGIN_SHELL", &c.LoginShell)
+	l.bool("ENTRY_INTERACTIVE_SHELL", &c.InteractiveShell)
+	l.bool("ENTRY_DETECT_SHELL", &c.DetectShell)
+	l.string("ENTRY_OUTPUT_ENCODING", &c.OutputEncoding)
+	l.string("ENTRY_EXEC_FIELDS", &c.ExecFields)
+	l.string("ENTRY_EXEC_ENV_ALLOW", &c.ExecEnvAllow)
+	l.string("ENTRY_EXEC_ENV_DENY", &c.ExecEnvDeny)
+	l.string("ENTRY_ROLE_POLICIES", &c.RolePolicies)
+	l.bool("ENTRY_READONLY_NOTICE", &c.ReadonlyNotice)
+	l.string("ENTRY_NODE_NAME", &c.NodeName)
+	l.bool("ENTRY_NODE_BANNER", &c.NodeBanner)
+	l.bool("ENTRY_ACCOUNTING", &c.Accounting)
+	l.string("ENTRY_REDACT_PATTERN", &c.RedactPattern)
+
+	l.seconds("ENTRY_AUTH_CACHE_TTL", &c.AuthCacheTTL)
+	l.bool("ENTRY_AUTH_CHECK", &c.AuthCheck)
+	l.string("ENTRY_STATE_APP", &c.StateApp)
+	l.string("ENTRY_FAKE_AUTH", &c.FakeAuth)
+	l.string("ENTRY_FAKE_AUTH_TOKENS", &c.FakeAuthTokens)
+	l.string("ENTRY_RESOLVER", &c.Resolver)
+	l.string("ENTRY_STATIC_RESOLVER", &c.StaticResolver)
+	l.seconds("ENTRY_RESOLVE_CACHE_TTL", &c.ResolveCacheTTL)
+	l.string("ENTRY_ALLOW_APPS", &c.AllowApps)
+	l.string("ENTRY_DENY_APPS", &c.DenyApps)
+	l.string("ENTRY_INSTANCE_POLICY", &c.InstancePolicy)
+	l.string("ENTRY_EXCLUSIVE_APPS", &c.ExclusiveApps)
+	l.bool("ENTRY_ENFORCE_APP_LABEL", &c.EnforceAppLabel)
+	l.bool("ENTRY_LOCAL_POD_ONLY", &c.LocalPodOnly)
+	l.string("ENTRY_POD_NAMESPACE", &c.PodNamespace)
+	l.string("ENTRY_POD_NAME", &c.PodName)
+
+	l.string("ENTRY_WEBHOOK_URL", &c.WebhookURL)
+	l.string("ENTRY_WEBHOOK_EVENTS", &c.WebhookEvents)
+	l.string("ENTRY_TRACE_ENDPOINT", &c.TraceEndpoint)
+	l.string("ENTRY_BREAK_GLASS_TOKEN_SHA256", &c.BreakGlassTokenHash)
+	l.string("ENTRY_CONTAINER_TOKEN_KEY", &c.ContainerTokenKey)
+	l.bool("ENTRY_CONTAINER_TOKEN_REQUIRED", &c.ContainerTokenRequired)
+	l.bool("ENTRY_SAFE_MODE", &c.SafeMode)
+
+	l.string("ENTRY_CORS_ORIGINS", &c.CORSOrigins)
+	l.string("ENTRY_CORS_METHODS", &c.CORSMethods)
+	l.string("ENTRY_CORS_HEADERS", &c.CORSHeaders)
+	l.bool("ENTRY_CORS_CREDENTIALS", &c.CORSCredentials)
+
+	l.string("ENTRY_TLS_CERT", &c.TLSCert)
+	l.string("ENTRY_TLS_KEY", &c.TLSKey)
+	l.string("ENTRY_TLS_CLIENT_CA", &c.TLSClientCA)
+	l.bool("ENTRY_MTLS_REQUIRED", &c.MTLSRequired)
+	l.string("ENTRY_MTLS_RULES", &c.MTLSRules)
+
+	l.string("ENTRY_DEBUG_IMAGE", &c.DebugImage)
+	l.string("ENTRY_DEBUG_CAPS", &c.DebugCapabilities)
+	l.bool("ENTRY_DEBUG_PRIVILEGED", &c.DebugPrivileged)
+	if l.err != nil {
+		return c, l.err
+	}
+	return c, c.Validate()
+}
+
+// Validate checks the settings which would otherwise fail late or silently.
+func (c Config) Validate() error {
+	if port, err := strconv.Atoi(c.Port); err != nil || port <= 0 || port > 65535 {
+		return fmt.Errorf("invalid port %q", c.Port)
+	}
+	if c.DockerEndpoint == "" {
+		return fmt.Errorf("docker endpoint is required")
+	}
+	// Fail closed, the clients are authorized against the console of the lain domain
+	// unless the authorization is replaced explicitly.
+	if c.LainDomain == "" && c.FakeAuth == "" {
+		return fmt.Errorf("lain domain is required to authorize clients, unless fake auth replaces the authorization")
+	}
+	if _, err := log.ParseLevel(c.LogLevel); err != nil {
+		return err
+	}
+	if _, err := parseDockerNodes(c.DockerNodes); err != nil {
+		return err
+	}
+	if c.Runtime != runtimeDocker && c.Runtime != runtimePodman {
+		return fmt.Errorf("unknown runtime %q, expected %s or %s", c.Runtime, runtimeDocker, runtimePodman)
+	}
+	// Podman containers have no labels of the kubelet.
+	if c.Runtime == runtimePodman && c.Resolver == resolverKubernetes {
+		return fmt.Errorf("the %s resolver doesn't support the %s runtime", c.Resolver, c.Runtime)
+	}
+	if _, err := newExecSpecPolicy(c.ExecFields); err != nil {
+		return err
+	}
+	if c.RolePolicies != "" && c.ExecFields != "" {
+		return fmt.Errorf("exec fields are given by the role policies, not by themselves")
+	}
+	if _, err := newInstancePolicy(c.InstancePolicy); err != nil {
+		return err
+	}
+	switch c.Resolver {
+	case "", resolverLain:
+	case resolverKubernetes:
+		if c.StaticResolver != "" {
+			return fmt.Errorf("static resolver replaces the %s resolver", c.Resolver)
+		}
+		// Pods have no lain labels.
+		if c.EnforceAppLabel {
+			return fmt.Errorf("app labels can't be enforced with the %s resolver", c.Resolver)
+		}
+	default:
+		return fmt.Errorf("unknown resolver %q, expected %s or %s", c.Resolver, resolverLain, resolverKubernetes)
+	}
+	if c.LocalPodOnly && (c.PodNamespace == "" || c.PodName == "") {
+		return fmt.Errorf("local pod only needs the pod namespace and name")
+	}
+	if c.ResolveCacheTTL < 0 || c.ResolveCacheTTL > maxResolveCacheTTL {
+		return fmt.Errorf("resolve cache ttl %s is not between 0 and %s", c.ResolveCacheTTL, maxResolveCacheTTL)
+	}
+	if c.AuthCacheTTL < 0 {
+		return fmt.Errorf("auth cache ttl can't be negative: %s", c.AuthCacheTTL)
+	}
+	if _, err := parseDetachKeys(c.DetachKeys); err != nil {
+		return err
+	}
+	if c.DetachTimeout < 0 {
+		return fmt.Errorf("detach timeout can't be negative: %s", c.DetachTimeout)
+	}
+	if c.ResumeBuffer < 0 {
+		return fmt.Errorf("resume buffer can't be negative: %d", c.ResumeBuffer)
+	}
+	if c.MaxHeaderSize <= 0 {
+		return fmt.Errorf("max header size must be positive: %d", c.MaxHeaderSize)
+	}
+	if c.OutputLimit < 0 {
+		return fmt.Errorf("output limit can't be negative: %d", c.OutputLimit)
+	}
+	if _, err := parseOutputEncoding(c.OutputEncoding); err != nil {
+		return err
+	}
+	if c.TCPKeepAlive < 0 {
+		return fmt.Errorf("tcp keepalive can't be negative: %s", c.TCPKeepAlive)
+	}
+	if c.DockerTimeout < 0 {
+		return fmt.Errorf("docker timeout can't be negative: %s", c.DockerTimeout)
+	}
+	if c.ExecRetries < 0 {
+		return fmt.Errorf("exec retries can't be negative: %d", c.ExecRetries)
+	}
+	if c.ExecPoolSize < 0 || c.ExecPoolSize > maxExecPoolSize {
+		return fmt.Errorf("exec pool size %d is not between 0 and %d", c.ExecPoolSize, maxExecPoolSize)
+	}
+	if c.ExecPoolSize > 0 && c.ExecPoolTTL <= 0 {
+		return fmt.Errorf("exec pool ttl must be positive: %s", c.ExecPoolTTL)
+	}
+	if c.PingInterval < 0 {
+		return fmt.Errorf("ping interval can't be negative: %s", c.PingInterval)
+	}
+	if c.WriteTimeout < 0 {
+		return fmt.Errorf("write timeout can't be negative: %s", c.WriteTimeout)
+	}
+	if c.ResizeWindow < 0 {
+		return fmt.Errorf("resize window can't be negative: %s", c.ResizeWindow)
+	}
+	if c.MaxCols < 1 || c.MaxCols > maxTermDimension || c.MaxRows < 1 || c.MaxRows > maxTermDimension {
+		return fmt.Errorf("max terminal size %dx%d is not between 1 and %d", c.MaxCols, c.MaxRows, maxTermDimension)
+	}
+	if c.ShellProbe < 0 {
+		return fmt.Errorf("shell probe can't be negative: %s", c.ShellProbe)
+	}
+	if c.EarlyOutputWait < 0 || c.EarlyOutputWait > maxEarlyOutputWait {
+		return fmt.Errorf("early output wait %s is not between 0 and %s", c.EarlyOutputWait, maxEarlyOutputWait)
+	}
+	if _, err := regexp.Compile(c.RedactPattern); err != nil {
+		return fmt.Errorf("invalid redact pattern: %s", err.Error())
+	}
+	if c.FakeAuth != "" && c.FakeAuth != "allow" && c.FakeAuth != "deny" {
+		return fmt.Errorf("unknown fake auth mode %q", c.FakeAuth)
+	}
+	if _, err := newAppFilter(c.AllowApps, c.DenyApps); err != nil {
+		return err
+	}
+	if _, err := newEnvFilter(c.ExecEnvAllow, c.ExecEnvDeny); err != nil {
+		return err
+	}
+	if _, err := newExclusivePolicy(c.ExclusiveApps); err != nil {
+		return err
+	}
+	if c.WebhookURL != "" {
+		if u, err := url.Parse(c.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid webhook url %q", c.WebhookURL)
+		}
+	}
+	if c.TraceEndpoint != "" {
+		if u, err := url.Parse(c.TraceEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid trace endpoint %q", c.TraceEndpoint)
+		}
+	}
+	if _, err := newBreakGlass(c.BreakGlassTokenHash); err != nil {
+		return err
+	}
+	if c.BreakGlassTokenHash != "" && c.WebhookURL == "" {
+		return fmt.Errorf("break-glass access needs a webhook url to post its uses")
+	}
+	if _, err := newContainerTokens(c.ContainerTokenKey, c.ContainerTokenRequired); err != nil {
+		return err
+	}
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return fmt.Errorf("TLS cert and key must be given together")
+	}
+	if c.TLSClientCA != "" && c.TLSCert == "" {
+		return fmt.Errorf("client CA is given but TLS is off")
+	}
+	if (c.MTLSRequired || c.MTLSRules != "") && c.TLSClientCA == "" {
+		return fmt.Errorf("mTLS needs a client CA")
+	}
+	if (c.DebugCapabilities != "" || c.DebugPrivileged) && c.DebugImage == "" {
+		return fmt.Errorf("debug privileges are given but debug image is not")
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func envOf(env map[string]string) func(string) string {
+	return func(name string) string { return env[name] }
+}
+
+func TestLoadConfig(t *testing.T) {
+	config, err := LoadConfig(envOf(map[string]string{"SWARM_PORT": "2376", "LAIN_DOMAIN": "lain.local"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Port != "80" || config.DockerEndpoint != "swarm.lain:2376" || config.LainDomain != "lain.local" ||
+		config.ExecRetries != defaultExecRetries || config.PingInterval != aliveDecectionInterval || !config.ReadonlyNotice {
+		t.Errorf("Case 1 failed: config is %+v", config)
+	}
+
+	config, err = LoadConfig(envOf(map[string]string{
+		"ENTRY_DOCKER_ENDPOINT": "unix:///var/run/docker.sock",
+		"ENTRY_FAKE_AUTH":       "allow",
+		"ENTRY_EXEC_PREFIX":     "script -q",
+		"ENTRY_PING_INTERVAL":   "0",
+		"ENTRY_PING_SEQUENCE":   "true",
+		"ENTRY_READONLY_NOTICE": "false",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.DockerEndpoint != "unix:///var/run/docker.sock" || !reflect.DeepEqual(config.ExecPrefix, []string{"script", "-q"}) ||
+		config.PingInterval != 0 || !config.PingSequence || config.ReadonlyNotice {
+		t.Errorf("Case 2 failed: config is %+v", config)
+	}
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	cases := []map[string]string{
+		{"ENTRY_PORT": "http"},
+		{"ENTRY_EXEC_RETRIES": "many"},
+		{"ENTRY_EXEC_RETRIES": "-1"},
+		{"ENTRY_PING_INTERVAL": "-10"},
+		{"ENTRY_WRITE_TIMEOUT": "-1"},
+		{"ENTRY_DOCKER_TIMEOUT": "-5"},
+		{"ENTRY_TCP_KEEPALIVE": "-1"},
+		{"ENTRY_OUTPUT_LIMIT": "-1"},
+		{"ENTRY_EXEC_FIELDS": "admin=cmd,root"},
+		{"ENTRY_AUTH_CACHE_TTL": "-1"},
+		{"ENTRY_OUTPUT_ENCODING": "ebcdic"},
+		{"ENTRY_LOGIN_SHELL": "sometimes"},
+		{"ENTRY_MAX_HEADER_SIZE": "0"},
+		{"ENTRY_DETACH_KEYS": "ctrl-1"},
+		{"ENTRY_DETACH_TIMEOUT": "-1"},
+		{"ENTRY_RESUME_BUFFER": "-1"},
+		{"ENTRY_EXEC_ENV_DENY": "LD_["},
+		{"ENTRY_INSTANCE_POLICY": "hello=3-1"},
+		{"ENTRY_RESOLVER": "swarm"},
+		{"ENTRY_SHELL_PROBE_MS": "-1"},
+		{"ENTRY_RESOLVER": "kubernetes", "ENTRY_ENFORCE_APP_LABEL": "true"},
+		{"ENTRY_BREAK_GLASS_TOKEN_SHA256": "not hex", "ENTRY_WEBHOOK_URL": "http://audit"},
+		{"ENTRY_BREAK_GLASS_TOKEN_SHA256": strings.Repeat("ab", 32)},
+		{"ENTRY_DOCKER_NODES": "node1=tcp://10.0.0.1:2375,node2"},
+		{"ENTRY_PING_SEQUENCE": "sometimes"},
+		{"ENTRY_ACCOUNTING": "maybe"},
+		{"ENTRY_REDACT_PATTERN": "("},
+		{"ENTRY_ENFORCE_APP_LABEL": "yes please"},
+		{"ENTRY_LOG_LEVEL": "verbose"},
+		{"ENTRY_FAKE_AUTH": "maybe"},
+		{"ENTRY_ALLOW_APPS": "["},
+		{"ENTRY_WEBHOOK_URL": "ftp://audit"},
+		{"ENTRY_TRACE_ENDPOINT": "collector:4318"},
+		{"ENTRY_MAX_COLS": "0"},
+		{"ENTRY_MAX_ROWS": "70000"},
+		{"ENTRY_EXEC_POOL_SIZE": "100"},
+		{"ENTRY_ROLE_POLICIES": "/etc/entry/roles.json", "ENTRY_EXEC_FIELDS": "admin=cmd"},
+		{"ENTRY_EXEC_POOL_SIZE": "2", "ENTRY_EXEC_POOL_TTL": "0"},
+		{"ENTRY_EARLY_OUTPUT_WAIT_MS": "60000"},
+		{"ENTRY_LOCAL_POD_ONLY": "true", "ENTRY_POD_NAME": "web-0"},
+		{"ENTRY_RESOLVE_CACHE_TTL": "3600"},
+		{"ENTRY_EXCLUSIVE_APPS": "hello,["},
+		{"ENTRY_CONTAINER_TOKEN_KEY": "short"},
+		{"ENTRY_CONTAINER_TOKEN_REQUIRED": "true"},
+		{"ENTRY_RUNTIME": "containerd"},
+		{"ENTRY_RUNTIME": "podman", "ENTRY_RESOLVER": "kubernetes"},
+		{"ENTRY_TLS_CERT": "/etc/entry/cert.pem"},
+		{"ENTRY_TLS_CLIENT_CA": "/etc/entry/ca.pem"},
+		{"ENTRY_TLS_CERT": "cert.pem", "ENTRY_TLS_KEY": "key.pem", "ENTRY_MTLS_REQUIRED": "true"},
+		{"ENTRY_DEBUG_PRIVILEGED": "true"},
+		{"LAIN_DOMAIN": ""},
+	}
+	for i, env := range cases {
+		// Every case is invalid by itself, not by the missing authorization.
+		if _, ok := env["LAIN_DOMAIN"]; !ok {
+			env["LAIN_DOMAIN"] = "lain.local"
+		}
+		if _, err := LoadConfig(envOf(env)); err == nil {
+			t.Errorf("Case %d failed: %v is accepted", i+1, env)
+		}
+	}
+}
+
+func TestDefaultConfigValid(t *testing.T) {
+	config := DefaultConfig()
+	config.DockerEndpoint = "unix:///var/run/docker.sock"
+	config.LainDomain = "lain.local"
+	config.PingInterval = 5 * time.Second
+	if err := config.Validate(); err != nil {
+		t.Errorf("Default config is invalid: %s", err.Error())
+	}
+	// Detaching is opt-in, the keys would never reach the shells otherwise.
+	if config.DetachKeys != "" {
+		t.Errorf("Default detach keys are %q", config.DetachKeys)
+	}
+}
@@ -0,0 +1,281 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/laincloud/entry/log"
+	"github.com/laincloud/entry/message"
+	"golang.org/x/text/encoding"
+)
+
+// defaultWriteTimeout is generous, a client reading nothing for so long is hardly alive.
+const defaultWriteTimeout = 60 * time.Second
+
+// maxEarlyOutputWait bounds how long the first output waits for the client, see holdOutput.
+const maxEarlyOutputWait = 10 * time.Second
+
+// safeConn guards the writes to a websocket connection with a mutex, as gorilla/websocket
+// supports only one concurrent writer while a session writes from several goroutines.
+// Reads are left to the embedded Conn because each session has a single reader.
+// The bytes of the messages in and out are counted for accounting.
+type safeConn struct {
+	bytesIn  int64
+	bytesOut int64
+	// outputBytes is the output of the session sent so far, bounded by outputLimit if positive.
+	outputBytes   int64
+	outputLimit   int64
+	outputLimited int32
+	*websocket.Conn
+	writeLock sync.Mutex
+	// writeTimeout bounds each write, so that a client not reading can't stall the session.
+	writeTimeout time.Duration
+	// outputEncoding is the encoding of the output of the session transcoded to UTF-8,
+	// nil if it's UTF-8 already.
+	outputEncoding encoding.Encoding
+	// textFrames sends binary messages as text frames, for the web clients behind
+	// intermediaries mangling binary frames. The messages must be valid UTF8 then.
+	textFrames bool
+	// lineBuffered sends the output by whole lines, for the clients consuming it line by line,
+	// at the cost of holding partial lines like prompts until their end.
+	lineBuffered bool
+	// pingFrames keeps the session alive by websocket ping frames rather than PING messages,
+	// for the web clients relying on the keepalive of the browser.
+	pingFrames bool
+	// barePings sends PING messages without content, for the web clients rendering the
+	// content of every message.
+	barePings bool
+	// activity is the last activity of the registered session, if not nil, see touch.
+	activity *int64
+	// totals counts the bytes of the server, if not nil.
+	totals *byteTotals
+	// detached drops the writes while the session has no client, see detach.
+	detached bool
+	// replay keeps the recent output of a detachable session for its client reattaching,
+	// nil if it's not kept, see writeOutput.
+	replay *outputRing
+	// readErr is the error which ended the reads, telling how the client went away.
+	readLock sync.Mutex
+	readErr  error
+	// caps is the capabilities negotiated with the client, see handleCaps.
+	caps atomic.Value
+	// ready is closed once the client sends a message, nil if the output doesn't wait for
+	// it, see holdOutput.
+	ready     chan struct{}
+	readyOnce sync.Once
+	readyWait time.Duration
+}
+
+func newSafeConn(ws *websocket.Conn, writeTimeout time.Duration) *safeConn {
+	return &safeConn{Conn: ws, writeTimeout: writeTimeout}
+}
+
+// WriteMessage writes a message within writeTimeout. On timeout the connection is closed,
+// which makes the reader of the session fail too and tears the whole session down.
+func (c *safeConn) WriteMessage(messageType int, data []byte) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	return c.writeMessage(messageType, data)
+}
+
+// writeOutput writes data, the message of the output content of tab, and keeps the output of
+// the first shell for replay, even while detached.
+func (c *safeConn) writeOutput(tab uint32, content, data []byte) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	if c.replay != nil && tab == 0 {
+		c.replay.write(content)
+	}
+	return c.writeMessage(websocket.BinaryMessage, data)
+}
+
+func (c *safeConn) writeMessage(messageType int, data []byte) error {
+	if c.detached {
+		return nil
+	}
+	if c.writeTimeout > 0 {
+		c.Conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	}
+	if c.textFrames && messageType == websocket.BinaryMessage {
+		messageType = websocket.TextMessage
+	}
+	err := c.Conn.WriteMessage(messageType, data)
+	if err == nil {
+		atomic.AddInt64(&c.bytesOut, int64(len(data)))
+		c.totals.addOut(len(data))
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		c.Conn.Close()
+	}
+	return err
+}
+
+// detach closes the connection and drops the writes until reattach, so that the session
+// goes on without its client. The reader of the connection must not be running.
+func (c *safeConn) detach() {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	c.detached = true
+	c.Conn.Close()
+}
+
+// reattach replaces the connection of a detached session by the one of other. The output
+// kept after offset is replayed first, it returns how much of it is lost, or why none is
+// replayed.
+func (c *safeConn) reattach(other *safeConn, offset int64, msgMarshaller Marshaler) (int64, error) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	c.Conn, c.textFrames, c.pingFrames, c.barePings, c.detached = other.Conn, other.textFrames, other.pingFrames, other.barePings, false
+	var (
+		missed []byte
+		lost   int64
+		err    error
+	)
+	if c.replay != nil {
+		missed, lost, err = c.replay.since(offset)
+	} else if offset >= 0 {
+		err = errOutputNotKept
+	}
+	if len(missed) > 0 {
+		data, marshalErr := msgMarshaller(&message.ResponseMessage{MsgType: message.ResponseMessage_STDOUT, Content: missed})
+		if marshalErr == nil {
+			marshalErr = c.writeMessage(websocket.BinaryMessage, data)
+		}
+		if marshalErr != nil {
+			log.Errorf("Replay of %d bytes of output failed: %s", len(missed), marshalErr.Error())
+		}
+	}
+	// The client negotiates again, if ever.
+	c.caps.Store(capabilities(nil))
+	c.readLock.Lock()
+	c.readErr = nil
+	c.readLock.Unlock()
+	return lost, err
+}
+
+func (c *safeConn) setCapabilities(caps capabilities) {
+	c.caps.Store(caps)
+}
+
+// capabilities returns the capabilities negotiated with the client, nil if none is.
+func (c *safeConn) capabilities() capabilities {
+	caps, _ := c.caps.Load().(capabilities)
+	return caps
+}
+
+// takeOutput counts n bytes of output against the output limit. It returns how many of them
+// may still be sent, and whether the limit is reached.
+func (c *safeConn) takeOutput(n int) (int, bool) {
+	if c.outputLimit <= 0 {
+		return n, false
+	}
+	over := atomic.AddInt64(&c.outputBytes, int64(n)) - c.outputLimit
+	if over <= 0 {
+		return n, false
+	}
+	if over > int64(n) {
+		over = int64(n)
+	}
+	return n - int(over), true
+}
+
+// limitOutput marks the session over its output limit, it returns false if it's marked already.
+func (c *safeConn) limitOutput() bool {
+	return atomic.CompareAndSwapInt32(&c.outputLimited, 0, 1)
+}
+
+// overOutputLimit reports whether the session has reached its output limit.
+func (c *safeConn) overOutputLimit() bool {
+	return atomic.LoadInt32(&c.outputLimited) == 1
+}
+
+// holdOutput makes the output wait for the first message of the client at most wait, as
+// clients may not render it until they're ready and say so. It's set before any output.
+func (c *safeConn) holdOutput(wait time.Duration) {
+	c.ready, c.readyWait = make(chan struct{}), wait
+}
+
+// markReady tells the output held by holdOutput to go on.
+func (c *safeConn) markReady() {
+	if c.ready != nil {
+		c.readyOnce.Do(func() { close(c.ready) })
+	}
+}
+
+// awaitReady waits until the client is ready for output, see holdOutput. Only the first
+// output waits, the output read meanwhile stays in the pipe of the exec.
+func (c *safeConn) awaitReady(ctx context.Context) {
+	if c.ready == nil {
+		return
+	}
+	select {
+	case <-c.ready:
+		return
+	default:
+	}
+	timer := time.NewTimer(c.readyWait)
+	defer timer.Stop()
+	select {
+	case <-c.ready:
+	case <-timer.C:
+		c.markReady()
+	case <-ctx.Done():
+	}
+}
+
+func (c *safeConn) ReadMessage() (int, []byte, error) {
+	messageType, data, err := c.Conn.ReadMessage()
+	atomic.AddInt64(&c.bytesIn, int64(len(data)))
+	c.totals.addIn(len(data))
+	if err != nil {
+		c.readLock.Lock()
+		c.readErr = err
+		c.readLock.Unlock()
+	} else {
+		c.touch()
+	}
+	return messageType, data, err
+}
+
+// touch records the activity of the session, the messages of the client and the output,
+// not the pings which keep idle sessions alive.
+func (c *safeConn) touch() {
+	if c.activity != nil {
+		atomic.StoreInt64(c.activity, time.Now().UnixNano())
+	}
+}
+
+// isNormalClose reports whether err is the client closing the connection on purpose.
+func isNormalClose(err error) bool {
+	return websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway)
+}
+
+// disconnectReason tells how the client went away, by the error which ended the reads.
+func (c *safeConn) disconnectReason() string {
+	c.readLock.Lock()
+	defer c.readLock.Unlock()
+	if c.readErr == nil || isNormalClose(c.readErr) {
+		return reasonClientDisconnected
+	}
+	return reasonConnectionLost
+}
+
+// logReadEnd logs the error which ended the reads of a session, normal closes are no errors.
+func logReadEnd(kind string, err error) {
+	if isNormalClose(err) || errors.Is(err, net.ErrClosed) {
+		log.Debugf("%s ended: %s", kind, err.Error())
+	} else {
+		log.Errorf("%s ended: %s", kind, err.Error())
+	}
+}
+
+func (c *safeConn) WriteControl(messageType int, data []byte, deadline time.Time) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	return c.Conn.WriteControl(messageType, data, deadline)
+}
@@ -0,0 +1,238 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gorilla/websocket"
+	"github.com/laincloud/entry/message"
+)
+
+func TestSafeConnConcurrentWrite(t *testing.T) {
+	const writers, messages = 4, 50
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ws := newSafeConn(conn, 0)
+		defer ws.Close()
+		wg := &sync.WaitGroup{}
+		wg.Add(writers)
+		for i := 0; i < writers; i++ {
+			go func() {
+				defer wg.Done()
+				for j := 0; j < messages; j++ {
+					ws.WriteMessage(websocket.BinaryMessage, []byte("data"))
+				}
+			}()
+		}
+		wg.Wait()
+	}))
+	defer ts.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial(strings.Replace(ts.URL, "http", "ws", 1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	for i := 0; i < writers*messages; i++ {
+		if _, data, err := ws.ReadMessage(); err != nil || string(data) != "data" {
+			t.Fatalf("Message %d is broken: %q, %v", i, data, err)
+		}
+	}
+}
+
+func TestSafeConnWriteTimeout(t *testing.T) {
+	result := make(chan error, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ws := newSafeConn(conn, 100*time.Millisecond)
+		defer ws.Close()
+		data := make([]byte, 64*1024)
+		for {
+			if err = ws.WriteMessage(websocket.BinaryMessage, data); err != nil {
+				result <- err
+				return
+			}
+		}
+	}))
+	defer ts.Close()
+
+	// The client never reads, until the buffers are full.
+	ws, _, err := websocket.DefaultDialer.Dial(strings.Replace(ts.URL, "http", "ws", 1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	select {
+	case err = <-result:
+		if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Write to a non-draining client hangs")
+	}
+}
+
+func TestEnterTextFrames(t *testing.T) {
+	fake := &fakeDocker{
+		startExec: func(id string, opts docker.StartExecOptions) (docker.CloseWaiter, error) {
+			w := &fakeWaiter{done: make(chan struct{})}
+			go func() {
+				opts.OutputStream.Write([]byte("\xff\xfe$ "))
+				close(w.done)
+			}()
+			return w, nil
+		},
+	}
+	server := &EntryServer{dockerClient: fake, authorizer: &FakeAuthorizer{Allow: true}, resolver: StaticResolver{"hello/web/1": "c1"}}
+	ts := httptest.NewServer(http.HandlerFunc(server.enter))
+	defer ts.Close()
+
+	for i, c := range []struct {
+		query     string
+		frameType int
+	}{
+		{"?method=web&frames=text", websocket.TextMessage},
+		{"?method=web", websocket.BinaryMessage},
+		// Protobuf messages are binary whatever asked.
+		{"?frames=text", websocket.BinaryMessage},
+	} {
+		ws := dialSession(t, ts, c.query, nil)
+		if strings.Contains(c.query, "method=web") {
+			ws.WriteMessage(websocket.TextMessage, []byte(`{"app_name": "hello", "proc_name": "web", "instance_no": "1"}`))
+		}
+		frameType, data, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if frameType != c.frameType {
+			t.Errorf("Case %d failed: frame type is %d", i+1, frameType)
+		}
+		if frameType == websocket.TextMessage {
+			msg := message.ResponseMessage{}
+			if err = json.Unmarshal(data, &msg); err != nil || string(msg.Content) != "\xff\xfe$ " {
+				t.Errorf("Case %d failed: %s, %v", i+1, data, err)
+			}
+		}
+		ws.Close()
+	}
+}
+
+func TestEnterLineBuffered(t *testing.T) {
+	long := strings.Repeat("x", writeBufferSize)
+	fake := &fakeDocker{
+		startExec: func(id string, opts docker.StartExecOptions) (docker.CloseWaiter, error) {
+			w := &fakeWaiter{done: make(chan struct{})}
+			go func() {
+				for _, chunk := range []string{"a\nb", "c\n", long + "\n", "$ "} {
+					opts.OutputStream.Write([]byte(chunk))
+				}
+				close(w.done)
+			}()
+			return w, nil
+		},
+	}
+	server := &EntryServer{dockerClient: fake, authorizer: &FakeAuthorizer{Allow: true}, resolver: StaticResolver{"hello/web/1": "c1"}}
+	ts := httptest.NewServer(http.HandlerFunc(server.enter))
+	defer ts.Close()
+
+	for i, c := range []struct {
+		query    string
+		expected []string
+	}{
+		{"", []string{"a\nb", "c\n", long, "\n", "$ "}},
+		// A line longer than the buffer is sent in pieces, the end of the output as it is.
+		{"?buffer=line", []string{"a\n", "bc\n", long, "\n", "$ "}},
+	} {
+		ws := dialSession(t, ts, c.query, nil)
+		var frames []string
+		for {
+			_, data, err := ws.ReadMessage()
+			if err != nil {
+				break
+			}
+			msg := &message.ResponseMessage{}
+			protoUnmarshalFunc(data, msg)
+			if msg.MsgType == message.ResponseMessage_STDOUT {
+				frames = append(frames, string(msg.Content))
+			}
+		}
+		ws.Close()
+		if !reflect.DeepEqual(frames, c.expected) {
+			t.Errorf("Case %d failed: frames are %q", i+1, frames)
+		}
+	}
+	server.sessions.Wait()
+}
+
+func TestEnterEarlyOutput(t *testing.T) {
+	fake := &fakeDocker{
+		startExec: func(id string, opts docker.StartExecOptions) (docker.CloseWaiter, error) {
+			w := &fakeWaiter{done: make(chan struct{})}
+			go func() {
+				opts.OutputStream.Write([]byte("Welcome\r\n$ "))
+				io.Copy(ioutil.Discard, opts.InputStream)
+				close(w.done)
+			}()
+			return w, nil
+		},
+	}
+	for i, c := range []struct {
+		wait  time.Duration
+		ready bool
+		min   time.Duration
+		max   time.Duration
+	}{
+		{0, false, 0, 500 * time.Millisecond},
+		// The reads of the client begin a second after the session starts.
+		{5 * time.Second, true, 900 * time.Millisecond, 4 * time.Second},
+		{200 * time.Millisecond, false, 150 * time.Millisecond, 900 * time.Millisecond},
+	} {
+		server := &EntryServer{dockerClient: fake, authorizer: &FakeAuthorizer{Allow: true}, resolver: StaticResolver{"hello/web/1": "c1"},
+			earlyOutputWait: c.wait}
+		ts := httptest.NewServer(http.HandlerFunc(server.enter))
+		header := http.Header{}
+		header.Set("app-name", "hello")
+		header.Set("proc-name", "web")
+		header.Set("instance-no", "1")
+		start := time.Now()
+		ws, _, err := websocket.DefaultDialer.Dial(strings.Replace(ts.URL, "http", "ws", 1), header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.ready {
+			data, _ := protoMarshalFunc(&message.RequestMessage{MsgType: message.RequestMessage_WINCH, Content: []byte("80 24")})
+			ws.WriteMessage(websocket.BinaryMessage, data)
+		}
+		_, data, err := ws.ReadMessage()
+		elapsed := time.Since(start)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := message.ResponseMessage{}
+		protoUnmarshalFunc(data, &msg)
+		if msg.MsgType != message.ResponseMessage_STDOUT || string(msg.Content) != "Welcome\r\n$ " || elapsed < c.min || elapsed > c.max {
+			t.Errorf("Case %d failed: %v %q after %s", i+1, msg.MsgType, msg.Content, elapsed)
+		}
+		ws.Close()
+		server.sessions.Wait()
+		ts.Close()
+	}
+}
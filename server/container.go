@@ -0,0 +1,198 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/laincloud/entry/log"
+)
+
+const lainLabelPrefix = "cc.bdp.lain.deployd."
+
+// ContainerInfo is the trimmed view of a container shown by web terminals.
+type ContainerInfo struct {
+	ID        string            `json:"id"`
+	Name      string            `json:"name"`
+	Image     string            `json:"image"`
+	Created   time.Time         `json:"created"`
+	StartedAt time.Time         `json:"started_at"`
+	Status    string            `json:"status"`
+	State     string            `json:"state"`
+	Labels    map[string]string `json:"labels"`
+	// Mounts may reveal host paths, so they are only shown to the roles with the details feature.
+	Mounts []docker.Mount `json:"mounts,omitempty"`
+}
+
+var (
+	errContainerPaused     = errors.New("container is paused")
+	errContainerRestarting = errors.New("container is restarting")
+	errContainerRemoving   = errors.New("container is being removed")
+	errContainerNotRunning = errors.New("container is not running")
+	errContainerOtherApp   = errors.New("container belongs to another application")
+)
+
+// containerStateMessages are shown to the user when the container state forbids entering.
+var containerStateMessages = map[error]string{
+	errContainerPaused:     "Container is paused.",
+	errContainerRestarting: "Container is restarting, try again later.",
+	errContainerRemoving:   "Container is being removed.",
+	errContainerNotRunning: "Container is not running.",
+}
+
+// checkContainerState returns an error when execs or attaches against a container
+// in the given state would hang or fail.
+func checkContainerState(state docker.State) error {
+	switch {
+	case state.RemovalInProgress:
+		return errContainerRemoving
+	case state.Restarting:
+		return errContainerRestarting
+	case state.Paused:
+		return errContainerPaused
+	case !state.Running:
+		return errContainerNotRunning
+	}
+	return nil
+}
+
+// commandExists reports whether name is an executable command inside the container.
+func (server *EntryServer) commandExists(containerID, name string) (bool, error) {
+	exec, err := server.dockerClient.CreateExec(docker.CreateExecOptions{
+		Container:    containerID,
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd:          []string{"sh", "-c", `command -v "$0"`, name},
+	})
+	if err != nil {
+		return false, err
+	}
+	if err = server.dockerClient.StartExec(exec.ID, docker.StartExecOptions{
+		OutputStream: ioutil.Discard,
+		ErrorStream:  ioutil.Discard,
+	}); err != nil {
+		return false, err
+	}
+	inspect, err := server.dockerClient.InspectExec(exec.ID)
+	if err != nil {
+		return false, err
+	}
+	return inspect.ExitCode == 0, nil
+}
+
+// checkContainerApp checks the container belongs to appName by its labels, when the
+// server enforces it. It guards against containers resolved or given by ID which escape
+// the authorization on appName, or the local pod of a sidecar entry.
+func (server *EntryServer) checkContainerApp(container *docker.Container, appName string) error {
+	if err := server.localPod.check(container); err != nil {
+		log.Warnf("Container %s is out of the local pod", container.ID)
+		return err
+	}
+	if server.enforceAppLabel && containerAppName(container) != appName {
+		log.Warnf("Container %s belongs to %q, not %s", container.ID, containerAppName(container), appName)
+		return errContainerOtherApp
+	}
+	return nil
+}
+
+// containerAppName returns the lain application which the container belongs to, or "" if unknown.
+func containerAppName(container *docker.Container) string {
+	if container.Config == nil {
+		return ""
+	}
+	return labelAppName(container.Config.Labels)
+}
+
+// labelAppName returns the lain application in the labels of a container, or "" if unknown.
+func labelAppName(labels map[string]string) string {
+	if pgName := labels[lainLabelPrefix+"pg_name"]; pgName != "" {
+		appName, _ := getAppProcName(strings.Split(pgName, "."))
+		return appName
+	}
+	return ""
+}
+
+// isAdminRole reports whether the role has every feature when no role policies are given,
+// see rolePolicies.
+func isAdminRole(role string) bool {
+	return role == "admin" || role == "owner"
+}
+
+// ContainerSessions is the number of the sessions of this server in a container, and their
+// users, which only the roles with the details feature may see.
+type ContainerSessions struct {
+	Container string   `json:"container"`
+	Sessions  int      `json:"sessions"`
+	Users     []string `json:"users,omitempty"`
+}
+
+// containerInfo serves GET /container/{id}/info, /container/{id}/shells and
+// /container/{id}/sessions for clients authorized on the container's application, given by
+// the access-token and app-name headers.
+func (server *EntryServer) containerInfo(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 3 || parts[0] != "container" || (parts[2] != "info" && parts[2] != "shells" && parts[2] != "sessions") {
+		http.NotFound(w, r)
+		return
+	}
+	appName := r.Header.Get("app-name")
+	if err := server.appFilter.check(appName); err != nil {
+		http.Error(w, "Application is not allowed.", http.StatusForbidden)
+		return
+	}
+	role, _, err := server.authorize(r, r.Header.Get("access-token"), appName)
+	if err != nil {
+		log.Errorf("Authorization for container info failed: %s", err.Error())
+		server.auditRequestDenied(r, "container-"+parts[2], appName, parts[1], err)
+		http.Error(w, "Authorization failed.", http.StatusForbidden)
+		return
+	}
+	container, err := server.dockerClient.InspectContainer(parts[1])
+	if err != nil || containerAppName(container) != appName || server.localPod.check(container) != nil {
+		http.Error(w, "Container is not found.", http.StatusNotFound)
+		return
+	}
+	switch parts[2] {
+	case "shells":
+		server.containerShells(w, container)
+		return
+	case "sessions":
+		sessions := ContainerSessions{Container: container.ID}
+		var users []string
+		sessions.Sessions, users = server.registry.inContainer(container.ID)
+		if server.roles.allows(role, "details") {
+			sessions.Users = users
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sessions)
+		return
+	}
+
+	info := ContainerInfo{
+		ID:        container.ID,
+		Name:      strings.TrimPrefix(container.Name, "/"),
+		Image:     container.Image,
+		Created:   container.Created,
+		StartedAt: container.State.StartedAt,
+		Status:    container.State.StateString(),
+		State:     container.State.String(),
+		Labels:    make(map[string]string),
+	}
+	if container.Config != nil {
+		info.Image = container.Config.Image
+		for key, value := range container.Config.Labels {
+			if strings.HasPrefix(key, lainLabelPrefix) {
+				info.Labels[key] = value
+			}
+		}
+	}
+	if server.roles.allows(role, "details") {
+		info.Mounts = container.Mounts
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
@@ -0,0 +1,212 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gorilla/websocket"
+	"github.com/laincloud/entry/message"
+)
+
+func TestCheckContainerState(t *testing.T) {
+	cases := []struct {
+		state    docker.State
+		expected error
+	}{
+		{docker.State{Running: true}, nil},
+		{docker.State{Running: true, Paused: true}, errContainerPaused},
+		{docker.State{Running: true, Restarting: true}, errContainerRestarting},
+		{docker.State{RemovalInProgress: true}, errContainerRemoving},
+		{docker.State{Dead: true}, errContainerNotRunning},
+		{docker.State{}, errContainerNotRunning},
+	}
+	for i, c := range cases {
+		if actual := checkContainerState(c.state); actual != c.expected {
+			t.Errorf("Case %d failed: actual is %v", i+1, actual)
+		}
+	}
+}
+
+func TestContainerInfo(t *testing.T) {
+	server := &EntryServer{
+		authorizer: &FakeAuthorizer{Tokens: map[string]string{"admin": "admin", "dev": "developer"}},
+		dockerClient: &fakeDocker{
+			inspectContainer: func(id string) (*docker.Container, error) {
+				return &docker.Container{
+					ID:     id,
+					Config: &docker.Config{Image: "hello:1", Labels: map[string]string{lainLabelPrefix + "pg_name": "hello.web.web", "other": "x"}},
+					State:  docker.State{Running: true},
+					Mounts: []docker.Mount{{Source: "/data", Destination: "/data"}},
+				}, nil
+			},
+		},
+	}
+	get := func(token, appName string) (*httptest.ResponseRecorder, ContainerInfo) {
+		r := httptest.NewRequest("GET", "/container/c1/info", nil)
+		r.Header.Set("access-token", token)
+		r.Header.Set("app-name", appName)
+		w := httptest.NewRecorder()
+		server.containerInfo(w, r)
+		info := ContainerInfo{}
+		json.Unmarshal(w.Body.Bytes(), &info)
+		return w, info
+	}
+
+	if w, info := get("admin", "hello"); w.Code != http.StatusOK || info.Image != "hello:1" || len(info.Mounts) != 1 || len(info.Labels) != 1 {
+		t.Errorf("Case 1 failed: %d %+v", w.Code, info)
+	}
+	if w, info := get("dev", "hello"); w.Code != http.StatusOK || len(info.Mounts) != 0 {
+		t.Errorf("Case 2 failed: %d %+v", w.Code, info)
+	}
+	if w, _ := get("dev", "world"); w.Code != http.StatusNotFound {
+		t.Errorf("Case 3 failed: %d", w.Code)
+	}
+	if w, _ := get("nobody", "hello"); w.Code != http.StatusForbidden {
+		t.Errorf("Case 4 failed: %d", w.Code)
+	}
+}
+
+func TestContainerSessions(t *testing.T) {
+	server := &EntryServer{
+		authorizer: &FakeAuthorizer{Tokens: map[string]string{"admin": "admin", "dev": "developer"}},
+		dockerClient: &fakeDocker{
+			inspectContainer: func(id string) (*docker.Container, error) {
+				return &docker.Container{ID: id, Config: &docker.Config{Labels: map[string]string{lainLabelPrefix + "pg_name": "hello.web.web"}}}, nil
+			},
+		},
+	}
+	noop := func() {}
+	server.registry.add(sessionInfo{kind: "enter", appName: "hello", containerID: "c1", user: "bob"}, noop)
+	server.registry.add(sessionInfo{kind: "attach", appName: "hello", containerID: "c1", user: "alice"}, noop)
+	server.registry.add(sessionInfo{kind: "enter", appName: "hello", containerID: "c1", user: "bob"}, noop)
+	moving := server.registry.add(sessionInfo{kind: "enter", appName: "hello", containerID: "c1", user: "carol"}, noop)
+	server.registry.moved(moving, "2", "c2")
+
+	cases := []struct {
+		token, container string
+		expected         ContainerSessions
+	}{
+		{"admin", "c1", ContainerSessions{Container: "c1", Sessions: 3, Users: []string{"alice", "bob"}}},
+		// Only admins may know who is in the container.
+		{"dev", "c1", ContainerSessions{Container: "c1", Sessions: 3}},
+		{"admin", "c2", ContainerSessions{Container: "c2", Sessions: 1, Users: []string{"carol"}}},
+		{"dev", "c3", ContainerSessions{Container: "c3"}},
+	}
+	for i, c := range cases {
+		r := httptest.NewRequest("GET", "/container/"+c.container+"/sessions", nil)
+		r.Header.Set("access-token", c.token)
+		r.Header.Set("app-name", "hello")
+		w := httptest.NewRecorder()
+		server.containerInfo(w, r)
+		actual := ContainerSessions{}
+		json.Unmarshal(w.Body.Bytes(), &actual)
+		if w.Code != http.StatusOK || !reflect.DeepEqual(actual, c.expected) {
+			t.Errorf("Case %d failed: %d %+v", i+1, w.Code, actual)
+		}
+	}
+}
+
+func TestEnterEnforceAppLabel(t *testing.T) {
+	fake := &fakeDocker{
+		inspectContainer: func(id string) (*docker.Container, error) {
+			labels := map[string]string{}
+			if id != "bare" {
+				labels[lainLabelPrefix+"pg_name"] = id + ".web.web"
+			}
+			return &docker.Container{ID: id, Config: &docker.Config{Labels: labels}, State: docker.State{Running: true}}, nil
+		},
+		startExec: func(id string, opts docker.StartExecOptions) (docker.CloseWaiter, error) {
+			w := &fakeWaiter{done: make(chan struct{})}
+			go func() {
+				opts.OutputStream.Write([]byte("$ "))
+				close(w.done)
+			}()
+			return w, nil
+		},
+	}
+	// The resolver maps instances to containers named after the application they belong to.
+	resolver := StaticResolver{"hello/web/1": "hello", "hello/web/2": "other", "hello/web/3": "bare"}
+	cases := []struct {
+		enforce    bool
+		instanceNo string
+		entered    bool
+	}{
+		{true, "1", true},
+		{true, "2", false},
+		{true, "3", false},
+		{false, "2", true},
+	}
+	for i, c := range cases {
+		server := &EntryServer{dockerClient: fake, authorizer: &FakeAuthorizer{Allow: true}, resolver: resolver, enforceAppLabel: c.enforce}
+		ts := httptest.NewServer(http.HandlerFunc(server.enter))
+		header := http.Header{}
+		header.Set("app-name", "hello")
+		header.Set("proc-name", "web")
+		header.Set("instance-no", c.instanceNo)
+		ws, _, err := websocket.DefaultDialer.Dial(strings.Replace(ts.URL, "http", "ws", 1), header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := message.ResponseMessage{}
+		if err = protoUnmarshalFunc(data, &msg); err != nil {
+			t.Fatal(err)
+		}
+		if entered := msg.MsgType == message.ResponseMessage_STDOUT; entered != c.entered {
+			t.Errorf("Case %d failed: %v %q", i+1, msg.MsgType, msg.Content)
+		}
+		ws.Close()
+		ts.Close()
+	}
+}
+
+func TestEnterReadonlyNotice(t *testing.T) {
+	fake := &fakeDocker{
+		inspectContainer: func(id string) (*docker.Container, error) {
+			return &docker.Container{ID: id, State: docker.State{Running: true}, HostConfig: &docker.HostConfig{ReadonlyRootfs: id == "ro"}}, nil
+		},
+	}
+	for i, c := range []struct {
+		instanceNo string
+		notice     bool
+	}{
+		{"1", true},
+		{"2", false},
+	} {
+		server := &EntryServer{dockerClient: fake, authorizer: &FakeAuthorizer{Allow: true}, resolver: StaticResolver{"hello/web/1": "ro", "hello/web/2": "rw"}, readonlyNotice: true}
+		ts := httptest.NewServer(http.HandlerFunc(server.enter))
+		header := http.Header{}
+		header.Set("app-name", "hello")
+		header.Set("proc-name", "web")
+		header.Set("instance-no", c.instanceNo)
+		ws, _, err := websocket.DefaultDialer.Dial(strings.Replace(ts.URL, "http", "ws", 1), header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		notice := false
+		for {
+			_, data, err := ws.ReadMessage()
+			if err != nil {
+				break
+			}
+			msg := message.ResponseMessage{}
+			protoUnmarshalFunc(data, &msg)
+			if msg.MsgType == message.ResponseMessage_NOTICE && strings.Contains(string(msg.Content), "read-only filesystem") {
+				notice = true
+			}
+		}
+		if notice != c.notice {
+			t.Errorf("Case %d failed: notice is %t", i+1, notice)
+		}
+		ws.Close()
+		ts.Close()
+	}
+}
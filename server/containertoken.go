@@ -0,0 +1,109 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// minContainerTokenKey is the shortest signing key of container tokens.
+	minContainerTokenKey = 32
+	// maxContainerTokenTTL bounds how far ahead container tokens expire, they are issued for
+	// the session about to open.
+	maxContainerTokenTTL = 10 * time.Minute
+)
+
+var (
+	errInvalidContainerToken  = errors.New("invalid container token")
+	errExpiredContainerToken  = errors.New("container token is expired")
+	errContainerTokenRequired = errors.New("container token is required")
+)
+
+// containerClaims are what a container token grants, the container of an application
+// until it expires.
+type containerClaims struct {
+	App       string `json:"app"`
+	Container string `json:"container"`
+	// Expires is in unix seconds.
+	Expires int64 `json:"exp"`
+}
+
+// containerTokens verifies the container tokens, issued by the console once the client is
+// authorized, so that the client enters the container granted rather than any it names.
+// A token is "<payload>.<signature>", the base64url of the JSON of containerClaims and of
+// its HMAC-SHA256 by the key shared with the console, see SignContainerToken. The zero
+// containerTokens is disabled.
+type containerTokens struct {
+	key []byte
+	// required refuses the sessions naming their container without a token.
+	required bool
+}
+
+// newContainerTokens creates the verifier of the tokens signed by key, empty disables it.
+func newContainerTokens(key string, required bool) (containerTokens, error) {
+	if key == "" {
+		if required {
+			return containerTokens{}, errors.New("container tokens can't be required without a signing key")
+		}
+		return containerTokens{}, nil
+	}
+	if len(key) < minContainerTokenKey {
+		return containerTokens{}, fmt.Errorf("container token key must have at least %d bytes", minContainerTokenKey)
+	}
+	return containerTokens{key: []byte(key), required: required}, nil
+}
+
+func (t containerTokens) enabled() bool {
+	return t.key != nil
+}
+
+// SignContainerToken issues a token granting the container containerID of app until
+// expires, signed by key.
+func SignContainerToken(key []byte, app, containerID string, expires time.Time) string {
+	payload, _ := json.Marshal(containerClaims{App: app, Container: containerID, Expires: expires.Unix()})
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(signContainerPayload(key, encoded))
+}
+
+func signContainerPayload(key []byte, encoded string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(encoded))
+	return mac.Sum(nil)
+}
+
+// verify returns the container granted by token on appName at now.
+func (t containerTokens) verify(token, appName string, now time.Time) (string, error) {
+	if !t.enabled() {
+		return "", errInvalidContainerToken
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return "", errInvalidContainerToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(signature, signContainerPayload(t.key, parts[0])) {
+		return "", errInvalidContainerToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", errInvalidContainerToken
+	}
+	var claims containerClaims
+	if err = json.Unmarshal(payload, &claims); err != nil || claims.Container == "" || claims.App != appName {
+		return "", errInvalidContainerToken
+	}
+	expires := time.Unix(claims.Expires, 0)
+	if !now.Before(expires) {
+		return "", errExpiredContainerToken
+	}
+	if expires.Sub(now) > maxContainerTokenTTL {
+		return "", fmt.Errorf("%s: expires in more than %s", errInvalidContainerToken, maxContainerTokenTTL)
+	}
+	return claims.Container, nil
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gorilla/websocket"
+	"github.com/laincloud/entry/message"
+)
+
+const testContainerTokenKey = "0123456789abcdef0123456789abcdef"
+
+func TestNewContainerTokens(t *testing.T) {
+	cases := []struct {
+		key      string
+		required bool
+		valid    bool
+	}{
+		{"", false, true},
+		{"", true, false},
+		{"short", false, false},
+		{testContainerTokenKey, true, true},
+	}
+	for i, c := range cases {
+		tokens, err := newContainerTokens(c.key, c.required)
+		if (err == nil) != c.valid || (err == nil && tokens.enabled() != (c.key != "")) {
+			t.Errorf("Case %d failed: %v", i+1, err)
+		}
+	}
+}
+
+func TestVerifyContainerToken(t *testing.T) {
+	now := time.Unix(1000, 0)
+	key := []byte(testContainerTokenKey)
+	tokens, _ := newContainerTokens(testContainerTokenKey, false)
+	valid := SignContainerToken(key, "hello", "c1", now.Add(time.Minute))
+	cases := []struct {
+		tokens    containerTokens
+		token     string
+		app       string
+		container string
+		err       error
+	}{
+		{tokens, valid, "hello", "c1", nil},
+		{tokens, valid, "console", "", errInvalidContainerToken},
+		{containerTokens{}, valid, "hello", "", errInvalidContainerToken},
+		{tokens, SignContainerToken([]byte("another key of at least 32 bytes"), "hello", "c1", now.Add(time.Minute)), "hello", "", errInvalidContainerToken},
+		{tokens, strings.Split(SignContainerToken(key, "hello", "c2", now.Add(time.Minute)), ".")[0] + valid[strings.Index(valid, "."):], "hello", "", errInvalidContainerToken},
+		{tokens, SignContainerToken(key, "hello", "c1", now), "hello", "", errExpiredContainerToken},
+		{tokens, SignContainerToken(key, "hello", "", now.Add(time.Minute)), "hello", "", errInvalidContainerToken},
+		{tokens, "garbage", "hello", "", errInvalidContainerToken},
+		{tokens, "a.b.c", "hello", "", errInvalidContainerToken},
+	}
+	for i, c := range cases {
+		container, err := c.tokens.verify(c.token, c.app, now)
+		if container != c.container || err != c.err {
+			t.Errorf("Case %d failed: actual is %q, %v", i+1, container, err)
+		}
+	}
+	if _, err := tokens.verify(SignContainerToken(key, "hello", "c1", now.Add(time.Hour)), "hello", now); err == nil {
+		t.Error("Token expiring too far ahead is accepted")
+	}
+}
+
+func TestEnterContainerToken(t *testing.T) {
+	hello := map[string]string{lainLabelPrefix + "pg_name": "hello.web.web", lainLabelPrefix + "instance_no": "2"}
+	entered := make(chan string, 1)
+	fake := &fakeDocker{
+		listContainers: func(opts docker.ListContainersOptions) ([]docker.APIContainers, error) {
+			return []docker.APIContainers{{ID: "abc123def456", Names: []string{"/hello.web.web.v1-i2-d0"}, Labels: hello}}, nil
+		},
+		createExec: func(opts docker.CreateExecOptions) (*docker.Exec, error) {
+			entered <- opts.Container
+			return &docker.Exec{ID: "exec"}, nil
+		},
+	}
+	tokens, _ := newContainerTokens(testContainerTokenKey, true)
+	server := &EntryServer{dockerClient: fake, authorizer: &FakeAuthorizer{Allow: true},
+		resolver: StaticResolver{"hello/web/1": "c1"}, containerTokens: tokens}
+	ts := httptest.NewServer(http.HandlerFunc(server.enter))
+	defer ts.Close()
+
+	valid := SignContainerToken([]byte(testContainerTokenKey), "hello", "abc123def456", time.Now().Add(time.Minute))
+	expired := SignContainerToken([]byte(testContainerTokenKey), "hello", "abc123def456", time.Now().Add(-time.Minute))
+	cases := []struct {
+		token     string
+		container string
+		content   string
+	}{
+		// The instance asked is ignored for the container granted.
+		{valid, "abc123def456", ""},
+		{valid + "x", "", "Container token is invalid."},
+		{expired, "", "Container token is expired"},
+		{"", "", "A container token is required."},
+	}
+	for i, c := range cases {
+		header := http.Header{}
+		header.Set("container-token", c.token)
+		ws := dialSession(t, ts, "", header)
+		ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if c.container != "" {
+			if container := <-entered; container != c.container {
+				t.Errorf("Case %d failed: entered %s", i+1, container)
+			}
+		} else {
+			_, data, err := ws.ReadMessage()
+			msg := &message.ResponseMessage{}
+			protoUnmarshalFunc(data, msg)
+			if err != nil || msg.MsgType != message.ResponseMessage_CLOSE || !strings.Contains(string(msg.Content), c.content) {
+				t.Errorf("Case %d failed: %v %q, %v", i+1, msg.MsgType, msg.Content, err)
+			}
+		}
+		ws.Close()
+		server.sessions.Wait()
+	}
+}
+
+func TestContainerTokenConfined(t *testing.T) {
+	hello := map[string]string{lainLabelPrefix + "pg_name": "hello.web.web", lainLabelPrefix + "instance_no": "1"}
+	fake := &fakeDocker{
+		listContainers: func(opts docker.ListContainersOptions) ([]docker.APIContainers, error) {
+			return []docker.APIContainers{{ID: "abc123def456", Names: []string{"/hello.web.web.v1-i1-d0"}, Labels: hello}}, nil
+		},
+		startExec: func(id string, opts docker.StartExecOptions) (docker.CloseWaiter, error) {
+			w := &fakeWaiter{done: make(chan struct{})}
+			go func() {
+				io.Copy(opts.OutputStream, opts.InputStream)
+				close(w.done)
+			}()
+			return w, nil
+		},
+	}
+	tokens, _ := newContainerTokens(testContainerTokenKey, false)
+	server := &EntryServer{dockerClient: fake, authorizer: &FakeAuthorizer{Allow: true},
+		resolver: StaticResolver{"hello/web/1": "c1", "hello/web/2": "c2"}, containerTokens: tokens}
+	ts := httptest.NewServer(http.HandlerFunc(server.enter))
+	defer ts.Close()
+
+	header := http.Header{}
+	header.Set("container-token", SignContainerToken([]byte(testContainerTokenKey), "hello", "abc123def456", time.Now().Add(time.Minute)))
+	ws := dialSession(t, ts, "", header)
+	defer ws.Close()
+	var err error
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for i, c := range []struct {
+		msg    message.RequestMessage
+		notice string
+	}{
+		{message.RequestMessage{MsgType: message.RequestMessage_SWITCH, Content: []byte("2")}, "Switching instances is not supported"},
+		{message.RequestMessage{MsgType: message.RequestMessage_OPEN_TAB, Tab: 1, Content: []byte("2")}, "Tabs of other instances are not supported"},
+	} {
+		data, _ := protoMarshalFunc(&c.msg)
+		if err := ws.WriteMessage(websocket.BinaryMessage, data); err != nil {
+			t.Fatal(err)
+		}
+		_, data, err = ws.ReadMessage()
+		msg := &message.ResponseMessage{}
+		protoUnmarshalFunc(data, msg)
+		if err != nil || msg.MsgType != message.ResponseMessage_NOTICE || !strings.Contains(string(msg.Content), c.notice) {
+			t.Errorf("Case %d failed: %v %q, %v", i+1, msg.MsgType, msg.Content, err)
+		}
+	}
+	ws.Close()
+	server.sessions.Wait()
+}
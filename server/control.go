@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/gorilla/websocket"
+	"github.com/laincloud/entry/log"
+	"github.com/laincloud/entry/message"
+)
+
+// controlRequest is the content of a CONTROL request, which operates on an enter session
+// apart from its terminal data, e.g.
+//
+//	{"id": 1, "op": "resize", "args": {"cols": 120, "rows": 40}}
+//
+// The ops and their args are:
+//
+//	resize  {"cols": 120, "rows": 40}  resizes the terminal, like WINCH.
+//	signal  {"name": "INT"}            sends INT, QUIT or TSTP to the foreground process by its
+//	                                   terminal key, as docker can't signal the process of an exec.
+//	switch  {"instance": "2"}          moves the session to another instance, like SWITCH.
+//	info    no args                    returns the app, proc, instance and container of the session.
+//
+// Every request is answered by a CONTROL response, see controlResponse.
+type controlRequest struct {
+	// ID is any JSON value given by the client, to match the response with the request.
+	ID   json.RawMessage `json:"id,omitempty"`
+	Op   string          `json:"op"`
+	Args json.RawMessage `json:"args,omitempty"`
+}
+
+// controlResponse is the content of a CONTROL response. On success, Result is what the op
+// returns, or true if nothing, otherwise Error tells why the op failed.
+type controlResponse struct {
+	ID     json.RawMessage `json:"id,omitempty"`
+	Result interface{}     `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// signalKeys are the terminal keys sending signals to the foreground process.
+var signalKeys = map[string]string{
+	"INT":  interruptKey,
+	"QUIT": "\x1c",
+	"TSTP": "\x1a",
+}
+
+// handleControl runs the control request of content on s and answers it. It returns an
+// error only when the session can't go on.
+func (server *EntryServer) handleControl(ctx context.Context, s *enterSession, content []byte) error {
+	var req controlRequest
+	if err := json.Unmarshal(content, &req); err != nil {
+		server.sendControlMessage(s.ws, controlResponse{Error: "invalid control request"}, s.msgMarshaller)
+		return nil
+	}
+	resp := controlResponse{ID: req.ID, Result: true}
+	switch req.Op {
+	case "resize":
+		var args struct {
+			Cols int `json:"cols"`
+			Rows int `json:"rows"`
+		}
+		if err := json.Unmarshal(req.Args, &args); err != nil || args.Cols <= 0 || args.Rows <= 0 || args.Cols > maxTermDimension || args.Rows > maxTermDimension {
+			resp.Error = "invalid size"
+			break
+		}
+		size := termSize{Width: args.Cols, Height: args.Rows}
+		s.lastSize = &size
+		if err := s.shell.resizer.resize(size); err != nil {
+			log.Errorf("Resize by control failed: %s", err.Error())
+			resp.Error = "resize failed"
+		}
+	case "signal":
+		var args struct {
+			Name string `json:"name"`
+		}
+		json.Unmarshal(req.Args, &args)
+		key, ok := signalKeys[strings.TrimPrefix(strings.ToUpper(args.Name), "SIG")]
+		if !ok {
+			resp.Error = fmt.Sprintf("unsupported signal %q", args.Name)
+			break
+		}
+		write := s.shell.input.write
+		if key == interruptKey {
+			write = s.shell.input.interrupt
+		}
+		if err := write([]byte(key)); err != nil {
+			resp.Error = "the shell is gone"
+		}
+	case "switch":
+		var args struct {
+			Instance string `json:"instance"`
+		}
+		if err := json.Unmarshal(req.Args, &args); err != nil || args.Instance == "" {
+			resp.Error = "instance is required"
+			break
+		}
+		refusal, err := server.switchSession(ctx, s, args.Instance)
+		if err != nil {
+			return err
+		}
+		resp.Error = refusal
+	case "info":
+		resp.Result = map[string]string{
+			"app":       s.info.appName,
+			"proc":      s.info.procName,
+			"instance":  s.info.instanceNo,
+			"container": s.info.containerID,
+		}
+	default:
+		resp.Error = fmt.Sprintf("unknown op %q", req.Op)
+	}
+	if resp.Error != "" {
+		resp.Result = nil
+	}
+	server.sendControlMessage(s.ws, resp, s.msgMarshaller)
+	return nil
+}
+
+func (server *EntryServer) sendControlMessage(ws *safeConn, resp controlResponse, msgMarshaller Marshaler) {
+	content, err := json.Marshal(resp)
+	if err != nil {
+		log.Errorf("Marshal control response failed: %s", err.Error())
+		return
+	}
+	controlMsg := &message.ResponseMessage{
+		MsgType: message.ResponseMessage_CONTROL,
+		Content: content,
+	}
+	if controlData, err := msgMarshaller(controlMsg); err != nil {
+		log.Errorf("Marshal control message failed: %s", err.Error())
+	} else {
+		ws.WriteMessage(websocket.BinaryMessage, controlData)
+	}
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gorilla/websocket"
+	"github.com/laincloud/entry/message"
+)
+
+func TestEnterControl(t *testing.T) {
+	input := make(chan string, 16)
+	resized := make(chan [2]int, 1)
+	fake := &fakeDocker{
+		startExec: func(id string, opts docker.StartExecOptions) (docker.CloseWaiter, error) {
+			w := &fakeWaiter{done: make(chan struct{})}
+			go func() {
+				buf := make([]byte, 16)
+				for {
+					n, err := opts.InputStream.Read(buf)
+					if err == io.EOF {
+						break
+					}
+					input <- string(buf[:n])
+				}
+				close(w.done)
+			}()
+			return w, nil
+		},
+		resizeExecTTY: func(id string, height, width int) error {
+			resized <- [2]int{width, height}
+			return nil
+		},
+	}
+	server := &EntryServer{dockerClient: fake, authorizer: &FakeAuthorizer{Allow: true}, resolver: StaticResolver{"hello/web/1": "c1"}}
+	ts := httptest.NewServer(http.HandlerFunc(server.enter))
+	defer ts.Close()
+
+	ws := dialSession(t, ts, "", nil)
+	defer ws.Close()
+	var err error
+
+	for i, c := range []struct {
+		request  string
+		response string
+	}{
+		{`{"id": 1, "op": "info"}`, `{"id":1,"result":{"app":"hello","container":"c1","instance":"1","proc":"web"}}`},
+		{`{"id": "r", "op": "resize", "args": {"cols": 120, "rows": 40}}`, `{"id":"r","result":true}`},
+		{`{"id": 3, "op": "resize", "args": {"cols": 0}}`, `{"id":3,"error":"invalid size"}`},
+		{`{"id": "big", "op": "resize", "args": {"cols": 80, "rows": 99999}}`, `{"id":"big","error":"invalid size"}`},
+		{`{"id": 4, "op": "signal", "args": {"name": "SIGINT"}}`, `{"id":4,"result":true}`},
+		{`{"id": 5, "op": "signal", "args": {"name": "KILL"}}`, `{"id":5,"error":"unsupported signal \"KILL\""}`},
+		{`{"id": 6, "op": "switch", "args": {"instance": "1"}}`, `{"id":6,"error":"Can't switch to instance 1. You are in it already."}`},
+		{`{"id": 7, "op": "reboot"}`, `{"id":7,"error":"unknown op \"reboot\""}`},
+		{`not json`, `{"error":"invalid control request"}`},
+	} {
+		data, _ := protoMarshalFunc(&message.RequestMessage{MsgType: message.RequestMessage_CONTROL, Content: []byte(c.request)})
+		if err := ws.WriteMessage(websocket.BinaryMessage, data); err != nil {
+			t.Fatal(err)
+		}
+		_, data, err = ws.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := message.ResponseMessage{}
+		if err = protoUnmarshalFunc(data, &msg); err != nil {
+			t.Fatal(err)
+		}
+		var actual, expected interface{}
+		json.Unmarshal(msg.Content, &actual)
+		json.Unmarshal([]byte(c.response), &expected)
+		if msg.MsgType != message.ResponseMessage_CONTROL || !reflect.DeepEqual(actual, expected) {
+			t.Errorf("Case %d failed: %v %s", i+1, msg.MsgType, msg.Content)
+		}
+	}
+	if size := <-resized; size != [2]int{120, 40} {
+		t.Errorf("Resized to %v", size)
+	}
+	if data := <-input; data != interruptKey {
+		t.Errorf("Signal is sent as %q", data)
+	}
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+)
+
+// corsPolicy answers cross origin requests to the plain HTTP endpoints. It's disabled
+// while origins is empty. Origins are globs like the app filter, e.g. https://*.lain.local.
+type corsPolicy struct {
+	origins     []string
+	methods     string
+	headers     string
+	credentials bool
+}
+
+const (
+	defaultCORSMethods = "GET, OPTIONS"
+	defaultCORSHeaders = "access-token, app-name, Content-Type"
+)
+
+func newCORSPolicy(origins, methods, headers string, credentials bool) corsPolicy {
+	p := corsPolicy{
+		origins:     splitPatterns(origins),
+		methods:     methods,
+		headers:     headers,
+		credentials: credentials,
+	}
+	if p.methods == "" {
+		p.methods = defaultCORSMethods
+	}
+	if p.headers == "" {
+		p.headers = defaultCORSHeaders
+	}
+	return p
+}
+
+func (p corsPolicy) enabled() bool {
+	return len(p.origins) > 0
+}
+
+func (p corsPolicy) allowOrigin(origin string) bool {
+	return matchAny(p.origins, origin)
+}
+
+// checkOrigin is the websocket origin check under the same allow-list. Requests without
+// an Origin header don't come from browsers and are always accepted.
+func (p corsPolicy) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	return origin == "" || p.allowOrigin(origin)
+}
+
+// wrap adds the CORS headers to the responses of handler, and answers preflight requests.
+func (p corsPolicy) wrap(handler http.HandlerFunc) http.HandlerFunc {
+	if !p.enabled() {
+		return handler
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		preflight := r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != ""
+		if origin == "" {
+			handler(w, r)
+			return
+		}
+		if !p.allowOrigin(origin) || (preflight && !p.hasMethod(r.Header.Get("Access-Control-Request-Method"))) {
+			if preflight {
+				http.Error(w, "Origin is not allowed.", http.StatusForbidden)
+			} else {
+				handler(w, r)
+			}
+			return
+		}
+		header := w.Header()
+		header.Set("Access-Control-Allow-Origin", origin)
+		header.Add("Vary", "Origin")
+		if p.credentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+		if preflight {
+			header.Set("Access-Control-Allow-Methods", p.methods)
+			header.Set("Access-Control-Allow-Headers", p.headers)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// hasMethod reports whether method is one of the allowed methods.
+func (p corsPolicy) hasMethod(method string) bool {
+	for _, m := range strings.Split(p.methods, ",") {
+		if strings.EqualFold(strings.TrimSpace(m), method) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORSPolicy(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	p := newCORSPolicy("https://*.lain.local", "", "", true)
+	handler := p.wrap(ok)
+	cases := []struct {
+		method, origin, requestMethod string
+		status                        int
+		allowOrigin                   string
+	}{
+		{"GET", "", "", http.StatusOK, ""},
+		{"GET", "https://console.lain.local", "", http.StatusOK, "https://console.lain.local"},
+		{"GET", "https://evil.com", "", http.StatusOK, ""},
+		{"OPTIONS", "https://console.lain.local", "GET", http.StatusNoContent, "https://console.lain.local"},
+		{"OPTIONS", "https://console.lain.local", "DELETE", http.StatusForbidden, ""},
+		{"OPTIONS", "https://evil.com", "GET", http.StatusForbidden, ""},
+	}
+	for i, c := range cases {
+		r := httptest.NewRequest(c.method, "/container/c1/info", nil)
+		if c.origin != "" {
+			r.Header.Set("Origin", c.origin)
+		}
+		if c.requestMethod != "" {
+			r.Header.Set("Access-Control-Request-Method", c.requestMethod)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if w.Code != c.status || w.Header().Get("Access-Control-Allow-Origin") != c.allowOrigin {
+			t.Errorf("Case %d failed: status is %d, headers are %v", i+1, w.Code, w.Header())
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/enter", nil)
+	r.Header.Set("Origin", "https://evil.com")
+	if p.checkOrigin(r) {
+		t.Errorf("Websocket origin check passed for a disallowed origin")
+	}
+	w := httptest.NewRecorder()
+	newCORSPolicy("", "", "", false).wrap(ok)(w, r)
+	if w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("CORS is not disabled by default")
+	}
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/laincloud/entry/log"
+)
+
+var (
+	errDebugDisabled  = errors.New("debug sessions are not enabled")
+	errDebugForbidden = errors.New("debug sessions are not allowed for the role")
+)
+
+// debugLabel marks the debug sidecars with the container they debug.
+const debugLabel = "cc.bdp.lain.entry.debug_target"
+
+// debugPolicy is how debug sessions are run. A debug session enters a sidecar container
+// of image sharing the pid and network namespaces of the target, with the extra
+// capabilities, or privileged, which the target itself lacks.
+type debugPolicy struct {
+	image        string
+	capabilities []string
+	privileged   bool
+}
+
+func newDebugPolicy(image, capabilities string, privileged bool) debugPolicy {
+	p := debugPolicy{image: image, privileged: privileged}
+	for _, capability := range splitPatterns(capabilities) {
+		p.capabilities = append(p.capabilities, strings.TrimPrefix(strings.ToUpper(capability), "CAP_"))
+	}
+	return p
+}
+
+func (p debugPolicy) enabled() bool {
+	return p.image != ""
+}
+
+// checkDebug returns an error if the client playing role can't start a debug session.
+func (server *EntryServer) checkDebug(role string) error {
+	if server.safeMode {
+		return errSafeMode
+	}
+	if !server.debug.enabled() {
+		return errDebugDisabled
+	}
+	if !server.roles.allows(role, "debug") {
+		return errDebugForbidden
+	}
+	return nil
+}
+
+// startDebugSidecar creates and starts the debug sidecar of the target container.
+func (server *EntryServer) startDebugSidecar(targetID string) (string, error) {
+	sidecar, err := server.dockerClient.CreateContainer(docker.CreateContainerOptions{
+		Config: &docker.Config{
+			Image: server.debug.image,
+			// Keep the sidecar alive, the debug shell is started by exec.
+			Cmd:    []string{"sh", "-c", "while true; do sleep 3600; done"},
+			Labels: map[string]string{debugLabel: targetID},
+		},
+		HostConfig: &docker.HostConfig{
+			PidMode:     "container:" + targetID,
+			NetworkMode: "container:" + targetID,
+			CapAdd:      server.debug.capabilities,
+			Privileged:  server.debug.privileged,
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	if err = server.dockerClient.StartContainer(sidecar.ID, nil); err != nil {
+		server.removeDebugSidecar(sidecar.ID)
+		return "", err
+	}
+	return sidecar.ID, nil
+}
+
+func (server *EntryServer) removeDebugSidecar(sidecarID string) {
+	if err := server.dockerClient.RemoveContainer(docker.RemoveContainerOptions{ID: sidecarID, Force: true}); err != nil {
+		log.Errorf("Remove debug sidecar %s error: %s", sidecarID, err.Error())
+	}
+}
+
+// auditDebug logs a debug session loudly, as it's more powerful than the application itself.
+func (server *EntryServer) auditDebug(info sessionInfo, sidecarID string) {
+	privileges := fmt.Sprintf("caps=%v", server.debug.capabilities)
+	if server.debug.privileged {
+		privileges = "PRIVILEGED"
+	}
+	log.Warnf("AUDIT: debug session by %s on %s[%s-%s] container %s, sidecar %s from %s with %s",
+		info.user, info.appName, info.procName, info.instanceNo, info.containerID, sidecarID, server.debug.image, privileges)
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestCheckDebug(t *testing.T) {
+	server := &EntryServer{}
+	if err := server.checkDebug("admin"); err != errDebugDisabled {
+		t.Errorf("Case 1 failed: err is %v", err)
+	}
+	server.debug = newDebugPolicy("nicolaka/netshoot", "", false)
+	if err := server.checkDebug("developer"); err != errDebugForbidden {
+		t.Errorf("Case 2 failed: err is %v", err)
+	}
+	if err := server.checkDebug("owner"); err != nil {
+		t.Errorf("Case 3 failed: err is %v", err)
+	}
+}
+
+func TestStartDebugSidecar(t *testing.T) {
+	var created docker.CreateContainerOptions
+	fake := &fakeDocker{
+		createContainer: func(opts docker.CreateContainerOptions) (*docker.Container, error) {
+			created = opts
+			return &docker.Container{ID: "sidecar"}, nil
+		},
+	}
+	server := &EntryServer{dockerClient: fake, debug: newDebugPolicy("nicolaka/netshoot", "cap_net_admin, SYS_PTRACE", false)}
+	sidecarID, err := server.startDebugSidecar("c1")
+	if err != nil || sidecarID != "sidecar" {
+		t.Fatalf("Start sidecar failed: %q, %v", sidecarID, err)
+	}
+	if created.Config.Image != "nicolaka/netshoot" || created.HostConfig.PidMode != "container:c1" ||
+		created.HostConfig.NetworkMode != "container:c1" || created.HostConfig.Privileged ||
+		!reflect.DeepEqual(created.HostConfig.CapAdd, []string{"NET_ADMIN", "SYS_PTRACE"}) {
+		t.Errorf("Unexpected sidecar: %+v %+v", created.Config, created.HostConfig)
+	}
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultDetachTimeout is how long a detached session waits for its client to reattach.
+	defaultDetachTimeout = 10 * time.Minute
+
+	detachedMsgTemplate = "\033[32m>>> Detached, the session goes on for %s. Reattach with the reattach header %s.\033[0m"
+	detachedAttachMsg   = "\033[32m>>> Detached, the container keeps running.\033[0m"
+)
+
+var (
+	errDetached           = errors.New("detached")
+	errNoDetachedSession  = errors.New("no such detached session")
+	errReattachNotAllowed = errors.New("only enter sessions can be reattached")
+)
+
+// parseDetachKeys parses a detach sequence in the format of docker, comma separated keys
+// which are either a single character or ctrl- followed by one of a-z, @, [, \, ], ^ or _.
+// An empty sequence disables detaching.
+func parseDetachKeys(keys string) ([]byte, error) {
+	if keys == "" {
+		return nil, nil
+	}
+	var sequence []byte
+	for _, key := range strings.Split(keys, ",") {
+		key = strings.TrimSpace(key)
+		if len(key) == 1 {
+			sequence = append(sequence, key[0])
+			continue
+		}
+		if !strings.HasPrefix(strings.ToLower(key), "ctrl-") || len(key) != len("ctrl-")+1 {
+			return nil, fmt.Errorf("invalid detach key %q", key)
+		}
+		c := key[len(key)-1]
+		switch {
+		case c >= 'a' && c <= 'z':
+			sequence = append(sequence, c-'a'+1)
+		case c == '@' || c == '[' || c == '\\' || c == ']' || c == '^' || c == '_':
+			sequence = append(sequence, c-'@')
+		default:
+			return nil, fmt.Errorf("invalid detach key %q", key)
+		}
+	}
+	return sequence, nil
+}
+
+// detachDetector finds the detach sequence in the input of a session. The input matching
+// the beginning of the sequence is held until the sequence is complete, or is given back
+// along with the next input otherwise.
+type detachDetector struct {
+	keys []byte
+	held int
+}
+
+// newDetachDetector returns the detector of the detach sequence, nil if it's disabled.
+func (server *EntryServer) newDetachDetector() *detachDetector {
+	if len(server.detachKeys) == 0 {
+		return nil
+	}
+	return &detachDetector{keys: server.detachKeys}
+}
+
+// scan returns the input of data to pass on, and whether the sequence is complete. The
+// input after the sequence is dropped.
+func (d *detachDetector) scan(data []byte) ([]byte, bool) {
+	out := make([]byte, 0, len(data)+d.held)
+	for _, b := range data {
+		if b == d.keys[d.held] {
+			if d.held++; d.held == len(d.keys) {
+				d.held = 0
+				return out, true
+			}
+			continue
+		}
+		out = append(out, d.keys[:d.held]...)
+		if d.held = 0; b == d.keys[0] {
+			d.held = 1
+			continue
+		}
+		out = append(out, b)
+	}
+	return out, false
+}
+
+// reattachRequest hands the connection of a client over to the detached session it
+// reattaches to. done is closed once the session is done with the connection.
+type reattachRequest struct {
+	ws     *safeConn
+	offset int64
+	done   chan struct{}
+}
+
+// parkSession detaches the client of s and keeps its shell running until the client
+// reattaches, the shell exits, the session is canceled or server.detachTimeout passes.
+// It returns true if the client reattached, otherwise the session ends with the result of
+// the shell. readerDone is closed when the reader of the detached connection ends.
+func (server *EntryServer) parkSession(s *enterSession, session *registeredSession, readerDone <-chan struct{}) (bool, error) {
+	server.sendCloseMessage(s.ws, []byte(fmt.Sprintf(detachedMsgTemplate, server.detachTimeout, session.ID)), s.msgMarshaller)
+	s.ws.detach()
+	<-readerDone
+	if s.attached != nil {
+		close(s.attached)
+		s.attached = nil
+	}
+	atomic.StoreInt32(&session.detached, 1)
+	s.info.logger.Infof("Session %s is detached", session.ID)
+
+	timer := time.NewTimer(server.detachTimeout)
+	defer timer.Stop()
+	select {
+	case req := <-session.reattach:
+		lost, err := s.ws.reattach(req.ws, req.offset, s.msgMarshaller)
+		s.attached = req.done
+		s.info.logger.Infof("Session %s is reattached", session.ID)
+		notice := fmt.Sprintf("Reattached to the session in %s.", s.info.containerID)
+		if err != nil {
+			s.info.logger.Warnf("Output of session %s from %d is not replayed: %s", session.ID, req.offset, err.Error())
+			notice = fmt.Sprintf("Reattached to the session in %s, no output is replayed as the %s.", s.info.containerID, err.Error())
+		} else if lost > 0 {
+			notice = fmt.Sprintf("Reattached to the session in %s, %d bytes of output were lost.", s.info.containerID, lost)
+		}
+		server.sendNoticeMessage(s.ws, notice, s.msgMarshaller)
+		return true, nil
+	case err := <-s.shell.done:
+		s.ended = reasonExitedDetached
+		s.shell.close()
+		atomic.CompareAndSwapInt32(&session.detached, 1, 0)
+		return false, err
+	case <-timer.C:
+		s.ended = reasonDetachTimeout
+	case <-s.ctx.Done():
+	}
+	// A client reattaching now is released when the session is removed from the registry.
+	atomic.CompareAndSwapInt32(&session.detached, 1, 0)
+	s.shell.close()
+	return false, <-s.shell.done
+}
+
+// reattachSession hands the connection of the client of info over to the detached session it
+// asks, and waits until the session is done with it.
+func (server *EntryServer) reattachSession(ws *safeConn, info sessionInfo, msgMarshaller Marshaler) error {
+	req := &reattachRequest{ws: ws, offset: info.resumeOffset, done: make(chan struct{})}
+	ended, err := server.registry.reattach(info, req)
+	if err != nil {
+		errMsg := fmt.Sprintf(errMsgTemplate, fmt.Sprintf("Session %s is not found or not detached.", info.reattach))
+		info.logger.Errorf("Reattach to %s refused: %s", info.reattach, err.Error())
+		server.sendCloseMessage(ws, []byte(errMsg), msgMarshaller)
+		return err
+	}
+	select {
+	case <-req.done:
+	case <-ended:
+	}
+	return nil
+}
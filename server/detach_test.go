@@ -0,0 +1,163 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gorilla/websocket"
+	"github.com/laincloud/entry/message"
+)
+
+func TestParseDetachKeys(t *testing.T) {
+	for i, c := range []struct {
+		keys     string
+		sequence string
+		valid    bool
+	}{
+		{"", "", true},
+		{"ctrl-p,ctrl-q", "\x10\x11", true},
+		{"ctrl-@, q", "\x00q", true},
+		{"ctrl-a,ctrl-\\", "\x01\x1c", true},
+		{"ctrl-1", "", false},
+		{"ctrl-", "", false},
+		{"ab", "", false},
+	} {
+		sequence, err := parseDetachKeys(c.keys)
+		if (err == nil) != c.valid || string(sequence) != c.sequence {
+			t.Errorf("Case %d failed: %q, %v", i+1, sequence, err)
+		}
+	}
+}
+
+func TestDetachDetector(t *testing.T) {
+	d := &detachDetector{keys: []byte("\x10\x11")}
+	for i, c := range []struct {
+		in       string
+		out      string
+		detached bool
+	}{
+		{"ls\r", "ls\r", false},
+		{"a\x10", "a", false},
+		{"b", "\x10b", false},
+		{"\x10\x10", "\x10", false},
+		{"\x11rest", "", true},
+		{"\x10", "", false},
+		{"\x11", "", true},
+	} {
+		out, detached := d.scan([]byte(c.in))
+		if string(out) != c.out || detached != c.detached {
+			t.Errorf("Case %d failed: %q, %t", i+1, out, detached)
+		}
+	}
+}
+
+func TestEnterDetach(t *testing.T) {
+	var shellEnded int32
+	fake := &fakeDocker{
+		startExec: func(id string, opts docker.StartExecOptions) (docker.CloseWaiter, error) {
+			w := &fakeWaiter{done: make(chan struct{})}
+			go func() {
+				io.Copy(opts.OutputStream, opts.InputStream)
+				atomic.StoreInt32(&shellEnded, 1)
+				close(w.done)
+			}()
+			return w, nil
+		},
+	}
+	server := &EntryServer{dockerClient: fake, authorizer: &FakeAuthorizer{Tokens: map[string]string{"mine": "developer", "other": "developer"}},
+		resolver: StaticResolver{"hello/web/1": "c1"}, detachKeys: []byte("\x10\x11"), detachTimeout: time.Minute}
+	ts := httptest.NewServer(http.HandlerFunc(server.enter))
+	defer ts.Close()
+
+	dial := func(token, reattach string) *websocket.Conn {
+		header := http.Header{}
+		header.Set("access-token", token)
+		header.Set("reattach", reattach)
+		ws := dialSession(t, ts, "", header)
+		ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+		return ws
+	}
+	read := func(ws *websocket.Conn) *message.ResponseMessage {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := &message.ResponseMessage{}
+		protoUnmarshalFunc(data, msg)
+		return msg
+	}
+	// readUntil returns the first message of msgType, skipping the output before it.
+	readUntil := func(ws *websocket.Conn, msgType message.ResponseMessage_ResponseType) *message.ResponseMessage {
+		for {
+			if msg := read(ws); msg.MsgType == msgType {
+				return msg
+			}
+		}
+	}
+	input := func(ws *websocket.Conn, content string) {
+		data, _ := protoMarshalFunc(&message.RequestMessage{MsgType: message.RequestMessage_PLAIN, Content: []byte(content)})
+		if err := ws.WriteMessage(websocket.BinaryMessage, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitDetached := func() *registeredSession {
+		for i := 0; i < 100; i++ {
+			if sessions := server.registry.list(); len(sessions) == 1 && atomic.LoadInt32(&sessions[0].detached) == 1 {
+				return sessions[0]
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatal("The session is not detached")
+		return nil
+	}
+
+	ws := dial("mine", "")
+	defer ws.Close()
+	input(ws, "ls\x10\x11")
+	if msg := readUntil(ws, message.ResponseMessage_CLOSE); !strings.Contains(string(msg.Content), "Detached") {
+		t.Fatalf("CLOSE of the detached session is %q", msg.Content)
+	}
+	session := waitDetached()
+	if atomic.LoadInt32(&shellEnded) == 1 {
+		t.Fatal("The shell of the detached session ended")
+	}
+
+	other := dial("other", session.ID)
+	defer other.Close()
+	if msg := read(other); msg.MsgType != message.ResponseMessage_CLOSE || !strings.Contains(string(msg.Content), "not found or not detached") {
+		t.Fatalf("Reattach of another user: %v %q", msg.MsgType, msg.Content)
+	}
+
+	again := dial("mine", session.ID)
+	defer again.Close()
+	if msg := read(again); msg.MsgType != message.ResponseMessage_NOTICE || !strings.Contains(string(msg.Content), "Reattached") {
+		t.Fatalf("Reattach: %v %q", msg.MsgType, msg.Content)
+	}
+	input(again, "pwd")
+	if msg := read(again); string(msg.Content) != "pwd" {
+		t.Fatalf("Output after reattach is %q", msg.Content)
+	}
+	again.Close()
+	server.sessions.Wait()
+	if atomic.LoadInt32(&shellEnded) != 1 {
+		t.Error("The shell goes on after the session ended")
+	}
+
+	// A session not reattached in time ends.
+	server.detachTimeout = 50 * time.Millisecond
+	atomic.StoreInt32(&shellEnded, 0)
+	ws = dial("mine", "")
+	defer ws.Close()
+	input(ws, "\x10\x11")
+	readUntil(ws, message.ResponseMessage_CLOSE)
+	server.sessions.Wait()
+	if atomic.LoadInt32(&shellEnded) != 1 || len(server.registry.list()) != 0 {
+		t.Error("The detached session goes on after the timeout")
+	}
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/laincloud/entry/log"
+)
+
+// dockerAPI is the part of *docker.Client which EntryServer relies on.
+type dockerAPI interface {
+	CreateExec(opts docker.CreateExecOptions) (*docker.Exec, error)
+	StartExec(id string, opts docker.StartExecOptions) error
+	StartExecNonBlocking(id string, opts docker.StartExecOptions) (docker.CloseWaiter, error)
+	InspectExec(id string) (*docker.ExecInspect, error)
+	ResizeExecTTY(id string, height, width int) error
+	ResizeContainerTTY(id string, height, width int) error
+	InspectContainer(id string) (*docker.Container, error)
+	AttachToContainerNonBlocking(opts docker.AttachToContainerOptions) (docker.CloseWaiter, error)
+	ListContainers(opts docker.ListContainersOptions) ([]docker.APIContainers, error)
+	CreateContainer(opts docker.CreateContainerOptions) (*docker.Container, error)
+	StartContainer(id string, hostConfig *docker.HostConfig) error
+	RemoveContainer(opts docker.RemoveContainerOptions) error
+	Stats(opts docker.StatsOptions) error
+}
+
+// errExecAlreadyStarted is returned by startExec for an exec started already, by this
+// server as execGuard tells, or by another as docker tells.
+var errExecAlreadyStarted = errors.New("exec is already started")
+
+// execGuard records the execs being run by this server, so that a reconnect or a
+// double submit never starts the same exec twice.
+type execGuard struct {
+	sync.Mutex
+	started map[string]bool
+}
+
+// acquire marks the exec as started, it returns false if the exec has been started already.
+func (g *execGuard) acquire(execID string) bool {
+	g.Lock()
+	defer g.Unlock()
+	if g.started == nil {
+		g.started = make(map[string]bool)
+	}
+	if g.started[execID] {
+		return false
+	}
+	g.started[execID] = true
+	return true
+}
+
+// release forgets the exec after its session ends.
+func (g *execGuard) release(execID string) {
+	g.Lock()
+	defer g.Unlock()
+	delete(g.started, execID)
+}
+
+const (
+	defaultExecRetries = 2
+	execRetryBackoff   = 200 * time.Millisecond
+)
+
+// isTransientDockerError reports whether err is likely caused by a busy or briefly
+// unreachable daemon, rather than by the container itself.
+func isTransientDockerError(err error) bool {
+	if isDaemonUnavailable(err) {
+		return true
+	}
+	switch e := err.(type) {
+	case *docker.NoSuchContainer, *docker.ContainerNotRunning, *docker.NoSuchExec:
+		return false
+	case *docker.Error:
+		return e.Status >= 500
+	case net.Error:
+		return true
+	}
+	return err == io.EOF || err == io.ErrUnexpectedEOF
+}
+
+// isDaemonUnavailable reports whether err means the daemon can't be reached at all, like
+// a connection refused by its socket, rather than a daemon failing a request.
+func isDaemonUnavailable(err error) bool {
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+	if opErr, ok := err.(*net.OpError); ok {
+		return opErr.Op == "dial"
+	}
+	return err == docker.ErrConnectionRefused
+}
+
+// dockerFailureMessage returns what to tell the client of a session failed by the docker
+// error err, userMsg unless the whole daemon is unavailable. Operators are warned then, as
+// every session on the host fails alike.
+func (info sessionInfo) dockerFailureMessage(err error, userMsg string) string {
+	if isDaemonUnavailable(err) {
+		info.logger.Warnf("Docker daemon is unavailable: %s", err.Error())
+		return fmt.Sprintf(errMsgTemplate, daemonUnavailableMsg)
+	}
+	return fmt.Sprintf(errMsgTemplate, userMsg)
+}
+
+// isExecNeverStarted reports whether err, returned by the start of an exec, proves that the
+// exec didn't start: a daemon never reached, or refusing the exec or its container. Others,
+// like a connection lost on the way, may come after the daemon started the exec.
+func isExecNeverStarted(err error) bool {
+	switch e := err.(type) {
+	case *docker.NoSuchContainer, *docker.ContainerNotRunning, *docker.NoSuchExec:
+		return true
+	case *docker.Error:
+		return e.Status == http.StatusNotFound || e.Status == http.StatusConflict
+	}
+	return isDaemonUnavailable(err)
+}
+
+// isExecAlreadyRunning reports whether err is the conflict docker answers to the start of
+// an exec which is running already, started by another server or client.
+func isExecAlreadyRunning(err error) bool {
+	e, ok := err.(*docker.Error)
+	return ok && e.Status == http.StatusConflict && strings.Contains(e.Message, "already running")
+}
+
+// startExec creates and starts an exec with the given options, retrying up to
+// server.execRetries times with backoff when docker fails transiently. Only the creation
+// is retried, or a start which never happened, so that a client never gets two shells
+// the first of which took its input. An exec pooled with the same options is started
+// instead if there is one.
+// It returns the started exec and the waiter of its session, the caller must
+// release the exec from server.execGuard when the session ends.
+func (server *EntryServer) startExec(createOpts docker.CreateExecOptions, startOpts docker.StartExecOptions) (*docker.Exec, docker.CloseWaiter, error) {
+	var (
+		exec   *docker.Exec
+		waiter docker.CloseWaiter
+		err    error
+	)
+	if exec = server.execPool.take(createOpts); exec != nil && server.execGuard.acquire(exec.ID) {
+		if waiter, err = server.dockerClient.StartExecNonBlocking(exec.ID, startOpts); err == nil {
+			return exec, waiter, nil
+		}
+		server.execGuard.release(exec.ID)
+		if !isExecNeverStarted(err) {
+			return nil, nil, err
+		}
+		// The container may have been restarted since, create another exec.
+		log.Debugf("Start pooled exec %s failed: %s", exec.ID, err.Error())
+	}
+	backoff := execRetryBackoff
+	for attempt := 0; ; attempt++ {
+		if exec, err = server.dockerClient.CreateExec(createOpts); err == nil {
+			if !server.execGuard.acquire(exec.ID) {
+				return nil, nil, errExecAlreadyStarted
+			}
+			if waiter, err = server.dockerClient.StartExecNonBlocking(exec.ID, startOpts); err == nil {
+				return exec, waiter, nil
+			}
+			server.execGuard.release(exec.ID)
+			if isExecAlreadyRunning(err) {
+				return nil, nil, errExecAlreadyStarted
+			}
+			if !isExecNeverStarted(err) {
+				return nil, nil, err
+			}
+		}
+		if attempt >= server.execRetries || !isTransientDockerError(err) {
+			return nil, nil, err
+		}
+		log.Warnf("Start exec in %s failed, retry in %s: %s", createOpts.Container, backoff, err.Error())
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
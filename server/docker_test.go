@@ -0,0 +1,334 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/laincloud/entry/message"
+)
+
+// fakeDocker implements dockerAPI with overridable functions, the zero value
+// behaves like a healthy daemon running a single container.
+type fakeDocker struct {
+	createExec       func(opts docker.CreateExecOptions) (*docker.Exec, error)
+	startExec        func(id string, opts docker.StartExecOptions) (docker.CloseWaiter, error)
+	inspectExec      func(id string) (*docker.ExecInspect, error)
+	resizeExecTTY    func(id string, height, width int) error
+	resizeContainer  func(id string, height, width int) error
+	inspectContainer func(id string) (*docker.Container, error)
+	attach           func(opts docker.AttachToContainerOptions) (docker.CloseWaiter, error)
+	listContainers   func(opts docker.ListContainersOptions) ([]docker.APIContainers, error)
+	createContainer  func(opts docker.CreateContainerOptions) (*docker.Container, error)
+	removeContainer  func(opts docker.RemoveContainerOptions) error
+	stats            func(opts docker.StatsOptions) error
+}
+
+type fakeWaiter struct {
+	done chan struct{}
+	err  error
+}
+
+func newFakeWaiter(err error) *fakeWaiter {
+	w := &fakeWaiter{done: make(chan struct{}), err: err}
+	close(w.done)
+	return w
+}
+
+func (w *fakeWaiter) Close() error { return nil }
+func (w *fakeWaiter) Wait() error  { <-w.done; return w.err }
+
+func (d *fakeDocker) CreateExec(opts docker.CreateExecOptions) (*docker.Exec, error) {
+	if d.createExec != nil {
+		return d.createExec(opts)
+	}
+	return &docker.Exec{ID: "exec"}, nil
+}
+
+func (d *fakeDocker) StartExec(id string, opts docker.StartExecOptions) error {
+	waiter, err := d.StartExecNonBlocking(id, opts)
+	if err != nil {
+		return err
+	}
+	return waiter.Wait()
+}
+
+func (d *fakeDocker) StartExecNonBlocking(id string, opts docker.StartExecOptions) (docker.CloseWaiter, error) {
+	if d.startExec != nil {
+		return d.startExec(id, opts)
+	}
+	return newFakeWaiter(nil), nil
+}
+
+func (d *fakeDocker) InspectExec(id string) (*docker.ExecInspect, error) {
+	if d.inspectExec != nil {
+		return d.inspectExec(id)
+	}
+	return &docker.ExecInspect{ID: id}, nil
+}
+
+func (d *fakeDocker) ResizeExecTTY(id string, height, width int) error {
+	if d.resizeExecTTY != nil {
+		return d.resizeExecTTY(id, height, width)
+	}
+	return nil
+}
+
+func (d *fakeDocker) ResizeContainerTTY(id string, height, width int) error {
+	if d.resizeContainer != nil {
+		return d.resizeContainer(id, height, width)
+	}
+	return nil
+}
+
+func (d *fakeDocker) InspectContainer(id string) (*docker.Container, error) {
+	if d.inspectContainer != nil {
+		return d.inspectContainer(id)
+	}
+	return &docker.Container{ID: id, State: docker.State{Running: true}}, nil
+}
+
+func (d *fakeDocker) AttachToContainerNonBlocking(opts docker.AttachToContainerOptions) (docker.CloseWaiter, error) {
+	if d.attach != nil {
+		return d.attach(opts)
+	}
+	return newFakeWaiter(nil), nil
+}
+
+func (d *fakeDocker) ListContainers(opts docker.ListContainersOptions) ([]docker.APIContainers, error) {
+	if d.listContainers != nil {
+		return d.listContainers(opts)
+	}
+	return nil, nil
+}
+
+func (d *fakeDocker) CreateContainer(opts docker.CreateContainerOptions) (*docker.Container, error) {
+	if d.createContainer != nil {
+		return d.createContainer(opts)
+	}
+	return &docker.Container{ID: "sidecar"}, nil
+}
+
+func (d *fakeDocker) StartContainer(id string, hostConfig *docker.HostConfig) error {
+	return nil
+}
+
+func (d *fakeDocker) RemoveContainer(opts docker.RemoveContainerOptions) error {
+	if d.removeContainer != nil {
+		return d.removeContainer(opts)
+	}
+	return nil
+}
+
+func (d *fakeDocker) Stats(opts docker.StatsOptions) error {
+	if d.stats != nil {
+		return d.stats(opts)
+	}
+	close(opts.Stats)
+	return nil
+}
+
+func TestStartExecRetry(t *testing.T) {
+	creates := 0
+	fake := &fakeDocker{
+		createExec: func(opts docker.CreateExecOptions) (*docker.Exec, error) {
+			creates++
+			if creates < 3 {
+				return nil, &docker.Error{Status: 500, Message: "daemon busy"}
+			}
+			return &docker.Exec{ID: "exec"}, nil
+		},
+	}
+	server := &EntryServer{dockerClient: fake, execRetries: 2}
+	if exec, _, err := server.startExec(docker.CreateExecOptions{}, docker.StartExecOptions{}); err != nil || exec.ID != "exec" {
+		t.Errorf("Case 1 failed: %v", err)
+	}
+	if creates != 3 {
+		t.Errorf("Case 1 failed: created %d times", creates)
+	}
+
+	creates = 0
+	server.execRetries = 1
+	if _, _, err := server.startExec(docker.CreateExecOptions{}, docker.StartExecOptions{}); err == nil || creates != 2 {
+		t.Errorf("Case 2 failed: created %d times, %v", creates, err)
+	}
+
+	creates = 0
+	fake.createExec = func(opts docker.CreateExecOptions) (*docker.Exec, error) {
+		creates++
+		return nil, &docker.NoSuchContainer{ID: opts.Container}
+	}
+	if _, _, err := server.startExec(docker.CreateExecOptions{}, docker.StartExecOptions{}); err == nil || creates != 1 {
+		t.Errorf("Case 3 failed: created %d times, %v", creates, err)
+	}
+
+	// A start which may have happened is never retried, unlike one the daemon never got.
+	fake.createExec = func(opts docker.CreateExecOptions) (*docker.Exec, error) {
+		creates++
+		return &docker.Exec{ID: fmt.Sprintf("exec%d", creates)}, nil
+	}
+	for i, c := range []struct {
+		err    error
+		starts int
+	}{
+		{io.ErrUnexpectedEOF, 1},
+		{&net.OpError{Op: "read", Net: "unix", Err: errors.New("connection reset by peer")}, 1},
+		{&docker.Error{Status: 500, Message: "daemon busy"}, 1},
+		{docker.ErrConnectionRefused, 2},
+	} {
+		creates = 0
+		starts := 0
+		fake.startExec = func(id string, opts docker.StartExecOptions) (docker.CloseWaiter, error) {
+			if starts++; starts == 1 {
+				return nil, c.err
+			}
+			return newFakeWaiter(nil), nil
+		}
+		_, _, err := server.startExec(docker.CreateExecOptions{}, docker.StartExecOptions{})
+		if starts != c.starts || (err == nil) != (c.starts > 1) {
+			t.Errorf("Case %d failed: started %d times, %v", i+4, starts, err)
+		}
+	}
+}
+
+func TestIsTransientDockerError(t *testing.T) {
+	cases := []struct {
+		err      error
+		expected bool
+	}{
+		{docker.ErrConnectionRefused, true},
+		{&docker.Error{Status: 503}, true},
+		{&docker.Error{Status: 404}, false},
+		{&docker.NoSuchContainer{ID: "c"}, false},
+		{&docker.ContainerNotRunning{ID: "c"}, false},
+		{errors.New("unknown"), false},
+	}
+	for i, c := range cases {
+		if actual := isTransientDockerError(c.err); actual != c.expected {
+			t.Errorf("Case %d failed: actual is %t", i+1, actual)
+		}
+	}
+}
+
+func TestIsDaemonUnavailable(t *testing.T) {
+	dialErr := &net.OpError{Op: "dial", Net: "unix", Err: errors.New("connect: no such file or directory")}
+	cases := []struct {
+		err      error
+		expected bool
+	}{
+		{docker.ErrConnectionRefused, true},
+		{dialErr, true},
+		{&url.Error{Op: "Post", URL: "http://unix.sock/exec", Err: dialErr}, true},
+		{&net.OpError{Op: "read", Net: "unix", Err: errors.New("connection reset by peer")}, false},
+		{&docker.Error{Status: 503}, false},
+		{&docker.NoSuchContainer{ID: "c"}, false},
+		{errDockerTimeout, false},
+	}
+	for i, c := range cases {
+		if actual := isDaemonUnavailable(c.err); actual != c.expected {
+			t.Errorf("Case %d failed: actual is %t", i+1, actual)
+		}
+	}
+}
+
+func TestEnterDaemonUnavailable(t *testing.T) {
+	refused := func(id string) (*docker.Container, error) { return nil, docker.ErrConnectionRefused }
+	cases := []struct {
+		fake     *fakeDocker
+		expected string
+	}{
+		{&fakeDocker{inspectContainer: refused}, daemonUnavailableMsg},
+		{&fakeDocker{createExec: func(opts docker.CreateExecOptions) (*docker.Exec, error) {
+			return nil, &url.Error{Op: "Post", URL: "http://unix.sock/exec", Err: &net.OpError{Op: "dial", Net: "unix", Err: errors.New("connect: connection refused")}}
+		}}, daemonUnavailableMsg},
+		// Problems of the container are told as before.
+		{&fakeDocker{createExec: func(opts docker.CreateExecOptions) (*docker.Exec, error) {
+			return nil, &docker.ContainerNotRunning{ID: opts.Container}
+		}}, "Can't enter your container, try again."},
+	}
+	for i, c := range cases {
+		server := &EntryServer{dockerClient: c.fake, authorizer: &FakeAuthorizer{Allow: true}, resolver: StaticResolver{"hello/web/1": "c1"}}
+		ts := httptest.NewServer(http.HandlerFunc(server.enter))
+		ws := dialSession(t, ts, "", nil)
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := message.ResponseMessage{}
+		protoUnmarshalFunc(data, &msg)
+		if msg.MsgType != message.ResponseMessage_CLOSE || !strings.Contains(string(msg.Content), c.expected) {
+			t.Errorf("Case %d failed: %v %q", i+1, msg.MsgType, msg.Content)
+		}
+		ws.Close()
+		server.sessions.Wait()
+		ts.Close()
+	}
+}
+
+func TestStartExecOnce(t *testing.T) {
+	starts := 0
+	fake := &fakeDocker{
+		startExec: func(id string, opts docker.StartExecOptions) (docker.CloseWaiter, error) {
+			starts++
+			return newFakeWaiter(nil), nil
+		},
+	}
+	server := &EntryServer{dockerClient: fake}
+	if _, _, err := server.startExec(docker.CreateExecOptions{}, docker.StartExecOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := server.startExec(docker.CreateExecOptions{}, docker.StartExecOptions{}); err != errExecAlreadyStarted {
+		t.Errorf("Duplicate start is not detected: %v", err)
+	}
+	if starts != 1 {
+		t.Errorf("Exec is started %d times", starts)
+	}
+	server.execGuard.release("exec")
+	if _, _, err := server.startExec(docker.CreateExecOptions{}, docker.StartExecOptions{}); err != nil {
+		t.Errorf("Released exec can't be started: %v", err)
+	}
+
+	// An exec started by another server is told by the conflict of docker, not retried.
+	server.execGuard.release("exec")
+	starts = 0
+	fake.startExec = func(id string, opts docker.StartExecOptions) (docker.CloseWaiter, error) {
+		starts++
+		return nil, &docker.Error{Status: http.StatusConflict, Message: "Exec command exec is already running"}
+	}
+	server.execRetries = 2
+	if _, _, err := server.startExec(docker.CreateExecOptions{}, docker.StartExecOptions{}); err != errExecAlreadyStarted || starts != 1 {
+		t.Errorf("Exec running already is started %d times: %v", starts, err)
+	}
+}
+
+func TestEnterExecAlreadyStarted(t *testing.T) {
+	fake := &fakeDocker{
+		startExec: func(id string, opts docker.StartExecOptions) (docker.CloseWaiter, error) {
+			return nil, &docker.Error{Status: http.StatusConflict, Message: "Exec command exec is already running"}
+		},
+	}
+	server := &EntryServer{dockerClient: fake, authorizer: &FakeAuthorizer{Allow: true}, resolver: StaticResolver{"hello/web/1": "c1"}}
+	ts := httptest.NewServer(http.HandlerFunc(server.enter))
+	defer ts.Close()
+
+	ws := dialSession(t, ts, "", nil)
+	defer ws.Close()
+	_, data, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := message.ResponseMessage{}
+	if err = protoUnmarshalFunc(data, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.MsgType != message.ResponseMessage_CLOSE || !strings.Contains(string(msg.Content), "This session has been started already.") {
+		t.Errorf("Unexpected response: %v", msg)
+	}
+}
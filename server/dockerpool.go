@@ -0,0 +1,228 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/laincloud/entry/log"
+)
+
+// execNodeSeparator joins the node to the ID of an exec created through a dockerPool,
+// docker IDs being hex they never contain it.
+const execNodeSeparator = "@"
+
+const (
+	// locatedSize bounds the containers located, those unused for locatedIdle are dropped
+	// beyond it. Like the resolve cache, all are dropped if it's still full then, the next
+	// resolve locates them again.
+	locatedSize = resolveCacheSize
+	locatedIdle = maxResolveCacheTTL
+)
+
+type locatedEntry struct {
+	node string
+	used time.Time
+}
+
+// dockerPool routes the docker requests of a container to the client of the node running
+// it, so that a single entry reaches the containers of a whole cluster. Containers are
+// located by the resolver, see EntryServer.resolve, the others like those entered by ID are
+// reached through fallback, e.g. a swarm manager. Execs carry their node in their ID.
+type dockerPool struct {
+	fallback dockerAPI
+	nodes    map[string]dockerAPI
+	now      func() time.Time
+
+	lock    sync.Mutex
+	located map[string]locatedEntry
+}
+
+func newDockerPool(fallback dockerAPI, nodes map[string]dockerAPI) *dockerPool {
+	return &dockerPool{fallback: fallback, nodes: nodes, now: time.Now, located: make(map[string]locatedEntry)}
+}
+
+// parseDockerNodes parses the comma separated node=endpoint pairs of nodes.
+func parseDockerNodes(nodes string) (map[string]string, error) {
+	endpoints := make(map[string]string)
+	for _, pair := range strings.Split(nodes, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid docker node %q, expected node=endpoint", pair)
+		}
+		endpoints[parts[0]] = parts[1]
+	}
+	return endpoints, nil
+}
+
+// locate records the node running containerID, an unknown node falls back.
+func (p *dockerPool) locate(containerID, node string) {
+	if _, ok := p.nodes[node]; !ok {
+		if node != "" {
+			log.Warnf("Container %s is on unknown node %s, reached by the default endpoint", containerID, node)
+		}
+		p.forget(containerID)
+		return
+	}
+	now := p.now()
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if _, ok := p.located[containerID]; !ok && len(p.located) >= locatedSize {
+		for id, entry := range p.located {
+			if now.Sub(entry.used) >= locatedIdle {
+				delete(p.located, id)
+			}
+		}
+		if len(p.located) >= locatedSize {
+			p.located = make(map[string]locatedEntry)
+		}
+	}
+	p.located[containerID] = locatedEntry{node: node, used: now}
+}
+
+func (p *dockerPool) forget(containerID string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	delete(p.located, containerID)
+}
+
+// container returns the client and the node of containerID, the node is empty for fallback.
+func (p *dockerPool) container(containerID string) (dockerAPI, string) {
+	now := p.now()
+	p.lock.Lock()
+	entry, ok := p.located[containerID]
+	if ok {
+		entry.used = now
+		p.located[containerID] = entry
+	}
+	p.lock.Unlock()
+	if !ok {
+		return p.fallback, ""
+	}
+	return p.nodes[entry.node], entry.node
+}
+
+// exec returns the client and the ID on its node of the exec id.
+func (p *dockerPool) exec(id string) (dockerAPI, string) {
+	i := strings.LastIndex(id, execNodeSeparator)
+	if i < 0 {
+		return p.fallback, id
+	}
+	if client, ok := p.nodes[id[:i]]; ok {
+		return client, id[i+1:]
+	}
+	return p.fallback, id[i+1:]
+}
+
+func (p *dockerPool) CreateExec(opts docker.CreateExecOptions) (*docker.Exec, error) {
+	client, node := p.container(opts.Container)
+	exec, err := client.CreateExec(opts)
+	if err != nil || node == "" {
+		return exec, err
+	}
+	return &docker.Exec{ID: node + execNodeSeparator + exec.ID}, nil
+}
+
+func (p *dockerPool) StartExec(id string, opts docker.StartExecOptions) error {
+	client, id := p.exec(id)
+	return client.StartExec(id, opts)
+}
+
+func (p *dockerPool) StartExecNonBlocking(id string, opts docker.StartExecOptions) (docker.CloseWaiter, error) {
+	client, id := p.exec(id)
+	return client.StartExecNonBlocking(id, opts)
+}
+
+func (p *dockerPool) InspectExec(id string) (*docker.ExecInspect, error) {
+	client, id := p.exec(id)
+	return client.InspectExec(id)
+}
+
+func (p *dockerPool) ResizeExecTTY(id string, height, width int) error {
+	client, id := p.exec(id)
+	return client.ResizeExecTTY(id, height, width)
+}
+
+func (p *dockerPool) ResizeContainerTTY(id string, height, width int) error {
+	client, _ := p.container(id)
+	return client.ResizeContainerTTY(id, height, width)
+}
+
+func (p *dockerPool) InspectContainer(id string) (*docker.Container, error) {
+	client, _ := p.container(id)
+	container, err := client.InspectContainer(id)
+	if _, ok := err.(*docker.NoSuchContainer); ok {
+		p.forget(id)
+	}
+	return container, err
+}
+
+func (p *dockerPool) AttachToContainerNonBlocking(opts docker.AttachToContainerOptions) (docker.CloseWaiter, error) {
+	client, _ := p.container(opts.Container)
+	return client.AttachToContainerNonBlocking(opts)
+}
+
+// ListContainers lists the containers known to the fallback only.
+func (p *dockerPool) ListContainers(opts docker.ListContainersOptions) ([]docker.APIContainers, error) {
+	return p.fallback.ListContainers(opts)
+}
+
+// CreateContainer creates a container joining another one, like a debug sidecar, on the
+// node of the other one.
+func (p *dockerPool) CreateContainer(opts docker.CreateContainerOptions) (*docker.Container, error) {
+	client, node := p.fallback, ""
+	if opts.HostConfig != nil && strings.HasPrefix(opts.HostConfig.NetworkMode, "container:") {
+		client, node = p.container(strings.TrimPrefix(opts.HostConfig.NetworkMode, "container:"))
+	}
+	container, err := client.CreateContainer(opts)
+	if err == nil && node != "" {
+		p.locate(container.ID, node)
+	}
+	return container, err
+}
+
+func (p *dockerPool) StartContainer(id string, hostConfig *docker.HostConfig) error {
+	client, _ := p.container(id)
+	return client.StartContainer(id, hostConfig)
+}
+
+func (p *dockerPool) RemoveContainer(opts docker.RemoveContainerOptions) error {
+	client, _ := p.container(opts.ID)
+	err := client.RemoveContainer(opts)
+	if err == nil {
+		p.forget(opts.ID)
+	}
+	return err
+}
+
+func (p *dockerPool) Stats(opts docker.StatsOptions) error {
+	client, _ := p.container(opts.ID)
+	return client.Stats(opts)
+}
+
+// newDockerAPI connects to config.DockerEndpoint, pooled with the endpoints of
+// config.DockerNodes if any.
+func newDockerAPI(config Config) (dockerAPI, error) {
+	client, err := newDockerClient(config.DockerEndpoint, config.SSHKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	fallback := newRuntimeClient(newTimeoutClient(client, config.DockerTimeout), config.Runtime)
+	endpoints, err := parseDockerNodes(config.DockerNodes)
+	if err != nil || len(endpoints) == 0 {
+		return fallback, err
+	}
+	nodes := make(map[string]dockerAPI, len(endpoints))
+	for node, endpoint := range endpoints {
+		if client, err = newDockerClient(endpoint, config.SSHKeyPath); err != nil {
+			return nil, fmt.Errorf("docker of node %s: %s", node, err.Error())
+		}
+		nodes[node] = newRuntimeClient(newTimeoutClient(client, config.DockerTimeout), config.Runtime)
+	}
+	return newDockerPool(fallback, nodes), nil
+}
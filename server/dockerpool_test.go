@@ -0,0 +1,118 @@
+package server
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// nodeResolver resolves every instance to container c1 on node1.
+type nodeResolver struct{ StaticResolver }
+
+func (r nodeResolver) ResolveNode(appName, procName, instanceNo string) (string, string, error) {
+	containerID, err := r.Resolve(appName, procName, instanceNo)
+	return containerID, "node1", err
+}
+
+func TestDockerPool(t *testing.T) {
+	var calls []string
+	fakeNode := func(name string) *fakeDocker {
+		return &fakeDocker{
+			createExec: func(opts docker.CreateExecOptions) (*docker.Exec, error) {
+				calls = append(calls, name+" create exec in "+opts.Container)
+				return &docker.Exec{ID: "e1"}, nil
+			},
+			resizeExecTTY: func(id string, height, width int) error {
+				calls = append(calls, name+" resize "+id)
+				return nil
+			},
+			createContainer: func(opts docker.CreateContainerOptions) (*docker.Container, error) {
+				calls = append(calls, name+" create container")
+				return &docker.Container{ID: "sidecar"}, nil
+			},
+			removeContainer: func(opts docker.RemoveContainerOptions) error {
+				calls = append(calls, name+" remove "+opts.ID)
+				return nil
+			},
+		}
+	}
+	pool := newDockerPool(fakeNode("fallback"), map[string]dockerAPI{"node1": fakeNode("node1")})
+	server := &EntryServer{dockerClient: pool, dockerPool: pool, resolver: nodeResolver{StaticResolver{"hello/web/1": "c1"}}}
+	if containerID, err := server.resolve("hello", "web", "1"); err != nil || containerID != "c1" {
+		t.Fatalf("Resolve failed: %s %v", containerID, err)
+	}
+
+	exec, _ := pool.CreateExec(docker.CreateExecOptions{Container: "c1"})
+	if exec.ID != "node1@e1" {
+		t.Errorf("Exec ID is %s", exec.ID)
+	}
+	pool.ResizeExecTTY(exec.ID, 24, 80)
+	exec, _ = pool.CreateExec(docker.CreateExecOptions{Container: "c2"})
+	pool.ResizeExecTTY(exec.ID, 24, 80)
+	pool.CreateContainer(docker.CreateContainerOptions{HostConfig: &docker.HostConfig{NetworkMode: "container:c1"}})
+	pool.RemoveContainer(docker.RemoveContainerOptions{ID: "sidecar"})
+	pool.RemoveContainer(docker.RemoveContainerOptions{ID: "sidecar"})
+
+	expected := []string{
+		"node1 create exec in c1",
+		"node1 resize e1",
+		"fallback create exec in c2",
+		"fallback resize e1",
+		"node1 create container",
+		"node1 remove sidecar",
+		"fallback remove sidecar",
+	}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("Calls are %q", calls)
+	}
+}
+
+func TestDockerPoolLocatedBound(t *testing.T) {
+	now := time.Now()
+	pool := newDockerPool(&fakeDocker{}, map[string]dockerAPI{"node1": &fakeDocker{}})
+	pool.now = func() time.Time { return now }
+	for i := 0; i < locatedSize; i++ {
+		pool.locate(fmt.Sprintf("c%d", i), "node1")
+	}
+	// c0 is still used, the others are idle once the pool is full.
+	now = now.Add(locatedIdle)
+	pool.container("c0")
+	pool.locate("new", "node1")
+	if len(pool.located) != 2 {
+		t.Errorf("%d containers are located", len(pool.located))
+	}
+	for _, id := range []string{"c0", "new"} {
+		if _, node := pool.container(id); node != "node1" {
+			t.Errorf("Container %s is on %q", id, node)
+		}
+	}
+
+	// Nothing is idle, all are dropped.
+	for i := len(pool.located); i < locatedSize; i++ {
+		pool.locate(fmt.Sprintf("c%d", i), "node1")
+	}
+	pool.locate("other", "node1")
+	if len(pool.located) != 1 {
+		t.Errorf("%d containers are located", len(pool.located))
+	}
+}
+
+func TestParseDockerNodes(t *testing.T) {
+	cases := []struct {
+		nodes    string
+		expected map[string]string
+	}{
+		{"", map[string]string{}},
+		{"node1=tcp://10.0.0.1:2375, node2=ssh://root@10.0.0.2", map[string]string{"node1": "tcp://10.0.0.1:2375", "node2": "ssh://root@10.0.0.2"}},
+		{"node1", nil},
+		{"=tcp://10.0.0.1:2375", nil},
+	}
+	for i, c := range cases {
+		if actual, _ := parseDockerNodes(c.nodes); !reflect.DeepEqual(actual, c.expected) {
+			t.Errorf("Case %d failed: actual is %v", i+1, actual)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/laincloud/entry/log"
+)
+
+// defaultDockerTimeout bounds the docker requests which should be answered at once.
+const defaultDockerTimeout = 30 * time.Second
+
+// errDockerTimeout is returned when the docker daemon doesn't answer in time, it's likely wedged.
+var errDockerTimeout = errors.New("docker request timed out")
+
+// timeoutClient bounds the docker requests of a client by timeout, so that a wedged daemon
+// fails sessions instead of hanging them. Only the setup of execs and attaches is bounded,
+// not their streams, and waiting for a container or sampling its stats is never bounded.
+// The requests not taking a context are left running in background when they time out.
+type timeoutClient struct {
+	*docker.Client
+	timeout time.Duration
+}
+
+// newTimeoutClient bounds the requests of client by timeout, zero leaves them unbounded.
+func newTimeoutClient(client *docker.Client, timeout time.Duration) dockerAPI {
+	if timeout <= 0 {
+		return client
+	}
+	return &timeoutClient{Client: client, timeout: timeout}
+}
+
+func (c *timeoutClient) timedOut(request string) error {
+	log.Warnf("Docker request %s timed out after %s", request, c.timeout)
+	return errDockerTimeout
+}
+
+// contextError tells a timeout from other errors of a request made with ctx.
+func (c *timeoutClient) contextError(ctx context.Context, request string, err error) error {
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return c.timedOut(request)
+	}
+	return err
+}
+
+func (c *timeoutClient) CreateExec(opts docker.CreateExecOptions) (*docker.Exec, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	opts.Context = ctx
+	exec, err := c.Client.CreateExec(opts)
+	return exec, c.contextError(ctx, "create exec", err)
+}
+
+func (c *timeoutClient) StartExec(id string, opts docker.StartExecOptions) error {
+	done := make(chan error, 1)
+	go func() { done <- c.Client.StartExec(id, opts) }()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(c.timeout):
+		return c.timedOut("start exec " + id)
+	}
+}
+
+func (c *timeoutClient) StartExecNonBlocking(id string, opts docker.StartExecOptions) (docker.CloseWaiter, error) {
+	return c.hijack("start exec "+id, func() (docker.CloseWaiter, error) {
+		return c.Client.StartExecNonBlocking(id, opts)
+	})
+}
+
+func (c *timeoutClient) AttachToContainerNonBlocking(opts docker.AttachToContainerOptions) (docker.CloseWaiter, error) {
+	return c.hijack("attach "+opts.Container, func() (docker.CloseWaiter, error) {
+		return c.Client.AttachToContainerNonBlocking(opts)
+	})
+}
+
+// hijack bounds the setup of a stream by request, a stream set up too late is closed.
+func (c *timeoutClient) hijack(request string, setup func() (docker.CloseWaiter, error)) (docker.CloseWaiter, error) {
+	type result struct {
+		waiter docker.CloseWaiter
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		waiter, err := setup()
+		done <- result{waiter, err}
+	}()
+	select {
+	case r := <-done:
+		return r.waiter, r.err
+	case <-time.After(c.timeout):
+		go func() {
+			if r := <-done; r.err == nil {
+				r.waiter.Close()
+			}
+		}()
+		return nil, c.timedOut(request)
+	}
+}
+
+func (c *timeoutClient) InspectExec(id string) (*docker.ExecInspect, error) {
+	type result struct {
+		inspect *docker.ExecInspect
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		inspect, err := c.Client.InspectExec(id)
+		done <- result{inspect, err}
+	}()
+	select {
+	case r := <-done:
+		return r.inspect, r.err
+	case <-time.After(c.timeout):
+		return nil, c.timedOut("inspect exec " + id)
+	}
+}
+
+func (c *timeoutClient) ResizeExecTTY(id string, height, width int) error {
+	done := make(chan error, 1)
+	go func() { done <- c.Client.ResizeExecTTY(id, height, width) }()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(c.timeout):
+		return c.timedOut("resize exec " + id)
+	}
+}
+
+func (c *timeoutClient) ResizeContainerTTY(id string, height, width int) error {
+	done := make(chan error, 1)
+	go func() { done <- c.Client.ResizeContainerTTY(id, height, width) }()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(c.timeout):
+		return c.timedOut("resize container " + id)
+	}
+}
+
+func (c *timeoutClient) InspectContainer(id string) (*docker.Container, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	container, err := c.Client.InspectContainerWithContext(id, ctx)
+	return container, c.contextError(ctx, "inspect container "+id, err)
+}
+
+func (c *timeoutClient) ListContainers(opts docker.ListContainersOptions) ([]docker.APIContainers, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	opts.Context = ctx
+	containers, err := c.Client.ListContainers(opts)
+	return containers, c.contextError(ctx, "list containers", err)
+}
+
+func (c *timeoutClient) CreateContainer(opts docker.CreateContainerOptions) (*docker.Container, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	opts.Context = ctx
+	container, err := c.Client.CreateContainer(opts)
+	return container, c.contextError(ctx, "create container", err)
+}
+
+func (c *timeoutClient) StartContainer(id string, hostConfig *docker.HostConfig) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	return c.contextError(ctx, "start container "+id, c.Client.StartContainerWithContext(id, hostConfig, ctx))
+}
+
+func (c *timeoutClient) RemoveContainer(opts docker.RemoveContainerOptions) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	opts.Context = ctx
+	return c.contextError(ctx, "remove container "+opts.ID, c.Client.RemoveContainer(opts))
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestTimeoutClient(t *testing.T) {
+	// The daemon is wedged, it never answers.
+	wedged := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-wedged
+	}))
+	defer ts.Close()
+	defer close(wedged)
+	client, err := docker.NewClient(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c := newTimeoutClient(client, 0); c != client {
+		t.Errorf("Zero timeout wraps the client: %T", c)
+	}
+	c := newTimeoutClient(client, 50*time.Millisecond)
+	for i, request := range []func() error{
+		func() error { _, err := c.InspectContainer("c1"); return err },
+		func() error { _, err := c.CreateExec(docker.CreateExecOptions{Container: "c1"}); return err },
+		func() error { _, err := c.InspectExec("e1"); return err },
+		func() error { return c.ResizeExecTTY("e1", 24, 80) },
+	} {
+		start := time.Now()
+		if err := request(); err != errDockerTimeout {
+			t.Errorf("Case %d failed: %v", i+1, err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("Case %d failed: timed out after %s", i+1, elapsed)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/transform"
+)
+
+// outputEncodings are the encodings of container output transcoded to UTF-8 for the
+// clients, by their lower case names. UTF-8 passes through as it is.
+var outputEncodings = map[string]encoding.Encoding{
+	"utf-8":        nil,
+	"utf8":         nil,
+	"gbk":          simplifiedchinese.GBK,
+	"gb2312":       simplifiedchinese.GBK,
+	"gb18030":      simplifiedchinese.GB18030,
+	"latin1":       charmap.ISO8859_1,
+	"iso-8859-1":   charmap.ISO8859_1,
+	"windows-1252": charmap.Windows1252,
+}
+
+// parseOutputEncoding returns the encoding named name, nil for UTF-8 or an empty name.
+func parseOutputEncoding(name string) (encoding.Encoding, error) {
+	if name == "" {
+		return nil, nil
+	}
+	enc, ok := outputEncodings[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return nil, fmt.Errorf("unknown output encoding %q", name)
+	}
+	return enc, nil
+}
+
+// decodingReader transcodes the output read from a session to UTF-8, incomplete sequences
+// at the end of a read are kept until the next one completes them.
+type decodingReader struct {
+	io.Reader
+	io.Closer
+}
+
+func decodeOutput(r io.ReadCloser, enc encoding.Encoding) io.ReadCloser {
+	return decodingReader{transform.NewReader(r, enc.NewDecoder()), r}
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/laincloud/entry/message"
+)
+
+func TestParseOutputEncoding(t *testing.T) {
+	for i, c := range []struct {
+		name  string
+		valid bool
+	}{
+		{"", true},
+		{"UTF-8", true},
+		{"gbk", true},
+		{" GB18030 ", true},
+		{"latin1", true},
+		{"ebcdic", false},
+	} {
+		if _, err := parseOutputEncoding(c.name); (err == nil) != c.valid {
+			t.Errorf("Case %d failed: err is %v", i+1, err)
+		}
+	}
+}
+
+func TestDecodeOutput(t *testing.T) {
+	for i, c := range []struct {
+		name   string
+		chunks []string
+		output string
+	}{
+		// 你好 in GBK, split in the middle of a character.
+		{"gbk", []string{"\xc4\xe3\xba", "\xc3!"}, "你好!"},
+		{"gb18030", []string{"\x81\x30\x81\x30"}, "\u0080"},
+		{"latin1", []string{"caf\xe9"}, "café"},
+		{"windows-1252", []string{"\x80"}, "€"},
+	} {
+		enc, _ := parseOutputEncoding(c.name)
+		pr, pw := io.Pipe()
+		go func() {
+			for _, chunk := range c.chunks {
+				pw.Write([]byte(chunk))
+			}
+			pw.Close()
+		}()
+		output, err := ioutil.ReadAll(decodeOutput(pr, enc))
+		if err != nil || string(output) != c.output {
+			t.Errorf("Case %d failed: output is %q, err is %v", i+1, output, err)
+		}
+	}
+}
+
+func TestEnterOutputEncoding(t *testing.T) {
+	fake := &fakeDocker{
+		startExec: func(id string, opts docker.StartExecOptions) (docker.CloseWaiter, error) {
+			w := &fakeWaiter{done: make(chan struct{})}
+			go func() {
+				opts.OutputStream.Write([]byte("\xc4\xe3"))
+				opts.OutputStream.Write([]byte("\xba\xc3"))
+				close(w.done)
+			}()
+			return w, nil
+		},
+	}
+	for i, c := range []struct {
+		header   string
+		fallback string
+		output   string
+		closeMsg string
+	}{
+		{"gbk", "", "你好", ""},
+		{"", "gbk", "你好", ""},
+		{"utf-8", "gbk", "\xc4\xe3\xba\xc3", ""},
+		{"ebcdic", "", "", "Unknown output encoding ebcdic"},
+	} {
+		server := &EntryServer{dockerClient: fake, authorizer: &FakeAuthorizer{Allow: true}, resolver: StaticResolver{"hello/web/1": "c1"},
+			outputEncoding: c.fallback}
+		ts := httptest.NewServer(http.HandlerFunc(server.enter))
+		header := http.Header{}
+		header.Set("output-encoding", c.header)
+		ws := dialSession(t, ts, "", header)
+		var output, closeMsg string
+		for {
+			_, data, err := ws.ReadMessage()
+			if err != nil {
+				break
+			}
+			msg := message.ResponseMessage{}
+			protoUnmarshalFunc(data, &msg)
+			if msg.MsgType == message.ResponseMessage_CLOSE {
+				closeMsg = string(msg.Content)
+				break
+			}
+			if msg.MsgType == message.ResponseMessage_STDOUT {
+				output += string(msg.Content)
+			}
+		}
+		ws.Close()
+		ts.Close()
+		if output != c.output || !strings.Contains(closeMsg, c.closeMsg) {
+			t.Errorf("Case %d failed: output is %q, CLOSE is %q", i+1, output, closeMsg)
+		}
+	}
+}
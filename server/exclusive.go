@@ -0,0 +1,33 @@
+package server
+
+import (
+	"errors"
+	"path"
+)
+
+const exclusiveMsg = "Another session is active on this container."
+
+var errSessionActive = errors.New("another session is active on the container")
+
+// exclusivePolicy lists the applications whose containers take one interactive session at a
+// time, by patterns in the syntax of path.Match, for the applications whose shells step on
+// each other, like a console holding a lock. An enter session is refused while another one
+// is in its container, unless it's forced by a role with the force feature. Attach sessions
+// only watch the output, they don't count.
+type exclusivePolicy []string
+
+// newExclusivePolicy creates an exclusivePolicy from comma separated app patterns.
+func newExclusivePolicy(apps string) (exclusivePolicy, error) {
+	p := exclusivePolicy(splitPatterns(apps))
+	for _, pattern := range p {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, errors.New("invalid exclusive app pattern " + pattern)
+		}
+	}
+	return p, nil
+}
+
+// applies reports whether the containers of appName are exclusive.
+func (p exclusivePolicy) applies(appName string) bool {
+	return matchAny(p, appName)
+}
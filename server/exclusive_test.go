@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gorilla/websocket"
+	"github.com/laincloud/entry/message"
+)
+
+func TestExclusivePolicy(t *testing.T) {
+	if _, err := newExclusivePolicy("hello,["); err == nil {
+		t.Error("Invalid pattern is accepted")
+	}
+	p, err := newExclusivePolicy("hello, db-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range []struct {
+		app     string
+		applies bool
+	}{
+		{"hello", true},
+		{"db-main", true},
+		{"hello2", false},
+		{"", false},
+	} {
+		if actual := p.applies(c.app); actual != c.applies {
+			t.Errorf("Case %d failed: actual is %t", i+1, actual)
+		}
+	}
+}
+
+func TestRegistryAddExclusive(t *testing.T) {
+	var reg sessionRegistry
+	_, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	attach := reg.add(sessionInfo{kind: "attach", containerID: "c1"}, cancel)
+	first := reg.addExclusive(sessionInfo{kind: "enter", containerID: "c1"}, cancel)
+	if first == nil {
+		t.Fatal("An attach session keeps enter sessions out")
+	}
+	if reg.addExclusive(sessionInfo{kind: "enter", containerID: "c1"}, cancel) != nil {
+		t.Error("A second enter session is added")
+	}
+	if reg.addExclusive(sessionInfo{kind: "enter", containerID: "c2"}, cancel) == nil {
+		t.Error("An enter session of another container isn't added")
+	}
+	reg.remove(first)
+	reg.remove(attach)
+	if reg.addExclusive(sessionInfo{kind: "enter", containerID: "c1"}, cancel) == nil {
+		t.Error("An enter session isn't added after the first one ends")
+	}
+}
+
+func TestEnterExclusive(t *testing.T) {
+	var execs int32
+	fake := &fakeDocker{
+		createExec: func(opts docker.CreateExecOptions) (*docker.Exec, error) {
+			return &docker.Exec{ID: fmt.Sprintf("exec%d", atomic.AddInt32(&execs, 1))}, nil
+		},
+		startExec: func(id string, opts docker.StartExecOptions) (docker.CloseWaiter, error) {
+			w := &fakeWaiter{done: make(chan struct{})}
+			go func() {
+				fmt.Fprint(opts.OutputStream, "$ ")
+				io.Copy(opts.OutputStream, opts.InputStream)
+				close(w.done)
+			}()
+			return w, nil
+		},
+	}
+	server := &EntryServer{dockerClient: fake, authorizer: &FakeAuthorizer{Tokens: map[string]string{"admin": "admin", "dev": "developer"}},
+		resolver: StaticResolver{"hello/web/1": "c1"}, exclusive: exclusivePolicy{"hello"}}
+	ts := httptest.NewServer(http.HandlerFunc(server.enter))
+	defer ts.Close()
+
+	dial := func(token, query string) *websocket.Conn {
+		header := http.Header{}
+		header.Set("access-token", token)
+		ws := dialSession(t, ts, query, header)
+		ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+		return ws
+	}
+	read := func(ws *websocket.Conn) *message.ResponseMessage {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := &message.ResponseMessage{}
+		protoUnmarshalFunc(data, msg)
+		return msg
+	}
+
+	first := dial("dev", "")
+	// The session is registered before its shell starts.
+	if msg := read(first); msg.MsgType != message.ResponseMessage_STDOUT {
+		t.Fatalf("First session: %v %q", msg.MsgType, msg.Content)
+	}
+	for i, c := range []struct {
+		token   string
+		query   string
+		entered bool
+		content string
+	}{
+		{"dev", "", false, exclusiveMsg},
+		{"dev", "?force=true", false, "Forcing a session is not allowed."},
+		{"admin", "", false, exclusiveMsg},
+		{"admin", "?force=true", true, "$ "},
+	} {
+		ws := dial(c.token, c.query)
+		msg := read(ws)
+		if entered := msg.MsgType == message.ResponseMessage_STDOUT; entered != c.entered || !strings.Contains(string(msg.Content), c.content) {
+			t.Errorf("Case %d failed: %v %q", i+1, msg.MsgType, msg.Content)
+		}
+		ws.Close()
+	}
+	first.Close()
+	server.sessions.Wait()
+
+	// The container takes a session again once the first one ended.
+	ws := dial("dev", "")
+	if msg := read(ws); msg.MsgType != message.ResponseMessage_STDOUT {
+		t.Errorf("Session after the first one: %v %q", msg.MsgType, msg.Content)
+	}
+	ws.Close()
+	server.sessions.Wait()
+}
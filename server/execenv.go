@@ -0,0 +1,51 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// defaultExecEnvDeny are the variables changing what the commands of execs load or run.
+const defaultExecEnvDeny = "LD_*,PATH"
+
+// envFilter is the operator's guardrail on the names of the variables exec specs set, see
+// ExecSpec. Patterns are globs in the syntax of path.Match.
+type envFilter struct {
+	// allow lists the only names which can be set when it's not empty.
+	allow []string
+	// deny lists the names never set, it wins over allow.
+	deny []string
+}
+
+// newEnvFilter creates an envFilter from comma separated allow and deny patterns.
+func newEnvFilter(allow, deny string) (envFilter, error) {
+	f := envFilter{allow: splitPatterns(allow), deny: splitPatterns(deny)}
+	for _, pattern := range append(f.allow, f.deny...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return f, errors.New("invalid env pattern " + pattern)
+		}
+	}
+	return f, nil
+}
+
+// execEnvError tells the variable of an exec spec not allowed by the operator.
+type execEnvError struct {
+	name string
+}
+
+func (e *execEnvError) Error() string {
+	return fmt.Sprintf("env %s is not allowed", e.name)
+}
+
+// check returns an *execEnvError if env, KEY=VALUE pairs, sets a name excluded by the filter.
+func (f envFilter) check(env []string) error {
+	for _, pair := range env {
+		name := strings.SplitN(pair, "=", 2)[0]
+		if matchAny(f.deny, name) || (len(f.allow) > 0 && !matchAny(f.allow, name)) {
+			return &execEnvError{name: name}
+		}
+	}
+	return nil
+}
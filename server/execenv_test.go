@@ -0,0 +1,96 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gorilla/websocket"
+	"github.com/laincloud/entry/message"
+)
+
+func TestEnvFilter(t *testing.T) {
+	if _, err := newEnvFilter("", "LD_["); err == nil {
+		t.Error("Invalid pattern is accepted")
+	}
+	cases := []struct {
+		allow, deny string
+		env         []string
+		refused     string
+	}{
+		{"", defaultExecEnvDeny, []string{"DEBUG=1", "LANG=C.UTF-8"}, ""},
+		{"", defaultExecEnvDeny, []string{"DEBUG=1", "LD_PRELOAD=/tmp/evil.so"}, "LD_PRELOAD"},
+		{"", defaultExecEnvDeny, []string{"LD_LIBRARY_PATH=/tmp"}, "LD_LIBRARY_PATH"},
+		{"", defaultExecEnvDeny, []string{"PATH=/tmp:/usr/bin"}, "PATH"},
+		{"", "LD_*", []string{"PATH=/tmp:/usr/bin"}, ""},
+		{"DEBUG,APP_*", "", []string{"DEBUG=1", "APP_MODE=dev"}, ""},
+		{"DEBUG,APP_*", "", []string{"DEBUG=1", "HOME=/tmp"}, "HOME"},
+		// The deny-list wins.
+		{"APP_*", "APP_SECRET", []string{"APP_SECRET=x"}, "APP_SECRET"},
+		{"", "", []string{"LD_PRELOAD=/tmp/evil.so"}, ""},
+	}
+	for i, c := range cases {
+		f, err := newEnvFilter(c.allow, c.deny)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = f.check(c.env)
+		if envErr, ok := err.(*execEnvError); (err != nil || c.refused != "") && (!ok || envErr.name != c.refused) {
+			t.Errorf("Case %d failed: %v", i+1, err)
+		}
+	}
+}
+
+func TestEnterExecEnv(t *testing.T) {
+	created := make(chan docker.CreateExecOptions, 1)
+	fake := &fakeDocker{
+		createExec: func(opts docker.CreateExecOptions) (*docker.Exec, error) {
+			created <- opts
+			return &docker.Exec{ID: "exec"}, nil
+		},
+	}
+	policy, _ := newExecSpecPolicy("developer=env")
+	filter, _ := newEnvFilter("", defaultExecEnvDeny)
+	server := &EntryServer{
+		dockerClient:   fake,
+		authorizer:     &FakeAuthorizer{Allow: true, Role: "developer"},
+		resolver:       StaticResolver{"hello/web/1": "c1"},
+		execSpecPolicy: policy,
+		envFilter:      filter,
+	}
+	ts := httptest.NewServer(http.HandlerFunc(server.enter))
+	defer ts.Close()
+	dial := func(spec string) *websocket.Conn {
+		header := http.Header{}
+		header.Set("exec-spec", spec)
+		ws := dialSession(t, ts, "", header)
+		return ws
+	}
+
+	ws := dial(`{"env": ["DEBUG=1"]}`)
+	opts := <-created
+	if !reflect.DeepEqual(opts.Cmd, []string{"env", "TERM=xterm-256color", "DEBUG=1", "/bin/bash"}) {
+		t.Errorf("Exec is created with %q", opts.Cmd)
+	}
+	ws.Close()
+
+	ws = dial(`{"env": ["DEBUG=1", "LD_PRELOAD=/tmp/evil.so"]}`)
+	defer ws.Close()
+	_, data, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := message.ResponseMessage{}
+	protoUnmarshalFunc(data, &msg)
+	if msg.MsgType != message.ResponseMessage_CLOSE || !strings.Contains(string(msg.Content), "Setting env LD_PRELOAD is not allowed") {
+		t.Errorf("Denied env: %v %q", msg.MsgType, msg.Content)
+	}
+	select {
+	case opts := <-created:
+		t.Errorf("Exec is created with %q", opts.Cmd)
+	default:
+	}
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/laincloud/entry/log"
+)
+
+const (
+	// defaultExecPoolTTL is how long a pooled exec waits for a session before it's dropped.
+	defaultExecPoolTTL = time.Minute
+	// maxExecPoolSize bounds the execs pooled for each container.
+	maxExecPoolSize = 8
+	// maxExecPoolKeys bounds the kinds of execs pooled at once, the least recently used
+	// is evicted beyond it.
+	maxExecPoolKeys = 64
+)
+
+// execPool keeps a few execs created but not started for the containers entered lately,
+// so that a session starting there only waits for StartExec. It saves the CreateExec round
+// trip to the daemon, one of the two requests starting a shell. What it saves against a real
+// daemon hasn't been measured.
+//
+// Execs are pooled by their options, so that only the sessions asking the same exec get
+// them. A container is pooled once entered, and forgotten when no session takes its execs
+// within the TTL. Stale execs are never started, the daemon drops them with their container.
+// A nil *execPool pools nothing.
+type execPool struct {
+	client dockerAPI
+	size   int
+	ttl    time.Duration
+
+	lock    sync.Mutex
+	entries map[string]*execPoolEntry
+}
+
+type execPoolEntry struct {
+	opts docker.CreateExecOptions
+	// execs are the pooled execs, the oldest first.
+	execs   []pooledExec
+	used    time.Time
+	filling bool
+}
+
+type pooledExec struct {
+	exec    *docker.Exec
+	created time.Time
+}
+
+// newExecPool creates a pool of at most size execs for each kind of exec.
+func newExecPool(client dockerAPI, size int, ttl time.Duration) *execPool {
+	return &execPool{client: client, size: size, ttl: ttl, entries: make(map[string]*execPoolEntry)}
+}
+
+func execPoolKey(opts docker.CreateExecOptions) string {
+	opts.Context = nil
+	return fmt.Sprintf("%#v", opts)
+}
+
+// take returns a pooled exec created by opts, or nil if there is none. Either way the
+// pool is refilled in background for the next session.
+func (p *execPool) take(opts docker.CreateExecOptions) *docker.Exec {
+	if p == nil {
+		return nil
+	}
+	key := execPoolKey(opts)
+	now := time.Now()
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	entry, ok := p.entries[key]
+	if !ok {
+		p.evict(now)
+		entry = &execPoolEntry{opts: opts}
+		p.entries[key] = entry
+	}
+	entry.used = now
+	var exec *docker.Exec
+	for len(entry.execs) > 0 && exec == nil {
+		pooled := entry.execs[0]
+		entry.execs = entry.execs[1:]
+		if now.Sub(pooled.created) < p.ttl {
+			exec = pooled.exec
+		}
+	}
+	if !entry.filling {
+		entry.filling = true
+		go p.fill(key, entry)
+	}
+	return exec
+}
+
+// evict forgets the entries not used within the TTL, and the least recently used one if
+// there are still too many. It's called with the lock held.
+func (p *execPool) evict(now time.Time) {
+	var oldest string
+	for key, entry := range p.entries {
+		if now.Sub(entry.used) >= p.ttl {
+			delete(p.entries, key)
+			continue
+		}
+		if oldest == "" || entry.used.Before(p.entries[oldest].used) {
+			oldest = key
+		}
+	}
+	if len(p.entries) >= maxExecPoolKeys {
+		delete(p.entries, oldest)
+	}
+}
+
+// fill creates execs for entry until it has size ones. An entry evicted meanwhile is
+// filled anyway, its execs are dropped with it.
+func (p *execPool) fill(key string, entry *execPoolEntry) {
+	defer func() {
+		p.lock.Lock()
+		entry.filling = false
+		p.lock.Unlock()
+	}()
+	for {
+		p.lock.Lock()
+		full := len(entry.execs) >= p.size || p.entries[key] != entry
+		p.lock.Unlock()
+		if full {
+			return
+		}
+		exec, err := p.client.CreateExec(entry.opts)
+		if err != nil {
+			log.Warnf("Create pooled exec in %s failed: %s", entry.opts.Container, err.Error())
+			return
+		}
+		p.lock.Lock()
+		entry.execs = append(entry.execs, pooledExec{exec: exec, created: time.Now()})
+		p.lock.Unlock()
+	}
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// waitPoolFilled waits until p has n execs pooled by opts.
+func waitPoolFilled(t testing.TB, p *execPool, opts docker.CreateExecOptions, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		p.lock.Lock()
+		entry := p.entries[execPoolKey(opts)]
+		filled := entry != nil && len(entry.execs) >= n && !entry.filling
+		p.lock.Unlock()
+		if filled {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("Exec pool of %s is not filled", opts.Container)
+}
+
+func TestExecPool(t *testing.T) {
+	var creates int32
+	fake := &fakeDocker{
+		createExec: func(opts docker.CreateExecOptions) (*docker.Exec, error) {
+			return &docker.Exec{ID: fmt.Sprintf("%s-%d", opts.Container, atomic.AddInt32(&creates, 1))}, nil
+		},
+	}
+	p := newExecPool(fake, 2, time.Hour)
+	c1 := docker.CreateExecOptions{Container: "c1", Tty: true, Cmd: []string{"bash"}}
+	c1Root := docker.CreateExecOptions{Container: "c1", Tty: true, Cmd: []string{"bash"}, User: "root"}
+
+	// The first session of a container is not pooled, it only starts pooling.
+	if exec := p.take(c1); exec != nil {
+		t.Fatalf("Case 1 failed: %s is taken", exec.ID)
+	}
+	waitPoolFilled(t, p, c1, 2)
+	cases := []struct {
+		opts     docker.CreateExecOptions
+		expected string
+	}{
+		{c1, "c1-1"},
+		{c1, "c1-2"},
+		{c1Root, ""},
+	}
+	for i, c := range cases {
+		exec := p.take(c.opts)
+		if (exec == nil && c.expected != "") || (exec != nil && exec.ID != c.expected) {
+			t.Errorf("Case %d failed: %v is taken", i+2, exec)
+		}
+	}
+	// The pool never holds more than its size.
+	waitPoolFilled(t, p, c1, 2)
+	if n := len(p.entries[execPoolKey(c1)].execs); n != 2 {
+		t.Errorf("Case 5 failed: %d execs are pooled", n)
+	}
+
+	// Stale execs are dropped, and so are the containers not entered any more.
+	p.ttl = 0
+	if exec := p.take(c1); exec != nil {
+		t.Errorf("Case 6 failed: stale %s is taken", exec.ID)
+	}
+	p.take(docker.CreateExecOptions{Container: "c2"})
+	p.lock.Lock()
+	if len(p.entries) != 1 {
+		t.Errorf("Case 7 failed: %d entries are pooled", len(p.entries))
+	}
+	p.lock.Unlock()
+
+	var none *execPool
+	if exec := none.take(c1); exec != nil {
+		t.Errorf("Case 8 failed: %s is taken from no pool", exec.ID)
+	}
+}
+
+func TestStartPooledExec(t *testing.T) {
+	var creates int32
+	started := make(chan string, 10)
+	fake := &fakeDocker{
+		createExec: func(opts docker.CreateExecOptions) (*docker.Exec, error) {
+			return &docker.Exec{ID: fmt.Sprintf("exec-%d", atomic.AddInt32(&creates, 1))}, nil
+		},
+		startExec: func(id string, opts docker.StartExecOptions) (docker.CloseWaiter, error) {
+			started <- id
+			if id == "exec-2" {
+				return nil, &docker.NoSuchExec{ID: id}
+			}
+			return newFakeWaiter(nil), nil
+		},
+	}
+	server := &EntryServer{dockerClient: fake}
+	server.execPool = newExecPool(fake, 1, time.Hour)
+	opts := docker.CreateExecOptions{Container: "c1"}
+	server.execPool.take(opts)
+	// The pooled exec is started, and another one is created if it fails to start.
+	for i, expected := range []string{"exec-1", "exec-2"} {
+		waitPoolFilled(t, server.execPool, opts, 1)
+		exec, _, err := server.startExec(opts, docker.StartExecOptions{})
+		if err != nil {
+			t.Fatalf("Case %d failed: %s", i+1, err.Error())
+		}
+		server.execGuard.release(exec.ID)
+		if actual := <-started; actual != expected {
+			t.Errorf("Case %d failed: %s is started instead of %s", i+1, actual, expected)
+		}
+	}
+	if id := <-started; id == "exec-2" {
+		t.Errorf("Case 3 failed: %s is started again", id)
+	}
+}
+
+// BenchmarkStartExec compares the start of execs with and without pooling, against a daemon
+// answering each request in 2ms.
+func BenchmarkStartExec(b *testing.B) {
+	fake := &fakeDocker{
+		createExec: func(opts docker.CreateExecOptions) (*docker.Exec, error) {
+			time.Sleep(2 * time.Millisecond)
+			return &docker.Exec{ID: "exec"}, nil
+		},
+		startExec: func(id string, opts docker.StartExecOptions) (docker.CloseWaiter, error) {
+			time.Sleep(2 * time.Millisecond)
+			return newFakeWaiter(nil), nil
+		},
+	}
+	opts := docker.CreateExecOptions{Container: "c1"}
+	for _, size := range []int{0, 1} {
+		b.Run(fmt.Sprintf("pool=%d", size), func(b *testing.B) {
+			server := &EntryServer{dockerClient: fake}
+			if size > 0 {
+				server.execPool = newExecPool(fake, size, time.Hour)
+			}
+			server.execPool.take(opts)
+			for i := 0; i < b.N; i++ {
+				if size > 0 {
+					// Sessions come far enough apart to find the pool filled.
+					b.StopTimer()
+					waitPoolFilled(b, server.execPool, opts, size)
+					b.StartTimer()
+				}
+				exec, _, err := server.startExec(opts, docker.StartExecOptions{})
+				if err != nil {
+					b.Fatal(err)
+				}
+				server.execGuard.release(exec.ID)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// ExecSpec is the exec asked by a client instead of the default shell, given as the JSON of
+// the exec-spec header, or as the "exec" object of the first message of web clients, e.g.
+//
+//	{"cmd": ["python3"], "env": ["DEBUG=1"], "user": "app", "workdir": "/srv", "privileged": false}
+//
+// Every field given must be allowed for the role of the client, see execSpecPolicy, and the
+// variables of env by the operator, see envFilter.
+type ExecSpec struct {
+	Cmd        []string `json:"cmd,omitempty"`
+	Env        []string `json:"env,omitempty"`
+	User       string   `json:"user,omitempty"`
+	WorkingDir string   `json:"workdir,omitempty"`
+	Privileged bool     `json:"privileged,omitempty"`
+}
+
+// execSpecFields are the names of the fields of ExecSpec, as allowed by execSpecPolicy.
+var execSpecFields = []string{"cmd", "env", "user", "workdir", "privileged"}
+
+var errInvalidExecSpec = errors.New("invalid exec spec")
+
+// parseExecSpec parses and validates the JSON of an exec spec, nil if data is empty.
+func parseExecSpec(data []byte) (*ExecSpec, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, nil
+	}
+	spec := &ExecSpec{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(spec); err != nil {
+		return nil, fmt.Errorf("%s: %s", errInvalidExecSpec, err.Error())
+	}
+	if spec.Cmd != nil && (len(spec.Cmd) == 0 || spec.Cmd[0] == "") {
+		return nil, fmt.Errorf("%s: empty cmd", errInvalidExecSpec)
+	}
+	for _, env := range spec.Env {
+		if i := strings.Index(env, "="); i <= 0 {
+			return nil, fmt.Errorf("%s: env %q is not KEY=VALUE", errInvalidExecSpec, env)
+		}
+	}
+	if spec.WorkingDir != "" && !path.IsAbs(spec.WorkingDir) {
+		return nil, fmt.Errorf("%s: workdir %q is not absolute", errInvalidExecSpec, spec.WorkingDir)
+	}
+	return spec, nil
+}
+
+// fields returns the names of the fields given in the spec.
+func (spec *ExecSpec) fields() []string {
+	var fields []string
+	if spec.Cmd != nil {
+		fields = append(fields, "cmd")
+	}
+	if len(spec.Env) > 0 {
+		fields = append(fields, "env")
+	}
+	if spec.User != "" {
+		fields = append(fields, "user")
+	}
+	if spec.WorkingDir != "" {
+		fields = append(fields, "workdir")
+	}
+	if spec.Privileged {
+		fields = append(fields, "privileged")
+	}
+	return fields
+}
+
+// execSpecPolicy allows the fields of exec specs per role, no field is allowed by default.
+type execSpecPolicy map[string]map[string]bool
+
+// newExecSpecPolicy parses rules like "admin=cmd,env,user,workdir,privileged;developer=env".
+func newExecSpecPolicy(rules string) (execSpecPolicy, error) {
+	p := make(execSpecPolicy)
+	for _, rule := range strings.Split(rules, ";") {
+		if rule = strings.TrimSpace(rule); rule == "" {
+			continue
+		}
+		parts := strings.SplitN(rule, "=", 2)
+		role := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || role == "" {
+			return nil, fmt.Errorf("invalid exec field rule %q, expected role=field,field", rule)
+		}
+		allowed := make(map[string]bool)
+		for _, field := range splitPatterns(parts[1]) {
+			if !isExecSpecField(field) {
+				return nil, fmt.Errorf("unknown exec field %q, expected one of %s", field, strings.Join(execSpecFields, ", "))
+			}
+			allowed[field] = true
+		}
+		p[role] = allowed
+	}
+	return p, nil
+}
+
+func isExecSpecField(field string) bool {
+	for _, name := range execSpecFields {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
+
+// execFieldError tells the field of an exec spec not allowed for a role.
+type execFieldError struct {
+	field string
+	role  string
+}
+
+func (e *execFieldError) Error() string {
+	return fmt.Sprintf("exec field %s is not allowed for role %q", e.field, e.role)
+}
+
+// check returns an *execFieldError if spec has a field not allowed for role.
+func (p execSpecPolicy) check(role string, spec *ExecSpec) error {
+	for _, field := range spec.fields() {
+		if !p[role][field] {
+			return &execFieldError{field: field, role: role}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gorilla/websocket"
+	"github.com/laincloud/entry/message"
+)
+
+func TestParseExecSpec(t *testing.T) {
+	cases := []struct {
+		data     string
+		expected *ExecSpec
+		ok       bool
+	}{
+		{"", nil, true},
+		{`{"cmd": ["python3"], "env": ["A=1"], "user": "app", "workdir": "/srv", "privileged": true}`,
+			&ExecSpec{Cmd: []string{"python3"}, Env: []string{"A=1"}, User: "app", WorkingDir: "/srv", Privileged: true}, true},
+		{`{"cmd": []}`, nil, false},
+		{`{"env": ["=1"]}`, nil, false},
+		{`{"workdir": "srv"}`, nil, false},
+		{`{"image": "busybox"}`, nil, false},
+		{`[]`, nil, false},
+	}
+	for i, c := range cases {
+		if actual, err := parseExecSpec([]byte(c.data)); !reflect.DeepEqual(actual, c.expected) || (err == nil) != c.ok {
+			t.Errorf("Case %d failed: actual is %+v, %v", i+1, actual, err)
+		}
+	}
+}
+
+func TestExecSpecPolicy(t *testing.T) {
+	if _, err := newExecSpecPolicy("admin"); err == nil {
+		t.Error("Rule without fields is accepted")
+	}
+	p, err := newExecSpecPolicy("admin=cmd,user,privileged; developer=env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		role  string
+		spec  ExecSpec
+		field string
+	}{
+		{"admin", ExecSpec{Cmd: []string{"sh"}, Privileged: true}, ""},
+		{"admin", ExecSpec{Env: []string{"A=1"}}, "env"},
+		{"developer", ExecSpec{Env: []string{"A=1"}}, ""},
+		{"developer", ExecSpec{Env: []string{"A=1"}, User: "root"}, "user"},
+		{"guest", ExecSpec{}, ""},
+	}
+	for i, c := range cases {
+		err := p.check(c.role, &c.spec)
+		if fieldErr, _ := err.(*execFieldError); (c.field == "" && err != nil) || (c.field != "" && (fieldErr == nil || fieldErr.field != c.field)) {
+			t.Errorf("Case %d failed: %v", i+1, err)
+		}
+	}
+}
+
+func TestEnterExecSpec(t *testing.T) {
+	created := make(chan docker.CreateExecOptions, 1)
+	fake := &fakeDocker{
+		createExec: func(opts docker.CreateExecOptions) (*docker.Exec, error) {
+			created <- opts
+			return &docker.Exec{ID: "exec"}, nil
+		},
+	}
+	policy, _ := newExecSpecPolicy("admin=cmd,user,privileged")
+	server := &EntryServer{
+		dockerClient:   fake,
+		authorizer:     &FakeAuthorizer{Tokens: map[string]string{"admin": "admin", "dev": "developer"}},
+		resolver:       StaticResolver{"hello/web/1": "c1"},
+		execSpecPolicy: policy,
+	}
+	ts := httptest.NewServer(http.HandlerFunc(server.enter))
+	defer ts.Close()
+	dial := func(token, spec string) *websocket.Conn {
+		header := http.Header{}
+		header.Set("access-token", token)
+		header.Set("exec-spec", spec)
+		ws := dialSession(t, ts, "", header)
+		return ws
+	}
+
+	ws := dial("admin", `{"cmd": ["python3"], "user": "root", "privileged": true}`)
+	opts := <-created
+	if !reflect.DeepEqual(opts.Cmd, []string{"env", "TERM=xterm-256color", "python3"}) || opts.User != "root" || !opts.Privileged {
+		t.Errorf("Exec is created with %+v", opts)
+	}
+	ws.Close()
+
+	ws = dial("dev", `{"privileged": true}`)
+	defer ws.Close()
+	_, data, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := message.ResponseMessage{}
+	protoUnmarshalFunc(data, &msg)
+	if msg.MsgType != message.ResponseMessage_CLOSE || !strings.Contains(string(msg.Content), "Exec field privileged is not allowed") {
+		t.Errorf("Disallowed field: %v %q", msg.MsgType, msg.Content)
+	}
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"errors"
+	"path"
+	"strings"
+)
+
+var errAppNotAllowed = errors.New("application is not allowed by the operator")
+
+// appFilter is the operator's guardrail on which applications can be entered, regardless
+// of the roles users have. Patterns are globs in the syntax of path.Match.
+type appFilter struct {
+	// allow lists the only enterable applications when it's not empty.
+	allow []string
+	// deny lists applications never enterable, it wins over allow.
+	deny []string
+}
+
+// newAppFilter creates an appFilter from comma separated allow and deny patterns.
+func newAppFilter(allow, deny string) (appFilter, error) {
+	f := appFilter{allow: splitPatterns(allow), deny: splitPatterns(deny)}
+	for _, pattern := range append(f.allow, f.deny...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return f, errors.New("invalid app pattern " + pattern)
+		}
+	}
+	return f, nil
+}
+
+func splitPatterns(value string) []string {
+	var patterns []string
+	for _, pattern := range strings.Split(value, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+func matchAny(patterns []string, appName string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, appName); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// check returns errAppNotAllowed if appName is excluded by the filter.
+func (f appFilter) check(appName string) error {
+	if matchAny(f.deny, appName) || (len(f.allow) > 0 && !matchAny(f.allow, appName)) {
+		return errAppNotAllowed
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package server
+
+import "testing"
+
+func TestAppFilter(t *testing.T) {
+	cases := []struct {
+		allow, deny string
+		appName     string
+		allowed     bool
+	}{
+		{"", "", "hello", true},
+		{"hello, world", "", "hello", true},
+		{"hello, world", "", "console", false},
+		{"resource.*", "", "resource.redis.hello", true},
+		{"*", "console,lain*", "lainlet", false},
+		{"*", "console,lain*", "hello", true},
+		{"hello", "hello", "hello", false},
+	}
+	for i, c := range cases {
+		f, err := newAppFilter(c.allow, c.deny)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := f.check(c.appName) == nil; actual != c.allowed {
+			t.Errorf("Case %d failed: actual is %t", i+1, actual)
+		}
+	}
+	if _, err := newAppFilter("[", ""); err == nil {
+		t.Errorf("Invalid pattern is accepted")
+	}
+}
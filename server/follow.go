@@ -0,0 +1,73 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var errSessionCanceled = errors.New("session is canceled")
+
+var (
+	// followPollInterval is how often a stopped container is checked while following.
+	followPollInterval = time.Second
+	// followRemovedTimeout is how long a container may be missing before the application
+	// is considered removed.
+	followRemovedTimeout = time.Minute
+)
+
+// closeReasonExited is the reason of the CLOSE sent when the process in the container exited.
+const closeReasonExited = "exited"
+
+// waitContainer waits until the container, which started at startedAt, exits and returns its
+// exit code, or errSessionCanceled once ctx is done. It polls the container, as a wait
+// request of the daemon can't be canceled and would outlive the session. A container
+// restarted between two polls runs again with another start, that tells it exited; docker
+// resets the exit code of a run which started, the code returned is that of the new run then.
+func (server *EntryServer) waitContainer(ctx context.Context, containerID string, startedAt time.Time) (int, error) {
+	ticker := time.NewTicker(followPollInterval)
+	defer ticker.Stop()
+	for {
+		container, err := server.dockerClient.InspectContainer(containerID)
+		if err != nil {
+			return 0, err
+		}
+		// A restarting container exited already.
+		if !container.State.Running || container.State.Restarting || !container.State.StartedAt.Equal(startedAt) {
+			return container.State.ExitCode, nil
+		}
+		select {
+		case <-ctx.Done():
+			return 0, errSessionCanceled
+		case <-ticker.C:
+		}
+	}
+}
+
+// waitForRestart waits until the instance of the session runs again, possibly in a new
+// container, and returns the running container. It gives up when the container can't be
+// found for followRemovedTimeout, or when ctx is done.
+func (server *EntryServer) waitForRestart(ctx context.Context, info sessionInfo) (string, error) {
+	ticker := time.NewTicker(followPollInterval)
+	defer ticker.Stop()
+	lastSeen := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return "", errSessionCanceled
+		case <-ticker.C:
+		}
+		containerID, err := server.resolve(info.appName, info.procName, info.instanceNo)
+		if err != nil {
+			if time.Since(lastSeen) > followRemovedTimeout {
+				return "", err
+			}
+			continue
+		}
+		lastSeen = time.Now()
+		if container, err := server.dockerClient.InspectContainer(containerID); err == nil && checkContainerState(container.State) == nil &&
+			server.checkContainerApp(container, info.appName) == nil {
+			return containerID, nil
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/laincloud/entry/message"
+)
+
+func TestAttachFollow(t *testing.T) {
+	followPollInterval = time.Millisecond
+	defer func() { followPollInterval = time.Second }()
+
+	resolver := StaticResolver{"hello/web/1": "c1"}
+	attached := make(chan string, 2)
+	var stopped int32
+	fake := &fakeDocker{
+		inspectContainer: func(id string) (*docker.Container, error) {
+			running := id != "c1" || atomic.LoadInt32(&stopped) == 0
+			return &docker.Container{ID: id, State: docker.State{Running: running}}, nil
+		},
+		attach: func(opts docker.AttachToContainerOptions) (docker.CloseWaiter, error) {
+			attached <- opts.Container
+			if opts.Container == "c1" {
+				// The first container stops at once and is replaced.
+				resolver["hello/web/1"] = "c2"
+				atomic.StoreInt32(&stopped, 1)
+				return newFakeWaiter(nil), nil
+			}
+			return &fakeWaiter{done: make(chan struct{})}, nil
+		},
+	}
+	server := &EntryServer{dockerClient: fake, authorizer: &FakeAuthorizer{Allow: true}, resolver: resolver}
+	ts := httptest.NewServer(http.HandlerFunc(server.attach))
+	defer ts.Close()
+
+	ws := dialSession(t, ts, "?follow=true", nil)
+	defer ws.Close()
+
+	var notices []string
+	for len(notices) < 2 {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := message.ResponseMessage{}
+		if err = protoUnmarshalFunc(data, &msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.MsgType != message.ResponseMessage_NOTICE {
+			t.Fatalf("Unexpected response: %v", msg)
+		}
+		notices = append(notices, string(msg.Content))
+	}
+	if !strings.Contains(notices[0], "c1 exited with code 0") || !strings.Contains(notices[1], "restarted container c2") {
+		t.Errorf("Unexpected notices: %q", notices)
+	}
+	if first, second := <-attached, <-attached; first != "c1" || second != "c2" {
+		t.Errorf("Attached to %s and %s", first, second)
+	}
+}
+
+func TestAttachExitCode(t *testing.T) {
+	var exited int32
+	fake := &fakeDocker{
+		inspectContainer: func(id string) (*docker.Container, error) {
+			if atomic.LoadInt32(&exited) == 1 {
+				return &docker.Container{ID: id, State: docker.State{ExitCode: 3}}, nil
+			}
+			return &docker.Container{ID: id, State: docker.State{Running: true}}, nil
+		},
+		attach: func(opts docker.AttachToContainerOptions) (docker.CloseWaiter, error) {
+			atomic.StoreInt32(&exited, 1)
+			return newFakeWaiter(nil), nil
+		},
+	}
+	server := &EntryServer{dockerClient: fake, authorizer: &FakeAuthorizer{Allow: true}, resolver: StaticResolver{"hello/web/1": "c1"}}
+	ts := httptest.NewServer(http.HandlerFunc(server.attach))
+	defer ts.Close()
+
+	ws := dialSession(t, ts, "", nil)
+	defer ws.Close()
+	_, data, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := message.ResponseMessage{}
+	if err = protoUnmarshalFunc(data, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.MsgType != message.ResponseMessage_CLOSE || msg.Reason != closeReasonExited || msg.ExitCode != 3 {
+		t.Errorf("Unexpected response: %v", msg)
+	}
+}
+
+func TestWaitContainer(t *testing.T) {
+	followPollInterval = time.Millisecond
+	defer func() { followPollInterval = time.Second }()
+
+	var polls int32
+	fake := &fakeDocker{
+		inspectContainer: func(id string) (*docker.Container, error) {
+			if atomic.AddInt32(&polls, 1) < 3 {
+				return &docker.Container{ID: id, State: docker.State{Running: true}}, nil
+			}
+			return &docker.Container{ID: id, State: docker.State{ExitCode: 2}}, nil
+		},
+	}
+	server := &EntryServer{dockerClient: fake}
+	if exitCode, err := server.waitContainer(context.Background(), "c1", time.Time{}); exitCode != 2 || err != nil {
+		t.Errorf("Wait returns %d, %v", exitCode, err)
+	}
+
+	// The wait ends with the session.
+	atomic.StoreInt32(&polls, -1000)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	if _, err := server.waitContainer(ctx, "c1", time.Time{}); err != errSessionCanceled {
+		t.Errorf("Wait of a canceled session returns %v", err)
+	}
+
+	// A container which exited and restarted between two polls runs again since another start.
+	started := time.Now()
+	fake.inspectContainer = func(id string) (*docker.Container, error) {
+		if atomic.AddInt32(&polls, 1) < 3 {
+			return &docker.Container{ID: id, State: docker.State{Running: true, StartedAt: started}}, nil
+		}
+		return &docker.Container{ID: id, State: docker.State{Running: true, StartedAt: started.Add(time.Second)}}, nil
+	}
+	atomic.StoreInt32(&polls, 0)
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if exitCode, err := server.waitContainer(ctx, "c1", started); exitCode != 0 || err != nil {
+		t.Errorf("Wait of a restarted container returns %d, %v", exitCode, err)
+	}
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+var (
+	errInfraForbidden   = errors.New("infra containers are not allowed for the role")
+	errNoInfraContainer = errors.New("no infra container")
+)
+
+// infraContainer returns the ID of the infra container of the pod of container, the pause
+// container holding the namespaces shared by the containers of the pod. The containers of
+// a pod join the network of their infra container, so it is found by their network mode.
+func infraContainer(container *docker.Container) (string, error) {
+	if container.HostConfig == nil || !strings.HasPrefix(container.HostConfig.NetworkMode, "container:") {
+		return "", errNoInfraContainer
+	}
+	infraID := strings.TrimPrefix(container.HostConfig.NetworkMode, "container:")
+	if infraID == "" || infraID == container.ID {
+		return "", errNoInfraContainer
+	}
+	return infraID, nil
+}
+
+// enterInfra returns the infra container of the pod of container, checked to be running,
+// if the client playing role may enter it.
+func (server *EntryServer) enterInfra(role string, container *docker.Container) (*docker.Container, error) {
+	if server.safeMode {
+		return nil, errSafeMode
+	}
+	if !server.roles.allows(role, "infra") {
+		return nil, errInfraForbidden
+	}
+	infraID, err := infraContainer(container)
+	if err != nil {
+		return nil, err
+	}
+	if server.dockerPool != nil {
+		// The infra container runs on the node of its pod.
+		if _, node := server.dockerPool.container(container.ID); node != "" {
+			server.dockerPool.locate(infraID, node)
+		}
+	}
+	infra, err := server.dockerClient.InspectContainer(infraID)
+	if err == nil {
+		err = server.localPod.check(infra)
+	}
+	return infra, err
+}
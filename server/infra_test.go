@@ -0,0 +1,111 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gorilla/websocket"
+	"github.com/laincloud/entry/message"
+)
+
+func TestInfraContainer(t *testing.T) {
+	for i, c := range []struct {
+		networkMode string
+		infraID     string
+	}{
+		{"container:pause1", "pause1"},
+		{"bridge", ""},
+		{"", ""},
+		{"container:", ""},
+		{"container:c1", ""},
+	} {
+		infraID, err := infraContainer(&docker.Container{ID: "c1", HostConfig: &docker.HostConfig{NetworkMode: c.networkMode}})
+		if infraID != c.infraID || (err == nil) != (c.infraID != "") {
+			t.Errorf("Case %d failed: infra container is %q, err is %v", i+1, infraID, err)
+		}
+	}
+	if _, err := infraContainer(&docker.Container{ID: "c1"}); err != errNoInfraContainer {
+		t.Errorf("Infra container without host config: %v", err)
+	}
+}
+
+func TestEnterInfra(t *testing.T) {
+	var lock sync.Mutex
+	var entered []string
+	fake := &fakeDocker{
+		inspectContainer: func(id string) (*docker.Container, error) {
+			switch id {
+			case "c1":
+				return &docker.Container{ID: id, State: docker.State{Running: true}, HostConfig: &docker.HostConfig{NetworkMode: "container:pause1"}}, nil
+			case "c2":
+				return &docker.Container{ID: id, State: docker.State{Running: true}, HostConfig: &docker.HostConfig{NetworkMode: "container:pause2"}}, nil
+			case "c3":
+				return &docker.Container{ID: id, State: docker.State{Running: true}, HostConfig: &docker.HostConfig{NetworkMode: "bridge"}}, nil
+			case "pause1":
+				return &docker.Container{ID: id, State: docker.State{Running: true}, HostConfig: &docker.HostConfig{}}, nil
+			}
+			return &docker.Container{ID: id, State: docker.State{Running: false}, HostConfig: &docker.HostConfig{}}, nil
+		},
+		createExec: func(opts docker.CreateExecOptions) (*docker.Exec, error) {
+			lock.Lock()
+			defer lock.Unlock()
+			entered = append(entered, opts.Container)
+			return &docker.Exec{ID: "exec1"}, nil
+		},
+	}
+	for i, c := range []struct {
+		role       string
+		instanceNo string
+		entered    string
+		closeMsg   string
+	}{
+		{"admin", "1", "pause1", ""},
+		{"developer", "1", "", "not allowed"},
+		{"admin", "2", "", "not running"},
+		{"admin", "3", "", "Infra container is not found"},
+	} {
+		entered = nil
+		server := &EntryServer{dockerClient: fake, authorizer: &FakeAuthorizer{Allow: true, Role: c.role},
+			resolver: StaticResolver{"hello/web/1": "c1", "hello/web/2": "c2", "hello/web/3": "c3"}}
+		ts := httptest.NewServer(http.HandlerFunc(server.enter))
+		header := http.Header{}
+		header.Set("app-name", "hello")
+		header.Set("proc-name", "web")
+		header.Set("instance-no", c.instanceNo)
+		ws, _, err := websocket.DefaultDialer.Dial(strings.Replace(ts.URL, "http", "ws", 1)+"?infra=true", header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var closeMsg string
+		for {
+			_, data, err := ws.ReadMessage()
+			if err != nil {
+				break
+			}
+			msg := message.ResponseMessage{}
+			protoUnmarshalFunc(data, &msg)
+			if msg.MsgType == message.ResponseMessage_CLOSE {
+				closeMsg = string(msg.Content)
+				break
+			}
+		}
+		ws.Close()
+		ts.Close()
+		server.sessions.Wait()
+		lock.Lock()
+		if c.entered != "" && (len(entered) == 0 || entered[0] != c.entered) {
+			t.Errorf("Case %d failed: entered %v", i+1, entered)
+		}
+		if c.entered == "" && len(entered) > 0 {
+			t.Errorf("Case %d failed: entered %v", i+1, entered)
+		}
+		lock.Unlock()
+		if c.closeMsg != "" && !strings.Contains(closeMsg, c.closeMsg) {
+			t.Errorf("Case %d failed: CLOSE is %q", i+1, closeMsg)
+		}
+	}
+}
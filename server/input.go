@@ -0,0 +1,96 @@
+package server
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+const (
+	// inputChunkSize is the most written to a session at once, so that an interrupt
+	// can cut in a large paste.
+	inputChunkSize = 1024
+	// inputQueueSize is the number of input messages waiting to be written before
+	// handleRequest is blocked.
+	inputQueueSize = 64
+	// interruptKey is Ctrl-C.
+	interruptKey = "\x03"
+)
+
+type inputItem struct {
+	data []byte
+	gen  int32
+}
+
+// inputWriter writes the input of a session in background, so that a slow shell consuming
+// a large paste never blocks the handling of the following messages.
+type inputWriter struct {
+	w      io.Writer
+	queue  chan inputItem
+	gen    int32
+	err    error
+	failed chan struct{}
+	done   chan struct{}
+}
+
+func newInputWriter(w io.Writer) *inputWriter {
+	iw := &inputWriter{
+		w:      w,
+		queue:  make(chan inputItem, inputQueueSize),
+		failed: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go iw.run()
+	return iw
+}
+
+func (iw *inputWriter) run() {
+	defer close(iw.done)
+	for item := range iw.queue {
+		for data := item.data; len(data) > 0 && item.gen == atomic.LoadInt32(&iw.gen); {
+			size := len(data)
+			if size > inputChunkSize {
+				size = inputChunkSize
+			}
+			if _, err := iw.w.Write(data[:size]); err != nil {
+				iw.err = err
+				close(iw.failed)
+				return
+			}
+			data = data[size:]
+		}
+	}
+}
+
+func (iw *inputWriter) enqueue(item inputItem) error {
+	select {
+	case <-iw.failed:
+		return iw.err
+	default:
+	}
+	select {
+	case iw.queue <- item:
+		return nil
+	case <-iw.failed:
+		return iw.err
+	}
+}
+
+// write queues data, it blocks only when the queue is full.
+func (iw *inputWriter) write(data []byte) error {
+	// The buffer of data is reused by the caller.
+	return iw.enqueue(inputItem{data: append([]byte(nil), data...), gen: atomic.LoadInt32(&iw.gen)})
+}
+
+// interrupt drops the input not written yet, then queues data, like a tty discarding
+// its pending input on Ctrl-C.
+func (iw *inputWriter) interrupt(data []byte) error {
+	gen := atomic.AddInt32(&iw.gen, 1)
+	return iw.enqueue(inputItem{data: append([]byte(nil), data...), gen: gen})
+}
+
+// close drops the input not written yet, and waits for the chunk being written.
+func (iw *inputWriter) close() {
+	atomic.AddInt32(&iw.gen, 1)
+	close(iw.queue)
+	<-iw.done
+}
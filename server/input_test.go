@@ -0,0 +1,94 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gorilla/websocket"
+	"github.com/laincloud/entry/message"
+)
+
+func TestInputWriterInterrupt(t *testing.T) {
+	reader, writer := io.Pipe()
+	iw := newInputWriter(writer)
+	paste := bytes.Repeat([]byte("x"), 100*inputChunkSize)
+	if err := iw.write(paste); err != nil {
+		t.Fatal(err)
+	}
+	// The paste is blocked by the shell not reading, the interrupt must not be.
+	done := make(chan error, 1)
+	go func() { done <- iw.interrupt([]byte(interruptKey)) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Interrupt is blocked by the paste")
+	}
+
+	var data []byte
+	buf := make([]byte, inputChunkSize)
+	for !bytes.HasSuffix(data, []byte(interruptKey)) {
+		n, err := reader.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, buf[:n]...)
+	}
+	if len(data) >= len(paste) {
+		t.Errorf("Paste is not interrupted: %d bytes read", len(data))
+	}
+	iw.close()
+}
+
+func TestEnterLargePaste(t *testing.T) {
+	resized := make(chan struct{})
+	pasted := make(chan int, 1)
+	fake := &fakeDocker{
+		startExec: func(id string, opts docker.StartExecOptions) (docker.CloseWaiter, error) {
+			w := &fakeWaiter{done: make(chan struct{})}
+			go func() {
+				// The shell is too busy to read until the terminal is resized.
+				<-resized
+				buf := make([]byte, 256*inputChunkSize)
+				n, _ := io.ReadFull(opts.InputStream, buf)
+				pasted <- n
+				close(w.done)
+			}()
+			return w, nil
+		},
+		resizeExecTTY: func(id string, height, width int) error {
+			close(resized)
+			return nil
+		},
+	}
+	server := &EntryServer{dockerClient: fake, authorizer: &FakeAuthorizer{Allow: true}, resolver: StaticResolver{"hello/web/1": "c1"}}
+	ts := httptest.NewServer(http.HandlerFunc(server.enter))
+	defer ts.Close()
+
+	ws := dialSession(t, ts, "", nil)
+	defer ws.Close()
+	for _, msg := range []*message.RequestMessage{
+		{MsgType: message.RequestMessage_PLAIN, Content: bytes.Repeat([]byte("x"), 256*inputChunkSize)},
+		{MsgType: message.RequestMessage_WINCH, Content: []byte("80 24")},
+	} {
+		data, _ := protoMarshalFunc(msg)
+		if err := ws.WriteMessage(websocket.BinaryMessage, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case n := <-pasted:
+		if n != 256*inputChunkSize {
+			t.Errorf("Pasted %d bytes", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("WINCH is blocked by the paste")
+	}
+}
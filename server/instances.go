@@ -0,0 +1,110 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+var errInstanceNotAllowed = errors.New("instance is not allowed to enter")
+
+// instanceRange is the instance numbers from..to, inclusive.
+type instanceRange struct {
+	from int
+	to   int
+}
+
+func (r instanceRange) contains(n int) bool {
+	return n >= r.from && n <= r.to
+}
+
+// instanceRule limits the instances enterable in the applications matching app.
+type instanceRule struct {
+	app   string
+	allow []instanceRange
+	deny  []instanceRange
+}
+
+// instancePolicy limits which instances of the applications can be entered, the first rule
+// matching an application applies and applications without a rule are not limited.
+type instancePolicy []instanceRule
+
+// newInstancePolicy parses rules like "hello=1-3,!2;db-*=!1", the applications are patterns
+// like in AllowApps. An instance is enterable if it's in one of the ranges of the rule, or
+// the rule has none, and it's not in any of the ranges excluded by "!".
+func newInstancePolicy(rules string) (instancePolicy, error) {
+	var p instancePolicy
+	for _, rule := range strings.Split(rules, ";") {
+		if rule = strings.TrimSpace(rule); rule == "" {
+			continue
+		}
+		parts := strings.SplitN(rule, "=", 2)
+		r := instanceRule{app: strings.TrimSpace(parts[0])}
+		if len(parts) != 2 || r.app == "" {
+			return nil, fmt.Errorf("invalid instance rule %q, expected app=range,!range", rule)
+		}
+		if _, err := path.Match(r.app, ""); err != nil {
+			return nil, fmt.Errorf("invalid application pattern %q: %s", r.app, err.Error())
+		}
+		items := splitPatterns(parts[1])
+		if len(items) == 0 {
+			return nil, fmt.Errorf("invalid instance rule %q, no instance is given", rule)
+		}
+		for _, item := range items {
+			excluded := strings.HasPrefix(item, "!")
+			ir, err := parseInstanceRange(strings.TrimPrefix(item, "!"))
+			if err != nil {
+				return nil, err
+			}
+			if excluded {
+				r.deny = append(r.deny, ir)
+			} else {
+				r.allow = append(r.allow, ir)
+			}
+		}
+		p = append(p, r)
+	}
+	return p, nil
+}
+
+// parseInstanceRange parses a single instance like "1" or a range like "1-3".
+func parseInstanceRange(value string) (instanceRange, error) {
+	bounds := strings.SplitN(value, "-", 2)
+	from, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+	to := from
+	if err == nil && len(bounds) == 2 {
+		to, err = strconv.Atoi(strings.TrimSpace(bounds[1]))
+	}
+	if err != nil || from < 0 || to < from {
+		return instanceRange{}, fmt.Errorf("invalid instance range %q", value)
+	}
+	return instanceRange{from: from, to: to}, nil
+}
+
+// check returns errInstanceNotAllowed if instance instanceNo of appName can't be entered.
+// An instance which is not a number can't be entered in a limited application.
+func (p instancePolicy) check(appName, instanceNo string) error {
+	for _, r := range p {
+		if matched, _ := path.Match(r.app, appName); !matched {
+			continue
+		}
+		n, err := strconv.Atoi(instanceNo)
+		if err != nil {
+			return errInstanceNotAllowed
+		}
+		allowed := len(r.allow) == 0
+		for _, ir := range r.allow {
+			allowed = allowed || ir.contains(n)
+		}
+		for _, ir := range r.deny {
+			allowed = allowed && !ir.contains(n)
+		}
+		if !allowed {
+			return errInstanceNotAllowed
+		}
+		return nil
+	}
+	return nil
+}
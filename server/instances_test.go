@@ -0,0 +1,81 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/laincloud/entry/message"
+)
+
+func TestNewInstancePolicy(t *testing.T) {
+	for i, c := range []struct {
+		rules string
+		valid bool
+	}{
+		{"", true},
+		{"hello=1-3,!2; db-*=!1", true},
+		{"hello=0", true},
+		{"hello", false},
+		{"=1", false},
+		{"hello=", false},
+		{"hello=a", false},
+		{"hello=3-1", false},
+		{"hello=-1", false},
+		{"[=1", false},
+	} {
+		if _, err := newInstancePolicy(c.rules); (err == nil) != c.valid {
+			t.Errorf("Case %d failed: %v", i+1, err)
+		}
+	}
+}
+
+func TestInstancePolicyCheck(t *testing.T) {
+	p, err := newInstancePolicy("hello=1-3,!2;db-*=!1;*=0-100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range []struct {
+		appName    string
+		instanceNo string
+		allowed    bool
+	}{
+		{"hello", "1", true},
+		{"hello", "2", false},
+		{"hello", "3", true},
+		{"hello", "4", false},
+		{"hello", "", false},
+		{"db-main", "1", false},
+		{"db-main", "2", true},
+		{"other", "100", true},
+		{"other", "101", false},
+	} {
+		if err := p.check(c.appName, c.instanceNo); (err == nil) != c.allowed {
+			t.Errorf("Case %d failed: %v", i+1, err)
+		}
+	}
+	if err := instancePolicy(nil).check("hello", "x"); err != nil {
+		t.Errorf("Empty policy: %v", err)
+	}
+}
+
+func TestEnterInstanceNotAllowed(t *testing.T) {
+	p, _ := newInstancePolicy("hello=!1")
+	server := &EntryServer{dockerClient: &fakeDocker{}, authorizer: &FakeAuthorizer{Allow: true, Role: "developer"},
+		resolver: StaticResolver{"hello/web/1": "c1"}, instancePolicy: p}
+	ts := httptest.NewServer(http.HandlerFunc(server.enter))
+	defer ts.Close()
+
+	ws := dialSession(t, ts, "", nil)
+	defer ws.Close()
+	_, data, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := message.ResponseMessage{}
+	protoUnmarshalFunc(data, &msg)
+	if msg.MsgType != message.ResponseMessage_CLOSE || !strings.Contains(string(msg.Content), "Entering instance 1 of hello is not allowed") {
+		t.Errorf("Disallowed instance: %v %q", msg.MsgType, msg.Content)
+	}
+}
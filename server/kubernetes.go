@@ -0,0 +1,70 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// The resolvers selected by config, see Config.Resolver.
+const (
+	resolverLain       = "lain"
+	resolverKubernetes = "kubernetes"
+)
+
+// The labels docker containers of kubernetes pods are given by the docker runtime.
+const (
+	k8sNamespaceLabel = "io.kubernetes.pod.namespace"
+	k8sPodLabel       = "io.kubernetes.pod.name"
+	k8sContainerLabel = "io.kubernetes.container.name"
+	// k8sSandboxName is the container name of the sandbox holding the namespaces of a pod.
+	k8sSandboxName = "POD"
+)
+
+var errAmbiguousPodContainer = errors.New("pod has several containers, the container name is required")
+
+// KubernetesResolver resolves the containers of kubernetes pods on the node of its docker
+// daemon, by the labels of the docker runtime. The application is the namespace of the pod,
+// the proc is the pod name, and the instance is the name of the container in the pod, which
+// may be empty if the pod runs a single one. The containers have no lain labels, so the
+// checks of the application labels must be disabled.
+type KubernetesResolver struct {
+	dockerClient dockerAPI
+}
+
+// NewKubernetesResolver creates a KubernetesResolver which asks the docker daemon of client.
+func NewKubernetesResolver(client dockerAPI) *KubernetesResolver {
+	return &KubernetesResolver{dockerClient: client}
+}
+
+func (r *KubernetesResolver) Resolve(appName, procName, instanceNo string) (string, error) {
+	filters := []string{k8sNamespaceLabel + "=" + appName, k8sPodLabel + "=" + procName}
+	if instanceNo != "" {
+		filters = append(filters, k8sContainerLabel+"="+instanceNo)
+	}
+	containers, err := r.dockerClient.ListContainers(docker.ListContainersOptions{
+		Filters: map[string][]string{"label": filters},
+	})
+	if err != nil {
+		return "", err
+	}
+	var matches []docker.APIContainers
+	for _, container := range containers {
+		labels := container.Labels
+		// The filters are checked again, in case the daemon ignores them.
+		if labels[k8sNamespaceLabel] != appName || labels[k8sPodLabel] != procName || labels[k8sContainerLabel] == k8sSandboxName {
+			continue
+		}
+		if instanceNo == "" || labels[k8sContainerLabel] == instanceNo {
+			matches = append(matches, container)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", errContainerNotfound
+	case 1:
+		return matches[0].ID, nil
+	}
+	return "", fmt.Errorf("%s: %s/%s", errAmbiguousPodContainer, appName, procName)
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestKubernetesResolver(t *testing.T) {
+	pod := func(id, namespace, podName, containerName string) docker.APIContainers {
+		return docker.APIContainers{ID: id, Labels: map[string]string{
+			k8sNamespaceLabel: namespace,
+			k8sPodLabel:       podName,
+			k8sContainerLabel: containerName,
+		}}
+	}
+	var filters map[string][]string
+	fake := &fakeDocker{
+		listContainers: func(opts docker.ListContainersOptions) ([]docker.APIContainers, error) {
+			filters = opts.Filters
+			// The daemon doesn't filter, the resolver does.
+			return []docker.APIContainers{
+				pod("sandbox", "hello", "web-0", k8sSandboxName),
+				pod("c1", "hello", "web-0", "web"),
+				pod("c2", "hello", "worker-0", "worker"),
+				pod("c3", "hello", "worker-0", "sidecar"),
+				pod("c4", "other", "web-0", "web"),
+			}, nil
+		},
+	}
+	r := NewKubernetesResolver(fake)
+	cases := []struct {
+		appName, procName, instanceNo string
+		containerID                   string
+		ok                            bool
+	}{
+		{"hello", "web-0", "", "c1", true},
+		{"hello", "web-0", "web", "c1", true},
+		{"hello", "worker-0", "sidecar", "c3", true},
+		{"hello", "worker-0", "", "", false},
+		{"hello", "web-0", k8sSandboxName, "", false},
+		{"hello", "web-1", "", "", false},
+		{"other", "worker-0", "worker", "", false},
+	}
+	for i, c := range cases {
+		containerID, err := r.Resolve(c.appName, c.procName, c.instanceNo)
+		if containerID != c.containerID || (err == nil) != c.ok {
+			t.Errorf("Case %d failed: actual is %q, %v", i+1, containerID, err)
+		}
+	}
+	expected := []string{k8sNamespaceLabel + "=other", k8sPodLabel + "=worker-0", k8sContainerLabel + "=worker"}
+	if !reflect.DeepEqual(filters["label"], expected) {
+		t.Errorf("Filters are %v", filters)
+	}
+}
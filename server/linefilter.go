@@ -0,0 +1,88 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"regexp"
+	"regexp/syntax"
+)
+
+const (
+	maxFilterLength = 256
+	// maxFilterInsts bounds the compiled size of a filter, as patterns like (a{100}){10}
+	// are short but costly to run on every line.
+	maxFilterInsts = 5000
+	// maxFilterLine is the longest line held back waiting for its end.
+	maxFilterLine = 64 * 1024
+)
+
+var errFilterTooComplex = errors.New("filter is too long or complex")
+
+// compileFilter compiles the regexp of a line filter within the complexity limits.
+func compileFilter(pattern string) (*regexp.Regexp, error) {
+	if len(pattern) > maxFilterLength {
+		return nil, errFilterTooComplex
+	}
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil, err
+	}
+	prog, err := syntax.Compile(re.Simplify())
+	if err != nil {
+		return nil, err
+	}
+	if len(prog.Inst) > maxFilterInsts {
+		return nil, errFilterTooComplex
+	}
+	return regexp.Compile(pattern)
+}
+
+// lineFilter passes the lines matching filter to w and drops the others.
+type lineFilter struct {
+	w      io.WriteCloser
+	filter *regexp.Regexp
+	line   []byte
+}
+
+func newLineFilter(w io.WriteCloser, filter *regexp.Regexp) *lineFilter {
+	return &lineFilter{w: w, filter: filter}
+}
+
+func (f *lineFilter) Write(p []byte) (int, error) {
+	for data := p; len(data) > 0; {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			f.line = append(f.line, data...)
+			if len(f.line) >= maxFilterLine {
+				// The line is too long to wait for its end.
+				if err := f.flush(); err != nil {
+					return 0, err
+				}
+			}
+			break
+		}
+		f.line = append(f.line, data[:i+1]...)
+		data = data[i+1:]
+		if err := f.flush(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (f *lineFilter) flush() error {
+	line := f.line
+	f.line = f.line[:0]
+	if len(line) == 0 || !f.filter.Match(bytes.TrimRight(line, "\r\n")) {
+		return nil
+	}
+	_, err := f.w.Write(line)
+	return err
+}
+
+// Close passes the last line if it matches, then closes w.
+func (f *lineFilter) Close() error {
+	f.flush()
+	return f.w.Close()
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+type bufferCloser struct {
+	bytes.Buffer
+}
+
+func (b *bufferCloser) Close() error { return nil }
+
+func TestLineFilter(t *testing.T) {
+	filter, err := compileFilter("ERROR|WARN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &bufferCloser{}
+	f := newLineFilter(out, filter)
+	for _, chunk := range []string{"INFO start\nERR", "OR failed\r\nINFO ok\n", "WARN last"} {
+		if n, err := f.Write([]byte(chunk)); err != nil || n != len(chunk) {
+			t.Fatalf("Write failed: %d, %v", n, err)
+		}
+	}
+	f.Close()
+	if actual := out.String(); actual != "ERROR failed\r\nWARN last" {
+		t.Errorf("Filtered output is %q", actual)
+	}
+
+	out.Reset()
+	f = newLineFilter(out, filter)
+	f.Write([]byte("ERROR " + strings.Repeat("x", maxFilterLine)))
+	if out.Len() == 0 {
+		t.Errorf("Long line is held back")
+	}
+}
+
+func TestCompileFilter(t *testing.T) {
+	cases := []struct {
+		pattern string
+		valid   bool
+	}{
+		{"ERROR", true},
+		{`^\d{4}-\d{2}-\d{2}.*(ERROR|FATAL)`, true},
+		{"(", false},
+		{strings.Repeat("a", maxFilterLength+1), false},
+		{"((a{100}){100}){100}", false},
+	}
+	for i, c := range cases {
+		if _, err := compileFilter(c.pattern); (err == nil) != c.valid {
+			t.Errorf("Case %d failed: err is %v", i+1, err)
+		}
+	}
+}
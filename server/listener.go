@@ -0,0 +1,28 @@
+package server
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+const (
+	// defaultTCPKeepAlive is the period of TCP keepalives, which keep idle websockets open
+	// through NATs and load balancers dropping silent connections.
+	defaultTCPKeepAlive = 30 * time.Second
+	// readHeaderTimeout bounds the requests headers only, the read and write timeouts of
+	// http.Server are left off as they would cut websockets.
+	readHeaderTimeout = 10 * time.Second
+	// idleTimeout closes the idle keep-alive connections of plain HTTP requests.
+	idleTimeout = 2 * time.Minute
+)
+
+// listen listens on addr with TCP keepalives every keepAlive, zero disables them.
+func listen(addr string, keepAlive time.Duration) (net.Listener, error) {
+	if keepAlive == 0 {
+		// A zero ListenConfig.KeepAlive means the system default.
+		keepAlive = -1
+	}
+	lc := net.ListenConfig{KeepAlive: keepAlive}
+	return lc.Listen(context.Background(), "tcp", addr)
+}
@@ -0,0 +1,47 @@
+//go:build linux
+// +build linux
+
+package server
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestListenKeepAlive(t *testing.T) {
+	for i, c := range []struct {
+		keepAlive time.Duration
+		enabled   int
+		idle      int
+	}{
+		{45 * time.Second, 1, 45},
+		{0, 0, 0},
+	} {
+		ln, err := listen("127.0.0.1:0", c.keepAlive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := ln.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, _ := conn.(*net.TCPConn).SyscallConn()
+		var enabled, idle int
+		raw.Control(func(fd uintptr) {
+			enabled, _ = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_KEEPALIVE)
+			idle, _ = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE)
+		})
+		if enabled != c.enabled || (c.enabled == 1 && idle != c.idle) {
+			t.Errorf("Case %d failed: keepalive=%d idle=%d", i+1, enabled, idle)
+		}
+		conn.Close()
+		client.Close()
+		ln.Close()
+	}
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+var errContainerOutOfPod = errors.New("container is out of the local pod")
+
+// localPod confines the sessions to the containers of the pod entry runs in, for entry
+// deployed as a sidecar with no business in other pods. The containers of the pod are
+// known by the labels the docker runtime gives them, its infra container included.
+// A nil *localPod confines nothing.
+type localPod struct {
+	namespace string
+	name      string
+}
+
+// newLocalPod confines the sessions to pod name of namespace, nil if name is empty.
+func newLocalPod(namespace, name string) *localPod {
+	if name == "" {
+		return nil
+	}
+	return &localPod{namespace: namespace, name: name}
+}
+
+// contains reports whether the container labeled labels is in the pod.
+func (p *localPod) contains(labels map[string]string) bool {
+	if p == nil {
+		return true
+	}
+	return labels[k8sNamespaceLabel] == p.namespace && labels[k8sPodLabel] == p.name
+}
+
+// check returns errContainerOutOfPod unless container is in the pod.
+func (p *localPod) check(container *docker.Container) error {
+	var labels map[string]string
+	if container.Config != nil {
+		labels = container.Config.Labels
+	}
+	if !p.contains(labels) {
+		return errContainerOutOfPod
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gorilla/websocket"
+	"github.com/laincloud/entry/message"
+)
+
+func podLabels(namespace, name, container string) map[string]string {
+	return map[string]string{k8sNamespaceLabel: namespace, k8sPodLabel: name, k8sContainerLabel: container}
+}
+
+func TestLocalPod(t *testing.T) {
+	pod := newLocalPod("shop", "web-0")
+	cases := []struct {
+		pod    *localPod
+		labels map[string]string
+		in     bool
+	}{
+		{pod, podLabels("shop", "web-0", "app"), true},
+		{pod, podLabels("shop", "web-0", k8sSandboxName), true},
+		{pod, podLabels("shop", "web-1", "app"), false},
+		{pod, podLabels("blog", "web-0", "app"), false},
+		{pod, nil, false},
+		{newLocalPod("shop", ""), nil, true},
+	}
+	for i, c := range cases {
+		if actual := c.pod.check(&docker.Container{ID: "c", Config: &docker.Config{Labels: c.labels}}) == nil; actual != c.in {
+			t.Errorf("Case %d failed: actual is %t", i+1, actual)
+		}
+	}
+}
+
+func TestEnterLocalPod(t *testing.T) {
+	labels := map[string]map[string]string{
+		"c1": podLabels("hello", "web-0", "web"),
+		"c2": podLabels("hello", "web-1", "web"),
+	}
+	fake := &fakeDocker{
+		inspectContainer: func(id string) (*docker.Container, error) {
+			return &docker.Container{ID: id, State: docker.State{Running: true}, Config: &docker.Config{Labels: labels[id]}}, nil
+		},
+	}
+	server := &EntryServer{dockerClient: fake, authorizer: &FakeAuthorizer{Allow: true},
+		resolver: StaticResolver{"hello/web/1": "c1", "hello/web/2": "c2"}, localPod: newLocalPod("hello", "web-0")}
+	ts := httptest.NewServer(http.HandlerFunc(server.enter))
+	defer ts.Close()
+
+	for i, c := range []struct {
+		instanceNo string
+		closeMsg   string
+	}{
+		{"1", "You quit the container safely."},
+		// The containers of other pods are not even told apart from missing ones.
+		{"2", "Container is not found."},
+	} {
+		header := http.Header{}
+		header.Set("app-name", "hello")
+		header.Set("proc-name", "web")
+		header.Set("instance-no", c.instanceNo)
+		ws, _, err := websocket.DefaultDialer.Dial(strings.Replace(ts.URL, "http", "ws", 1), header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var closeMsg string
+		for {
+			_, data, err := ws.ReadMessage()
+			if err != nil {
+				break
+			}
+			msg := message.ResponseMessage{}
+			protoUnmarshalFunc(data, &msg)
+			if msg.MsgType == message.ResponseMessage_CLOSE {
+				closeMsg = string(msg.Content)
+			}
+		}
+		ws.Close()
+		server.sessions.Wait()
+		if !strings.Contains(closeMsg, c.closeMsg) {
+			t.Errorf("Case %d failed: closed by %q", i+1, closeMsg)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// minContainerPrefix is the shortest ID prefix accepted, like the short IDs docker shows.
+const minContainerPrefix = 4
+
+// ambiguousContainerError is returned when a container reference matches several containers.
+type ambiguousContainerError struct {
+	ref        string
+	candidates []string
+}
+
+func (e *ambiguousContainerError) Error() string {
+	return fmt.Sprintf("container %s is ambiguous, candidates: %s", e.ref, strings.Join(e.candidates, ", "))
+}
+
+// lookupContainer finds the running container of appName referred by ref, which is a
+// container name or a prefix of the container ID. Only the containers of the application
+// are looked at, so the candidates never reveal containers of others.
+func (server *EntryServer) lookupContainer(appName, ref string) (docker.APIContainers, error) {
+	ref = strings.TrimPrefix(ref, "/")
+	containers, err := server.dockerClient.ListContainers(docker.ListContainersOptions{})
+	if err != nil {
+		return docker.APIContainers{}, err
+	}
+	var matches []docker.APIContainers
+	for _, container := range containers {
+		if labelAppName(container.Labels) != appName || !server.localPod.contains(container.Labels) {
+			continue
+		}
+		for _, name := range container.Names {
+			// A name is exact, so it wins over any ID prefix.
+			if strings.TrimPrefix(name, "/") == ref {
+				return container, nil
+			}
+		}
+		if len(ref) >= minContainerPrefix && strings.HasPrefix(container.ID, ref) {
+			matches = append(matches, container)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return docker.APIContainers{}, errContainerNotfound
+	case 1:
+		return matches[0], nil
+	}
+	candidates := make([]string, 0, len(matches))
+	for _, container := range matches {
+		candidate := container.ID
+		if len(candidate) > 12 {
+			candidate = candidate[:12]
+		}
+		if len(container.Names) > 0 {
+			candidate += " (" + strings.TrimPrefix(container.Names[0], "/") + ")"
+		}
+		candidates = append(candidates, candidate)
+	}
+	sort.Strings(candidates)
+	return docker.APIContainers{}, &ambiguousContainerError{ref: ref, candidates: candidates}
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestLookupContainer(t *testing.T) {
+	hello := map[string]string{lainLabelPrefix + "pg_name": "hello.web.web"}
+	console := map[string]string{lainLabelPrefix + "pg_name": "console.web.web"}
+	fake := &fakeDocker{
+		listContainers: func(opts docker.ListContainersOptions) ([]docker.APIContainers, error) {
+			return []docker.APIContainers{
+				{ID: "abc123def456", Names: []string{"/hello.web.web.v1-i1-d0"}, Labels: hello},
+				{ID: "abc999def456", Names: []string{"/hello.web.web.v1-i2-d0"}, Labels: hello},
+				{ID: "fed321cba654", Names: []string{"/console.web.web.v1-i1-d0"}, Labels: console},
+			}, nil
+		},
+	}
+	server := &EntryServer{dockerClient: fake}
+	cases := []struct {
+		ref      string
+		expected string
+		err      string
+	}{
+		{"abc1", "abc123def456", ""},
+		{"hello.web.web.v1-i2-d0", "abc999def456", ""},
+		{"/hello.web.web.v1-i1-d0", "abc123def456", ""},
+		{"abc", "", "not found"},
+		{"abcX", "", "not found"},
+		{"fed321", "", "not found"},
+		{"abc12", "abc123def456", ""},
+	}
+	for i, c := range cases {
+		container, err := server.lookupContainer("hello", c.ref)
+		if c.err == "" && (err != nil || container.ID != c.expected) {
+			t.Errorf("Case %d failed: actual is %q, %v", i+1, container.ID, err)
+		}
+		if c.err != "" && err != errContainerNotfound {
+			t.Errorf("Case %d failed: err is %v", i+1, err)
+		}
+	}
+
+	fake.listContainers = func(opts docker.ListContainersOptions) ([]docker.APIContainers, error) {
+		return []docker.APIContainers{
+			{ID: "abc123def456", Names: []string{"/hello.web.web.v1-i1-d0"}, Labels: hello},
+			{ID: "abc123fff456", Names: []string{"/hello.web.web.v1-i2-d0"}, Labels: hello},
+		}, nil
+	}
+	_, err := server.lookupContainer("hello", "abc123")
+	ambiguousErr, ok := err.(*ambiguousContainerError)
+	if !ok || len(ambiguousErr.candidates) != 2 || ambiguousErr.candidates[0] != "abc123def456 (hello.web.web.v1-i1-d0)" {
+		t.Errorf("Ambiguous prefix failed: err is %v", err)
+	}
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// The outcomes of sessions in metrics.
+const (
+	outcomeNormal     = "normal"
+	outcomeAuthFailed = "auth_failed"
+	outcomeTimeout    = "timeout"
+	outcomeError      = "error"
+	// outcomeCapacity is a session ended by a limit, like the output limit.
+	outcomeCapacity = "capacity"
+)
+
+// unknownApp labels the sessions of clients not authorized on their application, whose
+// names are anything the clients send and would make the labels unbounded.
+const unknownApp = "-"
+
+type sessionLabels struct {
+	kind    string
+	app     string
+	outcome string
+}
+
+type protocolLabels struct {
+	kind      string
+	marshaler string
+	protocol  string
+}
+
+// byteTotals counts the bytes of the messages of all the sessions, from and to the clients.
+// They are added atomically on every message, never under the lock of sessionMetrics.
+type byteTotals struct {
+	in  int64
+	out int64
+}
+
+func (t *byteTotals) addIn(n int) {
+	if t != nil {
+		atomic.AddInt64(&t.in, int64(n))
+	}
+}
+
+func (t *byteTotals) addOut(n int) {
+	if t != nil {
+		atomic.AddInt64(&t.out, int64(n))
+	}
+}
+
+// sessionMetrics counts the sessions by kind, application and outcome, served in the text
+// format of Prometheus. Applications are bounded, containers and users are not, so they are
+// never labels.
+type sessionMetrics struct {
+	bytes byteTotals
+	sync.Mutex
+	ended  map[sessionLabels]uint64
+	active map[string]int64
+	// breakGlass counts the ended sessions authorized by the break-glass token, by application.
+	breakGlass map[string]uint64
+	// protocols counts the ended sessions by their marshaler and protocol, see getMarshalers.
+	protocols map[protocolLabels]uint64
+}
+
+// begin counts a session of kind being served.
+func (m *sessionMetrics) begin(kind string) {
+	m.Lock()
+	defer m.Unlock()
+	if m.active == nil {
+		m.active = make(map[string]int64)
+	}
+	m.active[kind]++
+}
+
+// end counts a session of kind which ended with outcome, info is what is known of it.
+func (m *sessionMetrics) end(kind string, info sessionInfo, outcome string) {
+	app := unknownApp
+	if info.authorized {
+		app = info.appName
+	}
+	m.Lock()
+	defer m.Unlock()
+	if m.ended == nil {
+		m.ended = make(map[sessionLabels]uint64)
+	}
+	m.ended[sessionLabels{kind: kind, app: app, outcome: outcome}]++
+	if info.marshaler != "" {
+		if m.protocols == nil {
+			m.protocols = make(map[protocolLabels]uint64)
+		}
+		m.protocols[protocolLabels{kind: kind, marshaler: info.marshaler, protocol: info.protocol}]++
+	}
+	if info.breakGlass {
+		if m.breakGlass == nil {
+			m.breakGlass = make(map[string]uint64)
+		}
+		m.breakGlass[app]++
+	}
+	m.active[kind]--
+}
+
+// failureOutcome returns the outcome of a session which failed by err.
+func failureOutcome(err error) string {
+	if err == errAuthFailed {
+		return outcomeAuthFailed
+	}
+	if err == errDockerTimeout {
+		return outcomeTimeout
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return outcomeTimeout
+	}
+	return outcomeError
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// serveHTTP serves GET /metrics.
+func (m *sessionMetrics) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	m.Lock()
+	var ended, active, breakGlass, protocols []string
+	for labels, count := range m.ended {
+		ended = append(ended, fmt.Sprintf("entry_sessions_total{kind=\"%s\",app=\"%s\",outcome=\"%s\"} %d\n",
+			labelEscaper.Replace(labels.kind), labelEscaper.Replace(labels.app), labelEscaper.Replace(labels.outcome), count))
+	}
+	for kind, count := range m.active {
+		active = append(active, fmt.Sprintf("entry_sessions_active{kind=\"%s\"} %d\n", labelEscaper.Replace(kind), count))
+	}
+	for app, count := range m.breakGlass {
+		breakGlass = append(breakGlass, fmt.Sprintf("entry_break_glass_sessions_total{app=\"%s\"} %d\n", labelEscaper.Replace(app), count))
+	}
+	for labels, count := range m.protocols {
+		protocols = append(protocols, fmt.Sprintf("entry_sessions_protocol_total{kind=\"%s\",marshaler=\"%s\",protocol=\"%s\"} %d\n",
+			labelEscaper.Replace(labels.kind), labelEscaper.Replace(labels.marshaler), labelEscaper.Replace(labels.protocol), count))
+	}
+	m.Unlock()
+	sort.Strings(ended)
+	sort.Strings(active)
+	sort.Strings(breakGlass)
+	sort.Strings(protocols)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintln(w, "# HELP entry_sessions_total Sessions ended, by kind, application and outcome.")
+	fmt.Fprintln(w, "# TYPE entry_sessions_total counter")
+	fmt.Fprint(w, strings.Join(ended, ""))
+	fmt.Fprintln(w, "# HELP entry_sessions_active Sessions being served, by kind.")
+	fmt.Fprintln(w, "# TYPE entry_sessions_active gauge")
+	fmt.Fprint(w, strings.Join(active, ""))
+	fmt.Fprintln(w, "# HELP entry_break_glass_sessions_total Sessions authorized by the break-glass token, by application.")
+	fmt.Fprintln(w, "# TYPE entry_break_glass_sessions_total counter")
+	fmt.Fprint(w, strings.Join(breakGlass, ""))
+	fmt.Fprintln(w, "# HELP entry_sessions_protocol_total Sessions ended, by kind, marshaler and protocol.")
+	fmt.Fprintln(w, "# TYPE entry_sessions_protocol_total counter")
+	fmt.Fprint(w, strings.Join(protocols, ""))
+	fmt.Fprintln(w, "# HELP entry_received_bytes_total Bytes of the messages received from clients.")
+	fmt.Fprintln(w, "# TYPE entry_received_bytes_total counter")
+	fmt.Fprintf(w, "entry_received_bytes_total %d\n", atomic.LoadInt64(&m.bytes.in))
+	fmt.Fprintln(w, "# HELP entry_sent_bytes_total Bytes of the messages sent to clients.")
+	fmt.Fprintln(w, "# TYPE entry_sent_bytes_total counter")
+	fmt.Fprintf(w, "entry_sent_bytes_total %d\n", atomic.LoadInt64(&m.bytes.out))
+}
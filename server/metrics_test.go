@@ -0,0 +1,177 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gorilla/websocket"
+	swebLog "github.com/mijia/sweb/log"
+)
+
+func TestSessionMetrics(t *testing.T) {
+	fake := &fakeDocker{
+		createExec: func(opts docker.CreateExecOptions) (*docker.Exec, error) {
+			if opts.Container == "wedged" {
+				return nil, errDockerTimeout
+			}
+			return &docker.Exec{ID: "exec"}, nil
+		},
+	}
+	server := &EntryServer{
+		dockerClient: fake,
+		authorizer:   &FakeAuthorizer{Tokens: map[string]string{"dev": "developer"}},
+		resolver:     StaticResolver{"hello/web/1": "c1", "hello/web/2": "wedged"},
+	}
+	ts := httptest.NewServer(http.HandlerFunc(server.enter))
+	defer ts.Close()
+
+	for _, c := range []struct {
+		token, appName, instanceNo string
+	}{
+		{"dev", "hello", "1"},
+		{"dev", "hello", "1"},
+		{"dev", "hello", "2"},
+		{"nobody", "random-app-name", "1"},
+	} {
+		header := http.Header{}
+		header.Set("access-token", c.token)
+		header.Set("app-name", c.appName)
+		header.Set("proc-name", "web")
+		header.Set("instance-no", c.instanceNo)
+		ws, _, err := websocket.DefaultDialer.Dial(strings.Replace(ts.URL, "http", "ws", 1), header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The session ends by itself, read until it's closed.
+		for err == nil {
+			_, _, err = ws.ReadMessage()
+		}
+		ws.Close()
+	}
+	server.sessions.Wait()
+
+	w := httptest.NewRecorder()
+	server.metrics.serveHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	for i, line := range []string{
+		`entry_sessions_total{kind="enter",app="hello",outcome="normal"} 2`,
+		`entry_sessions_total{kind="enter",app="hello",outcome="timeout"} 1`,
+		`entry_sessions_total{kind="enter",app="-",outcome="auth_failed"} 1`,
+		`entry_sessions_active{kind="enter"} 0`,
+	} {
+		if !strings.Contains(w.Body.String(), line+"\n") {
+			t.Errorf("Case %d failed: %s", i+1, w.Body.String())
+		}
+	}
+	// Every session is told why it ends.
+	if strings.Contains(w.Body.String(), "entry_sent_bytes_total 0\n") {
+		t.Errorf("No byte is counted: %s", w.Body.String())
+	}
+}
+
+// lockedBuffer collects the log written by the goroutines of sessions.
+type lockedBuffer struct {
+	sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.String()
+}
+
+func TestSessionProtocol(t *testing.T) {
+	logged := &lockedBuffer{}
+	logger := swebLog.Logger()
+	defer logger.SetOutput(logger.Writer())
+	logger.SetOutput(logged)
+
+	server := &EntryServer{dockerClient: &fakeDocker{}, authorizer: &FakeAuthorizer{Allow: true}, resolver: StaticResolver{"hello/web/1": "c1"}}
+	ts := httptest.NewServer(http.HandlerFunc(server.enter))
+	defer ts.Close()
+	cases := []struct {
+		query       string
+		subprotocol string
+		expected    string
+	}{
+		{"", "", "marshaler=proto protocol=plain"},
+		{"", batchSubprotocol, "marshaler=proto protocol=entry.batch"},
+		{"?method=web", "", "marshaler=json protocol=plain"},
+	}
+	for i, c := range cases {
+		header := http.Header{}
+		header.Set("app-name", "hello")
+		header.Set("proc-name", "web")
+		header.Set("instance-no", "1")
+		dialer := websocket.Dialer{}
+		if c.subprotocol != "" {
+			dialer.Subprotocols = []string{c.subprotocol}
+		}
+		ws, _, err := dialer.Dial(strings.Replace(ts.URL, "http", "ws", 1)+c.query, header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.query != "" {
+			ws.WriteMessage(websocket.TextMessage, []byte(`{"app_name": "hello", "proc_name": "web", "instance_no": "1"}`))
+		}
+		for {
+			if _, _, err = ws.ReadMessage(); err != nil {
+				break
+			}
+		}
+		ws.Close()
+		server.sessions.Wait()
+		found := false
+		for _, line := range strings.Split(logged.String(), "\n") {
+			if strings.Contains(line, "Entering to c1 stopped") && strings.Contains(line, c.expected+"]") {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Case %d failed: %s is not logged", i+1, c.expected)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	server.metrics.serveHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	for i, expected := range []string{
+		`entry_sessions_protocol_total{kind="enter",marshaler="json",protocol="plain"} 1`,
+		`entry_sessions_protocol_total{kind="enter",marshaler="proto",protocol="entry.batch"} 1`,
+		`entry_sessions_protocol_total{kind="enter",marshaler="proto",protocol="plain"} 1`,
+	} {
+		if !strings.Contains(rec.Body.String(), expected) {
+			t.Errorf("Metric %d failed: %s is not in\n%s", i+1, expected, rec.Body.String())
+		}
+	}
+}
+
+func TestByteTotals(t *testing.T) {
+	m := &sessionMetrics{}
+	m.bytes.addIn(3)
+	m.bytes.addOut(5)
+	m.bytes.addOut(7)
+	var none *byteTotals
+	none.addIn(1)
+
+	w := httptest.NewRecorder()
+	m.serveHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	for i, line := range []string{
+		"entry_received_bytes_total 3",
+		"entry_sent_bytes_total 12",
+	} {
+		if !strings.Contains(w.Body.String(), line+"\n") {
+			t.Errorf("Case %d failed: %s", i+1, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+)
+
+// CertRule maps the client certificates whose CN or a SAN matches the glob Match to an
+// identity playing Role, for the applications matching Apps, or any application if empty.
+type CertRule struct {
+	Match    string   `json:"match"`
+	Identity string   `json:"identity"`
+	Role     string   `json:"role"`
+	Apps     []string `json:"apps"`
+}
+
+// LoadCertRules reads the client certificate rules from a JSON array file.
+func LoadCertRules(path string) ([]CertRule, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []CertRule
+	if err = json.Unmarshal(data, &rules); err != nil {
+		return nil, err
+	}
+	for _, rule := range rules {
+		if rule.Match == "" || rule.Identity == "" || rule.Role == "" {
+			return nil, errors.New("cert rule needs match, identity and role")
+		}
+	}
+	return rules, nil
+}
+
+// certNames returns the names a client certificate is known by.
+func certNames(cert *x509.Certificate) []string {
+	names := []string{cert.Subject.CommonName}
+	names = append(names, cert.DNSNames...)
+	return append(names, cert.EmailAddresses...)
+}
+
+// matchCertRule returns the first rule matching the verified client certificate of r for appName.
+func matchCertRule(rules []CertRule, r *http.Request, appName string) (CertRule, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return CertRule{}, false
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	for _, rule := range rules {
+		if matchNames(rule.Match, certNames(cert)) {
+			if len(rule.Apps) == 0 || matchAny(rule.Apps, appName) {
+				return rule, true
+			}
+		}
+	}
+	return CertRule{}, false
+}
+
+func matchNames(pattern string, names []string) bool {
+	for _, name := range names {
+		if name != "" && matchAny([]string{pattern}, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// authorize authorizes the client of r on appName, by its client certificate if a rule
+// matches, or else by token. It returns the role and the identity of the client.
+func (server *EntryServer) authorize(r *http.Request, token, appName string) (string, string, error) {
+	if rule, ok := matchCertRule(server.certRules, r, appName); ok {
+		return rule.Role, "cert:" + rule.Identity, nil
+	}
+	role, err := server.authorizer.Authorize(token, appName)
+	return role, tokenFingerprint(token), err
+}
+
+// newTLSConfig creates the TLS settings of the server which verifies client certificates
+// against the CA file clientCA, if given. Clients must present one if required.
+func newTLSConfig(clientCA string, required bool) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if clientCA == "" {
+		return config, nil
+	}
+	data, err := ioutil.ReadFile(clientCA)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New("no certificate found in " + clientCA)
+	}
+	config.ClientCAs = pool
+	config.ClientAuth = tls.VerifyClientCertIfGiven
+	if required {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
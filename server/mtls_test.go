@@ -0,0 +1,44 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthorizeByCert(t *testing.T) {
+	server := &EntryServer{
+		authorizer: &FakeAuthorizer{Tokens: map[string]string{"token": "developer"}},
+		certRules: []CertRule{
+			{Match: "ci-*.lain.local", Identity: "ci", Role: "developer", Apps: []string{"hello"}},
+			{Match: "ops@lain.local", Identity: "ops", Role: "admin"},
+		},
+	}
+	cases := []struct {
+		cert     *x509.Certificate
+		token    string
+		appName  string
+		role     string
+		identity string
+		valid    bool
+	}{
+		{&x509.Certificate{Subject: pkix.Name{CommonName: "ci-runner.lain.local"}}, "", "hello", "developer", "cert:ci", true},
+		{&x509.Certificate{Subject: pkix.Name{CommonName: "ci-runner.lain.local"}}, "", "console", "", "", false},
+		{&x509.Certificate{EmailAddresses: []string{"ops@lain.local"}}, "", "console", "admin", "cert:ops", true},
+		{&x509.Certificate{Subject: pkix.Name{CommonName: "stranger"}}, "token", "hello", "developer", tokenFingerprint("token"), true},
+		{nil, "token", "hello", "developer", tokenFingerprint("token"), true},
+		{nil, "", "hello", "", "", false},
+	}
+	for i, c := range cases {
+		r := httptest.NewRequest("GET", "/enter", nil)
+		if c.cert != nil {
+			r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{c.cert}}}
+		}
+		role, identity, err := server.authorize(r, c.token, c.appName)
+		if (err == nil) != c.valid || (c.valid && (role != c.role || identity != c.identity)) {
+			t.Errorf("Case %d failed: role is %q, identity is %q, %v", i+1, role, identity, err)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// ExitRule runs Command with sh in the container of the enter sessions of the applications
+// matching Apps when they end, e.g. to clean up what debugging left behind like
+// "pkill strace; rm -rf /tmp/debug".
+type ExitRule struct {
+	Apps    []string `json:"apps"`
+	Command string   `json:"command"`
+}
+
+// LoadExitRules reads the on-exit rules from a JSON array file.
+func LoadExitRules(path string) ([]ExitRule, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []ExitRule
+	if err = json.Unmarshal(data, &rules); err != nil {
+		return nil, err
+	}
+	for _, rule := range rules {
+		if len(rule.Apps) == 0 || rule.Command == "" {
+			return nil, errors.New("exit rule needs apps and command")
+		}
+	}
+	return rules, nil
+}
+
+// exitCommand returns the command of the first rule matching appName, "" if none.
+func exitCommand(rules []ExitRule, appName string) string {
+	for _, rule := range rules {
+		if matchAny(rule.Apps, appName) {
+			return rule.Command
+		}
+	}
+	return ""
+}
+
+// runExitCommand runs command in the container of the session of info after it ended. It's
+// done on a best-effort basis, the failures are only logged.
+func (server *EntryServer) runExitCommand(info sessionInfo, command string) {
+	exec, err := server.dockerClient.CreateExec(docker.CreateExecOptions{
+		Container:    info.containerID,
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd:          []string{"sh", "-c", command},
+	})
+	if err == nil {
+		err = server.dockerClient.StartExec(exec.ID, docker.StartExecOptions{
+			OutputStream: ioutil.Discard,
+			ErrorStream:  ioutil.Discard,
+		})
+	}
+	var inspect *docker.ExecInspect
+	if err == nil {
+		inspect, err = server.dockerClient.InspectExec(exec.ID)
+	}
+	if err != nil {
+		info.logger.Errorf("Exit command in %s failed: %s", info.containerID, err.Error())
+		return
+	}
+	if inspect.ExitCode != 0 {
+		info.logger.Warnf("Exit command in %s exited with code %d", info.containerID, inspect.ExitCode)
+		return
+	}
+	info.logger.Debugf("Exit command in %s is done", info.containerID)
+}
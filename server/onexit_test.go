@@ -0,0 +1,73 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestExitCommand(t *testing.T) {
+	rules := []ExitRule{
+		{Apps: []string{"hello"}, Command: "pkill strace"},
+		{Apps: []string{"db-*", "cache"}, Command: "rm -rf /tmp/debug"},
+		{Apps: []string{"*"}, Command: "true"},
+	}
+	for i, c := range []struct {
+		appName string
+		command string
+	}{
+		{"hello", "pkill strace"},
+		{"db-main", "rm -rf /tmp/debug"},
+		{"cache", "rm -rf /tmp/debug"},
+		{"other", "true"},
+	} {
+		if actual := exitCommand(rules, c.appName); actual != c.command {
+			t.Errorf("Case %d failed: actual is %q", i+1, actual)
+		}
+	}
+	if actual := exitCommand(nil, "hello"); actual != "" {
+		t.Errorf("No rule: actual is %q", actual)
+	}
+}
+
+func TestEnterExitCommand(t *testing.T) {
+	var (
+		lock  sync.Mutex
+		execs [][]string
+	)
+	fake := &fakeDocker{
+		createExec: func(opts docker.CreateExecOptions) (*docker.Exec, error) {
+			lock.Lock()
+			defer lock.Unlock()
+			execs = append(execs, opts.Cmd)
+			return &docker.Exec{ID: "exec"}, nil
+		},
+		inspectExec: func(id string) (*docker.ExecInspect, error) {
+			// The failure of the cleanup is only logged.
+			return &docker.ExecInspect{ID: id, ExitCode: 1}, nil
+		},
+	}
+	server := &EntryServer{dockerClient: fake, authorizer: &FakeAuthorizer{Allow: true, Role: "developer"},
+		resolver: StaticResolver{"hello/web/1": "c1"}, exitRules: []ExitRule{{Apps: []string{"hello"}, Command: "pkill strace"}}}
+	ts := httptest.NewServer(http.HandlerFunc(server.enter))
+	defer ts.Close()
+
+	ws := dialSession(t, ts, "", nil)
+	var err error
+	// The shell exits at once, read until the session is closed.
+	for err == nil {
+		_, _, err = ws.ReadMessage()
+	}
+	ws.Close()
+	server.sessions.Wait()
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(execs) != 2 || !reflect.DeepEqual(execs[1], []string{"sh", "-c", "pkill strace"}) {
+		t.Errorf("Execs are %q", execs)
+	}
+}
@@ -0,0 +1,24 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/laincloud/entry/log"
+)
+
+var errOutputLimit = errors.New(reasonOutputLimit)
+
+// endOutput ends the session which reached its output limit. Like a write timeout, it closes
+// the connection, which tears down the whole session whatever it runs.
+func (server *EntryServer) endOutput(ws *safeConn, msgMarshaller Marshaler) {
+	if !ws.limitOutput() {
+		return
+	}
+	log.Warnf("Session output reached the limit of %d bytes", ws.outputLimit)
+	server.sendNoticeMessage(ws, fmt.Sprintf("Output limit of %d bytes reached, terminating the session.", ws.outputLimit), msgMarshaller)
+	ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reasonOutputLimit), time.Now().Add(time.Second))
+	ws.Close()
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gorilla/websocket"
+	"github.com/laincloud/entry/message"
+)
+
+func TestEnterOutputLimit(t *testing.T) {
+	ended := make(chan struct{})
+	fake := &fakeDocker{
+		startExec: func(id string, opts docker.StartExecOptions) (docker.CloseWaiter, error) {
+			w := &fakeWaiter{done: make(chan struct{})}
+			go func() {
+				// Like `cat hugefile`, until the output can't be written.
+				for {
+					if _, err := opts.OutputStream.Write([]byte(strings.Repeat("x", 30))); err != nil {
+						break
+					}
+				}
+				close(w.done)
+				close(ended)
+			}()
+			return w, nil
+		},
+	}
+	server := &EntryServer{dockerClient: fake, authorizer: &FakeAuthorizer{Allow: true}, resolver: StaticResolver{"hello/web/1": "c1"}, outputLimit: 100}
+	ts := httptest.NewServer(http.HandlerFunc(server.enter))
+	defer ts.Close()
+
+	ws := dialSession(t, ts, "", nil)
+	defer ws.Close()
+
+	output, notice := 0, ""
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+				t.Errorf("Connection is not closed for the limit: %v", err)
+			}
+			break
+		}
+		msg := message.ResponseMessage{}
+		if err = protoUnmarshalFunc(data, &msg); err != nil {
+			t.Fatal(err)
+		}
+		switch msg.MsgType {
+		case message.ResponseMessage_STDOUT:
+			output += len(msg.Content)
+		case message.ResponseMessage_NOTICE:
+			notice = string(msg.Content)
+		}
+	}
+	if output != 100 || !strings.Contains(notice, "Output limit of 100 bytes reached") {
+		t.Errorf("Output is %d bytes, notice is %q", output, notice)
+	}
+	select {
+	case <-ended:
+	case <-time.After(time.Second):
+		t.Error("The exec is not torn down")
+	}
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/laincloud/entry/log"
+)
+
+const (
+	// runtimeDocker and runtimePodman are the container runtimes serving the docker API.
+	runtimeDocker = "docker"
+	runtimePodman = "podman"
+	// podmanResizeRetries bounds the retries of resizing an exec which isn't running yet,
+	// podmanResizeBackoff is the wait before each of them.
+	podmanResizeRetries = 5
+	podmanResizeBackoff = 50 * time.Millisecond
+)
+
+// podmanClient adapts the docker compatible API of Podman, e.g. on
+// unix:///run/podman/podman.sock, where it differs from docker:
+//
+//   - Podman starts an exec in background once its stream is hijacked, and refuses to
+//     resize its tty until it runs, where docker resizes it at once. The resizes of an exec
+//     not running yet are retried.
+//
+// Known limitations, not worked around:
+//
+//   - The kubernetes resolver needs the labels the kubelet sets on docker containers, which
+//     containers of Podman pods don't have, so only the lain and static resolvers work.
+//   - ssh:// endpoints run `docker system dial-stdio` on the remote host, not Podman's.
+type podmanClient struct {
+	dockerAPI
+}
+
+// newRuntimeClient adapts client to the container runtime serving it.
+func newRuntimeClient(client dockerAPI, runtime string) dockerAPI {
+	if runtime == runtimePodman {
+		return &podmanClient{dockerAPI: client}
+	}
+	return client
+}
+
+func (c *podmanClient) ResizeExecTTY(id string, height, width int) error {
+	err := c.dockerAPI.ResizeExecTTY(id, height, width)
+	for i := 0; i < podmanResizeRetries && isExecNotRunning(err); i++ {
+		log.Debugf("Exec %s is not running yet, its resize is retried", id)
+		time.Sleep(podmanResizeBackoff)
+		err = c.dockerAPI.ResizeExecTTY(id, height, width)
+	}
+	return err
+}
+
+// isExecNotRunning reports whether err is Podman refusing an exec which is not running.
+func isExecNotRunning(err error) bool {
+	e, ok := err.(*docker.Error)
+	return ok && (e.Status == http.StatusConflict || strings.Contains(e.Message, "not running"))
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestNewRuntimeClient(t *testing.T) {
+	fake := &fakeDocker{}
+	if client := newRuntimeClient(fake, runtimeDocker); client != dockerAPI(fake) {
+		t.Errorf("Docker client is adapted: %T", client)
+	}
+	if client, ok := newRuntimeClient(fake, runtimePodman).(*podmanClient); !ok || client.dockerAPI != dockerAPI(fake) {
+		t.Errorf("Podman client is not adapted")
+	}
+}
+
+func TestPodmanResizeExec(t *testing.T) {
+	notRunning := &docker.Error{Status: http.StatusConflict, Message: "exec session e1 is not running"}
+	noSuchExec := &docker.NoSuchExec{ID: "e1"}
+	cases := []struct {
+		errs     []error
+		attempts int
+		err      error
+	}{
+		{nil, 1, nil},
+		{[]error{notRunning, notRunning}, 3, nil},
+		{[]error{&docker.Error{Status: http.StatusInternalServerError, Message: "container is not running"}}, 2, nil},
+		// Other errors are not retried.
+		{[]error{noSuchExec}, 1, noSuchExec},
+		{[]error{notRunning, notRunning, notRunning, notRunning, notRunning, notRunning, notRunning}, podmanResizeRetries + 1, notRunning},
+	}
+	for i, c := range cases {
+		attempts := 0
+		fake := &fakeDocker{
+			resizeExecTTY: func(id string, height, width int) error {
+				attempts++
+				if attempts <= len(c.errs) {
+					return c.errs[attempts-1]
+				}
+				return nil
+			},
+		}
+		err := newRuntimeClient(fake, runtimePodman).ResizeExecTTY("e1", 24, 80)
+		if attempts != c.attempts || err != c.err {
+			t.Errorf("Case %d failed: %d attempts, %v", i+1, attempts, err)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/laincloud/entry/log"
+)
+
+const closedByUserMsg = "\033[31m>>> This session is closed by you from elsewhere.\033[0m"
+
+// ActiveSession is an enter or attach session being served, as listed to its user.
+type ActiveSession struct {
+	ID         string    `json:"id"`
+	Kind       string    `json:"kind"`
+	AppName    string    `json:"app_name"`
+	ProcName   string    `json:"proc_name"`
+	InstanceNo string    `json:"instance_no"`
+	Container  string    `json:"container"`
+	Started    time.Time `json:"started"`
+	// Detached is set while the session waits for its client to reattach.
+	Detached bool `json:"detached,omitempty"`
+}
+
+type registeredSession struct {
+	ActiveSession
+	user   string
+	web    bool
+	cancel context.CancelFunc
+	closed int32
+	// detached is set while the session is detached, a reattach is sent to reattach then.
+	detached int32
+	reattach chan *reattachRequest
+	// ended is closed once the session is removed.
+	ended chan struct{}
+	// lastActive is the unix nanoseconds of the last message of the client or output of the
+	// session, see safeConn.touch.
+	lastActive int64
+}
+
+// closedByUser reports whether the user closed the session through the registry.
+func (s *registeredSession) closedByUser() bool {
+	return atomic.LoadInt32(&s.closed) == 1
+}
+
+// sessionRegistry records the sessions being served by this server, so that users can find
+// and close the sessions they forgot.
+type sessionRegistry struct {
+	sync.Mutex
+	sessions map[string]*registeredSession
+}
+
+// add registers the session of info, cancel ends it when it's closed by its user.
+func (reg *sessionRegistry) add(info sessionInfo, cancel context.CancelFunc) *registeredSession {
+	s := newRegisteredSession(info, cancel)
+	reg.Lock()
+	defer reg.Unlock()
+	reg.insert(s)
+	return s
+}
+
+// addExclusive registers the session of info like add, unless another enter session is in
+// its container, it returns nil then. The check and the registration are atomic, two
+// sessions racing to the same container don't both get in.
+func (reg *sessionRegistry) addExclusive(info sessionInfo, cancel context.CancelFunc) *registeredSession {
+	s := newRegisteredSession(info, cancel)
+	reg.Lock()
+	defer reg.Unlock()
+	if reg.enteredLocked(info.containerID) {
+		return nil
+	}
+	reg.insert(s)
+	return s
+}
+
+// entered reports whether an enter session is in containerID.
+func (reg *sessionRegistry) entered(containerID string) bool {
+	reg.Lock()
+	defer reg.Unlock()
+	return reg.enteredLocked(containerID)
+}
+
+func (reg *sessionRegistry) enteredLocked(containerID string) bool {
+	for _, s := range reg.sessions {
+		if s.Kind == "enter" && s.Container == containerID {
+			return true
+		}
+	}
+	return false
+}
+
+func (reg *sessionRegistry) insert(s *registeredSession) {
+	if reg.sessions == nil {
+		reg.sessions = make(map[string]*registeredSession)
+	}
+	reg.sessions[s.ID] = s
+}
+
+func newRegisteredSession(info sessionInfo, cancel context.CancelFunc) *registeredSession {
+	id := make([]byte, 8)
+	rand.Read(id)
+	s := &registeredSession{
+		ActiveSession: ActiveSession{
+			ID:         hex.EncodeToString(id),
+			Kind:       info.kind,
+			AppName:    info.appName,
+			ProcName:   info.procName,
+			InstanceNo: info.instanceNo,
+			Container:  info.containerID,
+			Started:    time.Now(),
+		},
+		user:     info.user,
+		web:      info.viaWeb,
+		cancel:   cancel,
+		reattach: make(chan *reattachRequest, 1),
+		ended:    make(chan struct{}),
+	}
+	s.lastActive = s.Started.UnixNano()
+	return s
+}
+
+func (reg *sessionRegistry) remove(s *registeredSession) {
+	reg.Lock()
+	defer reg.Unlock()
+	delete(reg.sessions, s.ID)
+	close(s.ended)
+}
+
+// reattach sends req to the detached session asked by the client of info, if it's the
+// session of the client. It returns the channel closed when the session is removed.
+func (reg *sessionRegistry) reattach(info sessionInfo, req *reattachRequest) (<-chan struct{}, error) {
+	reg.Lock()
+	s := reg.sessions[info.reattach]
+	reg.Unlock()
+	// The messages of the client must be encoded like those of the session.
+	if s == nil || s.user == "" || s.user != info.user || s.AppName != info.appName || s.web != info.viaWeb {
+		return nil, errNoDetachedSession
+	}
+	if !atomic.CompareAndSwapInt32(&s.detached, 1, 0) {
+		return nil, errNoDetachedSession
+	}
+	s.reattach <- req
+	return s.ended, nil
+}
+
+// moved records that the session s switched to instanceNo in containerID.
+func (reg *sessionRegistry) moved(s *registeredSession, instanceNo, containerID string) {
+	reg.Lock()
+	defer reg.Unlock()
+	s.InstanceNo, s.Container = instanceNo, containerID
+}
+
+// active returns what's listed of s.
+func (reg *sessionRegistry) active(s *registeredSession) ActiveSession {
+	reg.Lock()
+	defer reg.Unlock()
+	session := s.ActiveSession
+	session.Detached = atomic.LoadInt32(&s.detached) == 1
+	return session
+}
+
+// inContainer returns the number of sessions in containerID, and their distinct users.
+func (reg *sessionRegistry) inContainer(containerID string) (int, []string) {
+	reg.Lock()
+	defer reg.Unlock()
+	count := 0
+	seen := make(map[string]bool)
+	users := []string{}
+	for _, s := range reg.sessions {
+		if s.Container != containerID {
+			continue
+		}
+		count++
+		if s.user != "" && !seen[s.user] {
+			seen[s.user] = true
+			users = append(users, s.user)
+		}
+	}
+	sort.Strings(users)
+	return count, users
+}
+
+// list returns the registered sessions, the oldest first.
+func (reg *sessionRegistry) list() []*registeredSession {
+	reg.Lock()
+	defer reg.Unlock()
+	sessions := make([]*registeredSession, 0, len(reg.sessions))
+	for _, s := range reg.sessions {
+		sessions = append(sessions, s)
+	}
+	// Started never changes, unlike the container of the sessions switching instances.
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].Started.Before(sessions[j].Started) })
+	return sessions
+}
+
+// mySessions serves GET /server/sessions/mine, the sessions of the client on this server,
+// and DELETE /server/sessions/mine/{id} to close one. The client is identified by its
+// access-token header, or its certificate, authorized on the application of every session.
+// The registry only knows the sessions of this server, the other servers of the fleet are
+// not asked: a client behind a load balancer has to ask each of them.
+func (server *EntryServer) mySessions(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 3 || len(parts) > 4 || parts[0] != "server" || parts[1] != "sessions" || parts[2] != "mine" {
+		http.NotFound(w, r)
+		return
+	}
+	if (len(parts) == 3 && r.Method != http.MethodGet) || (len(parts) == 4 && r.Method != http.MethodDelete) {
+		http.Error(w, "Method is not allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := r.Header.Get("access-token")
+	// The client is the user of a session if it's authorized on its application as the user.
+	users := make(map[string]string)
+	isMine := func(s *registeredSession) bool {
+		user, ok := users[s.AppName]
+		if !ok {
+			var err error
+			if _, user, err = server.authorize(r, token, s.AppName); err != nil {
+				log.Debugf("Authorization on %s for sessions failed: %s", s.AppName, err.Error())
+				server.auditRequestDenied(r, "my-sessions", s.AppName, "", err)
+				user = ""
+			}
+			users[s.AppName] = user
+		}
+		return user != "" && user == s.user
+	}
+	candidates := server.registry.list()
+	if len(parts) == 4 {
+		for _, s := range candidates {
+			if s.ID == parts[3] && isMine(s) {
+				log.Infof("Session %s to %s is closed by its user %s", s.ID, server.registry.active(s).Container, s.user)
+				atomic.StoreInt32(&s.closed, 1)
+				s.cancel()
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		http.Error(w, "Session is not found.", http.StatusNotFound)
+		return
+	}
+	sessions := []ActiveSession{}
+	for _, s := range candidates {
+		if isMine(s) {
+			sessions = append(sessions, server.registry.active(s))
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/laincloud/entry/message"
+)
+
+func TestMySessions(t *testing.T) {
+	fake := &fakeDocker{
+		startExec: func(id string, opts docker.StartExecOptions) (docker.CloseWaiter, error) {
+			w := &fakeWaiter{done: make(chan struct{})}
+			go func() {
+				fmt.Fprint(opts.OutputStream, "$ ")
+				io.Copy(ioutil.Discard, opts.InputStream)
+				close(w.done)
+			}()
+			return w, nil
+		},
+	}
+	server := &EntryServer{dockerClient: fake, authorizer: &FakeAuthorizer{Tokens: map[string]string{"mine": "developer", "other": "developer"}},
+		resolver: StaticResolver{"hello/web/1": "c1"}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/enter", server.enter)
+	mux.HandleFunc("/server/sessions/mine", server.mySessions)
+	mux.HandleFunc("/server/sessions/mine/", server.mySessions)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	header := http.Header{}
+	header.Set("access-token", "mine")
+	ws := dialSession(t, ts, "/enter", header)
+	defer ws.Close()
+	if _, _, err := ws.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(method, path, token string) *http.Response {
+		req, _ := http.NewRequest(method, ts.URL+path, nil)
+		req.Header.Set("access-token", token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	list := func(token string) []ActiveSession {
+		resp := request("GET", "/server/sessions/mine", token)
+		defer resp.Body.Close()
+		var sessions []ActiveSession
+		if err := json.NewDecoder(resp.Body).Decode(&sessions); err != nil {
+			t.Fatal(err)
+		}
+		return sessions
+	}
+	sessions := list("mine")
+	if len(sessions) != 1 || sessions[0].Kind != "enter" || sessions[0].AppName != "hello" || sessions[0].Container != "c1" {
+		t.Fatalf("Sessions of the user are %+v", sessions)
+	}
+	for i, c := range []struct {
+		method string
+		path   string
+		token  string
+		status int
+	}{
+		{"GET", "/server/sessions/mine", "nobody", http.StatusOK},
+		{"DELETE", "/server/sessions/mine/" + sessions[0].ID, "other", http.StatusNotFound},
+		{"DELETE", "/server/sessions/mine/unknown", "mine", http.StatusNotFound},
+		{"POST", "/server/sessions/mine", "mine", http.StatusMethodNotAllowed},
+		{"DELETE", "/server/sessions/mine/" + sessions[0].ID, "mine", http.StatusNoContent},
+	} {
+		if resp := request(c.method, c.path, c.token); resp.StatusCode != c.status {
+			t.Errorf("Case %d failed: status is %d", i+1, resp.StatusCode)
+		}
+	}
+	if others := list("other"); len(others) != 0 {
+		t.Errorf("Sessions of another user are %+v", others)
+	}
+
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := message.ResponseMessage{}
+		protoUnmarshalFunc(data, &msg)
+		if msg.MsgType == message.ResponseMessage_CLOSE {
+			if string(msg.Content) != closedByUserMsg {
+				t.Errorf("CLOSE of the closed session is %q", msg.Content)
+			}
+			break
+		}
+	}
+	server.sessions.Wait()
+	if sessions = list("mine"); len(sessions) != 0 {
+		t.Errorf("Sessions after close are %+v", sessions)
+	}
+}
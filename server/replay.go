@@ -0,0 +1,82 @@
+package server
+
+import (
+	"errors"
+	"strconv"
+	"unicode/utf8"
+)
+
+// defaultResumeBuffer is how much of the recent output of a detachable session is kept.
+const defaultResumeBuffer = 64 * 1024
+
+var (
+	errInvalidResumeOffset = errors.New("invalid resume offset")
+	errResumeOffsetAhead   = errors.New("resume offset is past the output")
+	errOutputNotKept       = errors.New("output is not kept for replay")
+)
+
+// outputRing keeps the last output of a session to replay what its client missed while it
+// was away. The offsets count the bytes of the output since the session started, those
+// older than the size of the ring are dropped.
+type outputRing struct {
+	buf []byte
+	// end is the offset of the end of the output.
+	end int64
+}
+
+func newOutputRing(size int) *outputRing {
+	return &outputRing{buf: make([]byte, size)}
+}
+
+func (r *outputRing) write(p []byte) {
+	size := len(r.buf)
+	if len(p) > size {
+		r.end += int64(len(p) - size)
+		p = p[len(p)-size:]
+	}
+	at := int(r.end % int64(size))
+	n := copy(r.buf[at:], p)
+	copy(r.buf, p[n:])
+	r.end += int64(len(p))
+}
+
+// since returns the output after offset, and how much of it is lost as it's dropped from
+// the ring already. A negative offset replays nothing, one past the output is
+// errResumeOffsetAhead.
+func (r *outputRing) since(offset int64) ([]byte, int64, error) {
+	if offset > r.end {
+		return nil, 0, errResumeOffsetAhead
+	}
+	if offset < 0 || offset == r.end {
+		return nil, 0, nil
+	}
+	var lost int64
+	if start := r.end - int64(len(r.buf)); offset < start {
+		lost, offset = start-offset, start
+	}
+	missed := make([]byte, r.end-offset)
+	at := int(offset % int64(len(r.buf)))
+	n := copy(missed, r.buf[at:])
+	copy(missed[n:], r.buf)
+	if lost > 0 {
+		// The ring may start in the middle of a character.
+		for len(missed) > 0 && !utf8.RuneStart(missed[0]) {
+			missed = missed[1:]
+			lost++
+		}
+	}
+	return missed, lost, nil
+}
+
+// parseResumeOffset parses the offset of the output acknowledged by a client reattaching,
+// -1 if it asks no replay.
+func parseResumeOffset(offset string) (int64, error) {
+	if offset == "" {
+		return -1, nil
+	}
+	n, err := strconv.ParseInt(offset, 10, 64)
+	if err != nil || n < 0 {
+		return 0, errInvalidResumeOffset
+	}
+	return n, nil
+}
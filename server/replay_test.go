@@ -0,0 +1,167 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gorilla/websocket"
+	"github.com/laincloud/entry/message"
+)
+
+func TestOutputRing(t *testing.T) {
+	cases := []struct {
+		writes []string
+		offset int64
+		missed string
+		lost   int64
+		err    error
+	}{
+		{[]string{"hello"}, 0, "hello", 0, nil},
+		{[]string{"hello", " world"}, 5, " world", 0, nil},
+		{[]string{"hello"}, 5, "", 0, nil},
+		{[]string{"hello"}, 6, "", 0, errResumeOffsetAhead},
+		{[]string{"hello"}, -1, "", 0, nil},
+		// The oldest output is dropped past the size of the ring.
+		{[]string{"hello", " world"}, 0, "o world", 4, nil},
+		{[]string{"hello", " world"}, 6, "world", 0, nil},
+		{[]string{"0123456789abcdef"}, 10, "abcdef", 0, nil},
+		{[]string{"0123456789abcdef"}, 0, "9abcdef", 9, nil},
+		// Characters cut by the ring are dropped too.
+		{[]string{"h€llo", "!!"}, 0, "llo!!", 4, nil},
+	}
+	for i, c := range cases {
+		r := newOutputRing(7)
+		for _, w := range c.writes {
+			r.write([]byte(w))
+		}
+		if missed, lost, err := r.since(c.offset); string(missed) != c.missed || lost != c.lost || err != c.err {
+			t.Errorf("Case %d failed: missed %q, lost %d, %v", i+1, missed, lost, err)
+		}
+	}
+}
+
+func TestParseResumeOffset(t *testing.T) {
+	cases := []struct {
+		offset string
+		parsed int64
+		err    error
+	}{
+		{"", -1, nil},
+		{"0", 0, nil},
+		{"1024", 1024, nil},
+		{"-1", 0, errInvalidResumeOffset},
+		{"ten", 0, errInvalidResumeOffset},
+	}
+	for i, c := range cases {
+		if parsed, err := parseResumeOffset(c.offset); parsed != c.parsed || err != c.err {
+			t.Errorf("Case %d failed: %d, %v", i+1, parsed, err)
+		}
+	}
+}
+
+func TestEnterResume(t *testing.T) {
+	outputs := make(chan io.Writer, 1)
+	fake := &fakeDocker{
+		startExec: func(id string, opts docker.StartExecOptions) (docker.CloseWaiter, error) {
+			w := &fakeWaiter{done: make(chan struct{})}
+			outputs <- opts.OutputStream
+			go func() {
+				io.Copy(opts.OutputStream, opts.InputStream)
+				close(w.done)
+			}()
+			return w, nil
+		},
+	}
+	server := &EntryServer{dockerClient: fake, authorizer: &FakeAuthorizer{Tokens: map[string]string{"mine": "developer"}},
+		resolver: StaticResolver{"hello/web/1": "c1"}, detachKeys: []byte("\x10\x11"), detachTimeout: time.Minute, resumeBuffer: 8}
+	ts := httptest.NewServer(http.HandlerFunc(server.enter))
+	defer ts.Close()
+
+	dial := func(reattach, offset string) *websocket.Conn {
+		header := http.Header{}
+		header.Set("access-token", "mine")
+		header.Set("reattach", reattach)
+		header.Set("resume-offset", offset)
+		ws := dialSession(t, ts, "", header)
+		ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+		return ws
+	}
+	read := func(ws *websocket.Conn) *message.ResponseMessage {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := &message.ResponseMessage{}
+		protoUnmarshalFunc(data, msg)
+		return msg
+	}
+	input := func(ws *websocket.Conn, content string) {
+		data, _ := protoMarshalFunc(&message.RequestMessage{MsgType: message.RequestMessage_PLAIN, Content: []byte(content)})
+		if err := ws.WriteMessage(websocket.BinaryMessage, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// detach detaches the client of the session, which outputs content while it's away.
+	detach := func(ws *websocket.Conn, output io.Writer, content string) string {
+		input(ws, "\x10\x11")
+		for read(ws).MsgType != message.ResponseMessage_CLOSE {
+		}
+		ws.Close()
+		var session *registeredSession
+		for i := 0; i < 100 && session == nil; i++ {
+			if sessions := server.registry.list(); len(sessions) == 1 && atomic.LoadInt32(&sessions[0].detached) == 1 {
+				session = sessions[0]
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if session == nil {
+			t.Fatal("The session is not detached")
+		}
+		output.Write([]byte(content))
+		// The output is handled once the next write is read.
+		output.Write(nil)
+		return session.ID
+	}
+
+	ws := dial("", "")
+	output := <-outputs
+	input(ws, "ls")
+	if msg := read(ws); string(msg.Content) != "ls" {
+		t.Fatalf("Output is %q", msg.Content)
+	}
+	for i, c := range []struct {
+		output string
+		offset string
+		missed string
+		notice string
+	}{
+		{"hello", "2", "hello", "Reattached to the session in c1."},
+		// The output dropped from the buffer is told.
+		{"0123456789", "7", "23456789", "2 bytes of output were lost."},
+		// Without offset, nothing is replayed.
+		{"again", "", "", "Reattached to the session in c1."},
+		// An offset past the output is told.
+		{"more", "100", "", "no output is replayed as the resume offset is past the output."},
+	} {
+		id := detach(ws, output, c.output)
+		ws = dial(id, c.offset)
+		msg := read(ws)
+		if c.missed != "" {
+			if msg.MsgType != message.ResponseMessage_STDOUT || string(msg.Content) != c.missed {
+				t.Fatalf("Case %d failed: replay is %v %q", i+1, msg.MsgType, msg.Content)
+			}
+			msg = read(ws)
+		}
+		if msg.MsgType != message.ResponseMessage_NOTICE || !strings.Contains(string(msg.Content), c.notice) {
+			t.Fatalf("Case %d failed: notice is %v %q", i+1, msg.MsgType, msg.Content)
+		}
+	}
+	ws.Close()
+	server.sessions.Wait()
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/laincloud/entry/log"
+	"github.com/laincloud/entry/message"
+)
+
+const (
+	// maxRequestSize bounds the frames read from clients, a larger one closes the connection.
+	// It's far above what a paste needs, as input is written in chunks anyway.
+	maxRequestSize = 1 << 20
+	// maxWinchSize bounds the content of WINCH messages, "<cols> <rows> <xpixel> <ypixel>".
+	maxWinchSize = 64
+	// maxControlSize bounds the JSON of CONTROL messages.
+	maxControlSize = 64 * 1024
+	// maxPongSize bounds the content of PONG messages, the echo of "ping <seq> <ms>".
+	maxPongSize = 64
+	// maxCapsSize bounds the features listed by CAPS messages.
+	maxCapsSize = 1024
+	// maxInstanceNoSize bounds the instance number of SWITCH and OPEN_TAB messages.
+	maxInstanceNoSize = 16
+	// maxTermDimension is the largest terminal size in cells or pixels, ttys keep them in 16 bits.
+	maxTermDimension = 65535
+	// defaultMaxHeaderSize bounds the values of sessionHeaders.
+	defaultMaxHeaderSize = 4096
+)
+
+// sessionHeaders are the request headers read by sessions.
+var sessionHeaders = []string{"access-token", "app-name", "proc-name", "instance-no", "container",
+	"container-token", "session-key", "exec-spec", "output-encoding", "term-type", "reattach",
+	"resume-offset"}
+
+var (
+	errInvalidRequest = errors.New("invalid request message")
+	errInvalidHeader  = errors.New("invalid request header")
+)
+
+// checkHeaders rejects the sessionHeaders longer than maxSize bytes, defaultMaxHeaderSize
+// if not positive, or with other characters than printable ASCII.
+func checkHeaders(header http.Header, maxSize int) error {
+	if maxSize <= 0 {
+		maxSize = defaultMaxHeaderSize
+	}
+	for _, name := range sessionHeaders {
+		for _, value := range header[http.CanonicalHeaderKey(name)] {
+			if len(value) > maxSize {
+				return fmt.Errorf("%s: %s of %d bytes", errInvalidHeader, name, len(value))
+			}
+			for i := 0; i < len(value); i++ {
+				if value[i] < 0x20 || value[i] > 0x7e {
+					return fmt.Errorf("%s: %s has byte 0x%02x", errInvalidHeader, name, value[i])
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// checkRequest rejects the request messages which no well-behaved client sends.
+func checkRequest(inMsg *message.RequestMessage) error {
+	switch inMsg.MsgType {
+	case message.RequestMessage_PLAIN, message.RequestMessage_QUIT:
+		return nil
+	case message.RequestMessage_WINCH:
+		if _, ok := getTermSize(inMsg.Content); !ok {
+			return fmt.Errorf("%s: bad WINCH %q", errInvalidRequest, truncate(inMsg.Content, maxWinchSize))
+		}
+	case message.RequestMessage_SWITCH:
+		if len(inMsg.Content) == 0 || len(inMsg.Content) > maxInstanceNoSize {
+			return fmt.Errorf("%s: bad SWITCH of %d bytes", errInvalidRequest, len(inMsg.Content))
+		}
+	case message.RequestMessage_CONTROL:
+		if len(inMsg.Content) > maxControlSize {
+			return fmt.Errorf("%s: CONTROL of %d bytes", errInvalidRequest, len(inMsg.Content))
+		}
+	case message.RequestMessage_PONG:
+		if len(inMsg.Content) > maxPongSize {
+			return fmt.Errorf("%s: PONG of %d bytes", errInvalidRequest, len(inMsg.Content))
+		}
+	case message.RequestMessage_CAPS:
+		if len(inMsg.Content) > maxCapsSize {
+			return fmt.Errorf("%s: CAPS of %d bytes", errInvalidRequest, len(inMsg.Content))
+		}
+	case message.RequestMessage_OPEN_TAB:
+		if len(inMsg.Content) > maxInstanceNoSize {
+			return fmt.Errorf("%s: bad OPEN_TAB of %d bytes", errInvalidRequest, len(inMsg.Content))
+		}
+	case message.RequestMessage_CLOSE_TAB:
+		return nil
+	default:
+		return fmt.Errorf("%s: unknown type %d", errInvalidRequest, inMsg.MsgType)
+	}
+	return nil
+}
+
+// decodeRequests decodes the request messages of a frame, which is a batch of them if batch
+// is set. The messages which can't be decoded or are invalid are dropped.
+func decodeRequests(frame []byte, batch bool, msgUnmarshaller Unmarshaler) []*message.RequestMessage {
+	msgs := [][]byte{frame}
+	if batch {
+		var err error
+		if msgs, err = splitBatch(frame); err != nil {
+			log.Errorf("Split request batch error: %s", err.Error())
+			return nil
+		}
+	}
+	requests := make([]*message.RequestMessage, 0, len(msgs))
+	for _, msg := range msgs {
+		inMsg := &message.RequestMessage{}
+		if err := msgUnmarshaller(msg, inMsg); err != nil {
+			log.Errorf("Unmarshall request error: %s", err.Error())
+			continue
+		}
+		if err := checkRequest(inMsg); err != nil {
+			log.Warnf("Request dropped: %s", err.Error())
+			continue
+		}
+		requests = append(requests, inMsg)
+	}
+	return requests
+}
+
+// truncate returns at most n bytes of data, to be logged.
+func truncate(data []byte, n int) []byte {
+	if len(data) > n {
+		return data[:n]
+	}
+	return data
+}
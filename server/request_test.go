@@ -0,0 +1,145 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/laincloud/entry/message"
+)
+
+func TestCheckRequest(t *testing.T) {
+	cases := []struct {
+		msg message.RequestMessage
+		ok  bool
+	}{
+		{message.RequestMessage{MsgType: message.RequestMessage_PLAIN, Content: []byte("ls\r")}, true},
+		{message.RequestMessage{MsgType: message.RequestMessage_WINCH, Content: []byte("80 24")}, true},
+		{message.RequestMessage{MsgType: message.RequestMessage_WINCH, Content: []byte("80")}, false},
+		{message.RequestMessage{MsgType: message.RequestMessage_WINCH, Content: []byte("80 100000")}, false},
+		{message.RequestMessage{MsgType: message.RequestMessage_SWITCH, Content: []byte("2")}, true},
+		{message.RequestMessage{MsgType: message.RequestMessage_SWITCH}, false},
+		{message.RequestMessage{MsgType: message.RequestMessage_CONTROL, Content: make([]byte, maxControlSize+1)}, false},
+		{message.RequestMessage{MsgType: message.RequestMessage_QUIT}, true},
+		{message.RequestMessage{MsgType: message.RequestMessage_PONG, Content: []byte("ping 1 1000")}, true},
+		{message.RequestMessage{MsgType: message.RequestMessage_PONG, Content: make([]byte, maxPongSize+1)}, false},
+		{message.RequestMessage{MsgType: message.RequestMessage_CAPS, Content: []byte("notice,rtt")}, true},
+		{message.RequestMessage{MsgType: message.RequestMessage_CAPS, Content: make([]byte, maxCapsSize+1)}, false},
+		{message.RequestMessage{MsgType: message.RequestMessage_OPEN_TAB, Tab: 1, Content: []byte("2")}, true},
+		{message.RequestMessage{MsgType: message.RequestMessage_OPEN_TAB, Tab: 1, Content: make([]byte, maxInstanceNoSize+1)}, false},
+		{message.RequestMessage{MsgType: message.RequestMessage_CLOSE_TAB, Tab: 1}, true},
+		{message.RequestMessage{MsgType: 42}, false},
+	}
+	for i, c := range cases {
+		if err := checkRequest(&c.msg); (err == nil) != c.ok {
+			t.Errorf("Case %d failed: %v", i+1, err)
+		}
+	}
+}
+
+func FuzzDecodeRequests(f *testing.F) {
+	for _, msg := range []*message.RequestMessage{
+		{MsgType: message.RequestMessage_PLAIN, Content: []byte("ls\r")},
+		{MsgType: message.RequestMessage_WINCH, Content: []byte("80 24 640 480")},
+		{MsgType: message.RequestMessage_SWITCH, Content: []byte("2")},
+		{MsgType: message.RequestMessage_CONTROL, Content: []byte(`{"op": "info"}`)},
+	} {
+		data, _ := protoMarshalFunc(msg)
+		f.Add(data, false)
+		f.Add(appendBatch(appendBatch(nil, data), data), true)
+		data, _ = json.Marshal(msg)
+		f.Add(data, false)
+	}
+	f.Add([]byte(`{"msgType": -1, "content": "AAAA"}`), false)
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff, 0x0f}, true)
+
+	fake := &fakeDocker{
+		resizeExecTTY: func(id string, height, width int) error {
+			if height < 0 || width < 0 || height > maxTermDimension || width > maxTermDimension {
+				panic("invalid tty size")
+			}
+			return nil
+		},
+	}
+	server := &EntryServer{dockerClient: fake}
+	f.Fuzz(func(t *testing.T, frame []byte, batch bool) {
+		input := newInputWriter(ioutil.Discard)
+		defer input.close()
+		resizer := server.newResizer("exec")
+		for _, unmarshal := range []Unmarshaler{protoUnmarshalFunc, json.Unmarshal} {
+			for _, inMsg := range decodeRequests(frame, batch, unmarshal) {
+				if err := checkRequest(inMsg); err != nil {
+					t.Fatalf("Invalid request decoded: %s", err.Error())
+				}
+				if err := server.handleRequestMessage(inMsg, input, resizer); err != nil {
+					t.Fatalf("Request failed: %s", err.Error())
+				}
+			}
+		}
+	})
+}
+
+func FuzzGetTermSize(f *testing.F) {
+	for _, content := range []string{"80 24", "80 24 640 480", "-1 24", "99999999999999999999 1", "+80 24"} {
+		f.Add([]byte(content))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		size, ok := getTermSize(data)
+		if !ok {
+			return
+		}
+		for _, n := range []int{size.Width, size.Height, size.XPixel, size.YPixel} {
+			if n < 0 || n > maxTermDimension {
+				t.Fatalf("Size out of range: %+v", size)
+			}
+		}
+	})
+}
+
+func TestCheckHeaders(t *testing.T) {
+	cases := []struct {
+		name  string
+		value string
+		ok    bool
+	}{
+		{"app-name", "hello", true},
+		{"exec-spec", `{"cmd": ["python3"]}`, true},
+		{"term-type", strings.Repeat("x", 100), false},
+		{"access-token", strings.Repeat("x", 64), true},
+		{"app-name", "hello\x00", false},
+		{"proc-name", "web\x1b[2J", false},
+		{"container", "容器", false},
+		{"container-token", strings.Repeat("x", 100), false},
+		{"reattach", strings.Repeat("x", 100), false},
+		{"resume-offset", strings.Repeat("1", 100), false},
+		// Other headers are not read by sessions.
+		{"user-agent", strings.Repeat("x", 100), true},
+	}
+	for i, c := range cases {
+		header := http.Header{}
+		header.Set(c.name, c.value)
+		if err := checkHeaders(header, 64); (err == nil) != c.ok {
+			t.Errorf("Case %d failed: err is %v", i+1, err)
+		}
+	}
+}
+
+func TestEnterInvalidHeaders(t *testing.T) {
+	server := &EntryServer{authorizer: &FakeAuthorizer{Allow: true}, resolver: StaticResolver{"hello/web/1": "c1"}}
+	ts := httptest.NewServer(http.HandlerFunc(server.enter))
+	defer ts.Close()
+
+	for i, value := range []string{strings.Repeat("x", defaultMaxHeaderSize+1), "xterm\x7f"} {
+		header := http.Header{}
+		header.Set("app-name", "hello")
+		header.Set("term-type", value)
+		_, resp, err := websocket.DefaultDialer.Dial(strings.Replace(ts.URL, "http", "ws", 1), header)
+		if err == nil || resp == nil || resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Case %d failed: err is %v", i+1, err)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/laincloud/entry/log"
+)
+
+const (
+	// defaultResizeWindow is how long WINCH messages are coalesced before the tty is resized.
+	defaultResizeWindow = 100 * time.Millisecond
+	// defaultMaxCols and defaultMaxRows bound the tty sizes, far beyond any real screen.
+	defaultMaxCols = 1000
+	defaultMaxRows = 500
+)
+
+// resizer coalesces the resizes of an exec tty, so that a flood of WINCH messages
+// costs the docker daemon at most one resize per window, to the latest size. It may
+// resize the tty of a container instead, see newContainerResizer.
+type resizer struct {
+	sync.Mutex
+	server  *EntryServer
+	execID  string
+	window  time.Duration
+	maxCols int
+	maxRows int
+	pending termSize
+	timer   *time.Timer
+	stopped bool
+	// containerID is the container whose tty is resized instead, see newContainerResizer.
+	containerID string
+}
+
+func (server *EntryServer) newResizer(execID string) *resizer {
+	return &resizer{server: server, execID: execID, window: server.resizeWindow, maxCols: server.maxCols, maxRows: server.maxRows}
+}
+
+// newContainerResizer creates a resizer of the tty of containerID, for attached clients.
+func (server *EntryServer) newContainerResizer(containerID string) *resizer {
+	return &resizer{server: server, containerID: containerID, window: server.resizeWindow, maxCols: server.maxCols, maxRows: server.maxRows}
+}
+
+// retarget resizes the tty of containerID from now on, like when a followed container
+// is replaced by its restart.
+func (r *resizer) retarget(containerID string) {
+	r.Lock()
+	defer r.Unlock()
+	r.containerID = containerID
+}
+
+// clamp bounds the cols and rows of size to maxCols and maxRows, zero leaves them unbounded.
+func (size termSize) clamp(maxCols, maxRows int) termSize {
+	if maxCols > 0 && size.Width > maxCols {
+		size.Width = maxCols
+	}
+	if maxRows > 0 && size.Height > maxRows {
+		size.Height = maxRows
+	}
+	return size
+}
+
+// resize resizes the tty to size at the end of the current window, or immediately
+// without a window. Sizes beyond the maximum are clamped, and empty ones are ignored
+// as no program can draw on them.
+func (r *resizer) resize(size termSize) error {
+	if size.Width <= 0 || size.Height <= 0 {
+		log.Debugf("Empty size %dx%d of %s is ignored", size.Width, size.Height, r.target())
+		return nil
+	}
+	size = size.clamp(r.maxCols, r.maxRows)
+	if r.window <= 0 {
+		return r.apply(size)
+	}
+	r.Lock()
+	defer r.Unlock()
+	r.pending = size
+	if r.timer == nil && !r.stopped {
+		r.timer = time.AfterFunc(r.window, r.flush)
+	}
+	return nil
+}
+
+func (r *resizer) flush() {
+	r.Lock()
+	size := r.pending
+	r.timer = nil
+	stopped := r.stopped
+	r.Unlock()
+	if stopped {
+		return
+	}
+	if err := r.apply(size); err != nil {
+		log.Errorf("Resize tty of %s failed: %s", r.target(), err.Error())
+	}
+}
+
+func (r *resizer) target() string {
+	r.Lock()
+	defer r.Unlock()
+	if r.containerID != "" {
+		return "container " + r.containerID
+	}
+	return "exec " + r.execID
+}
+
+func (r *resizer) apply(size termSize) error {
+	r.Lock()
+	containerID := r.containerID
+	r.Unlock()
+	if containerID != "" {
+		log.Debugf("Resize container %s to %dx%d", containerID, size.Width, size.Height)
+		return r.server.dockerClient.ResizeContainerTTY(containerID, size.Height, size.Width)
+	}
+	log.Debugf("Resize exec %s to %dx%d", r.execID, size.Width, size.Height)
+	return r.server.dockerClient.ResizeExecTTY(r.execID, size.Height, size.Width)
+}
+
+// stop drops the pending resize.
+func (r *resizer) stop() {
+	r.Lock()
+	defer r.Unlock()
+	r.stopped = true
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestResizerCoalesce(t *testing.T) {
+	var (
+		lock    sync.Mutex
+		resizes [][2]int
+	)
+	fake := &fakeDocker{
+		resizeExecTTY: func(id string, height, width int) error {
+			lock.Lock()
+			defer lock.Unlock()
+			resizes = append(resizes, [2]int{width, height})
+			return nil
+		},
+	}
+	server := &EntryServer{dockerClient: fake, resizeWindow: 50 * time.Millisecond}
+	r := server.newResizer("exec")
+	for i := 0; i < 100; i++ {
+		r.resize(termSize{Width: 80 + i, Height: 24})
+	}
+	time.Sleep(200 * time.Millisecond)
+	lock.Lock()
+	if len(resizes) != 1 || resizes[0] != [2]int{179, 24} {
+		t.Errorf("Case 1 failed: resizes are %v", resizes)
+	}
+	lock.Unlock()
+
+	r.resize(termSize{Width: 100, Height: 30})
+	r.stop()
+	time.Sleep(100 * time.Millisecond)
+	lock.Lock()
+	if len(resizes) != 1 {
+		t.Errorf("Case 2 failed: resizes are %v", resizes)
+	}
+	lock.Unlock()
+
+	server.resizeWindow = 0
+	server.newResizer("exec").resize(termSize{Width: 120, Height: 40})
+	lock.Lock()
+	if len(resizes) != 2 || resizes[1] != [2]int{120, 40} {
+		t.Errorf("Case 3 failed: resizes are %v", resizes)
+	}
+	lock.Unlock()
+}
+
+func TestResizerClamp(t *testing.T) {
+	var resizes [][2]int
+	fake := &fakeDocker{
+		resizeExecTTY: func(id string, height, width int) error {
+			resizes = append(resizes, [2]int{width, height})
+			return nil
+		},
+	}
+	server := &EntryServer{dockerClient: fake, maxCols: 1000, maxRows: 500}
+	r := server.newResizer("exec")
+	cases := []struct {
+		size     termSize
+		expected [][2]int
+	}{
+		{termSize{Width: 80, Height: 24}, [][2]int{{80, 24}}},
+		{termSize{Width: 65535, Height: 24}, [][2]int{{1000, 24}}},
+		{termSize{Width: 80, Height: 65535, XPixel: 640, YPixel: 480}, [][2]int{{80, 500}}},
+		{termSize{Width: 1000, Height: 500}, [][2]int{{1000, 500}}},
+		{termSize{Width: 0, Height: 24}, nil},
+		{termSize{Width: 80, Height: 0}, nil},
+	}
+	for i, c := range cases {
+		resizes = nil
+		if err := r.resize(c.size); err != nil || !reflect.DeepEqual(resizes, c.expected) {
+			t.Errorf("Case %d failed: resizes are %v, %v", i+1, resizes, err)
+		}
+	}
+}
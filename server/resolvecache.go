@@ -0,0 +1,102 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// maxResolveCacheTTL bounds the TTL of resolutions, the cache is no registry of containers.
+	maxResolveCacheTTL = 5 * time.Minute
+	// resolveCacheSize bounds the entries kept, the expired ones are dropped beyond it.
+	resolveCacheSize = 10000
+)
+
+type resolveEntry struct {
+	containerID string
+	expires     time.Time
+}
+
+// resolveCache caches the containers resolved by instance for ttl, so that reconnects and
+// instance switches don't ask the scheduler each. A cached container is checked before use,
+// see EntryServer.resolve, as it may have been rescheduled to a new one since. Failures are
+// never cached. A nil *resolveCache caches nothing.
+type resolveCache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	lock    sync.Mutex
+	entries map[string]resolveEntry
+}
+
+func newResolveCache(ttl time.Duration) *resolveCache {
+	return &resolveCache{ttl: ttl, now: time.Now, entries: make(map[string]resolveEntry)}
+}
+
+func resolveKey(appName, procName, instanceNo string) string {
+	return appName + "/" + procName + "/" + instanceNo
+}
+
+// get returns the container cached by key, if it's not expired.
+func (c *resolveCache) get(key string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || !c.now().Before(entry.expires) {
+		return "", false
+	}
+	return entry.containerID, true
+}
+
+func (c *resolveCache) put(key, containerID string) {
+	if c == nil {
+		return
+	}
+	now := c.now()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if len(c.entries) >= resolveCacheSize {
+		for k, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= resolveCacheSize {
+			c.entries = make(map[string]resolveEntry)
+		}
+	}
+	c.entries[key] = resolveEntry{containerID: containerID, expires: now.Add(c.ttl)}
+}
+
+// drop forgets the container cached by key, once it's found stale.
+func (c *resolveCache) drop(key string) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.entries, key)
+}
+
+// stillResolves reports whether the cached containerID is still the running container of
+// instance instanceNo, by its state and the instance in its labels if any.
+func (server *EntryServer) stillResolves(containerID, instanceNo string) bool {
+	container, err := server.dockerClient.InspectContainer(containerID)
+	if err != nil || checkContainerState(container.State) != nil {
+		return false
+	}
+	if container.Config == nil {
+		return true
+	}
+	labels := container.Config.Labels
+	if no, ok := labels[lainLabelPrefix+"instance_no"]; ok && no != instanceNo {
+		return false
+	}
+	if name, ok := labels[k8sContainerLabel]; ok && instanceNo != "" && name != instanceNo {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestResolveCache(t *testing.T) {
+	now := time.Now()
+	c := newResolveCache(time.Minute)
+	c.now = func() time.Time { return now }
+	c.put("hello/web/1", "c1")
+	cases := []struct {
+		at       time.Duration
+		key      string
+		expected string
+	}{
+		{0, "hello/web/1", "c1"},
+		{59 * time.Second, "hello/web/1", "c1"},
+		{0, "hello/web/2", ""},
+		{time.Minute, "hello/web/1", ""},
+	}
+	start := now
+	for i, cs := range cases {
+		now = start.Add(cs.at)
+		if actual, _ := c.get(cs.key); actual != cs.expected {
+			t.Errorf("Case %d failed: actual is %q", i+1, actual)
+		}
+	}
+	c.drop("hello/web/1")
+	if _, ok := c.get("hello/web/1"); ok {
+		t.Error("Dropped entry is cached")
+	}
+
+	var none *resolveCache
+	none.put("hello/web/1", "c1")
+	if _, ok := none.get("hello/web/1"); ok {
+		t.Error("Nil cache must cache nothing")
+	}
+}
+
+// countingResolver resolves by a table which changes as instances are rescheduled.
+type countingResolver struct {
+	containers StaticResolver
+	resolves   int
+}
+
+func (r *countingResolver) Resolve(appName, procName, instanceNo string) (string, error) {
+	r.resolves++
+	return r.containers.Resolve(appName, procName, instanceNo)
+}
+
+func TestResolveStale(t *testing.T) {
+	containers := map[string]*docker.Container{
+		"c1": {ID: "c1", State: docker.State{Running: true}, Config: &docker.Config{Labels: map[string]string{lainLabelPrefix + "instance_no": "1"}}},
+		"c2": {ID: "c2", State: docker.State{Running: true}, Config: &docker.Config{Labels: map[string]string{lainLabelPrefix + "instance_no": "1"}}},
+		"c3": {ID: "c3", State: docker.State{Running: true}},
+	}
+	fake := &fakeDocker{
+		inspectContainer: func(id string) (*docker.Container, error) {
+			if container, ok := containers[id]; ok {
+				return container, nil
+			}
+			return nil, &docker.NoSuchContainer{ID: id}
+		},
+	}
+	resolver := &countingResolver{containers: StaticResolver{"hello/web/1": "c1"}}
+	server := &EntryServer{dockerClient: fake, resolver: resolver, resolutions: newResolveCache(time.Minute)}
+
+	cases := []struct {
+		// reschedule moves instance 1 to the container, if not empty.
+		reschedule string
+		// update changes what is known of the container cached, if not nil.
+		update   func()
+		expected string
+		resolves int
+	}{
+		{"", nil, "c1", 1},
+		{"", nil, "c1", 1},
+		// The container rescheduled is still the one cached until it stops.
+		{"c2", nil, "c1", 1},
+		{"", func() { containers["c1"].State.Running = false }, "c2", 2},
+		{"c3", func() { delete(containers, "c2") }, "c3", 3},
+		// A container reused by another instance is no match either.
+		{"c1", func() {
+			containers["c1"].State.Running = true
+			containers["c3"].Config = &docker.Config{Labels: map[string]string{lainLabelPrefix + "instance_no": "2"}}
+		}, "c1", 4},
+	}
+	for i, c := range cases {
+		if c.reschedule != "" {
+			resolver.containers["hello/web/1"] = c.reschedule
+		}
+		if c.update != nil {
+			c.update()
+		}
+		containerID, err := server.resolve("hello", "web", "1")
+		if err != nil || containerID != c.expected || resolver.resolves != c.resolves {
+			t.Errorf("Case %d failed: resolved %q by %d resolves, %v", i+1, containerID, resolver.resolves, err)
+		}
+	}
+}
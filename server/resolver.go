@@ -0,0 +1,125 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	lainlet "github.com/laincloud/lainlet/client"
+)
+
+// Resolver finds the container which runs the given instance of an application's proc.
+// Implementations make EntryServer independent of the scheduler in use.
+type Resolver interface {
+	Resolve(appName, procName, instanceNo string) (containerID string, err error)
+}
+
+// NodeResolver is a Resolver which also finds the node running the container, so that
+// the container is reached through the docker endpoint of its node.
+type NodeResolver interface {
+	Resolver
+	ResolveNode(appName, procName, instanceNo string) (containerID, node string, err error)
+}
+
+type CoreInfo map[string]AppInfo
+
+type Container struct {
+	ContainerID string `json:"ContainerId"`
+	NodeName    string `json:"NodeName"`
+}
+
+type AppInfo struct {
+	PodInfos []PodInfo `json:"PodInfos"`
+}
+
+type PodInfo struct {
+	InstanceNo int         `json:"InstanceNo"`
+	Containers []Container `json:"ContainerInfos"`
+}
+
+var errContainerNotfound = errors.New("get data successfully but not found the container")
+
+// LainResolver resolves containers from the core info published by lainlet.
+type LainResolver struct {
+	lainletClient *lainlet.Client
+}
+
+func (r *LainResolver) Resolve(appName, procName, instanceNo string) (string, error) {
+	containerID, _, err := r.ResolveNode(appName, procName, instanceNo)
+	return containerID, err
+}
+
+func (r *LainResolver) ResolveNode(appName, procName, instanceNo string) (string, string, error) {
+	var (
+		data []byte
+		err  error
+	)
+	if data, err = r.lainletClient.Get("v2/coreinfowatcher?appname="+appName, 2*time.Second); err != nil {
+		return "", "", err
+	}
+	coreInfo := make(CoreInfo)
+	if err := json.Unmarshal(data, &coreInfo); err != nil {
+		return "", "", err
+	}
+	container, err := coreInfo.container(appName, procName, instanceNo)
+	return container.ContainerID, container.NodeName, err
+}
+
+func (coreInfo CoreInfo) containerID(appName, procName, instanceNo string) (string, error) {
+	container, err := coreInfo.container(appName, procName, instanceNo)
+	return container.ContainerID, err
+}
+
+func (coreInfo CoreInfo) container(appName, procName, instanceNo string) (Container, error) {
+	for procFullName, procInfo := range coreInfo {
+		curAppName, curProcName := getAppProcName(strings.Split(procFullName, "."))
+		if curProcName == procName && curAppName == appName {
+			for _, containerInfo := range procInfo.PodInfos {
+				if strconv.Itoa(containerInfo.InstanceNo) == instanceNo &&
+					len(containerInfo.Containers) > 0 &&
+					containerInfo.Containers[0].ContainerID != "" {
+					return containerInfo.Containers[0], nil
+				}
+			}
+		}
+	}
+	return Container{}, errContainerNotfound
+}
+
+// StaticResolver resolves containers from a fixed table keyed by "app/proc/instance".
+// It is meant for tests and small deployments without a scheduler to ask.
+type StaticResolver map[string]string
+
+func (r StaticResolver) Resolve(appName, procName, instanceNo string) (string, error) {
+	if containerID, exist := r[appName+"/"+procName+"/"+instanceNo]; exist && containerID != "" {
+		return containerID, nil
+	}
+	return "", errContainerNotfound
+}
+
+// LoadStaticResolver reads a StaticResolver from a JSON object file.
+func LoadStaticResolver(path string) (StaticResolver, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r := make(StaticResolver)
+	if err = json.Unmarshal(data, &r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// getAppProcName splits the dot separated parts of a proc full name like "hello.web.web",
+// which lain composes of the app name, the proc type and the proc name, into the app name
+// and the proc name. The app name may contain dots itself, as resource instances like
+// "resource.redis.hello" do. Keys with less than three parts return zero values.
+func getAppProcName(key []string) (string, string) {
+	if len(key) < 3 {
+		return "", ""
+	}
+	return strings.Join(key[:len(key)-2], "."), key[len(key)-1]
+}
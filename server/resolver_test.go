@@ -0,0 +1,65 @@
+package server
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestStaticResolver(t *testing.T) {
+	r := StaticResolver{"hello/web/1": "abc123"}
+	if actual, err := r.Resolve("hello", "web", "1"); err != nil || actual != "abc123" {
+		t.Errorf("Case 1 failed: actual is %q, %v", actual, err)
+	}
+	if _, err := r.Resolve("hello", "web", "2"); err != errContainerNotfound {
+		t.Errorf("Case 2 failed: err is %v", err)
+	}
+}
+
+func TestCoreInfoContainerID(t *testing.T) {
+	data := []byte(`{"hello.web.web": {"PodInfos": [
+		{"InstanceNo": 1, "ContainerInfos": [{"ContainerId": "c1", "NodeName": "node1"}]},
+		{"InstanceNo": 2, "ContainerInfos": []}
+	]}}`)
+	coreInfo := make(CoreInfo)
+	if err := json.Unmarshal(data, &coreInfo); err != nil {
+		t.Fatal(err)
+	}
+	if actual, err := coreInfo.containerID("hello", "web", "1"); err != nil || actual != "c1" {
+		t.Errorf("Case 1 failed: actual is %q, %v", actual, err)
+	}
+	if _, err := coreInfo.containerID("hello", "web", "2"); err != errContainerNotfound {
+		t.Errorf("Case 2 failed: err is %v", err)
+	}
+	if _, err := coreInfo.containerID("hello", "worker", "1"); err != errContainerNotfound {
+		t.Errorf("Case 3 failed: err is %v", err)
+	}
+	if actual, err := coreInfo.container("hello", "web", "1"); err != nil || actual.NodeName != "node1" {
+		t.Errorf("Case 4 failed: actual is %+v, %v", actual, err)
+	}
+}
+
+func TestGetAppProcName(t *testing.T) {
+	cases := []struct {
+		key      string
+		appName  string
+		procName string
+	}{
+		{"hello.web.web", "hello", "web"},
+		{"hello.worker.cron", "hello", "cron"},
+		{"resource.redis.hello.worker.redis", "resource.redis.hello", "redis"},
+		{"hello.web", "", ""},
+		{"hello", "", ""},
+		{"", "", ""},
+		{"..", "", ""},
+	}
+	for i, c := range cases {
+		appName, procName := getAppProcName(strings.Split(c.key, "."))
+		if appName != c.appName || procName != c.procName {
+			t.Errorf("Case %d failed: actual is %q, %q", i+1, appName, procName)
+		}
+	}
+	if appName, procName := getAppProcName(nil); appName != "" || procName != "" {
+		t.Errorf("Nil key failed: actual is %q, %q", appName, procName)
+	}
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+// roleFeatures are the features of the sessions and endpoints a role may be given:
+//
+//	details  sensitive details, like the mounts of containers and who is in them.
+//	debug    debug sessions in a sidecar, see debugPolicy.
+//	infra    sessions in the infra container of pods, see enterInfra.
+//	resize   resizing the tty of attached containers.
+//	force    forcing sessions into exclusive containers, see exclusivePolicy.
+//	state    the state of the server at /debug/state, see serveState.
+var roleFeatures = []string{"details", "debug", "infra", "resize", "force", "state"}
+
+// RolePolicy is what a role may do beyond entering, in the JSON object of the role policy
+// file keyed by role, e.g.
+//
+//	{
+//	  "admin": {"exec_fields": ["cmd", "user"], "features": ["details", "debug", "infra", "resize"]},
+//	  "developer": {"exec_fields": ["cmd", "env"], "commands": ["python3", "/usr/bin/*"]}
+//	}
+//
+// The roles not in the file may only enter with the default shell.
+type RolePolicy struct {
+	// ExecFields are the fields of exec specs the role may give, see ExecSpec.
+	ExecFields []string `json:"exec_fields,omitempty"`
+	// Commands are the patterns of the commands the role may run by the cmd field of exec
+	// specs, matching their first word, any if empty.
+	Commands []string `json:"commands,omitempty"`
+	// Features are those of roleFeatures the role has.
+	Features []string `json:"features,omitempty"`
+}
+
+// rolePolicies are the policies of the roles by name. Without policies, every role may give
+// the exec fields of execSpecPolicy, and admins have every feature.
+type rolePolicies map[string]RolePolicy
+
+// LoadRolePolicies reads the role policies from a JSON object file, and validates them.
+func LoadRolePolicies(file string) (rolePolicies, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	policies := make(rolePolicies)
+	if err = json.Unmarshal(data, &policies); err != nil {
+		return nil, err
+	}
+	for role, policy := range policies {
+		if strings.TrimSpace(role) == "" {
+			return nil, fmt.Errorf("role policy needs a role")
+		}
+		for _, field := range policy.ExecFields {
+			if !isExecSpecField(field) {
+				return nil, fmt.Errorf("unknown exec field %q of role %s, expected one of %s", field, role, strings.Join(execSpecFields, ", "))
+			}
+		}
+		if len(policy.Commands) > 0 && !policy.allowsField("cmd") {
+			return nil, fmt.Errorf("role %s has commands but no cmd exec field", role)
+		}
+		for _, command := range policy.Commands {
+			if _, err = path.Match(command, ""); err != nil || command == "" {
+				return nil, fmt.Errorf("invalid command pattern %q of role %s", command, role)
+			}
+		}
+		for _, feature := range policy.Features {
+			if !isRoleFeature(feature) {
+				return nil, fmt.Errorf("unknown feature %q of role %s, expected one of %s", feature, role, strings.Join(roleFeatures, ", "))
+			}
+		}
+	}
+	return policies, nil
+}
+
+func isRoleFeature(feature string) bool {
+	for _, name := range roleFeatures {
+		if feature == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (policy RolePolicy) allowsField(field string) bool {
+	for _, allowed := range policy.ExecFields {
+		if allowed == field {
+			return true
+		}
+	}
+	return false
+}
+
+// allows reports whether role has feature.
+func (p rolePolicies) allows(role, feature string) bool {
+	if p == nil {
+		return isAdminRole(role)
+	}
+	for _, allowed := range p[role].Features {
+		if allowed == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// execCommandError tells the command of an exec spec not allowed for a role.
+type execCommandError struct {
+	command string
+	role    string
+}
+
+func (e *execCommandError) Error() string {
+	return fmt.Sprintf("command %s is not allowed for role %q", e.command, e.role)
+}
+
+// checkExec returns an *execFieldError if spec has a field not allowed for role, or an
+// *execCommandError if its command is not allowed.
+func (p rolePolicies) checkExec(role string, spec *ExecSpec) error {
+	policy := p[role]
+	for _, field := range spec.fields() {
+		if !policy.allowsField(field) {
+			return &execFieldError{field: field, role: role}
+		}
+	}
+	if spec.Cmd != nil && len(policy.Commands) > 0 && !matchAny(policy.Commands, spec.Cmd[0]) {
+		return &execCommandError{command: spec.Cmd[0], role: role}
+	}
+	return nil
+}
+
+// checkExecSpec checks the exec spec of a client playing role, by the role policies if any,
+// then its variables by the env filter.
+func (server *EntryServer) checkExecSpec(role string, spec *ExecSpec) error {
+	var err error
+	if server.roles != nil {
+		err = server.roles.checkExec(role, spec)
+	} else {
+		err = server.execSpecPolicy.check(role, spec)
+	}
+	if err != nil {
+		return err
+	}
+	return server.envFilter.check(spec.Env)
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/laincloud/entry/message"
+)
+
+func TestLoadRolePolicies(t *testing.T) {
+	cases := []struct {
+		content string
+		ok      bool
+	}{
+		{`{"admin": {"exec_fields": ["cmd", "user"], "features": ["details", "debug"]}, "developer": {"exec_fields": ["cmd"], "commands": ["python3", "/usr/bin/*"]}}`, true},
+		{`{}`, true},
+		{`{"admin": {"exec_fields": ["shell"]}}`, false},
+		{`{"admin": {"features": ["root"]}}`, false},
+		{`{"developer": {"commands": ["python3"]}}`, false},
+		{`{"developer": {"exec_fields": ["cmd"], "commands": ["[python"]}}`, false},
+		{`{" ": {}}`, false},
+		{`["admin"]`, false},
+	}
+	for i, c := range cases {
+		file, err := ioutil.TempFile("", "roles")
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.WriteString(c.content)
+		file.Close()
+		if _, err = LoadRolePolicies(file.Name()); (err == nil) != c.ok {
+			t.Errorf("Case %d failed: %v", i+1, err)
+		}
+		os.Remove(file.Name())
+	}
+}
+
+func TestRolePolicies(t *testing.T) {
+	policies := rolePolicies{
+		"admin":     {ExecFields: []string{"cmd", "user"}, Features: []string{"details", "infra"}},
+		"developer": {ExecFields: []string{"cmd", "env"}, Commands: []string{"python3", "/usr/bin/*"}},
+	}
+	cases := []struct {
+		policies rolePolicies
+		role     string
+		feature  string
+		allowed  bool
+	}{
+		{policies, "admin", "details", true},
+		{policies, "admin", "debug", false},
+		{policies, "developer", "details", false},
+		{policies, "owner", "infra", false},
+		// Without policies, admins have every feature.
+		{nil, "owner", "infra", true},
+		{nil, "admin", "resize", true},
+		{nil, "developer", "details", false},
+	}
+	for i, c := range cases {
+		if actual := c.policies.allows(c.role, c.feature); actual != c.allowed {
+			t.Errorf("Case %d failed: actual is %t", i+1, actual)
+		}
+	}
+
+	for i, c := range []struct {
+		role  string
+		spec  ExecSpec
+		field string
+		cmd   string
+	}{
+		{"admin", ExecSpec{Cmd: []string{"bash"}, User: "root"}, "", ""},
+		{"admin", ExecSpec{Env: []string{"A=1"}}, "env", ""},
+		{"developer", ExecSpec{Cmd: []string{"python3", "-i"}, Env: []string{"A=1"}}, "", ""},
+		{"developer", ExecSpec{Cmd: []string{"/usr/bin/top"}}, "", ""},
+		{"developer", ExecSpec{Cmd: []string{"bash"}}, "", "bash"},
+		{"developer", ExecSpec{Cmd: []string{"/usr/bin/../../bin/sh"}}, "", "/usr/bin/../../bin/sh"},
+		{"developer", ExecSpec{User: "root"}, "user", ""},
+		{"guest", ExecSpec{Cmd: []string{"python3"}}, "cmd", ""},
+	} {
+		err := policies.checkExec(c.role, &c.spec)
+		fieldErr, _ := err.(*execFieldError)
+		cmdErr, _ := err.(*execCommandError)
+		if (c.field == "") != (fieldErr == nil) || (fieldErr != nil && fieldErr.field != c.field) ||
+			(c.cmd == "") != (cmdErr == nil) || (cmdErr != nil && cmdErr.command != c.cmd) {
+			t.Errorf("Exec case %d failed: %v", i+1, err)
+		}
+	}
+}
+
+func TestEnterRolePolicies(t *testing.T) {
+	server := &EntryServer{
+		dockerClient: &fakeDocker{},
+		authorizer:   &FakeAuthorizer{Tokens: map[string]string{"dev": "developer"}},
+		resolver:     StaticResolver{"hello/web/1": "c1"},
+		roles:        rolePolicies{"developer": {ExecFields: []string{"cmd"}, Commands: []string{"python3"}}},
+	}
+	ts := httptest.NewServer(http.HandlerFunc(server.enter))
+	defer ts.Close()
+
+	header := http.Header{}
+	header.Set("access-token", "dev")
+	header.Set("exec-spec", `{"cmd": ["bash"]}`)
+	ws := dialSession(t, ts, "", header)
+	defer ws.Close()
+	_, data, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := message.ResponseMessage{}
+	protoUnmarshalFunc(data, &msg)
+	if msg.MsgType != message.ResponseMessage_CLOSE || !strings.Contains(string(msg.Content), "Command bash is not allowed for your role") {
+		t.Errorf("Disallowed command: %v %q", msg.MsgType, msg.Content)
+	}
+}
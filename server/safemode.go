@@ -0,0 +1,26 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+)
+
+// errSafeMode refuses what safe mode disables. Safe mode, by EntryServer.safeMode, locks
+// entry down during incidents: only attaching and entering with the default shell are
+// left, what goes beyond them is refused:
+//
+//	debug sessions, with their sidecars and extra capabilities, see checkDebug.
+//	exec specs, which run other commands, as other users or privileged, see ExecSpec.
+//	sessions in the infra container of pods, see enterInfra.
+//	forcing sessions into exclusive containers, see exclusivePolicy.
+//	resizing the tty of attached containers.
+//	break-glass access, which bypasses the console, see breakGlass.
+//	exit commands, which run in containers after the sessions, see ExitRule.
+//
+// The signals of CONTROL requests are the keys any client can type, they are left.
+var errSafeMode = errors.New("disabled in safe mode")
+
+// safeModeMsg tells the client that what is refused in safe mode.
+func safeModeMsg(what string) string {
+	return fmt.Sprintf("%s disabled while entry is in safe mode.", what)
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gorilla/websocket"
+	"github.com/laincloud/entry/message"
+)
+
+func TestEnterSafeMode(t *testing.T) {
+	var (
+		lock  sync.Mutex
+		execs [][]string
+	)
+	fake := &fakeDocker{
+		createExec: func(opts docker.CreateExecOptions) (*docker.Exec, error) {
+			lock.Lock()
+			defer lock.Unlock()
+			execs = append(execs, opts.Cmd)
+			return &docker.Exec{ID: fmt.Sprintf("exec%d", len(execs))}, nil
+		},
+	}
+	sum := sha256.Sum256([]byte("emergency"))
+	bg, _ := newBreakGlass(hex.EncodeToString(sum[:]))
+	// Admins may do everything but what safe mode disables.
+	server := &EntryServer{dockerClient: fake, authorizer: &FakeAuthorizer{Tokens: map[string]string{"admin": "admin"}},
+		resolver: StaticResolver{"hello/web/1": "c1"}, debug: debugPolicy{image: "busybox"}, breakGlass: bg,
+		exclusive: exclusivePolicy{"hello"}, exitRules: []ExitRule{{Apps: []string{"hello"}, Command: "pkill strace"}}, safeMode: true}
+	ts := httptest.NewServer(http.HandlerFunc(server.enter))
+	defer ts.Close()
+
+	for i, c := range []struct {
+		token    string
+		query    string
+		execSpec string
+		refused  string
+	}{
+		{"admin", "?debug=true", "", safeModeMsg("Debug sessions are")},
+		{"admin", "", `{"cmd": ["bash"]}`, safeModeMsg("Exec specs are")},
+		{"admin", "?infra=true", "", safeModeMsg("Entering the infra container is")},
+		{"admin", "?force=true", "", safeModeMsg("Forcing a session is")},
+		{"emergency", "", "", safeModeMsg("Break-glass access is")},
+		// The default shell is left.
+		{"admin", "", "", ""},
+	} {
+		header := http.Header{}
+		header.Set("access-token", c.token)
+		header.Set("app-name", "hello")
+		header.Set("proc-name", "web")
+		header.Set("instance-no", "1")
+		if c.execSpec != "" {
+			header.Set("exec-spec", c.execSpec)
+		}
+		ws, _, err := websocket.DefaultDialer.Dial(strings.Replace(ts.URL, "http", "ws", 1)+c.query, header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var closed string
+		for {
+			_, data, err := ws.ReadMessage()
+			if err != nil {
+				break
+			}
+			msg := &message.ResponseMessage{}
+			protoUnmarshalFunc(data, msg)
+			if msg.MsgType == message.ResponseMessage_CLOSE {
+				closed = string(msg.Content)
+			}
+		}
+		ws.Close()
+		if refused := strings.Contains(closed, "safe mode"); refused != (c.refused != "") || !strings.Contains(closed, c.refused) {
+			t.Errorf("Case %d failed: closed by %q", i+1, closed)
+		}
+	}
+	server.sessions.Wait()
+
+	// Only the shell of the last session ran, without the exit command.
+	lock.Lock()
+	defer lock.Unlock()
+	if len(execs) != 1 {
+		t.Errorf("Execs are %q", execs)
+	}
+}
+
+func TestSafeModeChecks(t *testing.T) {
+	server := &EntryServer{debug: debugPolicy{image: "busybox"}, safeMode: true}
+	if err := server.checkDebug("admin"); err != errSafeMode {
+		t.Errorf("Debug check is %v", err)
+	}
+	container := &docker.Container{ID: "c1", HostConfig: &docker.HostConfig{NetworkMode: "container:infra"}}
+	if _, err := server.enterInfra("admin", container); err != errSafeMode {
+		t.Errorf("Infra check is %v", err)
+	}
+	// Nothing is refused by default.
+	server.safeMode = false
+	if err := server.checkDebug("admin"); err != nil {
+		t.Errorf("Debug check is %v without safe mode", err)
+	}
+}
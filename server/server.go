@@ -1,249 +1,918 @@
 package server
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 	"unicode/utf8"
 
 	"github.com/fsouza/go-dockerclient"
 	"github.com/golang/protobuf/proto"
 	"github.com/gorilla/websocket"
+	"github.com/laincloud/entry/log"
 	"github.com/laincloud/entry/message"
 	lainlet "github.com/laincloud/lainlet/client"
-	"github.com/mijia/sweb/log"
 )
 
 type EntryServer struct {
-	dockerClient  *docker.Client
+	dockerClient dockerAPI
+	// dockerPool is dockerClient when it reaches several nodes.
+	dockerPool    *dockerPool
 	lainletClient *lainlet.Client
-	httpClient    *http.Client
+	authorizer    Authorizer
+	resolver      Resolver
+	execPrefix    []string
+	execRetries   int
+	pingInterval  time.Duration
+	pingSequence  bool
+	pingRTT       bool
+	resizeWindow  time.Duration
+	maxCols       int
+	maxRows       int
+	writeTimeout  time.Duration
+	closeGrace    time.Duration
+	// attachSilence is how long an attach may show nothing before the client is told why, zero never tells.
+	attachSilence time.Duration
+	accounting    bool
+	// shellProbe is how long a probed shell may show nothing, zero never probes, see probeShell.
+	shellProbe time.Duration
+	// earlyOutputWait is how long the first output of enter sessions waits for the client,
+	// zero never waits, see holdOutput.
+	earlyOutputWait time.Duration
+	// outputLimit bounds the output bytes of each session, zero leaves it unbounded.
+	outputLimit int64
+	// enforceAppLabel checks entered containers belong to the authorized application.
+	enforceAppLabel bool
+	// outputEncoding is the encoding of the container output by default, see outputEncodings.
+	outputEncoding string
+	// maxHeaderSize bounds the session headers, see checkHeaders.
+	maxHeaderSize int
+	// breakGlass authorizes the emergency token, if enabled.
+	breakGlass breakGlass
+	// tracer exports the spans of sessions, nil if tracing is disabled.
+	tracer *tracer
+	// detachKeys is the sequence detaching the client from its session, none if empty.
+	detachKeys []byte
+	// detachTimeout is how long a detached session waits for its client.
+	detachTimeout time.Duration
+	// resumeBuffer is how much of the recent output of detachable sessions is replayed to
+	// their clients reattaching, see outputRing.
+	resumeBuffer int
+	// shell is the command of the sessions which don't ask another one, see shellCmd.
+	shell []string
+	// detectShell replaces shell by one probed in the images lacking it, see sessionShell.
+	detectShell bool
+	// readonlyNotice tells the clients entering containers with a read-only root filesystem.
+	readonlyNotice bool
+	// redact masks its matches in the output of sessions if not nil, see redactReader.
+	redact *redactor
+	// outputTransform rewrites the output of sessions after redaction if not nil.
+	outputTransform OutputTransform
+	execGuard       execGuard
+	execPool        *execPool
+	sessionKeys     sessionKeys
+	shellCache      shellCache
+	appFilter       appFilter
+	envFilter       envFilter
+	instancePolicy  instancePolicy
+	webhook         *webhookEmitter
+	cors            corsPolicy
+	certRules       []CertRule
+	exitRules       []ExitRule
+	debug           debugPolicy
+	execSpecPolicy  execSpecPolicy
+	roles           rolePolicies
+	metrics         sessionMetrics
+	registry        sessionRegistry
+	// sessions are the enter and attach sessions being served, waited on shutdown.
+	sessions sync.WaitGroup
+	// deniedAudits rate-limits the audits of denied access, nil audits every denial.
+	deniedAudits *deniedAudits
+	// localPod confines the sessions to the pod of a sidecar entry, nil if they aren't.
+	localPod *localPod
+	// resolutions caches the containers resolved by instance, nil if they aren't.
+	resolutions *resolveCache
+	// exclusive lists the applications whose containers take one enter session at a time.
+	exclusive exclusivePolicy
+	// containerTokens verifies the tokens granting the container of sessions, if enabled.
+	containerTokens containerTokens
+	// stateApp is the application whose roles with the state feature may read /debug/state,
+	// which is not served if empty.
+	stateApp string
+	// node identifies the host of this server in the sessions, nodeBanner tells it to their
+	// clients.
+	node       string
+	nodeBanner bool
+	// safeMode refuses the destructive features, see errSafeMode.
+	safeMode bool
 }
 
-type ConsoleAuthConf struct {
-	Type string `json:"type"`
-	URL  string `json:"url"`
-}
-
-type ConsoleRole struct {
-	Role string `json:"role"`
-}
-
-type ConsoleAuthResponse struct {
-	Message string      `json:"msg"`
-	URL     string      `json:"url"`
-	Role    ConsoleRole `json:"role"`
-}
-
-type CoreInfo map[string]AppInfo
 type ViaMethod int
 type Marshaler func(interface{}) ([]byte, error)
 type Unmarshaler func([]byte, interface{}) error
 
-type Container struct {
-	ContainerID string `json:"ContainerId"`
-}
-
-type AppInfo struct {
-	PodInfos []PodInfo `json:"PodInfos"`
-}
-
-type PodInfo struct {
-	InstanceNo int         `json:"InstanceNo"`
-	Containers []Container `json:"ContainerInfos"`
-}
-
 const (
 	readBufferSize         = 1024
 	writeBufferSize        = 10240 //The write buffer size should be large
 	aliveDecectionInterval = time.Second * 10
 	byebyeMsg              = "\033[32m>>> You quit the container safely.\033[0m"
+	shutdownMsg            = "\033[31m>>> Entry is shutting down, please try again later.\033[0m"
 	errMsgTemplate         = "\033[31m>>> %s\033[0m"
+	// daemonUnavailableMsg tells the sessions failed as the docker daemon can't be reached.
+	daemonUnavailableMsg = "The container host is temporarily unavailable, please try again later."
+	// shutdownTimeout is how long the sessions are waited to say goodbye on shutdown.
+	shutdownTimeout = 10 * time.Second
 )
 
+// defaultCloseGracePeriod is how long a client may stay after the goodbye of its session.
+const defaultCloseGracePeriod = 5 * time.Second
+
 var (
 	upgrader = websocket.Upgrader{
 		ReadBufferSize:  readBufferSize,
 		WriteBufferSize: writeBufferSize,
+		Subprotocols:    []string{batchSubprotocol},
 		CheckOrigin:     func(r *http.Request) bool { return true },
 	}
-	errAuthFailed        = errors.New("authorize failed")
-	errAuthNotSupported  = errors.New("entry only works on lain-sso authorization")
-	errContainerNotfound = errors.New("get data successfully but not found the container")
-	lainDomain           = os.Getenv("LAIN_DOMAIN")
 )
 
-//StartServer starts an EntryServer listening on port and connects to DockerSwarm with endpoint.
-func StartServer(port, endpoint string) {
-	var server *EntryServer
+// StartServer starts an EntryServer with config, which listens on config.Port and
+// connects to docker with config.DockerEndpoint, and config.DockerNodes if any.
+func StartServer(config Config) {
+	if err := config.Validate(); err != nil {
+		log.Fatalf("Invalid config: %s", err.Error())
+	}
+	level, _ := log.ParseLevel(config.LogLevel)
+	log.SetLevel(level)
+	var (
+		server *EntryServer
+		client dockerAPI
+		err    error
+	)
 	for {
-		if client, err := docker.NewClient(endpoint); err != nil {
-			log.Errorf("Initialize docker client error: %s", err.Error())
-			time.Sleep(time.Second * 10)
-		} else {
-			server = &EntryServer{
-				dockerClient:  client,
-				lainletClient: lainlet.New(net.JoinHostPort("lainlet.lain", os.Getenv("LAINLET_PORT"))),
-				httpClient: &http.Client{
-					Timeout: 4 * time.Second,
-				},
-			}
+		if client, err = newDockerAPI(config); err == nil {
+			server, err = newEntryServer(config, client)
 			break
 		}
+		log.Errorf("Initialize docker client error: %s", err.Error())
+		time.Sleep(time.Second * 10)
+	}
+	if err != nil {
+		log.Fatalf("Initialize entry server error: %s", err.Error())
+	}
+	if server.cors.enabled() {
+		upgrader.CheckOrigin = server.cors.checkOrigin
 	}
 
 	http.HandleFunc("/enter", server.enter)
 	http.HandleFunc("/attach", server.attach)
-	log.Fatal(http.ListenAndServe(net.JoinHostPort("", port), nil))
+	http.HandleFunc("/container/", server.cors.wrap(server.containerInfo))
+	http.HandleFunc("/metrics", server.metrics.serveHTTP)
+	http.HandleFunc("/server/sessions/mine", server.cors.wrap(server.mySessions))
+	http.HandleFunc("/server/sessions/mine/", server.cors.wrap(server.mySessions))
+	if config.AuthCheck {
+		http.HandleFunc("/authcheck", server.authCheck)
+	}
+	if server.stateApp != "" {
+		http.HandleFunc("/debug/state", server.serveState)
+	}
+
+	// Sessions run in the context of their requests, which is canceled on shutdown.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	httpServer := &http.Server{
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+		ReadHeaderTimeout: readHeaderTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+	ln, err := listen(net.JoinHostPort("", config.Port), config.TCPKeepAlive)
+	if err != nil {
+		log.Fatalf("Listen error: %s", err.Error())
+	}
+	go func() {
+		<-ctx.Done()
+		log.Infof("Entry is shutting down")
+		httpServer.Shutdown(context.Background())
+	}()
+	if config.TLSCert == "" {
+		err = httpServer.Serve(ln)
+	} else {
+		if httpServer.TLSConfig, err = newTLSConfig(config.TLSClientCA, config.MTLSRequired); err != nil {
+			log.Fatalf("Initialize TLS error: %s", err.Error())
+		}
+		err = httpServer.ServeTLS(ln, config.TLSCert, config.TLSKey)
+	}
+	if err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	server.waitSessions(shutdownTimeout)
+}
+
+// waitSessions waits at most timeout for the sessions being served to end.
+func (server *EntryServer) waitSessions(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		server.sessions.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Warnf("Some sessions are not ended in %s", timeout)
+	}
+}
+
+// resolve finds the container of the instance, located on its node when docker is pooled.
+func (server *EntryServer) resolve(appName, procName, instanceNo string) (string, error) {
+	key := resolveKey(appName, procName, instanceNo)
+	if containerID, ok := server.resolutions.get(key); ok {
+		if server.stillResolves(containerID, instanceNo) {
+			return containerID, nil
+		}
+		log.Infof("Cached container %s of %s is stale, resolve it again", containerID, key)
+		server.resolutions.drop(key)
+	}
+	var (
+		containerID, node string
+		err               error
+	)
+	if nodeResolver, ok := server.resolver.(NodeResolver); ok && server.dockerPool != nil {
+		if containerID, node, err = nodeResolver.ResolveNode(appName, procName, instanceNo); err == nil {
+			server.dockerPool.locate(containerID, node)
+		}
+	} else {
+		containerID, err = server.resolver.Resolve(appName, procName, instanceNo)
+	}
+	if err == nil {
+		server.resolutions.put(key, containerID)
+	}
+	return containerID, err
+}
+
+// newEntryServer creates an EntryServer with config which works with dockerClient.
+func newEntryServer(config Config, dockerClient dockerAPI) (*EntryServer, error) {
+	var err error
+	lainletClient := lainlet.New(net.JoinHostPort("lainlet.lain", config.LainletPort))
+	authClient := config.AuthHTTPClient
+	if authClient == nil {
+		authClient = &http.Client{Timeout: consoleAuthTimeout}
+	}
+	server := &EntryServer{
+		dockerClient:  dockerClient,
+		lainletClient: lainletClient,
+		authorizer: &LainAuthorizer{
+			lainletClient: lainletClient,
+			lainDomain:    config.LainDomain,
+			httpClient:    authClient,
+		},
+		resolver:        &LainResolver{lainletClient: lainletClient},
+		execPrefix:      config.ExecPrefix,
+		shell:           shellCmd(config.LoginShell, config.InteractiveShell),
+		detectShell:     config.DetectShell,
+		maxHeaderSize:   config.MaxHeaderSize,
+		execRetries:     config.ExecRetries,
+		pingInterval:    config.PingInterval,
+		pingSequence:    config.PingSequence,
+		pingRTT:         config.PingRTT,
+		resizeWindow:    config.ResizeWindow,
+		maxCols:         config.MaxCols,
+		maxRows:         config.MaxRows,
+		writeTimeout:    config.WriteTimeout,
+		closeGrace:      defaultCloseGracePeriod,
+		attachSilence:   defaultAttachSilence,
+		shellProbe:      config.ShellProbe,
+		earlyOutputWait: config.EarlyOutputWait,
+		accounting:      config.Accounting,
+		outputLimit:     int64(config.OutputLimit),
+		enforceAppLabel: config.EnforceAppLabel,
+		readonlyNotice:  config.ReadonlyNotice,
+		outputEncoding:  config.OutputEncoding,
+		debug:           newDebugPolicy(config.DebugImage, config.DebugCapabilities, config.DebugPrivileged),
+		cors:            newCORSPolicy(config.CORSOrigins, config.CORSMethods, config.CORSHeaders, config.CORSCredentials),
+		deniedAudits:    newDeniedAudits(),
+		stateApp:        config.StateApp,
+		node:            config.NodeName,
+		nodeBanner:      config.NodeBanner,
+		safeMode:        config.SafeMode,
+	}
+	if server.node == "" {
+		server.node, _ = os.Hostname()
+	}
+	if config.SafeMode {
+		log.Warnf("Safe mode is on, the destructive features are disabled")
+	}
+	if config.LocalPodOnly {
+		server.localPod = newLocalPod(config.PodNamespace, config.PodName)
+		log.Infof("Sessions are confined to pod %s/%s", config.PodNamespace, config.PodName)
+	}
+	// Containers are located on their nodes as they are resolved.
+	server.dockerPool, _ = dockerClient.(*dockerPool)
+	if server.execSpecPolicy, err = newExecSpecPolicy(config.ExecFields); err != nil {
+		return nil, err
+	}
+	if config.RolePolicies != "" {
+		if server.roles, err = LoadRolePolicies(config.RolePolicies); err != nil {
+			return nil, err
+		}
+	}
+	if config.FakeAuth != "" {
+		if server.authorizer, err = NewFakeAuthorizer(config.FakeAuth, config.FakeAuthTokens); err != nil {
+			return nil, err
+		}
+		log.Warnf("Fake authorizer is enabled, NEVER do this in production")
+	} else if config.AuthCacheTTL > 0 {
+		server.authorizer = NewCachedAuthorizer(server.authorizer, config.AuthCacheTTL)
+	}
+	if config.DetachTimeout > 0 {
+		if server.detachKeys, err = parseDetachKeys(config.DetachKeys); err != nil {
+			return nil, err
+		}
+		server.detachTimeout = config.DetachTimeout
+		server.resumeBuffer = config.ResumeBuffer
+	}
+	if server.breakGlass, err = newBreakGlass(config.BreakGlassTokenHash); err != nil {
+		return nil, err
+	}
+	if server.breakGlass.enabled() {
+		log.Warnf("Break-glass access is enabled, every use of it is audited")
+	}
+	if server.containerTokens, err = newContainerTokens(config.ContainerTokenKey, config.ContainerTokenRequired); err != nil {
+		return nil, err
+	}
+	if server.appFilter, err = newAppFilter(config.AllowApps, config.DenyApps); err != nil {
+		return nil, err
+	}
+	if server.envFilter, err = newEnvFilter(config.ExecEnvAllow, config.ExecEnvDeny); err != nil {
+		return nil, err
+	}
+	if server.instancePolicy, err = newInstancePolicy(config.InstancePolicy); err != nil {
+		return nil, err
+	}
+	if server.exclusive, err = newExclusivePolicy(config.ExclusiveApps); err != nil {
+		return nil, err
+	}
+	if config.WebhookURL != "" {
+		server.webhook = newWebhookEmitter(config.WebhookURL, config.WebhookEvents)
+	}
+	if config.ResolveCacheTTL > 0 {
+		server.resolutions = newResolveCache(config.ResolveCacheTTL)
+	}
+	if config.ExecPoolSize > 0 {
+		server.execPool = newExecPool(server.dockerClient, config.ExecPoolSize, config.ExecPoolTTL)
+	}
+	if config.TraceEndpoint != "" {
+		server.tracer = newTracer(config.TraceEndpoint)
+	}
+	if config.MTLSRules != "" {
+		if server.certRules, err = LoadCertRules(config.MTLSRules); err != nil {
+			return nil, err
+		}
+	}
+	if config.ExitCommands != "" {
+		if server.exitRules, err = LoadExitRules(config.ExitCommands); err != nil {
+			return nil, err
+		}
+	}
+	if config.RedactPattern != "" {
+		if server.redact, err = newRedactor(config.RedactPattern); err != nil {
+			return nil, fmt.Errorf("invalid redact pattern: %s", err.Error())
+		}
+	}
+	server.outputTransform = config.OutputTransform
+	if config.Resolver == resolverKubernetes {
+		server.resolver = NewKubernetesResolver(dockerClient)
+	}
+	if config.StaticResolver != "" {
+		if server.resolver, err = LoadStaticResolver(config.StaticResolver); err != nil {
+			return nil, err
+		}
+	}
+	return server, nil
 }
 
 func (server *EntryServer) enter(w http.ResponseWriter, r *http.Request) {
-	ws, containerID, err := server.prepare(w, r)
+	server.sessions.Add(1)
+	defer server.sessions.Done()
+	server.metrics.begin("enter")
+	// The session is an error unless it ends normally.
+	outcome := outcomeError
+	ws, info, err := server.prepare(w, r, "enter")
+	defer func() {
+		info.span.endSession(info, outcome)
+		server.metrics.end("enter", info, outcome)
+	}()
 	if ws != nil {
 		defer ws.Close()
 	}
 	if err != nil {
+		outcome = failureOutcome(err)
 		return
 	}
-	var exec *docker.Exec
+	containerID := info.containerID
+	msgMarshaller, msgUnmarshaller, _ := getMarshalers(r)
+	if info.reattach != "" {
+		if err = server.reattachSession(ws, info, msgMarshaller); err == nil {
+			outcome = outcomeNormal
+		}
+		return
+	}
+	if !server.sessionKeys.acquire(info.user, info.sessionKey) {
+		errMsg := fmt.Sprintf(errMsgTemplate, "This session is active already.")
+		info.logger.Errorf("Duplicate session %s of %s refused", info.sessionKey, info.user)
+		server.sendCloseMessage(ws, []byte(errMsg), msgMarshaller)
+		return
+	}
+	defer server.sessionKeys.release(info.user, info.sessionKey)
 
 	termType := r.Header.Get("term-type")
 	if len(termType) == 0 {
 		termType = "xterm-256color"
 	}
 
-	execCmd := []string{"env", fmt.Sprintf("TERM=%s", termType), "/bin/bash"}
-	opts := docker.CreateExecOptions{
-		Container:    containerID,
-		AttachStdin:  true,
-		AttachStdout: true,
-		AttachStderr: true,
-		Tty:          true,
-		Cmd:          execCmd,
+	debug, _ := strconv.ParseBool(r.URL.Query().Get("debug"))
+	if debug {
+		if err = server.checkDebug(info.role); err != nil {
+			errMsg := fmt.Sprintf(errMsgTemplate, "Debug session is not allowed.")
+			if err == errSafeMode {
+				errMsg = fmt.Sprintf(errMsgTemplate, safeModeMsg("Debug sessions are"))
+			}
+			info.logger.Errorf("Debug %s refused: %s", containerID, err.Error())
+			server.sendCloseMessage(ws, []byte(errMsg), msgMarshaller)
+			return
+		}
+		sidecarID, err := server.startDebugSidecar(containerID)
+		if err != nil {
+			errMsg := info.dockerFailureMessage(err, "Can't start the debug container, try again.")
+			info.logger.Errorf("Start debug sidecar of %s failed: %s", containerID, err.Error())
+			server.sendCloseMessage(ws, []byte(errMsg), msgMarshaller)
+			return
+		}
+		defer server.removeDebugSidecar(sidecarID)
+		server.auditDebug(info, sidecarID)
+		// The session goes on in the sidecar, which sees the processes and network of the container.
+		containerID = sidecarID
+	}
+	if len(server.execPrefix) > 0 {
+		if exist, err := server.commandExists(containerID, server.execPrefix[0]); err != nil || !exist {
+			errMsg := fmt.Sprintf(errMsgTemplate, fmt.Sprintf("Session wrapper %s is not available in your container.", server.execPrefix[0]))
+			info.logger.Errorf("Check exec prefix %s in %s failed: exist=%t, err=%v", server.execPrefix[0], containerID, exist, err)
+			server.sendCloseMessage(ws, []byte(errMsg), msgMarshaller)
+			return
+		}
 	}
 
-	msgMarshaller, msgUnmarshaller := getMarshalers(r)
-
-	if exec, err = server.dockerClient.CreateExec(opts); err != nil {
-		errMsg := fmt.Sprintf(errMsgTemplate, "Can't enter your container, try again.")
-		log.Errorf("Create exec failed: %s", err.Error())
+	// Every goroutine of the session ends when ctx is done, which happens when the session
+	// ends or the server shuts down. Those of a connection end with connCtx, when the client
+	// goes away too.
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	var session *registeredSession
+	if server.exclusive.applies(info.appName) {
+		force, _ := strconv.ParseBool(r.URL.Query().Get("force"))
+		if force && server.safeMode {
+			info.logger.Errorf("Forced session in %s refused: %s", info.containerID, errSafeMode.Error())
+			server.sendCloseMessage(ws, []byte(fmt.Sprintf(errMsgTemplate, safeModeMsg("Forcing a session is"))), msgMarshaller)
+			return
+		}
+		if force && !server.roles.allows(info.role, "force") {
+			info.logger.Errorf("Forced session in %s refused: role %q may not force", info.containerID, info.role)
+			server.sendCloseMessage(ws, []byte(fmt.Sprintf(errMsgTemplate, "Forcing a session is not allowed.")), msgMarshaller)
+			return
+		}
+		if force {
+			log.Warnf("AUDIT: %s forced an exclusive session in %s[%s-%s] container %s", info.user, info.appName, info.procName, info.instanceNo, info.containerID)
+			session = server.registry.add(info, cancel)
+		} else if session = server.registry.addExclusive(info, cancel); session == nil {
+			info.logger.Errorf("Enter %s refused: %s", info.containerID, errSessionActive.Error())
+			server.sendCloseMessage(ws, []byte(fmt.Sprintf(errMsgTemplate, exclusiveMsg)), msgMarshaller)
+			return
+		}
+	} else {
+		session = server.registry.add(info, cancel)
+	}
+	defer server.registry.remove(session)
+	ws.activity = &session.lastActive
+	if server.detachTimeout > 0 && server.resumeBuffer > 0 {
+		ws.replay = newOutputRing(server.resumeBuffer)
+	}
+	if server.earlyOutputWait > 0 {
+		ws.holdOutput(server.earlyOutputWait)
+	}
+	execSpan := info.span.child("exec")
+	shell, err := server.startSession(ctx, ws, containerID, info.image, termType, info.exec, 0, msgMarshaller)
+	execSpan.fail(err)
+	execSpan.end()
+	if err != nil {
+		errMsg := info.dockerFailureMessage(err, "Can't enter your container, try again.")
+		if err == errExecAlreadyStarted {
+			errMsg = fmt.Sprintf(errMsgTemplate, "This session has been started already.")
+		}
+		info.logger.Errorf("Start exec failed: %s", err.Error())
 		server.sendCloseMessage(ws, []byte(errMsg), msgMarshaller)
+		outcome = failureOutcome(err)
 		return
 	}
+	server.webhook.emit(info.event(eventSessionStart, ""))
+	server.sendNodeBanner(ws, msgMarshaller)
+	// A debug sidecar has its own filesystem.
+	if server.readonlyNotice && info.readonlyRootfs && !debug {
+		server.sendNoticeMessage(ws, "This container has a read-only filesystem, writes out of its volumes will fail.", msgMarshaller)
+	}
+	go server.probeShell(ctx, ws, shell, msgMarshaller)
+	usage := server.newUsage()
+	usage.enter(containerID)
 
-	stdinPipeReader, stdinPipeWriter := io.Pipe()
-	stdoutPipeReader, stdoutPipeWriter := io.Pipe()
-	stderrPipeReader, stderrPipeWriter := io.Pipe()
-	stopSignal := make(chan int)
-	wg := &sync.WaitGroup{}
-	wg.Add(3)
-	go server.handleAliveDetection(ws, stopSignal, msgMarshaller)
-	go server.handleRequest(ws, stdinPipeWriter, wg, exec.ID, msgUnmarshaller)
-	go server.handleResponse(ws, stdoutPipeReader, wg, message.ResponseMessage_STDOUT, msgMarshaller)
-	go server.handleResponse(ws, stderrPipeReader, wg, message.ResponseMessage_STDERR, msgMarshaller)
-	if err = server.dockerClient.StartExec(exec.ID, docker.StartExecOptions{
-		Detach:       false,
-		OutputStream: stdoutPipeWriter,
-		ErrorStream:  stderrPipeWriter,
-		InputStream:  stdinPipeReader,
-		RawTerminal:  false,
-	}); err != nil {
+	reason := "exited"
+	s := &enterSession{
+		ctx:      ctx,
+		ws:       ws,
+		info:     &info,
+		termType: termType,
+		// A debug session stays in its sidecar, an infra session in its infra container, and
+		// a session granted by a container token in that container.
+		switchable:    !debug && !info.infra && !info.tokenGranted,
+		msgMarshaller: msgMarshaller,
+		shell:         shell,
+		usage:         usage,
+		detach:        server.newDetachDetector(),
+		registered:    session,
+	}
+	var connCtx context.Context
+	var connCancel context.CancelFunc
+	var readerDone chan struct{}
+	for reattached := true; reattached; {
+		connCtx, connCancel = context.WithCancel(ctx)
+		defer connCancel()
+		requests := make(chan *message.RequestMessage)
+		readerDone = make(chan struct{})
+		go server.handleAliveDetection(connCtx, ws, msgMarshaller)
+		go func() {
+			server.handleRequest(connCtx, connCancel, ws, requests, msgUnmarshaller)
+			close(readerDone)
+		}()
+		err = server.serveSession(connCtx, s, requests)
+		server.closeTabs(s)
+		if err != errDetached {
+			break
+		}
+		connCancel()
+		reattached, err = server.parkSession(s, session, readerDone)
+	}
+	usage.report(info, ws)
+	outcome = outcomeNormal
+	switch {
+	case r.Context().Err() != nil:
+		server.sendCloseMessage(ws, []byte(shutdownMsg), msgMarshaller)
+		reason = "shutdown"
+	case s.quit:
+		server.sendCloseMessage(ws, []byte(byebyeMsg), msgMarshaller)
+		reason = reasonClientQuit
+	case ws.overOutputLimit():
+		reason, outcome = reasonOutputLimit, outcomeCapacity
+	case session.closedByUser():
+		server.sendCloseMessage(ws, []byte(closedByUserMsg), msgMarshaller)
+		reason = reasonClosedByUser
+	case s.ended != "":
+		reason = s.ended
+	case connCtx.Err() != nil:
+		if reason = ws.disconnectReason(); reason == reasonConnectionLost {
+			outcome = outcomeError
+		}
+	case err != nil:
 		errMsg := fmt.Sprintf(errMsgTemplate, "Can't enter your container, try again.")
-		log.Errorf("Start exec failed: %s", err.Error())
+		info.logger.Errorf("Exec session failed: %s", err.Error())
 		server.sendCloseMessage(ws, []byte(errMsg), msgMarshaller)
-	} else {
-		server.sendCloseMessage(ws, []byte(byebyeMsg), msgMarshaller)
+		reason, outcome = err.Error(), failureOutcome(err)
+	default:
+		// The shell exited on its own, by exit or as its tty was closed.
+		server.sendShellExitMessage(ws, s.shell, msgMarshaller)
+	}
+	server.webhook.emit(info.event(eventSessionEnd, reason))
+	if command := exitCommand(server.exitRules, info.appName); command != "" && server.safeMode {
+		info.logger.Warnf("Exit command of %s skipped: %s", info.containerID, errSafeMode.Error())
+	} else if command != "" {
+		// The client is told goodbye already, it doesn't wait for the cleanup.
+		server.sessions.Add(1)
+		go func() {
+			defer server.sessions.Done()
+			server.runExitCommand(info, command)
+		}()
 	}
 
-	stdoutPipeWriter.Close()
-	stderrPipeWriter.Close()
-	stdinPipeReader.Close()
-	wg.Wait()
-	stopSignal <- 0
-	log.Infof("Entering to %s stopped", containerID)
+	// Give the client a moment to go away after the goodbye, the rest of the session
+	// is canceled then. The reader of the client is blocked on the connection whatever
+	// its context, so the connection is closed too, and the reader is waited for.
+	select {
+	case <-connCtx.Done():
+	case <-time.After(server.closeGrace):
+	}
+	connCancel()
+	ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	ws.Close()
+	<-readerDone
+	info.logger.Infof("Entering to %s stopped: %s", info.containerID, reason)
 }
 
 func (server *EntryServer) attach(w http.ResponseWriter, r *http.Request) {
-	ws, containerID, err := server.prepare(w, r)
+	server.sessions.Add(1)
+	defer server.sessions.Done()
+	server.metrics.begin("attach")
+	// The session is an error unless it ends normally.
+	outcome := outcomeError
+	ws, info, err := server.prepare(w, r, "attach")
+	defer func() {
+		info.span.endSession(info, outcome)
+		server.metrics.end("attach", info, outcome)
+	}()
 	if ws != nil {
 		defer ws.Close()
 	}
 	if err != nil {
+		outcome = failureOutcome(err)
 		return
 	}
-	stdoutPipeReader, stdoutPipeWriter := io.Pipe()
-	stderrPipeReader, stderrPipeWriter := io.Pipe()
-	wg := &sync.WaitGroup{}
-	wg.Add(2)
+	containerID := info.containerID
+	msgMarshaller, msgUnmarshaller, _ := getMarshalers(r)
+	attachStdout, attachStderr, err := parseStreams(r.URL.Query().Get("streams"))
+	if err != nil {
+		errMsg := fmt.Sprintf(errMsgTemplate, "Unknown streams, use stdout, stderr or both.")
+		info.logger.Errorf("Attach to %s refused: %s", containerID, err.Error())
+		server.sendCloseMessage(ws, []byte(errMsg), msgMarshaller)
+		return
+	}
+	// Like `docker logs --timestamps`, but kept off by default to leave interactive output raw.
+	timestamps, _ := strconv.ParseBool(r.URL.Query().Get("timestamps"))
+	var filter *regexp.Regexp
+	if pattern := r.URL.Query().Get("filter"); pattern != "" {
+		if filter, err = compileFilter(pattern); err != nil {
+			errMsg := fmt.Sprintf(errMsgTemplate, "Invalid filter: "+err.Error())
+			info.logger.Errorf("Attach to %s refused: %s", containerID, err.Error())
+			server.sendCloseMessage(ws, []byte(errMsg), msgMarshaller)
+			return
+		}
+	}
 
+	// The tty of the container is shared by everyone attached and the app, only the roles
+	// with the resize feature may resize it by WINCH messages.
+	var ttyResizer *resizer
+	if resizeTTY, _ := strconv.ParseBool(r.URL.Query().Get("resize")); resizeTTY {
+		if !info.tty {
+			server.sendNoticeMessage(ws, fmt.Sprintf("Container %s has no terminal to resize.", containerID), msgMarshaller)
+		} else if server.safeMode {
+			server.sendNoticeMessage(ws, safeModeMsg("Resizing the terminal of the container is"), msgMarshaller)
+		} else if server.roles.allows(info.role, "resize") {
+			ttyResizer = server.newContainerResizer(containerID)
+			defer ttyResizer.stop()
+		} else {
+			server.sendNoticeMessage(ws, "Your role may not resize the terminal of the container.", msgMarshaller)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	session := server.registry.add(info, cancel)
+	defer server.registry.remove(session)
+	ws.activity = &session.lastActive
 	opts := docker.AttachToContainerOptions{
-		Container:    containerID,
-		Stdin:        false,
-		Stdout:       true,
-		Stderr:       true,
-		Stream:       true,
-		OutputStream: stdoutPipeWriter,
-		ErrorStream:  stderrPipeWriter,
-	}
-
-	msgMarshaller, _ := getMarshalers(r)
-	go server.handleResponse(ws, stdoutPipeReader, wg, message.ResponseMessage_STDOUT, msgMarshaller)
-	go server.handleResponse(ws, stderrPipeReader, wg, message.ResponseMessage_STDERR, msgMarshaller)
-
-	if waiter, err := server.dockerClient.AttachToContainerNonBlocking(opts); err != nil {
-		errMsg := fmt.Sprintf(errMsgTemplate, "Can't attach your container, try again.")
-		log.Errorf("Attach failed: %s", err.Error())
-		server.sendCloseMessage(ws, []byte(errMsg), msgMarshaller)
-	} else {
-		// Check whether the websocket is closed
+		Container: containerID,
+		Stdin:     false,
+		Stdout:    attachStdout,
+		Stderr:    attachStderr,
+		Stream:    true,
+	}
+	var pipeWriters []io.Closer
+	// seen is set once any output goes to the client.
+	var seen int32
+	// With a filter, only the matching lines go through the pipes.
+	filterOutput := func(w io.WriteCloser) io.WriteCloser {
+		w = outputWatch{w, &seen}
+		if filter == nil {
+			return w
+		}
+		return newLineFilter(w, filter)
+	}
+	wg := &sync.WaitGroup{}
+	if attachStdout {
+		stdoutPipeReader, stdoutPipeWriter := io.Pipe()
+		stdout := filterOutput(stdoutPipeWriter)
+		opts.OutputStream = stdout
+		pipeWriters = append(pipeWriters, stdout)
+		wg.Add(1)
+		go server.handleResponse(ctx, ws, stdoutPipeReader, wg, message.ResponseMessage_STDOUT, 0, msgMarshaller, timestamps)
+	}
+	if attachStderr {
+		stderrPipeReader, stderrPipeWriter := io.Pipe()
+		stderr := filterOutput(stderrPipeWriter)
+		opts.ErrorStream = stderr
+		pipeWriters = append(pipeWriters, stderr)
+		wg.Add(1)
+		go server.handleResponse(ctx, ws, stderrPipeReader, wg, message.ResponseMessage_STDERR, 0, msgMarshaller, timestamps)
+	}
+
+	// The session is canceled once the websocket is closed, or the client quits or detaches.
+	var quit, detached int32
+	detach := server.newDetachDetector()
+	go func() {
+		defer cancel()
 		for {
-			if _, _, err = ws.ReadMessage(); err == nil {
-				time.Sleep(10 * time.Millisecond)
-			} else {
-				break
+			_, data, err := ws.ReadMessage()
+			if err != nil {
+				logReadEnd("Attach reader", err)
+				return
+			}
+			inMsg := message.RequestMessage{}
+			if msgUnmarshaller(data, &inMsg) == nil && server.refuseRequest(ws, &inMsg, msgMarshaller) {
+				continue
+			}
+			if inMsg.MsgType == message.RequestMessage_QUIT {
+				atomic.StoreInt32(&quit, 1)
+				return
+			}
+			switch inMsg.MsgType {
+			case message.RequestMessage_PONG:
+				server.handlePong(ws, info.logger, inMsg.Content, msgMarshaller)
+				continue
+			case message.RequestMessage_CAPS:
+				server.handleCaps(ws, info.logger, inMsg.Content, msgMarshaller)
+				continue
+			case message.RequestMessage_WINCH:
+				if size, ok := getTermSize(inMsg.Content); ok && ttyResizer != nil {
+					ttyResizer.resize(size)
+				}
+				continue
+			}
+			// Nothing is written to the container, the input only matters for detaching.
+			if inMsg.MsgType == message.RequestMessage_PLAIN && detach != nil {
+				if _, ok := detach.scan(inMsg.Content); ok {
+					atomic.StoreInt32(&detached, 1)
+					return
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	// With follow, the restarted container is attached again until the client disconnects.
+	follow, _ := strconv.ParseBool(r.URL.Query().Get("follow"))
+	reason := reasonClientDisconnected
+	exited, exitCode := false, 0
+	watchCtx, stopWatch := context.WithCancel(ctx)
+	outcome = outcomeNormal
+	for attached := false; ; attached = true {
+		// The start of the container tells whether it restarted since the attach.
+		container, err := server.dockerClient.InspectContainer(opts.Container)
+		var waiter docker.CloseWaiter
+		if err == nil {
+			waiter, err = server.dockerClient.AttachToContainerNonBlocking(opts)
+		}
+		if err != nil {
+			errMsg := info.dockerFailureMessage(err, "Can't attach your container, try again.")
+			info.logger.Errorf("Attach failed: %s", err.Error())
+			server.sendCloseMessage(ws, []byte(errMsg), msgMarshaller)
+			reason, outcome = err.Error(), failureOutcome(err)
+			break
+		}
+		if !attached {
+			server.webhook.emit(info.event(eventSessionStart, ""))
+			server.sendNodeBanner(ws, msgMarshaller)
+			go server.watchSilence(watchCtx, ws, opts.Container, &seen, filter != nil, msgMarshaller)
+		} else {
+			server.sendNoticeMessage(ws, fmt.Sprintf("Attached to the restarted container %s.", opts.Container), msgMarshaller)
+		}
+		go server.suggestTTYSize(watchCtx, ws, opts.Container, msgMarshaller)
+		stopped := make(chan error, 1)
+		go func() { stopped <- waiter.Wait() }()
+		select {
+		case <-ctx.Done():
+			waiter.Close()
+		case <-stopped:
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		if exitCode, err = server.waitContainer(ctx, opts.Container, container.State.StartedAt); err != nil {
+			if err != errSessionCanceled {
+				errMsg := fmt.Sprintf(errMsgTemplate, "Lost your container, try again.")
+				info.logger.Errorf("Wait container %s failed: %s", opts.Container, err.Error())
+				server.sendCloseMessage(ws, []byte(errMsg), msgMarshaller)
+				reason, outcome = err.Error(), failureOutcome(err)
+			}
+			break
+		}
+		if !follow {
+			exited, reason = true, fmt.Sprintf("exited with code %d", exitCode)
+			break
+		}
+
+		server.sendNoticeMessage(ws, fmt.Sprintf("Container %s exited with code %d, waiting for it to restart.", opts.Container, exitCode), msgMarshaller)
+		if opts.Container, err = server.waitForRestart(ctx, info); err != nil {
+			if err != errSessionCanceled {
+				errMsg := fmt.Sprintf(errMsgTemplate, "Container is gone, stop following.")
+				info.logger.Errorf("Follow %s[%s-%s] stopped: %s", info.appName, info.procName, info.instanceNo, err.Error())
+				server.sendCloseMessage(ws, []byte(errMsg), msgMarshaller)
+				reason, outcome = err.Error(), failureOutcome(err)
 			}
+			break
+		}
+		server.registry.moved(session, info.instanceNo, opts.Container)
+		if ttyResizer != nil {
+			ttyResizer.retarget(opts.Container)
 		}
-		waiter.Close()
 	}
-	stdoutPipeWriter.Close()
-	stderrPipeWriter.Close()
+	stopWatch()
+	if r.Context().Err() != nil {
+		reason = "shutdown"
+	} else if atomic.LoadInt32(&quit) == 1 {
+		reason = reasonClientQuit
+	} else if atomic.LoadInt32(&detached) == 1 {
+		reason = reasonDetached
+	} else if ws.overOutputLimit() {
+		reason, outcome = reasonOutputLimit, outcomeCapacity
+	} else if session.closedByUser() {
+		reason = reasonClosedByUser
+	} else if reason == reasonClientDisconnected {
+		if reason = ws.disconnectReason(); reason == reasonConnectionLost {
+			outcome = outcomeError
+		}
+	}
+	server.webhook.emit(info.event(eventSessionEnd, reason))
+	for _, pipeWriter := range pipeWriters {
+		pipeWriter.Close()
+	}
 	wg.Wait()
-	log.Infof("Attaching to %s stopped", containerID)
+	// Sent after the output is drained, so that it's the last message the client gets.
+	if exited {
+		server.sendExitMessage(ws, exitCode, msgMarshaller)
+	} else if reason == "shutdown" {
+		server.sendCloseMessage(ws, []byte(shutdownMsg), msgMarshaller)
+	} else if reason == reasonClientQuit {
+		server.sendCloseMessage(ws, []byte(byebyeMsg), msgMarshaller)
+	} else if reason == reasonClosedByUser {
+		server.sendCloseMessage(ws, []byte(closedByUserMsg), msgMarshaller)
+	} else if reason == reasonDetached {
+		server.sendCloseMessage(ws, []byte(detachedAttachMsg), msgMarshaller)
+	}
+	info.logger.Infof("Attaching to %s stopped: %s", containerID, reason)
 }
 
-func (server *EntryServer) prepare(w http.ResponseWriter, r *http.Request) (*websocket.Conn, string, error) {
-	var (
-		err error
-		ws  *websocket.Conn
-	)
+// prepare upgrades the request to a websocket of the kind of session, then authorizes the client
+// and finds the container. On failure, the client is told with a CLOSE message.
+func (server *EntryServer) prepare(w http.ResponseWriter, r *http.Request, kind string) (*safeConn, sessionInfo, error) {
+	// The span of the session is ended with it, see span.endSession.
+	root := server.tracer.start("entry."+kind, r.Header)
 	isViaWeb := r.URL.Query().Get("method") == "web"
-	ws, err = upgrader.Upgrade(w, r, nil)
+	if err := checkHeaders(r.Header, server.maxHeaderSize); err != nil {
+		log.Errorf("Session refused before upgrade: %s", err.Error())
+		http.Error(w, "Invalid request headers.", http.StatusBadRequest)
+		return nil, sessionInfo{span: root}, err
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Errorf("Upgrade websocket protocol error: %s", err.Error())
-		return ws, "", err
+		return nil, sessionInfo{span: root}, err
 	}
+	ws := newSafeConn(conn, server.writeTimeout)
+	ws.totals = &server.metrics.bytes
+	ws.SetReadLimit(maxRequestSize)
+	ws.outputLimit = server.outputLimit
+	// JSON messages are text, with their contents in base64, so web clients may ask for text frames.
+	ws.textFrames = isViaWeb && r.URL.Query().Get("frames") == "text"
+	ws.lineBuffered = r.URL.Query().Get("buffer") == "line"
+	ws.pingFrames = isViaWeb && r.URL.Query().Get("keepalive") == "websocket"
+	ws.barePings = isViaWeb && r.URL.Query().Get("keepalive") == "bare"
 
-	var accessToken, appName, procName, instanceNo string
-	msgMarshaller, _ := getMarshalers(r)
+	var accessToken, appName, procName, instanceNo, containerRef, containerToken, sessionKey, outputEncoding, reattach, resumeOffset string
+	var execSpec []byte
+	msgMarshaller, _, marshaler := getMarshalers(r)
 	if !isViaWeb {
 		accessToken = r.Header.Get("access-token")
 		appName = r.Header.Get("app-name")
 		procName = r.Header.Get("proc-name")
 		instanceNo = r.Header.Get("instance-no")
+		containerRef = r.Header.Get("container")
+		containerToken = r.Header.Get("container-token")
+		sessionKey = r.Header.Get("session-key")
+		execSpec = []byte(r.Header.Get("exec-spec"))
+		outputEncoding = r.Header.Get("output-encoding")
+		reattach = r.Header.Get("reattach")
+		resumeOffset = r.Header.Get("resume-offset")
 	} else {
 		_, msgData, err := ws.ReadMessage()
 		if err != nil {
 			log.Errorf("Read auth message from webclient failed: %s", err.Error())
-			return ws, "", errAuthFailed
+			return ws, sessionInfo{span: root}, errAuthFailed
 		}
 		msg := make(map[string]string)
 		json.Unmarshal(msgData, &msg)
@@ -251,91 +920,332 @@ func (server *EntryServer) prepare(w http.ResponseWriter, r *http.Request) (*web
 		appName = msg["app_name"]
 		procName = msg["proc_name"]
 		instanceNo = msg["instance_no"]
+		containerRef = msg["container"]
+		containerToken = msg["container_token"]
+		sessionKey = msg["session_key"]
+		outputEncoding = msg["output_encoding"]
+		reattach = msg["reattach"]
+		resumeOffset = msg["resume_offset"]
+		var spec struct {
+			Exec json.RawMessage `json:"exec"`
+		}
+		json.Unmarshal(msgData, &spec)
+		execSpec = spec.Exec
+	}
+
+	info := sessionInfo{
+		kind:       kind,
+		appName:    appName,
+		procName:   procName,
+		instanceNo: instanceNo,
+		user:       tokenFingerprint(accessToken),
+		sessionKey: sessionKey,
+		viaWeb:     isViaWeb,
+		reattach:   reattach,
+		span:       root,
+		node:       server.node,
+		marshaler:  marshaler,
+		protocol:   sessionProtocol(ws.Subprotocol()),
+	}
+	info.logger = info.newLogger()
+	info.logger.Infof("A user wants to enter %s[%s-%s]", appName, procName, instanceNo)
+
+	if outputEncoding == "" {
+		outputEncoding = server.outputEncoding
+	}
+	if ws.outputEncoding, err = parseOutputEncoding(outputEncoding); err != nil {
+		errMsg := fmt.Sprintf(errMsgTemplate, fmt.Sprintf("Unknown output encoding %s.", outputEncoding))
+		info.logger.Errorf("Session of %s refused: %s", appName, err.Error())
+		server.sendCloseMessage(ws, []byte(errMsg), msgMarshaller)
+		return ws, info, err
 	}
 
-	var containerID string
-	log.Infof("A user wants to enter %s[%s-%s]", appName, procName, instanceNo)
+	if err = server.appFilter.check(appName); err != nil {
+		errMsg := fmt.Sprintf(errMsgTemplate, "Entering this application is not allowed.")
+		info.logger.Errorf("Entering %s rejected: %s", appName, err.Error())
+		server.sendCloseMessage(ws, []byte(errMsg), msgMarshaller)
+		return ws, info, err
+	}
 
-	if err = server.auth(accessToken, appName); err != nil {
+	authSpan := info.span.child("auth")
+	if server.safeMode && server.breakGlass.match(accessToken) {
+		errMsg := fmt.Sprintf(errMsgTemplate, safeModeMsg("Break-glass access is"))
+		info.logger.Errorf("Break-glass access refused: %s", errSafeMode.Error())
+		authSpan.fail(errSafeMode)
+		authSpan.end()
+		server.sendCloseMessage(ws, []byte(errMsg), msgMarshaller)
+		return ws, info, errAuthFailed
+	} else if server.breakGlass.match(accessToken) {
+		// The console is bypassed, which is never done silently.
+		info.role, info.user, info.breakGlass = breakGlassRole, "break-glass:"+tokenFingerprint(accessToken), true
+		info.logger = info.newLogger()
+		server.auditBreakGlass(info, r.RemoteAddr)
+	} else if info.role, info.user, err = server.authorize(r, accessToken, appName); err != nil {
 		errMsg := fmt.Sprintf(errMsgTemplate, "Authorization failed.")
-		log.Errorf("Authorization failed: %s", err.Error())
+		info.logger.Errorf("Authorization failed: %s", err.Error())
+		authSpan.fail(err)
+		authSpan.end()
+		server.auditAuthFailure(info, containerRef, r.RemoteAddr, err)
 		server.sendCloseMessage(ws, []byte(errMsg), msgMarshaller)
-		return ws, containerID, errAuthFailed
+		return ws, info, errAuthFailed
 	}
+	info.authorized = true
+	authSpan.set("entry.role", info.role)
+	authSpan.end()
 
-	if containerID, err = server.getContainerID(appName, procName, instanceNo); err != nil {
-		errMsg := fmt.Sprintf(errMsgTemplate, "Container is not found.")
-		log.Errorf("Find container %s[%s-%s] error: %s", appName, procName, instanceNo, err.Error())
+	if info.exec, err = parseExecSpec(execSpec); err == nil && info.exec != nil {
+		if kind != "enter" {
+			err = fmt.Errorf("%s: only enter sessions run execs", errInvalidExecSpec)
+		} else if server.safeMode {
+			err = errSafeMode
+		} else {
+			err = server.checkExecSpec(info.role, info.exec)
+		}
+	}
+	if err != nil {
+		errMsg := fmt.Sprintf(errMsgTemplate, "Invalid exec spec: "+err.Error()+".")
+		if err == errSafeMode {
+			errMsg = fmt.Sprintf(errMsgTemplate, safeModeMsg("Exec specs are"))
+		}
+		switch policyErr := err.(type) {
+		case *execFieldError:
+			errMsg = fmt.Sprintf(errMsgTemplate, fmt.Sprintf("Exec field %s is not allowed for your role.", policyErr.field))
+		case *execCommandError:
+			errMsg = fmt.Sprintf(errMsgTemplate, fmt.Sprintf("Command %s is not allowed for your role.", policyErr.command))
+		case *execEnvError:
+			errMsg = fmt.Sprintf(errMsgTemplate, fmt.Sprintf("Setting env %s is not allowed.", policyErr.name))
+		}
+		info.logger.Errorf("Exec spec of %s rejected: %s", info.user, err.Error())
 		server.sendCloseMessage(ws, []byte(errMsg), msgMarshaller)
+		return ws, info, err
+	}
+
+	if info.reattach != "" {
+		// The container is the one of the detached session, see reattachSession.
+		if kind != "enter" {
+			errMsg := fmt.Sprintf(errMsgTemplate, "Only enter sessions can be reattached.")
+			server.sendCloseMessage(ws, []byte(errMsg), msgMarshaller)
+			return ws, info, errReattachNotAllowed
+		}
+		if info.resumeOffset, err = parseResumeOffset(resumeOffset); err != nil {
+			errMsg := fmt.Sprintf(errMsgTemplate, fmt.Sprintf("Invalid resume offset %s.", resumeOffset))
+			server.sendCloseMessage(ws, []byte(errMsg), msgMarshaller)
+			return ws, info, err
+		}
+		return ws, info, nil
+	}
+
+	// The resolution goes on with the checks of the container until the end of prepare.
+	resolveSpan := info.span.child("resolve")
+	defer func() {
+		resolveSpan.fail(err)
+		resolveSpan.end()
+	}()
+	if containerToken != "" {
+		// The container granted by the token wins over any named by the client.
+		if containerRef, err = server.containerTokens.verify(containerToken, appName, time.Now()); err != nil {
+			errMsg := fmt.Sprintf(errMsgTemplate, "Container token is invalid.")
+			if err == errExpiredContainerToken {
+				errMsg = fmt.Sprintf(errMsgTemplate, "Container token is expired, ask for another one.")
+			}
+			info.logger.Errorf("Container token of %s rejected: %s", info.user, err.Error())
+			server.sendCloseMessage(ws, []byte(errMsg), msgMarshaller)
+			return ws, info, err
+		}
+		info.tokenGranted = true
+	} else if server.containerTokens.required {
+		err = errContainerTokenRequired
+		errMsg := fmt.Sprintf(errMsgTemplate, "A container token is required.")
+		info.logger.Errorf("Session of %s refused: %s", info.user, err.Error())
+		server.sendCloseMessage(ws, []byte(errMsg), msgMarshaller)
+		return ws, info, err
+	}
+	if containerRef != "" {
+		// The container is given by name or ID prefix instead of the proc instance.
+		var container docker.APIContainers
+		if container, err = server.lookupContainer(appName, containerRef); err != nil {
+			errMsg := fmt.Sprintf(errMsgTemplate, "Container is not found.")
+			if ambiguousErr, ok := err.(*ambiguousContainerError); ok {
+				errMsg = fmt.Sprintf(errMsgTemplate, fmt.Sprintf("Container %s is ambiguous, did you mean one of: %s?",
+					ambiguousErr.ref, strings.Join(ambiguousErr.candidates, ", ")))
+			}
+			info.logger.Errorf("Find container %s of %s error: %s", containerRef, appName, err.Error())
+			server.sendCloseMessage(ws, []byte(errMsg), msgMarshaller)
+			return ws, info, err
+		}
+		info.containerID = container.ID
+		_, info.procName = getAppProcName(strings.Split(container.Labels[lainLabelPrefix+"pg_name"], "."))
+		info.instanceNo = container.Labels[lainLabelPrefix+"instance_no"]
+	} else if info.containerID, err = server.resolve(appName, procName, instanceNo); err != nil {
+		// Resolvers may ask the daemon too.
+		errMsg := info.dockerFailureMessage(err, "Container is not found.")
+		info.logger.Errorf("Find container %s[%s-%s] error: %s", appName, procName, instanceNo, err.Error())
+		server.sendCloseMessage(ws, []byte(errMsg), msgMarshaller)
+		return ws, info, err
+	}
+	if kind == "enter" {
+		if err = server.instancePolicy.check(appName, info.instanceNo); err != nil {
+			errMsg := fmt.Sprintf(errMsgTemplate, fmt.Sprintf("Entering instance %s of %s is not allowed.", info.instanceNo, appName))
+			info.logger.Errorf("Entering %s[%s-%s] rejected: %s", appName, info.procName, info.instanceNo, err.Error())
+			server.sendCloseMessage(ws, []byte(errMsg), msgMarshaller)
+			return ws, info, err
+		}
 	}
-	return ws, containerID, err
+
+	info.logger = info.newLogger()
+	var container *docker.Container
+	if container, err = server.dockerClient.InspectContainer(info.containerID); err == nil {
+		err = server.checkContainerApp(container, appName)
+	}
+	if err != nil {
+		errMsg := info.dockerFailureMessage(err, "Container is not found.")
+		info.logger.Errorf("Inspect container %s error: %s", info.containerID, err.Error())
+		server.sendCloseMessage(ws, []byte(errMsg), msgMarshaller)
+		return ws, info, err
+	}
+	// Namespace tooling enters the infra container of the pod instead, its application
+	// is the one of the pod's container checked above.
+	if info.infra, _ = strconv.ParseBool(r.URL.Query().Get("infra")); info.infra {
+		var infra *docker.Container
+		if infra, err = server.enterInfra(info.role, container); err != nil {
+			errMsg := fmt.Sprintf(errMsgTemplate, "Infra container is not found.")
+			switch err {
+			case errInfraForbidden:
+				errMsg = fmt.Sprintf(errMsgTemplate, "Entering the infra container is not allowed.")
+			case errSafeMode:
+				errMsg = fmt.Sprintf(errMsgTemplate, safeModeMsg("Entering the infra container is"))
+			}
+			info.logger.Errorf("Infra container of %s refused: %s", info.containerID, err.Error())
+			server.sendCloseMessage(ws, []byte(errMsg), msgMarshaller)
+			return ws, info, err
+		}
+		container, info.containerID = infra, infra.ID
+		info.logger = info.newLogger()
+	}
+	info.image = container.Image
+	if err = checkContainerState(container.State); err != nil {
+		errMsg := fmt.Sprintf(errMsgTemplate, containerStateMessages[err])
+		info.logger.Errorf("Container %s can't be entered: %s", info.containerID, err.Error())
+		server.sendCloseMessage(ws, []byte(errMsg), msgMarshaller)
+	}
+	info.readonlyRootfs = container.HostConfig != nil && container.HostConfig.ReadonlyRootfs
+	info.tty = container.Config != nil && container.Config.Tty
+	return ws, info, err
 }
 
-func (server *EntryServer) handleRequest(ws *websocket.Conn, sessionWriter io.WriteCloser, wg *sync.WaitGroup, execID string, msgUnmarshaller Unmarshaler) {
+// handleRequest reads the request messages of a session into requests until ctx is done,
+// it cancels the session when the client goes away.
+func (server *EntryServer) handleRequest(ctx context.Context, cancel context.CancelFunc, ws *safeConn, requests chan<- *message.RequestMessage, msgUnmarshaller Unmarshaler) {
 	var (
 		err   error
 		wsMsg []byte
 	)
-	time.Sleep(time.Second)
-	inMsg := message.RequestMessage{}
+	defer cancel()
+	batch := ws.Subprotocol() == batchSubprotocol
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(time.Second):
+	}
 	for err == nil {
 		if _, wsMsg, err = ws.ReadMessage(); err == nil {
-			if unmarshalErr := msgUnmarshaller(wsMsg, &inMsg); unmarshalErr == nil {
-				switch inMsg.MsgType {
-				case message.RequestMessage_PLAIN:
-					if len(inMsg.Content) > 0 {
-						_, err = sessionWriter.Write(inMsg.Content)
-					}
-				case message.RequestMessage_WINCH:
-					if width, height := getWidthAndHeight(inMsg.Content); width >= 0 && height >= 0 {
-						err = server.dockerClient.ResizeExecTTY(execID, height, width)
-					}
+			ws.markReady()
+			for _, inMsg := range decodeRequests(wsMsg, batch, msgUnmarshaller) {
+				select {
+				case requests <- inMsg:
+				case <-ctx.Done():
+					return
 				}
-
-			} else {
-				log.Errorf("Unmarshall request error: %s", unmarshalErr.Error())
 			}
 		}
 	}
-	if err != nil {
-		log.Errorf("HandleRequest ended: %s", err.Error())
-	}
+	logReadEnd("HandleRequest", err)
+}
 
-	sessionWriter.Close()
-	wg.Done()
+func (server *EntryServer) handleRequestMessage(inMsg *message.RequestMessage, input *inputWriter, resizer *resizer) error {
+	switch inMsg.MsgType {
+	case message.RequestMessage_PLAIN:
+		if string(inMsg.Content) == interruptKey {
+			return input.interrupt(inMsg.Content)
+		}
+		if len(inMsg.Content) > 0 {
+			return input.write(inMsg.Content)
+		}
+	case message.RequestMessage_WINCH:
+		// Docker resizes ttys by cols and rows only, pixel sizes are dropped here.
+		if size, ok := getTermSize(inMsg.Content); ok {
+			return resizer.resize(size)
+		}
+	}
+	return nil
 }
 
-func (server *EntryServer) handleResponse(ws *websocket.Conn, sessionReader io.ReadCloser, wg *sync.WaitGroup, respType message.ResponseMessage_ResponseType, msgMarshaller Marshaler) {
+// handleResponse sends what's read from sessionReader to the client until its end, or until
+// ctx is done as nobody cares about the output any more. The output is of tab, see tabs.
+func (server *EntryServer) handleResponse(ctx context.Context, ws *safeConn, sessionReader io.ReadCloser, wg *sync.WaitGroup, respType message.ResponseMessage_ResponseType, tab uint32, msgMarshaller Marshaler, timestamps bool) {
 	var (
 		err  error
 		size int
 	)
+	if ws.outputEncoding != nil {
+		sessionReader = decodeOutput(sessionReader, ws.outputEncoding)
+	}
+	if server.redact != nil {
+		sessionReader = newRedactReader(sessionReader, server.redact)
+	}
 	buf := make([]byte, writeBufferSize)
 	cursor := 0
 	for err == nil {
-		if size, err = sessionReader.Read(buf[cursor:]); err == nil || (err == io.EOF && size > 0) {
-			validLen := getValidUT8Length(buf[:cursor+size])
-			if validLen == 0 {
-				log.Errorf("No valid UTF8 sequence prefix")
-				break
-			}
-			outMsg := &message.ResponseMessage{
-				MsgType: respType,
-				Content: buf[:validLen],
-			}
+		size, err = sessionReader.Read(buf[cursor:])
+		if err == nil && ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		content := buf[:cursor+size]
+		validLen := getValidUT8Length(content)
+		if err != nil || (validLen == 0 && len(content) == len(buf)) {
+			// Nothing more will complete the pending bytes, send them as they are.
+			validLen = len(content)
+		}
+		if ws.lineBuffered && err == nil && len(content) < len(buf) {
+			// Only whole lines are sent, unless they don't fit in the buffer.
+			validLen = bytes.LastIndexByte(content[:validLen], '\n') + 1
+		}
+		if validLen == 0 {
+			cursor = len(content)
+			continue
+		}
+		outMsg := &message.ResponseMessage{
+			MsgType: respType,
+			Content: content[:validLen],
+			Tab:     tab,
+		}
+		if server.outputTransform != nil {
+			outMsg.Content = server.outputTransform(outMsg.Content)
+		}
+		ws.touch()
+		if allowed, reached := ws.takeOutput(len(outMsg.Content)); reached {
+			outMsg.Content = outMsg.Content[:getValidUT8Length(outMsg.Content[:allowed])]
+			err = errOutputLimit
+		}
+		if timestamps {
+			outMsg.Timestamp = time.Now().Format(time.RFC3339Nano)
+		}
+		// Nothing may be left under the output limit.
+		if len(outMsg.Content) > 0 {
+			ws.awaitReady(ctx)
 			data, marshalErr := msgMarshaller(outMsg)
-			if marshalErr == nil {
-				err = ws.WriteMessage(websocket.BinaryMessage, data)
-				cursor := size - validLen
-				for i := 0; i < cursor; i++ {
-					buf[i] = buf[cursor+i]
-				}
-			} else {
+			if marshalErr != nil {
 				log.Errorf("Marshal response error: %s", marshalErr.Error())
+			} else if writeErr := ws.writeOutput(tab, outMsg.Content, data); writeErr != nil && err == nil {
+				err = writeErr
 			}
 		}
+		// Keep the incomplete UTF8 sequence at the end for the next read.
+		cursor = copy(buf, content[validLen:])
 	}
-	if err != nil {
+	if err == errOutputLimit {
+		server.endOutput(ws, msgMarshaller)
+	} else if err != io.EOF && err != context.Canceled {
 		log.Errorf("HandleResponse ended: %s", err.Error())
 	}
 
@@ -343,110 +1253,136 @@ func (server *EntryServer) handleResponse(ws *websocket.Conn, sessionReader io.R
 	wg.Done()
 }
 
-func (server *EntryServer) handleAliveDetection(ws *websocket.Conn, isStop chan int, msgMarshaller Marshaler) {
-	pingMsg := &message.ResponseMessage{
-		MsgType: message.ResponseMessage_PING,
-		Content: []byte("ping"),
+// pinger generates the content of the alive detection pings of a session.
+// With sequence enabled, the content is "ping <seq> <unix milliseconds>" so that clients
+// can detect missed pings and measure latency, otherwise it's the plain "ping", or nothing
+// if bare.
+type pinger struct {
+	sequence bool
+	bare     bool
+	seq      uint64
+}
+
+func (p *pinger) next(now time.Time) []byte {
+	if !p.sequence {
+		if p.bare {
+			return nil
+		}
+		return []byte("ping")
 	}
-	data, _ := msgMarshaller(pingMsg)
-	ticker := time.NewTicker(aliveDecectionInterval)
+	p.seq++
+	return []byte(fmt.Sprintf("ping %d %d", p.seq, now.UnixNano()/int64(time.Millisecond)))
+}
+
+// handleAliveDetection pings the client every pingInterval until ctx is done. Web clients
+// asking keepalive=bare get pings without content unless they're sequenced, just
+// {"msgType":3} in JSON, so that the clients rendering the content of every message show
+// nothing of them. Those asking keepalive=websocket get websocket ping frames instead,
+// answered by the browser.
+func (server *EntryServer) handleAliveDetection(ctx context.Context, ws *safeConn, msgMarshaller Marshaler) {
+	if server.pingInterval <= 0 {
+		return
+	}
+	// The round trip is measured by the time in the pings.
+	p := &pinger{sequence: server.pingSequence || server.pingRTT, bare: ws.barePings}
+	pingFrames := ws.pingFrames
+	ticker := time.NewTicker(server.pingInterval)
+	defer ticker.Stop()
 	for {
 		select {
-		case <-isStop:
+		case <-ctx.Done():
 			return
-		case <-ticker.C:
-			ws.WriteMessage(websocket.BinaryMessage, data)
+		case now := <-ticker.C:
+			if pingFrames {
+				ws.WriteControl(websocket.PingMessage, nil, now.Add(server.pingInterval))
+				continue
+			}
+			pingMsg := &message.ResponseMessage{
+				MsgType: message.ResponseMessage_PING,
+				Content: p.next(now),
+			}
+			if data, err := msgMarshaller(pingMsg); err == nil {
+				ws.WriteMessage(websocket.BinaryMessage, data)
+			}
 		}
 	}
 }
 
-// auth authorizes whether the client with the token has the right to access the application
-func (server *EntryServer) auth(token, appName string) error {
-	var (
-		data []byte
-		err  error
-	)
-	if data, err = server.lainletClient.Get("/v2/configwatcher?target=auth/console", 2*time.Second); err != nil {
-		return err
-	}
-	authDataMap := make(map[string]string)
-	if err = json.Unmarshal(data, &authDataMap); err != nil {
-		return err
+// parsePingTime returns the time a ping was sent, by its content echoed in a PONG.
+func parsePingTime(content []byte) (time.Time, bool) {
+	fields := strings.Fields(string(content))
+	if len(fields) != 3 || fields[0] != "ping" {
+		return time.Time{}, false
 	}
-	if authStr, exist := authDataMap["auth/console"]; exist {
-		c := ConsoleAuthConf{}
-		if err = json.Unmarshal([]byte(authStr), &c); err != nil {
-			return err
-		}
-		if c.Type == "lain-sso" {
-			authURL := fmt.Sprintf("http://console.%s/api/v1/repos/%s/roles/", lainDomain, appName)
-			return server.validateConsoleRole(authURL, token)
-		}
-		return errAuthNotSupported
+	ms, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return time.Time{}, false
 	}
-
-	return nil
+	return time.Unix(0, ms*int64(time.Millisecond)), true
 }
 
-func (server *EntryServer) validateConsoleRole(authURL, token string) error {
-	var (
-		err       error
-		req       *http.Request
-		resp      *http.Response
-		respBytes []byte
-	)
-	if req, err = http.NewRequest("GET", authURL, nil); err != nil {
-		return err
+// handlePong measures the round trip of the ping echoed by a PONG, and tells it to the
+// client in an RTT message if enabled.
+func (server *EntryServer) handlePong(ws *safeConn, logger *log.Logger, content []byte, msgMarshaller Marshaler) {
+	sent, ok := parsePingTime(content)
+	if !ok {
+		logger.Debugf("Invalid PONG %q", truncate(content, maxPongSize))
+		return
 	}
-	req.Header.Set("access-token", token)
-	if resp, err = server.httpClient.Do(req); err != nil {
-		return err
+	rtt := time.Since(sent)
+	if rtt < 0 {
+		return
 	}
-	defer resp.Body.Close()
-	if respBytes, err = ioutil.ReadAll(resp.Body); err != nil {
-		return err
+	logger.Debugf("Round trip time is %s", rtt)
+	if !server.pingRTT || !ws.capabilities().has("rtt") {
+		return
 	}
-	caResp := ConsoleAuthResponse{}
-	if err = json.Unmarshal(respBytes, &caResp); err != nil {
-		return err
+	rttMsg := &message.ResponseMessage{
+		MsgType: message.ResponseMessage_RTT,
+		Content: []byte(strconv.FormatInt(int64(rtt/time.Millisecond), 10)),
 	}
-	if caResp.Role.Role == "" {
-		return errAuthFailed
+	if data, err := msgMarshaller(rttMsg); err == nil {
+		ws.WriteMessage(websocket.BinaryMessage, data)
 	}
-	return nil
 }
 
-func (server *EntryServer) getContainerID(appName, procName, instanceNo string) (string, error) {
-	var (
-		data []byte
-		err  error
-	)
-	if data, err = server.lainletClient.Get("v2/coreinfowatcher?appname="+appName, 2*time.Second); err != nil {
-		return "", err
-	}
-	coreInfo := make(CoreInfo)
-	if err := json.Unmarshal(data, &coreInfo); err != nil {
-		return "", err
-	}
-	for procFullName, procInfo := range coreInfo {
-		curAppName, curProcName := getAppProcName(strings.Split(procFullName, "."))
-		if curProcName == procName && curAppName == appName {
-			for _, containerInfo := range procInfo.PodInfos {
-				if strconv.Itoa(containerInfo.InstanceNo) == instanceNo &&
-					len(containerInfo.Containers) > 0 &&
-					containerInfo.Containers[0].ContainerID != "" {
-					return containerInfo.Containers[0].ContainerID, nil
-				}
-			}
-		}
+func (server *EntryServer) sendCloseMessage(ws *safeConn, content []byte, msgMarshaller Marshaler) {
+	closeMsg := &message.ResponseMessage{
+		MsgType: message.ResponseMessage_CLOSE,
+		Content: content,
+	}
+	if closeData, err := msgMarshaller(closeMsg); err != nil {
+		log.Errorf("Marshal close message failed: %s", err.Error())
+	} else {
+		ws.WriteMessage(websocket.BinaryMessage, closeData)
+	}
+}
+
+// sendExitMessage closes the session because the process of the container exited with exitCode.
+func (server *EntryServer) sendExitMessage(ws *safeConn, exitCode int, msgMarshaller Marshaler) {
+	closeMsg := &message.ResponseMessage{
+		MsgType:  message.ResponseMessage_CLOSE,
+		Content:  []byte(fmt.Sprintf(errMsgTemplate, fmt.Sprintf("Container exited with code %d.", exitCode))),
+		Reason:   closeReasonExited,
+		ExitCode: int32(exitCode),
+	}
+	if closeData, err := msgMarshaller(closeMsg); err != nil {
+		log.Errorf("Marshal close message failed: %s", err.Error())
+	} else {
+		ws.WriteMessage(websocket.BinaryMessage, closeData)
 	}
-	return "", errContainerNotfound
 }
 
-func (server *EntryServer) sendCloseMessage(ws *websocket.Conn, content []byte, msgMarshaller Marshaler) {
+// sendShellExitMessage says goodbye to the client of the shell which exited on its own, with
+// its exit code if it's known.
+func (server *EntryServer) sendShellExitMessage(ws *safeConn, shell *execSession, msgMarshaller Marshaler) {
 	closeMsg := &message.ResponseMessage{
 		MsgType: message.ResponseMessage_CLOSE,
-		Content: content,
+		Content: []byte(byebyeMsg),
+		Reason:  closeReasonExited,
+	}
+	if inspect, err := server.dockerClient.InspectExec(shell.execID); err == nil {
+		closeMsg.ExitCode = int32(inspect.ExitCode)
 	}
 	if closeData, err := msgMarshaller(closeMsg); err != nil {
 		log.Errorf("Marshal close message failed: %s", err.Error())
@@ -455,57 +1391,159 @@ func (server *EntryServer) sendCloseMessage(ws *websocket.Conn, content []byte,
 	}
 }
 
-func getWidthAndHeight(data []byte) (int, int) {
-	sizeStr := string(data)
-	sizeArr := strings.Split(sizeStr, " ")
+// sendNodeBanner tells the client the node serving its session, if enabled, so that it can
+// be found on the dashboards of the host.
+func (server *EntryServer) sendNodeBanner(ws *safeConn, msgMarshaller Marshaler) {
+	if server.nodeBanner && server.node != "" {
+		server.sendNoticeMessage(ws, fmt.Sprintf("This session is served by node %s.", server.node), msgMarshaller)
+	}
+}
 
-	if len(sizeArr) != 2 {
-		return -1, -1
+// sendNoticeMessage tells the client about what entry is doing, in a line of its own.
+func (server *EntryServer) sendNoticeMessage(ws *safeConn, notice string, msgMarshaller Marshaler) {
+	noticeMsg := &message.ResponseMessage{
+		MsgType: message.ResponseMessage_NOTICE,
+		Content: []byte(fmt.Sprintf("\r\n\033[33m>>> %s\033[0m\r\n", notice)),
 	}
-	var width, height int
-	var err error
+	// The notice is a line of the terminal to the clients not telling NOTICE apart.
+	if !ws.capabilities().has("notice") {
+		noticeMsg.MsgType = message.ResponseMessage_STDOUT
+	}
+	if noticeData, err := msgMarshaller(noticeMsg); err != nil {
+		log.Errorf("Marshal notice message failed: %s", err.Error())
+	} else {
+		ws.WriteMessage(websocket.BinaryMessage, noticeData)
+	}
+}
 
-	if width, err = strconv.Atoi(sizeArr[0]); err != nil {
-		return -1, -1
+// parseStreams parses which output streams of a container are attached,
+// value is one of "stdout", "stderr" and "both", and defaults to "both".
+func parseStreams(value string) (stdout, stderr bool, err error) {
+	switch value {
+	case "", "both":
+		return true, true, nil
+	case "stdout":
+		return true, false, nil
+	case "stderr":
+		return false, true, nil
 	}
-	if height, err = strconv.Atoi(sizeArr[1]); err != nil {
-		return -1, -1
+	return false, false, fmt.Errorf("unknown streams %q", value)
+}
+
+// defaultShell is the shell of the sessions which don't ask another command.
+var defaultShell = []string{"/bin/bash"}
+
+// shellCmd returns the default shell, started as a login shell sourcing the profiles and
+// as an interactive shell sourcing ~/.bashrc when asked, to get the environment of the app.
+func shellCmd(login, interactive bool) []string {
+	shell := append([]string{}, defaultShell...)
+	if login {
+		shell = append(shell, "-l")
 	}
+	if interactive {
+		shell = append(shell, "-i")
+	}
+	return shell
+}
 
-	return width, height
+// buildExecCmd returns the command of an interactive session, wrapped by prefix if any.
+// The command and its environment and working directory may be given by spec, otherwise
+// it's shell, defaultShell if empty.
+func buildExecCmd(prefix, shell []string, termType string, spec *ExecSpec) []string {
+	if len(shell) == 0 {
+		shell = defaultShell
+	}
+	execCmd := make([]string, 0, len(prefix)+len(shell)+2)
+	execCmd = append(execCmd, prefix...)
+	execCmd = append(execCmd, "env", fmt.Sprintf("TERM=%s", termType))
+	if spec == nil {
+		return append(execCmd, shell...)
+	}
+	execCmd = append(execCmd, spec.Env...)
+	if spec.WorkingDir != "" {
+		// The docker API of the client can't set the working directory of execs.
+		execCmd = append(execCmd, "sh", "-c", `cd "$0" && exec "$@"`, spec.WorkingDir)
+	}
+	if spec.Cmd != nil {
+		return append(execCmd, spec.Cmd...)
+	}
+	return append(execCmd, shell...)
 }
 
+// termSize is the terminal size carried by a WINCH message, whose content is either
+// "<cols> <rows>" or "<cols> <rows> <xpixel> <ypixel>". Pixel sizes are optional hints,
+// they are zero when absent or invalid.
+type termSize struct {
+	Width, Height  int
+	XPixel, YPixel int
+}
+
+func getTermSize(data []byte) (termSize, bool) {
+	if len(data) > maxWinchSize {
+		return termSize{}, false
+	}
+	sizeArr := strings.Split(string(data), " ")
+	if len(sizeArr) != 2 && len(sizeArr) != 4 {
+		return termSize{}, false
+	}
+	var (
+		size termSize
+		err  error
+	)
+	if size.Width, err = strconv.Atoi(sizeArr[0]); err != nil || size.Width < 0 || size.Width > maxTermDimension {
+		return termSize{}, false
+	}
+	if size.Height, err = strconv.Atoi(sizeArr[1]); err != nil || size.Height < 0 || size.Height > maxTermDimension {
+		return termSize{}, false
+	}
+	if len(sizeArr) == 4 {
+		xPixel, xErr := strconv.Atoi(sizeArr[2])
+		yPixel, yErr := strconv.Atoi(sizeArr[3])
+		if xErr == nil && yErr == nil && xPixel >= 0 && yPixel >= 0 && xPixel <= maxTermDimension && yPixel <= maxTermDimension {
+			size.XPixel, size.YPixel = xPixel, yPixel
+		}
+	}
+	return size, true
+}
+
+// getValidUT8Length returns the length of data without the incomplete UTF8 sequence at its end.
+// Invalid bytes are not held back, as no more bytes can make them valid.
 func getValidUT8Length(data []byte) int {
-	validLen := 0
-	for i := len(data) - 1; i >= 0; i-- {
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
 		if utf8.RuneStart(data[i]) {
-			validLen = i
-			if utf8.Valid(data[i:]) {
-				validLen = len(data)
+			if utf8.FullRune(data[i:]) {
+				return len(data)
 			}
-			break
+			return i
 		}
 	}
-	return validLen
+	return len(data)
 }
 
-func getAppProcName(key []string) (string, string) {
-	var procName string
-	if len(key) > 0 {
-		procName = key[len(key)-1]
-	}
-	var tmp []string
-	for i := len(key) - 3; i >= 0; i-- {
-		tmp = append(tmp, key[i])
+// The marshalers of the messages of sessions, told in their logs and metrics.
+const (
+	marshalerProto = "proto"
+	marshalerJSON  = "json"
+)
+
+// getMarshalers returns the functions marshaling the messages of the session of r, JSON for
+// the web clients, and the name of the marshaler.
+func getMarshalers(r *http.Request) (Marshaler, Unmarshaler, string) {
+	if r.URL.Query().Get("method") == "web" {
+		return json.Marshal, json.Unmarshal, marshalerJSON
 	}
-	return strings.Join(tmp, "."), procName
+	return protoMarshalFunc, protoUnmarshalFunc, marshalerProto
 }
 
-func getMarshalers(r *http.Request) (Marshaler, Unmarshaler) {
-	if r.URL.Query().Get("method") == "web" {
-		return json.Marshal, json.Unmarshal
+// plainProtocol is the protocol of the sessions negotiating no subprotocol, a message per frame.
+const plainProtocol = "plain"
+
+// sessionProtocol returns the protocol of a session by the subprotocol it negotiated.
+func sessionProtocol(subprotocol string) string {
+	if subprotocol == "" {
+		return plainProtocol
 	}
-	return protoMarshalFunc, protoUnmarshalFunc
+	return subprotocol
 }
 
 // Adapters
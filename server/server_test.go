@@ -1,7 +1,20 @@
 package server
 
 import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gorilla/websocket"
+	"github.com/laincloud/entry/message"
 )
 
 func TestGetValidUTF8Length(t *testing.T) {
@@ -18,4 +31,271 @@ func TestGetValidUTF8Length(t *testing.T) {
 	if actual := getValidUT8Length(testCase[:1]); actual != 0 {
 		t.Errorf("Case 4 failed: actual is %d", actual)
 	}
+	if actual := getValidUT8Length([]byte{'a', 0xff}); actual != 2 {
+		t.Errorf("Case 5 failed: actual is %d", actual)
+	}
+}
+
+// dialSession opens a session on ts, the test server of an enter or attach handler, with
+// query appended to its URL and the headers of header. The instance is instance 1 of the web
+// proc of hello, unless header tells another.
+func dialSession(t testing.TB, ts *httptest.Server, query string, header http.Header) *websocket.Conn {
+	if header == nil {
+		header = http.Header{}
+	}
+	for name, value := range map[string]string{"app-name": "hello", "proc-name": "web", "instance-no": "1"} {
+		if header.Get(name) == "" {
+			header.Set(name, value)
+		}
+	}
+	ws, _, err := websocket.DefaultDialer.Dial(strings.Replace(ts.URL, "http", "ws", 1)+query, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ws
+}
+
+func TestBuildExecCmd(t *testing.T) {
+	expected := []string{"env", "TERM=xterm", "/bin/bash"}
+	if actual := buildExecCmd(nil, nil, "xterm", nil); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Case 1 failed: actual is %v", actual)
+	}
+	expected = []string{"tini", "--", "env", "TERM=xterm", "/bin/bash"}
+	if actual := buildExecCmd([]string{"tini", "--"}, nil, "xterm", nil); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Case 2 failed: actual is %v", actual)
+	}
+	spec := &ExecSpec{Cmd: []string{"python3"}, Env: []string{"DEBUG=1"}, WorkingDir: "/srv"}
+	expected = []string{"env", "TERM=xterm", "DEBUG=1", "sh", "-c", `cd "$0" && exec "$@"`, "/srv", "python3"}
+	if actual := buildExecCmd(nil, shellCmd(true, false), "xterm", spec); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Case 3 failed: actual is %v", actual)
+	}
+	expected = []string{"env", "TERM=xterm", "/bin/bash", "-l", "-i"}
+	if actual := buildExecCmd(nil, shellCmd(true, true), "xterm", nil); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Case 4 failed: actual is %v", actual)
+	}
+	expected = []string{"env", "TERM=xterm", "DEBUG=1", "/bin/bash", "-i"}
+	if actual := buildExecCmd(nil, shellCmd(false, true), "xterm", &ExecSpec{Env: []string{"DEBUG=1"}}); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Case 5 failed: actual is %v", actual)
+	}
+}
+
+func TestParseStreams(t *testing.T) {
+	cases := []struct {
+		value          string
+		stdout, stderr bool
+		isErr          bool
+	}{
+		{"", true, true, false},
+		{"both", true, true, false},
+		{"stdout", true, false, false},
+		{"stderr", false, true, false},
+		{"stdin", false, false, true},
+	}
+	for i, c := range cases {
+		stdout, stderr, err := parseStreams(c.value)
+		if stdout != c.stdout || stderr != c.stderr || (err != nil) != c.isErr {
+			t.Errorf("Case %d failed: actual is %t, %t, %v", i+1, stdout, stderr, err)
+		}
+	}
+}
+
+func TestPinger(t *testing.T) {
+	now := time.Unix(1, 0)
+	p := &pinger{}
+	if actual := string(p.next(now)); actual != "ping" {
+		t.Errorf("Case 1 failed: actual is %s", actual)
+	}
+	p = &pinger{sequence: true}
+	for i, expected := range []string{"ping 1 1000", "ping 2 1000", "ping 3 1000"} {
+		if actual := string(p.next(now)); actual != expected {
+			t.Errorf("Case %d failed: actual is %s", i+2, actual)
+		}
+	}
+	p = &pinger{bare: true}
+	if actual := p.next(now); actual != nil {
+		t.Errorf("Case 5 failed: actual is %s", actual)
+	}
+	p = &pinger{sequence: true, bare: true}
+	if actual := string(p.next(now)); actual != "ping 1 1000" {
+		t.Errorf("Case 6 failed: actual is %s", actual)
+	}
+}
+
+func TestEnterWebPing(t *testing.T) {
+	var execs int32
+	fake := &fakeDocker{
+		createExec: func(opts docker.CreateExecOptions) (*docker.Exec, error) {
+			return &docker.Exec{ID: "exec" + strconv.Itoa(int(atomic.AddInt32(&execs, 1)))}, nil
+		},
+		startExec: func(id string, opts docker.StartExecOptions) (docker.CloseWaiter, error) {
+			w := &fakeWaiter{done: make(chan struct{})}
+			go func() {
+				io.Copy(ioutil.Discard, opts.InputStream)
+				close(w.done)
+			}()
+			return w, nil
+		},
+	}
+	server := &EntryServer{dockerClient: fake, authorizer: &FakeAuthorizer{Allow: true}, resolver: StaticResolver{"hello/web/1": "c1"},
+		pingInterval: 20 * time.Millisecond}
+	ts := httptest.NewServer(http.HandlerFunc(server.enter))
+	defer ts.Close()
+
+	dial := func(query string) *websocket.Conn {
+		ws, _, err := websocket.DefaultDialer.Dial(strings.Replace(ts.URL, "http", "ws", 1)+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ws.WriteMessage(websocket.TextMessage, []byte(`{"app_name": "hello", "proc_name": "web", "instance_no": "1"}`))
+		ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+		return ws
+	}
+
+	for i, c := range []struct {
+		query string
+		ping  string
+	}{
+		{"?method=web", `{"msgType":3,"content":"cGluZw=="}`},
+		// The ping of web clients asking it bare is a message of its own type, with nothing
+		// to render.
+		{"?method=web&keepalive=bare", `{"msgType":3}`},
+	} {
+		ws := dial(c.query)
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != c.ping {
+			t.Errorf("Case %d failed: ping is %s", i+1, data)
+		}
+		ws.Close()
+	}
+
+	// Web clients relying on the websocket keepalive get ping frames only.
+	ws := dial("?method=web&keepalive=websocket")
+	pinged := make(chan struct{}, 1)
+	ws.SetPingHandler(func(string) error {
+		select {
+		case pinged <- struct{}{}:
+		default:
+		}
+		return nil
+	})
+	messages := make(chan []byte, 1)
+	go func() {
+		for {
+			_, data, err := ws.ReadMessage()
+			if err != nil {
+				close(messages)
+				return
+			}
+			messages <- data
+		}
+	}()
+	select {
+	case <-pinged:
+	case data := <-messages:
+		t.Errorf("Message %s is sent to a client relying on the websocket keepalive", data)
+	case <-time.After(5 * time.Second):
+		t.Error("No ping frame is sent")
+	}
+	ws.Close()
+	for range messages {
+	}
+	server.sessions.Wait()
+}
+
+func TestParsePingTime(t *testing.T) {
+	cases := []struct {
+		content string
+		ms      int64
+		ok      bool
+	}{
+		{"ping 1 1000", 1000, true},
+		{"ping 2 1500", 1500, true},
+		{"ping", 0, false},
+		{"ping 1", 0, false},
+		{"pong 1 1000", 0, false},
+		{"ping 1 x", 0, false},
+	}
+	for i, c := range cases {
+		sent, ok := parsePingTime([]byte(c.content))
+		if ok != c.ok || (ok && sent.UnixNano()/int64(time.Millisecond) != c.ms) {
+			t.Errorf("Case %d failed: actual is %s, %t", i+1, sent, ok)
+		}
+	}
+}
+
+func TestPingRTT(t *testing.T) {
+	fake := &fakeDocker{
+		startExec: func(id string, opts docker.StartExecOptions) (docker.CloseWaiter, error) {
+			w := &fakeWaiter{done: make(chan struct{})}
+			go func() {
+				io.Copy(ioutil.Discard, opts.InputStream)
+				close(w.done)
+			}()
+			return w, nil
+		},
+	}
+	server := &EntryServer{dockerClient: fake, authorizer: &FakeAuthorizer{Allow: true, Role: "developer"},
+		resolver: StaticResolver{"hello/web/1": "c1"}, pingInterval: 10 * time.Millisecond, pingRTT: true}
+	ts := httptest.NewServer(http.HandlerFunc(server.enter))
+	defer ts.Close()
+
+	ws := dialSession(t, ts, "", nil)
+	defer ws.Close()
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	read := func() *message.ResponseMessage {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := &message.ResponseMessage{}
+		protoUnmarshalFunc(data, msg)
+		return msg
+	}
+
+	ping := read()
+	if _, ok := parsePingTime(ping.Content); ping.MsgType != message.ResponseMessage_PING || !ok {
+		t.Fatalf("Ping is %v %q", ping.MsgType, ping.Content)
+	}
+	data, _ := protoMarshalFunc(&message.RequestMessage{MsgType: message.RequestMessage_PONG, Content: ping.Content})
+	if err := ws.WriteMessage(websocket.BinaryMessage, data); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		msg := read()
+		if msg.MsgType != message.ResponseMessage_RTT {
+			continue
+		}
+		if ms, err := strconv.Atoi(string(msg.Content)); err != nil || ms < 0 {
+			t.Errorf("RTT is %q", msg.Content)
+		}
+		break
+	}
+}
+
+func TestGetTermSize(t *testing.T) {
+	cases := []struct {
+		content  string
+		expected termSize
+		ok       bool
+	}{
+		{"80 24", termSize{Width: 80, Height: 24}, true},
+		{"80 24 640 480", termSize{80, 24, 640, 480}, true},
+		{"80 24 -1 480", termSize{Width: 80, Height: 24}, true},
+		{"80 24 x y", termSize{Width: 80, Height: 24}, true},
+		{"80", termSize{}, false},
+		{"80 24 640", termSize{}, false},
+		{"-80 24", termSize{}, false},
+		{"a b", termSize{}, false},
+		{"65536 24", termSize{}, false},
+		{"80 24 65536 480", termSize{Width: 80, Height: 24}, true},
+		{"80 99999999999999999999", termSize{}, false},
+	}
+	for i, c := range cases {
+		if actual, ok := getTermSize([]byte(c.content)); actual != c.expected || ok != c.ok {
+			t.Errorf("Case %d failed: actual is %+v, %t", i+1, actual, ok)
+		}
+	}
 }
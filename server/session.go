@@ -0,0 +1,273 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/laincloud/entry/log"
+	"github.com/laincloud/entry/message"
+)
+
+var errSwitchToSelf = errors.New("already in the instance")
+
+// execSession is the shell serving an enter session, with its output pumped to the client.
+// A session switching to another instance replaces it with a new one.
+type execSession struct {
+	execID  string
+	input   *inputWriter
+	resizer *resizer
+	stdin   io.WriteCloser
+	// seen is set once the shell writes anything.
+	seen int32
+	// done gets the result of the exec, once all its output is sent.
+	done      chan error
+	closeOnce sync.Once
+}
+
+// startSession starts a shell in containerID of image whose output is sent to ws, as the
+// output of tab. The exec may be specified by spec, see ExecSpec.
+func (server *EntryServer) startSession(ctx context.Context, ws *safeConn, containerID, image, termType string, spec *ExecSpec, tab uint32, msgMarshaller Marshaler) (*execSession, error) {
+	shell := server.shell
+	if spec == nil || spec.Cmd == nil {
+		shell = server.sessionShell(containerID, image)
+	}
+	opts := docker.CreateExecOptions{
+		Container:    containerID,
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+		Tty:          true,
+		Cmd:          buildExecCmd(server.execPrefix, shell, termType, spec),
+	}
+	if spec != nil {
+		opts.User, opts.Privileged = spec.User, spec.Privileged
+	}
+
+	stdinPipeReader, stdinPipeWriter := io.Pipe()
+	stdoutPipeReader, stdoutPipeWriter := io.Pipe()
+	stderrPipeReader, stderrPipeWriter := io.Pipe()
+	session := &execSession{
+		stdin: stdinPipeWriter,
+		done:  make(chan error, 1),
+	}
+	exec, waiter, err := server.startExec(opts, docker.StartExecOptions{
+		Detach:       false,
+		OutputStream: outputWatch{stdoutPipeWriter, &session.seen},
+		ErrorStream:  outputWatch{stderrPipeWriter, &session.seen},
+		InputStream:  stdinPipeReader,
+		RawTerminal:  false,
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("Exec %s started in %s: %v", exec.ID, containerID, opts.Cmd)
+	session.execID = exec.ID
+	session.input = newInputWriter(stdinPipeWriter)
+	session.resizer = server.newResizer(exec.ID)
+	outputWg := &sync.WaitGroup{}
+	outputWg.Add(2)
+	go server.handleResponse(ctx, ws, stdoutPipeReader, outputWg, message.ResponseMessage_STDOUT, tab, msgMarshaller, false)
+	go server.handleResponse(ctx, ws, stderrPipeReader, outputWg, message.ResponseMessage_STDERR, tab, msgMarshaller, false)
+	go func() {
+		err := waiter.Wait()
+		server.execGuard.release(exec.ID)
+		// The output is all in the pipes once the exec ends, drain them before saying goodbye,
+		// or the client may stop reading before the last output arrives.
+		stdoutPipeWriter.Close()
+		stderrPipeWriter.Close()
+		outputWg.Wait()
+		// Nothing reads the input any more, unblock its writer.
+		stdinPipeReader.Close()
+		session.done <- err
+	}()
+	return session, nil
+}
+
+// close ends the input of the session, on which the shell exits. It may be called again.
+func (s *execSession) close() {
+	s.closeOnce.Do(func() {
+		s.resizer.stop()
+		s.input.close()
+		s.stdin.Close()
+	})
+}
+
+// enterSession is the state of an enter session being served, whose shell is replaced when
+// the session switches to another instance.
+type enterSession struct {
+	// ctx lives as long as the session, which may outlive the connections of its client
+	// when it's detached.
+	ctx           context.Context
+	ws            *safeConn
+	info          *sessionInfo
+	termType      string
+	switchable    bool
+	msgMarshaller Marshaler
+	shell         *execSession
+	usage         *sessionUsage
+	// lastSize is the terminal size last requested, given to a new shell.
+	lastSize *termSize
+	// quit is set when the client ends the session deliberately.
+	quit bool
+	// detach finds the detach sequence in the input, nil if detaching is disabled.
+	detach *detachDetector
+	// attached is closed when the session is done with the connection of a reattached client.
+	attached chan struct{}
+	// ended is the reason of a session ended while detached.
+	ended string
+	// registered is the session in the registry, nil if it's not registered.
+	registered *registeredSession
+	// tabs are the shells opened besides the first one by number, see handleTabRequest.
+	tabs     map[uint32]*sessionTab
+	tabExits chan tabExit
+}
+
+// serveSession feeds the requests of the client to the shell of s until it exits or ctx is
+// done, and returns the result of the last shell.
+func (server *EntryServer) serveSession(ctx context.Context, s *enterSession, requests <-chan *message.RequestMessage) error {
+	for {
+		select {
+		case err := <-s.shell.done:
+			s.shell.close()
+			return err
+		case <-ctx.Done():
+			// The shell exits at the end of its input.
+			s.shell.close()
+			return <-s.shell.done
+		case exit := <-s.tabExits:
+			server.endTab(s, exit)
+		case inMsg := <-requests:
+			if server.refuseRequest(s.ws, inMsg, s.msgMarshaller) {
+				continue
+			}
+			if isTabRequest(inMsg) {
+				server.handleTabRequest(ctx, s, inMsg)
+				continue
+			}
+			switch inMsg.MsgType {
+			case message.RequestMessage_SWITCH:
+				refusal, err := server.switchSession(s.ctx, s, string(inMsg.Content))
+				if refusal != "" {
+					server.sendNoticeMessage(s.ws, refusal, s.msgMarshaller)
+				}
+				if err != nil {
+					return err
+				}
+				continue
+			case message.RequestMessage_QUIT:
+				s.quit = true
+				s.shell.close()
+				return <-s.shell.done
+			case message.RequestMessage_CONTROL:
+				if err := server.handleControl(s.ctx, s, inMsg.Content); err != nil {
+					return err
+				}
+				continue
+			case message.RequestMessage_PONG:
+				server.handlePong(s.ws, s.info.logger, inMsg.Content, s.msgMarshaller)
+				continue
+			case message.RequestMessage_CAPS:
+				server.handleCaps(s.ws, s.info.logger, inMsg.Content, s.msgMarshaller)
+				continue
+			case message.RequestMessage_WINCH:
+				if size, ok := getTermSize(inMsg.Content); ok {
+					s.lastSize = &size
+				}
+			}
+			detached := false
+			if inMsg.MsgType == message.RequestMessage_PLAIN && s.detach != nil {
+				inMsg.Content, detached = s.detach.scan(inMsg.Content)
+			}
+			if err := server.handleRequestMessage(inMsg, s.shell.input, s.shell.resizer); err != nil {
+				log.Errorf("HandleRequest ended: %s", err.Error())
+				s.shell.close()
+				return <-s.shell.done
+			}
+			if detached {
+				// The shell goes on until the client reattaches.
+				return errDetached
+			}
+		}
+	}
+}
+
+// switchSession replaces the shell of s by one in instance instanceNo of the same proc. The
+// authorization of the client is per application, so it holds in every instance. If the
+// instance can't be entered, it returns what to tell the client and the session goes on.
+// It returns an error if the new shell can't be started after the old one exited.
+func (server *EntryServer) switchSession(ctx context.Context, s *enterSession, instanceNo string) (string, error) {
+	info := s.info
+	if !s.switchable {
+		return "Switching instances is not supported in this session.", nil
+	}
+	container, userMsg, err := server.resolveSwitch(*info, instanceNo)
+	if err != nil {
+		info.logger.Errorf("Switch %s[%s-%s] to instance %s failed: %s", info.appName, info.procName, info.instanceNo, instanceNo, err.Error())
+		return fmt.Sprintf("Can't switch to instance %s. %s", instanceNo, userMsg), nil
+	}
+
+	s.shell.close()
+	if err = <-s.shell.done; err != nil {
+		info.logger.Errorf("Exec session failed: %s", err.Error())
+	}
+	s.usage.leave()
+	server.webhook.emit(info.event(eventSessionEnd, "switched to instance "+instanceNo))
+	containerID := container.ID
+	info.instanceNo, info.containerID, info.image = instanceNo, containerID, container.Image
+	if s.registered != nil {
+		server.registry.moved(s.registered, instanceNo, containerID)
+	}
+	info.logger = info.newLogger()
+	// Told between the output of the two shells.
+	server.sendNoticeMessage(s.ws, fmt.Sprintf("Switching to instance %s of %s.", instanceNo, info.procName), s.msgMarshaller)
+
+	if s.shell, err = server.startSession(ctx, s.ws, containerID, info.image, s.termType, info.exec, 0, s.msgMarshaller); err != nil {
+		return "", err
+	}
+	server.webhook.emit(info.event(eventSessionStart, ""))
+	go server.probeShell(ctx, s.ws, s.shell, s.msgMarshaller)
+	s.usage.enter(containerID)
+	if s.lastSize != nil {
+		s.shell.resizer.resize(*s.lastSize)
+	}
+	info.logger.Infof("Entering switched to %s[%s-%s]", info.appName, info.procName, instanceNo)
+	return "", nil
+}
+
+// resolveSwitch finds the container of instance instanceNo of the proc of info, and checks it
+// can be entered. On failure, it also returns what to tell the user.
+func (server *EntryServer) resolveSwitch(info sessionInfo, instanceNo string) (*docker.Container, string, error) {
+	if instanceNo == info.instanceNo {
+		return nil, "You are in it already.", errSwitchToSelf
+	}
+	if err := server.instancePolicy.check(info.appName, instanceNo); err != nil {
+		return nil, "Entering this instance is not allowed.", err
+	}
+	containerID, err := server.resolve(info.appName, info.procName, instanceNo)
+	if err != nil {
+		return nil, "Instance is not found.", err
+	}
+	container, err := server.dockerClient.InspectContainer(containerID)
+	if err == nil {
+		err = server.checkContainerApp(container, info.appName)
+	}
+	if err != nil {
+		return nil, "Instance is not found.", err
+	}
+	if err = checkContainerState(container.State); err != nil {
+		return nil, containerStateMessages[err], err
+	}
+	if server.exclusive.applies(info.appName) && server.registry.entered(containerID) {
+		return nil, "Another session is active in it.", errSessionActive
+	}
+	if len(server.execPrefix) > 0 {
+		if exist, err := server.commandExists(containerID, server.execPrefix[0]); err != nil || !exist {
+			return nil, fmt.Sprintf("Session wrapper %s is not available in it.", server.execPrefix[0]), fmt.Errorf("exec prefix exist=%t, err=%v", exist, err)
+		}
+	}
+	return container, "", nil
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gorilla/websocket"
+	"github.com/laincloud/entry/message"
+)
+
+func TestEnterSwitch(t *testing.T) {
+	var lock sync.Mutex
+	containers := map[string]string{}
+	fake := &fakeDocker{
+		createExec: func(opts docker.CreateExecOptions) (*docker.Exec, error) {
+			lock.Lock()
+			defer lock.Unlock()
+			id := fmt.Sprintf("exec%d", len(containers)+1)
+			containers[id] = opts.Container
+			return &docker.Exec{ID: id}, nil
+		},
+		startExec: func(id string, opts docker.StartExecOptions) (docker.CloseWaiter, error) {
+			lock.Lock()
+			container := containers[id]
+			lock.Unlock()
+			w := &fakeWaiter{done: make(chan struct{})}
+			go func() {
+				fmt.Fprintf(opts.OutputStream, "in %s;", container)
+				// The shell exits at the end of its input.
+				io.Copy(ioutil.Discard, opts.InputStream)
+				close(w.done)
+			}()
+			return w, nil
+		},
+	}
+	resolver := StaticResolver{"hello/web/1": "c1", "hello/web/2": "c2"}
+	server := &EntryServer{dockerClient: fake, authorizer: &FakeAuthorizer{Allow: true}, resolver: resolver}
+	ts := httptest.NewServer(http.HandlerFunc(server.enter))
+	defer ts.Close()
+
+	ws := dialSession(t, ts, "", nil)
+	defer ws.Close()
+	read := func() *message.ResponseMessage {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := &message.ResponseMessage{}
+		if err = protoUnmarshalFunc(data, msg); err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+	switchTo := func(instanceNo string) {
+		data, _ := protoMarshalFunc(&message.RequestMessage{MsgType: message.RequestMessage_SWITCH, Content: []byte(instanceNo)})
+		if err := ws.WriteMessage(websocket.BinaryMessage, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if msg := read(); string(msg.Content) != "in c1;" {
+		t.Fatalf("Output is %q", msg.Content)
+	}
+	switchTo("3")
+	if msg := read(); msg.MsgType != message.ResponseMessage_NOTICE || !strings.Contains(string(msg.Content), "Can't switch to instance 3") {
+		t.Fatalf("Switch to a missing instance: %v %q", msg.MsgType, msg.Content)
+	}
+	switchTo("2")
+	if msg := read(); msg.MsgType != message.ResponseMessage_NOTICE || !strings.Contains(string(msg.Content), "Switching to instance 2") {
+		t.Fatalf("Switch to instance 2: %v %q", msg.MsgType, msg.Content)
+	}
+	if msg := read(); string(msg.Content) != "in c2;" {
+		t.Fatalf("Output after switch is %q", msg.Content)
+	}
+}
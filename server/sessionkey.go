@@ -0,0 +1,36 @@
+package server
+
+import "sync"
+
+// sessionKeys records the keys of the active enter sessions, given by clients so that a
+// retried upgrade never starts a second shell for the same intended session.
+// Keys are scoped by user, one can't block the sessions of another.
+type sessionKeys struct {
+	sync.Mutex
+	active map[string]bool
+}
+
+// acquire marks the session key of user as active, it returns false if it's active already.
+// An empty key is always accepted.
+func (k *sessionKeys) acquire(user, key string) bool {
+	if key == "" {
+		return true
+	}
+	k.Lock()
+	defer k.Unlock()
+	if k.active == nil {
+		k.active = make(map[string]bool)
+	}
+	if k.active[user+"/"+key] {
+		return false
+	}
+	k.active[user+"/"+key] = true
+	return true
+}
+
+// release forgets the session key of user after its session ends.
+func (k *sessionKeys) release(user, key string) {
+	k.Lock()
+	defer k.Unlock()
+	delete(k.active, user+"/"+key)
+}
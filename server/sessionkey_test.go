@@ -0,0 +1,82 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gorilla/websocket"
+	"github.com/laincloud/entry/message"
+)
+
+func TestSessionKeys(t *testing.T) {
+	keys := &sessionKeys{}
+	if !keys.acquire("u1", "k") || keys.acquire("u1", "k") {
+		t.Error("Duplicate key is accepted")
+	}
+	if !keys.acquire("u2", "k") {
+		t.Error("Key of another user is refused")
+	}
+	if !keys.acquire("u1", "") || !keys.acquire("u1", "") {
+		t.Error("Empty key is refused")
+	}
+	keys.release("u1", "k")
+	if !keys.acquire("u1", "k") {
+		t.Error("Released key is refused")
+	}
+}
+
+func TestEnterDuplicateSessionKey(t *testing.T) {
+	var execs int32
+	fake := &fakeDocker{
+		createExec: func(opts docker.CreateExecOptions) (*docker.Exec, error) {
+			atomic.AddInt32(&execs, 1)
+			return &docker.Exec{ID: "exec"}, nil
+		},
+		startExec: func(id string, opts docker.StartExecOptions) (docker.CloseWaiter, error) {
+			w := &fakeWaiter{done: make(chan struct{})}
+			go func() {
+				opts.OutputStream.Write([]byte("$ "))
+				buf := make([]byte, 1)
+				for _, err := opts.InputStream.Read(buf); err == nil; _, err = opts.InputStream.Read(buf) {
+				}
+				close(w.done)
+			}()
+			return w, nil
+		},
+	}
+	server := &EntryServer{dockerClient: fake, authorizer: &FakeAuthorizer{Allow: true}, resolver: StaticResolver{"hello/web/1": "c1"}}
+	ts := httptest.NewServer(http.HandlerFunc(server.enter))
+	defer ts.Close()
+
+	dial := func() (*websocket.Conn, *message.ResponseMessage) {
+		header := http.Header{}
+		header.Set("session-key", "retry-me")
+		ws := dialSession(t, ts, "", header)
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := &message.ResponseMessage{}
+		if err = protoUnmarshalFunc(data, msg); err != nil {
+			t.Fatal(err)
+		}
+		return ws, msg
+	}
+	first, msg := dial()
+	defer first.Close()
+	if msg.MsgType != message.ResponseMessage_STDOUT {
+		t.Fatalf("First session got %v %q", msg.MsgType, msg.Content)
+	}
+	second, msg := dial()
+	defer second.Close()
+	if msg.MsgType != message.ResponseMessage_CLOSE || !strings.Contains(string(msg.Content), "active already") {
+		t.Errorf("Duplicate session got %v %q", msg.MsgType, msg.Content)
+	}
+	if n := atomic.LoadInt32(&execs); n != 1 {
+		t.Errorf("%d execs are created", n)
+	}
+}
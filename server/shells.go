@@ -0,0 +1,166 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/laincloud/entry/log"
+)
+
+// probedShells are the shells looked for in containers, in the order of preference.
+var probedShells = []string{"bash", "zsh", "ash", "sh"}
+
+// nonShells are listed in /etc/shells by some distributions, but are no shells to enter.
+var nonShells = map[string]bool{"false": true, "nologin": true, "screen": true, "tmux": true}
+
+// probeShellsScript prints the paths of the shells given as arguments, then those of
+// /etc/shells which are executable.
+const probeShellsScript = `for s in "$@"; do command -v "$s"; done
+if [ -r /etc/shells ]; then
+	while read -r s; do case "$s" in /*) [ -x "$s" ] && echo "$s";; esac; done < /etc/shells
+fi
+true`
+
+// ContainerShells is the result of probing the shells of a container.
+type ContainerShells struct {
+	Image string `json:"image"`
+	// Shells are the paths of the shells available, the preferred first.
+	Shells []string `json:"shells"`
+}
+
+// shellCacheTTL is how long the shells probed in an image are trusted, a container may
+// install or remove one by itself.
+var shellCacheTTL = 10 * time.Minute
+
+// shellCache records the shells probed per image. Images don't change, so neither do their
+// shells, unless a container installs or removes one by itself, which is caught up with
+// once the entry expires in shellCacheTTL rather than by a probe per request.
+type shellCache struct {
+	sync.Mutex
+	shells map[string]cachedShells
+}
+
+type cachedShells struct {
+	shells   []string
+	probedAt time.Time
+}
+
+func (c *shellCache) get(image string) ([]string, bool) {
+	c.Lock()
+	defer c.Unlock()
+	cached, ok := c.shells[image]
+	if ok && time.Since(cached.probedAt) > shellCacheTTL {
+		delete(c.shells, image)
+		return nil, false
+	}
+	return cached.shells, ok
+}
+
+func (c *shellCache) put(image string, shells []string) {
+	c.Lock()
+	defer c.Unlock()
+	if c.shells == nil {
+		c.shells = make(map[string]cachedShells)
+	}
+	c.shells[image] = cachedShells{shells, time.Now()}
+}
+
+// probeShells returns the paths of probedShells available in the container, by a harmless
+// `command -v` of them all in one exec, followed by the other shells of /etc/shells. An
+// image without sh has no shell to enter anyway.
+func (server *EntryServer) probeShells(containerID string) ([]string, error) {
+	exec, err := server.dockerClient.CreateExec(docker.CreateExecOptions{
+		Container:    containerID,
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd:          append([]string{"sh", "-c", probeShellsScript, "sh"}, probedShells...),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var stdout bytes.Buffer
+	if err = server.dockerClient.StartExec(exec.ID, docker.StartExecOptions{
+		OutputStream: &stdout,
+		ErrorStream:  ioutil.Discard,
+	}); err != nil {
+		return nil, err
+	}
+	inspect, err := server.dockerClient.InspectExec(exec.ID)
+	if err != nil {
+		return nil, err
+	}
+	shells := []string{}
+	if inspect.ExitCode != 0 {
+		return shells, nil
+	}
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		// command -v prints the names of builtins and aliases, which are not shells.
+		if line = strings.TrimSpace(line); strings.HasPrefix(line, "/") && !seen[line] && !nonShells[path.Base(line)] {
+			seen[line] = true
+			shells = append(shells, line)
+		}
+	}
+	return shells, nil
+}
+
+// sessionShell returns the shell of the sessions in containerID of image which don't ask
+// another command. It's server.shell, unless shells are detected and the image lacks it,
+// then it's the preferred shell of the image probed once, with the options of server.shell.
+// Images whose probe fails or finds nothing keep server.shell.
+func (server *EntryServer) sessionShell(containerID, image string) []string {
+	shell := server.shell
+	if len(shell) == 0 {
+		shell = defaultShell
+	}
+	if !server.detectShell || image == "" {
+		return shell
+	}
+	shells, ok := server.shellCache.get(image)
+	if !ok {
+		var err error
+		if shells, err = server.probeShells(containerID); err != nil {
+			// Not cached, the next session probes again.
+			log.Warnf("Probe shells of container %s failed, %s is used: %s", containerID, shell[0], err.Error())
+			return shell
+		}
+		server.shellCache.put(image, shells)
+	}
+	if len(shells) == 0 {
+		return shell
+	}
+	for _, available := range shells {
+		if available == shell[0] {
+			return shell
+		}
+	}
+	return append([]string{shells[0]}, shell[1:]...)
+}
+
+// containerShells serves GET /container/{id}/shells, which reports the shells available
+// in the container, so that clients can pick one working before entering.
+func (server *EntryServer) containerShells(w http.ResponseWriter, container *docker.Container) {
+	if err := checkContainerState(container.State); err != nil {
+		http.Error(w, containerStateMessages[err], http.StatusConflict)
+		return
+	}
+	shells, ok := server.shellCache.get(container.Image)
+	if !ok {
+		var err error
+		if shells, err = server.probeShells(container.ID); err != nil {
+			log.Errorf("Probe shells of container %s failed: %s", container.ID, err.Error())
+			http.Error(w, "Probing shells failed.", http.StatusBadGateway)
+			return
+		}
+		server.shellCache.put(container.Image, shells)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ContainerShells{Image: container.Image, Shells: shells})
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestContainerShells(t *testing.T) {
+	probes := 0
+	server := &EntryServer{
+		authorizer: &FakeAuthorizer{Allow: true},
+		dockerClient: &fakeDocker{
+			inspectContainer: func(id string) (*docker.Container, error) {
+				return &docker.Container{
+					ID:     id,
+					Image:  "sha256:" + id[:1],
+					Config: &docker.Config{Labels: map[string]string{lainLabelPrefix + "pg_name": "hello.web.web"}},
+					State:  docker.State{Running: id != "stopped"},
+				}, nil
+			},
+			startExec: func(id string, opts docker.StartExecOptions) (docker.CloseWaiter, error) {
+				probes++
+				fmt.Fprint(opts.OutputStream, "/bin/bash\n/bin/sh\n")
+				return newFakeWaiter(nil), nil
+			},
+		},
+	}
+	get := func(id string) (int, ContainerShells) {
+		r := httptest.NewRequest("GET", "/container/"+id+"/shells", nil)
+		r.Header.Set("app-name", "hello")
+		w := httptest.NewRecorder()
+		server.containerInfo(w, r)
+		shells := ContainerShells{}
+		json.Unmarshal(w.Body.Bytes(), &shells)
+		return w.Code, shells
+	}
+
+	expected := []string{"/bin/bash", "/bin/sh"}
+	for i, id := range []string{"a1", "a2"} {
+		if code, shells := get(id); code != http.StatusOK || !reflect.DeepEqual(shells.Shells, expected) {
+			t.Errorf("Case %d failed: %d %+v", i+1, code, shells)
+		}
+	}
+	if probes != 1 {
+		t.Errorf("Containers of the same image are probed %d times", probes)
+	}
+	if code, _ := get("stopped"); code != http.StatusConflict {
+		t.Errorf("Stopped container is probed: %d", code)
+	}
+}
+
+func TestSessionShell(t *testing.T) {
+	probes := 0
+	outputs := map[string]string{
+		// Shells of /etc/shells follow those looked for, which they may repeat.
+		"alpine": "/bin/ash\n/bin/sh\n/bin/ash\n/bin/sh\n",
+		"debian": "/bin/bash\n/bin/sh\n/bin/sh\n/bin/bash\n/usr/bin/tmux\n",
+		"fish":   "/bin/sh\n/usr/bin/fish\n/usr/sbin/nologin\n",
+		"none":   "",
+	}
+	server := &EntryServer{
+		shell:       shellCmd(true, false),
+		detectShell: true,
+		dockerClient: &fakeDocker{
+			startExec: func(id string, opts docker.StartExecOptions) (docker.CloseWaiter, error) {
+				probes++
+				if id == "broken" {
+					return nil, &docker.Error{Status: 500, Message: "daemon busy"}
+				}
+				fmt.Fprint(opts.OutputStream, outputs[id])
+				return newFakeWaiter(nil), nil
+			},
+			createExec: func(opts docker.CreateExecOptions) (*docker.Exec, error) {
+				// The image is the container here.
+				return &docker.Exec{ID: opts.Container}, nil
+			},
+		},
+	}
+	cases := []struct {
+		image    string
+		expected []string
+		probes   int
+	}{
+		{"alpine", []string{"/bin/ash", "-l"}, 1},
+		{"alpine", []string{"/bin/ash", "-l"}, 1},
+		{"debian", []string{"/bin/bash", "-l"}, 2},
+		{"fish", []string{"/bin/sh", "-l"}, 3},
+		{"none", []string{"/bin/bash", "-l"}, 4},
+		// Failed probes are not cached.
+		{"broken", []string{"/bin/bash", "-l"}, 5},
+		{"broken", []string{"/bin/bash", "-l"}, 6},
+		{"", []string{"/bin/bash", "-l"}, 6},
+	}
+	for i, c := range cases {
+		if actual := server.sessionShell(c.image, c.image); !reflect.DeepEqual(actual, c.expected) || probes != c.probes {
+			t.Errorf("Case %d failed: actual is %v, probed %d times", i+1, actual, probes)
+		}
+	}
+	if shells, _ := server.shellCache.get("debian"); !reflect.DeepEqual(shells, []string{"/bin/bash", "/bin/sh"}) {
+		t.Errorf("Shells of debian are %v", shells)
+	}
+	server.detectShell = false
+	if actual := server.sessionShell("fish", "fish"); !reflect.DeepEqual(actual, []string{"/bin/bash", "-l"}) || probes != 6 {
+		t.Errorf("Shell is detected though disabled: %v", actual)
+	}
+
+	// Expired shells are probed again.
+	server.detectShell = true
+	shellCacheTTL = 0
+	defer func() { shellCacheTTL = 10 * time.Minute }()
+	if actual := server.sessionShell("alpine", "alpine"); !reflect.DeepEqual(actual, []string{"/bin/ash", "-l"}) || probes != 7 {
+		t.Errorf("Expired shells: actual is %v, probed %d times", actual, probes)
+	}
+}
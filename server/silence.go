@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/laincloud/entry/log"
+)
+
+// defaultAttachSilence is how long an attach may show nothing before the client is told why.
+const defaultAttachSilence = 3 * time.Second
+
+// outputWatch marks once anything is written through it.
+type outputWatch struct {
+	io.WriteCloser
+	seen *int32
+}
+
+func (w outputWatch) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		atomic.StoreInt32(w.seen, 1)
+	}
+	return w.WriteCloser.Write(p)
+}
+
+// probeShell writes a newline to shell if it shows nothing in server.shellProbe once
+// started, and tells the client if it still shows nothing in server.shellProbe after. A shell
+// started in a broken image may hang silently, which leaves the client with a blank screen
+// and no clue. It's off by default, as it injects a keystroke into the shell.
+func (server *EntryServer) probeShell(ctx context.Context, ws *safeConn, shell *execSession, msgMarshaller Marshaler) {
+	if server.shellProbe <= 0 || !server.waitSilentShell(ctx, shell) {
+		return
+	}
+	if err := shell.input.write([]byte("\n")); err != nil {
+		return
+	}
+	if server.waitSilentShell(ctx, shell) {
+		server.sendNoticeMessage(ws, "The shell shows nothing, it may be broken in this image.", msgMarshaller)
+	}
+}
+
+// waitSilentShell waits server.shellProbe, and tells if shell has shown nothing yet.
+func (server *EntryServer) waitSilentShell(ctx context.Context, shell *execSession) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(server.shellProbe):
+	}
+	return atomic.LoadInt32(&shell.seen) == 0
+}
+
+// watchSilence tells the client why an attach shows nothing, if no output is seen in
+// server.attachSilence. A container may be idle, log to files or have its output filtered
+// away, which is indistinguishable from a broken stream on a blank screen. It's only advice,
+// the attach goes on.
+func (server *EntryServer) watchSilence(ctx context.Context, ws *safeConn, containerID string, seen *int32, filtered bool, msgMarshaller Marshaler) {
+	if server.attachSilence <= 0 {
+		return
+	}
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(server.attachSilence):
+	}
+	if atomic.LoadInt32(seen) == 1 {
+		return
+	}
+	var notice string
+	container, err := server.dockerClient.InspectContainer(containerID)
+	switch {
+	case err != nil:
+		log.Errorf("Inspect silent container %s failed: %s", containerID, err.Error())
+		return
+	case checkContainerState(container.State) != nil:
+		notice = fmt.Sprintf("Container %s is %s, there is nothing to show.", containerID, container.State.StateString())
+	case filtered:
+		notice = fmt.Sprintf("No output of container %s matches the filter yet.", containerID)
+	default:
+		notice = fmt.Sprintf("Container %s has no output yet, it may be idle or write its logs to files instead.", containerID)
+	}
+	server.sendNoticeMessage(ws, notice, msgMarshaller)
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/laincloud/entry/message"
+)
+
+func TestAttachSilence(t *testing.T) {
+	for i, c := range []struct {
+		output string
+		query  string
+		notice string
+	}{
+		{"", "", "has no output yet"},
+		{"hello\n", "?filter=world", "No output of container c1 matches the filter"},
+		{"hello\n", "", ""},
+	} {
+		fake := &fakeDocker{
+			attach: func(opts docker.AttachToContainerOptions) (docker.CloseWaiter, error) {
+				opts.OutputStream.Write([]byte(c.output))
+				return &fakeWaiter{done: make(chan struct{})}, nil
+			},
+		}
+		server := &EntryServer{dockerClient: fake, authorizer: &FakeAuthorizer{Allow: true}, resolver: StaticResolver{"hello/web/1": "c1"}, attachSilence: 10 * time.Millisecond}
+		ts := httptest.NewServer(http.HandlerFunc(server.attach))
+
+		ws := dialSession(t, ts, c.query, nil)
+		notice := ""
+		ws.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		for {
+			_, data, err := ws.ReadMessage()
+			if err != nil {
+				break
+			}
+			msg := message.ResponseMessage{}
+			if err = protoUnmarshalFunc(data, &msg); err == nil && msg.MsgType == message.ResponseMessage_NOTICE {
+				notice = string(msg.Content)
+			}
+		}
+		if (c.notice == "") != (notice == "") || !strings.Contains(notice, c.notice) {
+			t.Errorf("Case %d failed: %q", i+1, notice)
+		}
+		ws.Close()
+		ts.Close()
+	}
+}
+
+func TestProbeShell(t *testing.T) {
+	for i, c := range []struct {
+		// prompt is what the shell shows once started, answer what it shows on the newline
+		// of the probe.
+		prompt, answer string
+		probed, notice bool
+	}{
+		{"", "$ ", true, false},
+		{"", "", true, true},
+		// A shell showing its prompt is left alone.
+		{"$ ", "", false, false},
+	} {
+		probed := make(chan struct{}, 1)
+		fake := &fakeDocker{
+			startExec: func(id string, opts docker.StartExecOptions) (docker.CloseWaiter, error) {
+				w := &fakeWaiter{done: make(chan struct{})}
+				go func() {
+					defer close(w.done)
+					opts.OutputStream.Write([]byte(c.prompt))
+					buf := make([]byte, 16)
+					for {
+						n, err := opts.InputStream.Read(buf)
+						if err != nil {
+							return
+						}
+						if string(buf[:n]) == "\n" {
+							probed <- struct{}{}
+							opts.OutputStream.Write([]byte(c.answer))
+						}
+					}
+				}()
+				return w, nil
+			},
+		}
+		server := &EntryServer{dockerClient: fake, authorizer: &FakeAuthorizer{Allow: true}, resolver: StaticResolver{"hello/web/1": "c1"}, shellProbe: 10 * time.Millisecond}
+		ts := httptest.NewServer(http.HandlerFunc(server.enter))
+
+		ws := dialSession(t, ts, "", nil)
+		notice := false
+		ws.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		for {
+			_, data, err := ws.ReadMessage()
+			if err != nil {
+				break
+			}
+			msg := message.ResponseMessage{}
+			if err = protoUnmarshalFunc(data, &msg); err == nil && msg.MsgType == message.ResponseMessage_NOTICE {
+				notice = strings.Contains(string(msg.Content), "The shell shows nothing")
+			}
+		}
+		if notice != c.notice || (len(probed) == 1) != c.probed {
+			t.Errorf("Case %d failed: notice is %t, probed is %t", i+1, notice, len(probed) == 1)
+		}
+		ws.Close()
+		ts.Close()
+	}
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+const sshScheme = "ssh://"
+
+// sshDockerHost is the placeholder address used in requests to a docker daemon reached
+// over SSH, the connection itself is always made by sshDialer.
+const sshDockerHost = "tcp://docker.ssh:2375"
+
+var errSSHEndpoint = errors.New("ssh endpoint must look like ssh://[user@]host[:port]")
+
+// newDockerClient creates a docker client for endpoint. Besides the endpoints supported by
+// go-dockerclient, ssh://[user@]host[:port] reaches the remote daemon through the ssh command,
+// authenticated with the private key at sshKeyPath if it's not empty.
+func newDockerClient(endpoint, sshKeyPath string) (*docker.Client, error) {
+	if !strings.HasPrefix(endpoint, sshScheme) {
+		return docker.NewClient(endpoint)
+	}
+	dialer, err := newSSHDialer(endpoint, sshKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	client, err := docker.NewClient(sshDockerHost)
+	if err != nil {
+		return nil, err
+	}
+	client.Dialer = dialer
+	client.HTTPClient = &http.Client{
+		Transport: &http.Transport{
+			Dial: dialer.Dial,
+			// Every connection costs an ssh process, so keep a few of them around.
+			MaxIdleConnsPerHost: 4,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+	return client, nil
+}
+
+// sshDialer connects to a remote docker daemon by running `docker system dial-stdio`
+// over ssh, which requires docker 18.09 or later on the remote host.
+type sshDialer struct {
+	args []string
+}
+
+func newSSHDialer(endpoint, keyPath string) (*sshDialer, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Hostname() == "" || (u.Path != "" && u.Path != "/") {
+		return nil, errSSHEndpoint
+	}
+	args := []string{"-o", "BatchMode=yes"}
+	if keyPath != "" {
+		args = append(args, "-i", keyPath)
+	}
+	if port := u.Port(); port != "" {
+		args = append(args, "-p", port)
+	}
+	host := u.Hostname()
+	if u.User != nil {
+		host = u.User.Username() + "@" + host
+	}
+	args = append(args, "--", host, "docker", "system", "dial-stdio")
+	return &sshDialer{args: args}, nil
+}
+
+// Dial ignores network and address, every connection goes to the daemon behind the ssh host.
+func (d *sshDialer) Dial(network, address string) (net.Conn, error) {
+	cmd := exec.Command("ssh", d.args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &sshConn{cmd: cmd, stdin: stdin, stdout: stdout}, nil
+}
+
+// sshConn is a net.Conn over the standard streams of an ssh process.
+// Deadlines are not supported and are silently ignored.
+type sshConn struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+}
+
+func (c *sshConn) Read(b []byte) (int, error)  { return c.stdout.Read(b) }
+func (c *sshConn) Write(b []byte) (int, error) { return c.stdin.Write(b) }
+
+// CloseWrite is required by hijacked connections to signal the end of stdin.
+func (c *sshConn) CloseWrite() error { return c.stdin.Close() }
+
+func (c *sshConn) Close() error {
+	c.stdin.Close()
+	if c.cmd.Process != nil {
+		c.cmd.Process.Kill()
+	}
+	return c.cmd.Wait()
+}
+
+func (c *sshConn) LocalAddr() net.Addr                { return sshAddr("local") }
+func (c *sshConn) RemoteAddr() net.Addr               { return sshAddr(strings.Join(c.cmd.Args, " ")) }
+func (c *sshConn) SetDeadline(t time.Time) error      { return nil }
+func (c *sshConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *sshConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type sshAddr string
+
+func (a sshAddr) Network() string { return "ssh" }
+func (a sshAddr) String() string  { return string(a) }
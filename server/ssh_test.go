@@ -0,0 +1,41 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewSSHDialer(t *testing.T) {
+	cases := []struct {
+		endpoint string
+		keyPath  string
+		expected []string
+	}{
+		{"ssh://host", "", []string{"-o", "BatchMode=yes", "--", "host", "docker", "system", "dial-stdio"}},
+		{"ssh://deploy@host:2222", "/etc/entry/id_rsa", []string{"-o", "BatchMode=yes", "-i", "/etc/entry/id_rsa", "-p", "2222", "--", "deploy@host", "docker", "system", "dial-stdio"}},
+		{"ssh://", "", nil},
+		{"ssh://host/var/run/docker.sock", "", nil},
+	}
+	for i, c := range cases {
+		dialer, err := newSSHDialer(c.endpoint, c.keyPath)
+		if c.expected == nil {
+			if err == nil {
+				t.Errorf("Case %d failed: error expected", i+1)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(dialer.args, c.expected) {
+			t.Errorf("Case %d failed: actual is %v, %v", i+1, dialer, err)
+		}
+	}
+}
+
+func TestNewDockerClientSSH(t *testing.T) {
+	client, err := newDockerClient("ssh://deploy@host", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := client.Dialer.(*sshDialer); !ok {
+		t.Errorf("Dialer is %T", client.Dialer)
+	}
+}
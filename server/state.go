@@ -0,0 +1,87 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/laincloud/entry/log"
+)
+
+// ServerState is the state of this server told by /debug/state, to diagnose leaked
+// goroutines and stuck sessions in production.
+type ServerState struct {
+	Goroutines int `json:"goroutines"`
+	// Served counts the sessions being served by kind, including those not registered yet
+	// as they are still authorizing or resolving.
+	Served map[string]int64 `json:"served"`
+	// Sessions are the registered sessions, the oldest first.
+	Sessions []SessionState `json:"sessions"`
+}
+
+// SessionState is a registered session with its user, age and activity.
+type SessionState struct {
+	ActiveSession
+	User       string    `json:"user,omitempty"`
+	Age        float64   `json:"age_seconds"`
+	LastActive time.Time `json:"last_active"`
+	// Idle is the time since the last message of the client or output of the container.
+	Idle float64 `json:"idle_seconds"`
+}
+
+// served returns the number of the sessions being served by kind.
+func (m *sessionMetrics) served() map[string]int64 {
+	m.Lock()
+	defer m.Unlock()
+	served := make(map[string]int64)
+	for kind, count := range m.active {
+		served[kind] = count
+	}
+	return served
+}
+
+// state returns the state of the server at now.
+func (server *EntryServer) state(now time.Time) ServerState {
+	state := ServerState{
+		Goroutines: runtime.NumGoroutine(),
+		Served:     server.metrics.served(),
+		Sessions:   []SessionState{},
+	}
+	for _, s := range server.registry.list() {
+		lastActive := time.Unix(0, atomic.LoadInt64(&s.lastActive))
+		state.Sessions = append(state.Sessions, SessionState{
+			ActiveSession: server.registry.active(s),
+			User:          s.user,
+			Age:           now.Sub(s.Started).Seconds(),
+			LastActive:    lastActive,
+			Idle:          now.Sub(lastActive).Seconds(),
+		})
+	}
+	return state
+}
+
+// serveState serves GET /debug/state to the clients authorized on server.stateApp with the
+// state feature, as the users of the sessions are sensitive.
+func (server *EntryServer) serveState(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method is not allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+	role, identity, err := server.authorize(r, r.Header.Get("access-token"), server.stateApp)
+	if err != nil {
+		log.Errorf("Authorization for server state failed: %s", err.Error())
+		server.auditRequestDenied(r, "state", server.stateApp, "", err)
+		http.Error(w, "Authorization failed.", http.StatusForbidden)
+		return
+	}
+	if !server.roles.allows(role, "state") {
+		log.Warnf("Server state refused to %q: role %q has no state feature", identity, role)
+		http.Error(w, "Server state is not allowed.", http.StatusForbidden)
+		return
+	}
+	log.Infof("Server state is read by %q", identity)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(server.state(time.Now()))
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestServeState(t *testing.T) {
+	server := &EntryServer{authorizer: &FakeAuthorizer{Tokens: map[string]string{"admin": "admin", "dev": "developer"}}, stateApp: "entry"}
+	_, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server.metrics.begin("enter")
+	server.metrics.begin("enter")
+	session := server.registry.add(sessionInfo{kind: "enter", appName: "hello", containerID: "c1", user: "alice"}, cancel)
+	defer server.registry.remove(session)
+	atomic.StoreInt64(&session.lastActive, session.Started.Add(-time.Minute).UnixNano())
+
+	cases := []struct {
+		method string
+		token  string
+		status int
+	}{
+		{http.MethodGet, "admin", http.StatusOK},
+		{http.MethodGet, "dev", http.StatusForbidden},
+		{http.MethodGet, "unknown", http.StatusForbidden},
+		{http.MethodPost, "admin", http.StatusMethodNotAllowed},
+	}
+	for i, c := range cases {
+		r := httptest.NewRequest(c.method, "/debug/state", nil)
+		r.Header.Set("access-token", c.token)
+		w := httptest.NewRecorder()
+		server.serveState(w, r)
+		if w.Code != c.status {
+			t.Errorf("Case %d failed: status is %d", i+1, w.Code)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		var state ServerState
+		if err := json.NewDecoder(w.Body).Decode(&state); err != nil {
+			t.Fatal(err)
+		}
+		if state.Goroutines <= 0 || state.Served["enter"] != 2 || len(state.Sessions) != 1 {
+			t.Fatalf("Case %d failed: state is %+v", i+1, state)
+		}
+		if s := state.Sessions[0]; s.ID != session.ID || s.User != "alice" || s.Container != "c1" || s.Idle < 60 || s.Age < 0 {
+			t.Errorf("Case %d failed: session is %+v", i+1, s)
+		}
+	}
+}
+
+func TestSafeConnTouch(t *testing.T) {
+	c := &safeConn{}
+	// Nothing is recorded before the session is registered.
+	c.touch()
+	var activity int64
+	c.activity = &activity
+	before := time.Now().UnixNano()
+	c.touch()
+	if actual := atomic.LoadInt64(&activity); actual < before {
+		t.Errorf("Activity is %d, before %d", actual, before)
+	}
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gorilla/websocket"
+	"github.com/laincloud/entry/log"
+	"github.com/laincloud/entry/message"
+)
+
+// maxTabs bounds the tabs opened in a session besides its first shell.
+const maxTabs = 8
+
+// sessionTab is the shell of a tab, with the info of the container it's in.
+type sessionTab struct {
+	shell *execSession
+	info  sessionInfo
+	// registered is the tab in the registry when it's in another container than its
+	// session, cancel closes its shell then.
+	registered *registeredSession
+	cancel     context.CancelFunc
+}
+
+// tabExit is the result of the shell of a tab.
+type tabExit struct {
+	tab uint32
+	err error
+}
+
+// A session hosts more shells than the one it started with in tabs, numbered by the client.
+// An OPEN_TAB request starts the shell of a tab, in the container of the session or in
+// another instance of its proc, and the PLAIN and WINCH requests of the tab go to it. The
+// output of a tab is tagged with its number, and a CLOSE of the tab tells when its shell
+// exits, the session goes on. Tabs are closed when the client goes away, they never
+// survive detaching.
+func (server *EntryServer) handleTabRequest(ctx context.Context, s *enterSession, inMsg *message.RequestMessage) {
+	switch inMsg.MsgType {
+	case message.RequestMessage_OPEN_TAB:
+		if refusal := server.openTab(ctx, s, inMsg.Tab, string(inMsg.Content)); refusal != "" {
+			server.sendNoticeMessage(s.ws, refusal, s.msgMarshaller)
+		}
+	case message.RequestMessage_CLOSE_TAB:
+		if t, ok := s.tabs[inMsg.Tab]; ok {
+			// The tab is forgotten once its shell exits.
+			t.shell.close()
+		}
+	default:
+		t, ok := s.tabs[inMsg.Tab]
+		if !ok {
+			log.Debugf("Request of unknown tab %d dropped", inMsg.Tab)
+			return
+		}
+		if err := server.handleRequestMessage(inMsg, t.shell.input, t.shell.resizer); err != nil {
+			t.info.logger.Errorf("HandleRequest of tab %d ended: %s", inMsg.Tab, err.Error())
+			t.shell.close()
+		}
+	}
+}
+
+// openTab starts the shell of tab in instance instanceNo, or in the container of s if it's
+// empty or the instance of s. If the tab can't be opened, it returns what to tell the client.
+func (server *EntryServer) openTab(ctx context.Context, s *enterSession, tab uint32, instanceNo string) string {
+	if tab == 0 {
+		return "Tab 0 is the shell of the session."
+	}
+	if _, ok := s.tabs[tab]; ok {
+		return fmt.Sprintf("Tab %d is open already.", tab)
+	}
+	if len(s.tabs) >= maxTabs {
+		return fmt.Sprintf("Can't open more than %d tabs.", maxTabs)
+	}
+	info := *s.info
+	if instanceNo != "" && instanceNo != info.instanceNo {
+		if !s.switchable {
+			return "Tabs of other instances are not supported in this session."
+		}
+		container, userMsg, err := server.resolveSwitch(info, instanceNo)
+		if err != nil {
+			info.logger.Errorf("Open tab %d in %s[%s-%s] failed: %s", tab, info.appName, info.procName, instanceNo, err.Error())
+			return fmt.Sprintf("Can't open tab %d in instance %s. %s", tab, instanceNo, userMsg)
+		}
+		info.instanceNo, info.containerID, info.image = instanceNo, container.ID, container.Image
+		info.logger = info.newLogger()
+	}
+	t := &sessionTab{info: info}
+	tabCtx := ctx
+	// A tab in another container is a session there, its user sees it in the registry and
+	// the exclusive sessions of the container keep it out.
+	if info.containerID != s.info.containerID {
+		tabCtx, t.cancel = context.WithCancel(ctx)
+		if server.exclusive.applies(info.appName) {
+			t.registered = server.registry.addExclusive(info, t.cancel)
+		} else {
+			t.registered = server.registry.add(info, t.cancel)
+		}
+		if t.registered == nil {
+			t.cancel()
+			info.logger.Errorf("Open tab %d in %s refused: %s", tab, info.containerID, errSessionActive.Error())
+			return fmt.Sprintf("Can't open tab %d in instance %s. Another session is active in it.", tab, instanceNo)
+		}
+	}
+	shell, err := server.startSession(ctx, s.ws, info.containerID, info.image, s.termType, info.exec, tab, s.msgMarshaller)
+	if err != nil {
+		server.unregisterTab(t)
+		info.logger.Errorf("Open tab %d failed: %s", tab, err.Error())
+		return fmt.Sprintf("Can't open tab %d, try again.", tab)
+	}
+	if t.registered != nil {
+		go func() {
+			<-tabCtx.Done()
+			shell.close()
+		}()
+	}
+	if s.tabs == nil {
+		s.tabs = make(map[uint32]*sessionTab)
+		s.tabExits = make(chan tabExit, maxTabs)
+	}
+	t.shell = shell
+	s.tabs[tab] = t
+	server.webhook.emit(info.event(eventSessionStart, ""))
+	info.logger.Infof("Tab %d opened in %s", tab, info.containerID)
+	go func() {
+		s.tabExits <- tabExit{tab: tab, err: <-shell.done}
+	}()
+	return ""
+}
+
+// endTab forgets a tab whose shell exited, and tells the client with a CLOSE of the tab.
+func (server *EntryServer) endTab(s *enterSession, exit tabExit) {
+	t := s.tabs[exit.tab]
+	delete(s.tabs, exit.tab)
+	t.shell.close()
+	server.unregisterTab(t)
+	info := t.info
+	closeMsg := &message.ResponseMessage{
+		MsgType: message.ResponseMessage_CLOSE,
+		Content: []byte(fmt.Sprintf("Tab %d exited.", exit.tab)),
+		Reason:  closeReasonExited,
+		Tab:     exit.tab,
+	}
+	if exit.err != nil {
+		info.logger.Errorf("Shell of tab %d failed: %s", exit.tab, exit.err.Error())
+		closeMsg.Reason = exit.err.Error()
+	} else if inspect, err := server.dockerClient.InspectExec(t.shell.execID); err == nil {
+		closeMsg.ExitCode = int32(inspect.ExitCode)
+	}
+	if closeData, err := s.msgMarshaller(closeMsg); err != nil {
+		log.Errorf("Marshal close message failed: %s", err.Error())
+	} else {
+		s.ws.WriteMessage(websocket.BinaryMessage, closeData)
+	}
+	server.webhook.emit(info.event(eventSessionEnd, "tab "+closeMsg.Reason))
+}
+
+// closeTabs ends the shells of every tab of s and waits for them.
+func (server *EntryServer) closeTabs(s *enterSession) {
+	for _, t := range s.tabs {
+		t.shell.close()
+	}
+	for len(s.tabs) > 0 {
+		exit := <-s.tabExits
+		t := s.tabs[exit.tab]
+		server.unregisterTab(t)
+		server.webhook.emit(t.info.event(eventSessionEnd, "tab closed"))
+		delete(s.tabs, exit.tab)
+	}
+}
+
+// unregisterTab removes t from the registry, if it's in it.
+func (server *EntryServer) unregisterTab(t *sessionTab) {
+	if t.registered != nil {
+		t.cancel()
+		server.registry.remove(t.registered)
+	}
+}
+
+// isTabRequest reports whether inMsg is for the tabs rather than the first shell.
+func isTabRequest(inMsg *message.RequestMessage) bool {
+	switch inMsg.MsgType {
+	case message.RequestMessage_OPEN_TAB, message.RequestMessage_CLOSE_TAB:
+		return true
+	case message.RequestMessage_PLAIN, message.RequestMessage_WINCH:
+		return inMsg.Tab != 0
+	}
+	return false
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gorilla/websocket"
+	"github.com/laincloud/entry/message"
+)
+
+func TestIsTabRequest(t *testing.T) {
+	cases := []struct {
+		msg message.RequestMessage
+		tab bool
+	}{
+		{message.RequestMessage{MsgType: message.RequestMessage_PLAIN}, false},
+		{message.RequestMessage{MsgType: message.RequestMessage_PLAIN, Tab: 1}, true},
+		{message.RequestMessage{MsgType: message.RequestMessage_WINCH, Tab: 2}, true},
+		{message.RequestMessage{MsgType: message.RequestMessage_OPEN_TAB}, true},
+		{message.RequestMessage{MsgType: message.RequestMessage_CLOSE_TAB, Tab: 1}, true},
+		{message.RequestMessage{MsgType: message.RequestMessage_SWITCH, Tab: 1}, false},
+		{message.RequestMessage{MsgType: message.RequestMessage_QUIT, Tab: 1}, false},
+	}
+	for i, c := range cases {
+		if actual := isTabRequest(&c.msg); actual != c.tab {
+			t.Errorf("Case %d failed: actual is %t", i+1, actual)
+		}
+	}
+}
+
+func TestEnterTabs(t *testing.T) {
+	var lock sync.Mutex
+	containers := map[string]string{}
+	live := 0
+	fake := &fakeDocker{
+		createExec: func(opts docker.CreateExecOptions) (*docker.Exec, error) {
+			lock.Lock()
+			defer lock.Unlock()
+			id := fmt.Sprintf("exec%d", len(containers)+1)
+			containers[id] = opts.Container
+			return &docker.Exec{ID: id}, nil
+		},
+		startExec: func(id string, opts docker.StartExecOptions) (docker.CloseWaiter, error) {
+			lock.Lock()
+			container := containers[id]
+			live++
+			lock.Unlock()
+			w := &fakeWaiter{done: make(chan struct{})}
+			go func() {
+				fmt.Fprintf(opts.OutputStream, "%s in %s;", id, container)
+				// The shell echoes its input, and exits at its end.
+				buf := make([]byte, 64)
+				for {
+					n, err := opts.InputStream.Read(buf)
+					if err != nil {
+						break
+					}
+					fmt.Fprintf(opts.OutputStream, "%s:%s;", id, buf[:n])
+				}
+				lock.Lock()
+				live--
+				lock.Unlock()
+				close(w.done)
+			}()
+			return w, nil
+		},
+		inspectExec: func(id string) (*docker.ExecInspect, error) {
+			return &docker.ExecInspect{ID: id, ExitCode: 3}, nil
+		},
+	}
+	resolver := StaticResolver{"hello/web/1": "c1", "hello/web/2": "c2", "hello/web/4": "c4"}
+	server := &EntryServer{dockerClient: fake, authorizer: &FakeAuthorizer{Allow: true}, resolver: resolver, exclusive: exclusivePolicy{"hello"}}
+	ts := httptest.NewServer(http.HandlerFunc(server.enter))
+	defer ts.Close()
+	// Another session is in instance 4.
+	other := server.registry.add(sessionInfo{kind: "enter", appName: "hello", containerID: "c4"}, func() {})
+	defer server.registry.remove(other)
+	// registered lists the containers of the sessions in the registry.
+	registered := func() string {
+		var entered []string
+		for _, s := range server.registry.list() {
+			entered = append(entered, s.Container)
+		}
+		sort.Strings(entered)
+		return strings.Join(entered, ",")
+	}
+
+	ws := dialSession(t, ts, "", nil)
+	send := func(msg *message.RequestMessage) {
+		data, _ := protoMarshalFunc(msg)
+		if err := ws.WriteMessage(websocket.BinaryMessage, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// expect finds a message of tab with content, among those read before as the output of
+	// the tabs interleaves.
+	var read []*message.ResponseMessage
+	expect := func(tab uint32, msgType message.ResponseMessage_ResponseType, content string) *message.ResponseMessage {
+		for i := 0; ; i++ {
+			if i == len(read) {
+				_, data, err := ws.ReadMessage()
+				if err != nil {
+					t.Fatalf("No %v %q of tab %d: %s", msgType, content, tab, err.Error())
+				}
+				msg := &message.ResponseMessage{}
+				protoUnmarshalFunc(data, msg)
+				read = append(read, msg)
+			}
+			if msg := read[i]; msg.Tab == tab && msg.MsgType == msgType && strings.Contains(string(msg.Content), content) {
+				read = append(read[:i], read[i+1:]...)
+				return msg
+			}
+		}
+	}
+
+	expect(0, message.ResponseMessage_STDOUT, "exec1 in c1;")
+	send(&message.RequestMessage{MsgType: message.RequestMessage_OPEN_TAB, Tab: 1})
+	expect(1, message.ResponseMessage_STDOUT, "exec2 in c1;")
+	send(&message.RequestMessage{MsgType: message.RequestMessage_OPEN_TAB, Tab: 2, Content: []byte("2")})
+	expect(2, message.ResponseMessage_STDOUT, "exec3 in c2;")
+	send(&message.RequestMessage{MsgType: message.RequestMessage_OPEN_TAB, Tab: 2})
+	expect(0, message.ResponseMessage_NOTICE, "Tab 2 is open already.")
+	send(&message.RequestMessage{MsgType: message.RequestMessage_OPEN_TAB, Tab: 3, Content: []byte("3")})
+	expect(0, message.ResponseMessage_NOTICE, "Can't open tab 3 in instance 3.")
+	send(&message.RequestMessage{MsgType: message.RequestMessage_OPEN_TAB, Tab: 4, Content: []byte("4")})
+	expect(0, message.ResponseMessage_NOTICE, "Can't open tab 4 in instance 4. Another session is active in it.")
+	// Tabs in other containers are registered there.
+	if actual := registered(); actual != "c1,c2,c4" {
+		t.Errorf("Sessions are in %s", actual)
+	}
+
+	// The input of each tab goes to its own shell.
+	send(&message.RequestMessage{MsgType: message.RequestMessage_PLAIN, Tab: 2, Content: []byte("b")})
+	send(&message.RequestMessage{MsgType: message.RequestMessage_PLAIN, Tab: 1, Content: []byte("a")})
+	send(&message.RequestMessage{MsgType: message.RequestMessage_PLAIN, Content: []byte("z")})
+	expect(2, message.ResponseMessage_STDOUT, "exec3:b;")
+	expect(1, message.ResponseMessage_STDOUT, "exec2:a;")
+	expect(0, message.ResponseMessage_STDOUT, "exec1:z;")
+
+	// Closing a tab only ends its shell.
+	send(&message.RequestMessage{MsgType: message.RequestMessage_CLOSE_TAB, Tab: 1})
+	if msg := expect(1, message.ResponseMessage_CLOSE, "Tab 1 exited."); msg.Reason != closeReasonExited || msg.ExitCode != 3 {
+		t.Errorf("Close of tab 1 is %q with code %d", msg.Reason, msg.ExitCode)
+	}
+	send(&message.RequestMessage{MsgType: message.RequestMessage_PLAIN, Tab: 2, Content: []byte("c")})
+	expect(2, message.ResponseMessage_STDOUT, "exec3:c;")
+	send(&message.RequestMessage{MsgType: message.RequestMessage_CLOSE_TAB, Tab: 2})
+	expect(2, message.ResponseMessage_CLOSE, "Tab 2 exited.")
+	if actual := registered(); actual != "c1,c4" {
+		t.Errorf("Sessions are in %s after tab 2 is closed", actual)
+	}
+
+	// Every shell ends with the connection.
+	ws.Close()
+	server.sessions.Wait()
+	lock.Lock()
+	defer lock.Unlock()
+	if live != 0 {
+		t.Errorf("%d shells are still running", live)
+	}
+}
@@ -0,0 +1,291 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gorilla/websocket"
+	"github.com/laincloud/entry/message"
+)
+
+func TestEnterDeliversLastOutput(t *testing.T) {
+	execs := 0
+	fake := &fakeDocker{
+		// Sessions may overlap, so every exec has its own ID.
+		createExec: func(opts docker.CreateExecOptions) (*docker.Exec, error) {
+			execs++
+			return &docker.Exec{ID: fmt.Sprintf("exec%d", execs)}, nil
+		},
+		startExec: func(id string, opts docker.StartExecOptions) (docker.CloseWaiter, error) {
+			w := &fakeWaiter{done: make(chan struct{})}
+			go func() {
+				fmt.Fprint(opts.OutputStream, "last words世")
+				close(w.done)
+			}()
+			return w, nil
+		},
+	}
+	server := &EntryServer{dockerClient: fake, authorizer: &FakeAuthorizer{Allow: true}, resolver: StaticResolver{"hello/web/1": "c1"}}
+	ts := httptest.NewServer(http.HandlerFunc(server.enter))
+	defer ts.Close()
+
+	header := http.Header{}
+	header.Set("app-name", "hello")
+	header.Set("proc-name", "web")
+	header.Set("instance-no", "1")
+	for i := 0; i < 20; i++ {
+		ws, _, err := websocket.DefaultDialer.Dial(strings.Replace(ts.URL, "http", "ws", 1), header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var output string
+		for {
+			_, data, err := ws.ReadMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			msg := message.ResponseMessage{}
+			if err = protoUnmarshalFunc(data, &msg); err != nil {
+				t.Fatal(err)
+			}
+			if msg.MsgType == message.ResponseMessage_CLOSE {
+				break
+			}
+			output += string(msg.Content)
+		}
+		ws.Close()
+		if output != "last words世" {
+			t.Fatalf("Round %d failed: output before CLOSE is %q", i+1, output)
+		}
+	}
+}
+
+func TestEnterTeardown(t *testing.T) {
+	for i, c := range []struct {
+		trigger string
+		// execEnds makes the shell exit by itself, otherwise it exits at the end of its input.
+		execEnds bool
+	}{
+		{"disconnect", false},
+		{"exec end", true},
+		{"shutdown", false},
+	} {
+		baseline := runtime.NumGoroutine()
+		fake := &fakeDocker{
+			startExec: func(id string, opts docker.StartExecOptions) (docker.CloseWaiter, error) {
+				w := &fakeWaiter{done: make(chan struct{})}
+				go func() {
+					fmt.Fprint(opts.OutputStream, "$ ")
+					if !c.execEnds {
+						io.Copy(ioutil.Discard, opts.InputStream)
+					}
+					close(w.done)
+				}()
+				return w, nil
+			},
+		}
+		server := &EntryServer{dockerClient: fake, authorizer: &FakeAuthorizer{Allow: true}, resolver: StaticResolver{"hello/web/1": "c1"},
+			closeGrace: 100 * time.Millisecond}
+		ctx, shutdown := context.WithCancel(context.Background())
+		returned := make(chan struct{})
+		ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			server.enter(w, r)
+			close(returned)
+		}))
+		ts.Config.BaseContext = func(net.Listener) context.Context { return ctx }
+		ts.Start()
+
+		ws := dialSession(t, ts, "", nil)
+		if _, _, err := ws.ReadMessage(); err != nil {
+			t.Fatalf("Case %d failed: %v", i+1, err)
+		}
+		switch c.trigger {
+		case "disconnect":
+			ws.Close()
+		case "shutdown":
+			shutdown()
+		}
+		// Without the client going away, the session ends after the grace period.
+		select {
+		case <-returned:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Case %d failed: session is not ended on %s", i+1, c.trigger)
+		}
+		ws.Close()
+		ts.Close()
+		shutdown()
+		for deadline := time.Now().Add(5 * time.Second); runtime.NumGoroutine() > baseline; time.Sleep(10 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("Case %d failed: %d goroutines are left after %s", i+1, runtime.NumGoroutine()-baseline, c.trigger)
+			}
+		}
+	}
+}
+
+func TestEnterShellExits(t *testing.T) {
+	fake := &fakeDocker{
+		startExec: func(id string, opts docker.StartExecOptions) (docker.CloseWaiter, error) {
+			w := &fakeWaiter{done: make(chan struct{})}
+			go func() {
+				// The shell exits on its own, its input still open.
+				fmt.Fprint(opts.OutputStream, "$ exit 2")
+				close(w.done)
+			}()
+			return w, nil
+		},
+		inspectExec: func(id string) (*docker.ExecInspect, error) {
+			return &docker.ExecInspect{ID: id, ExitCode: 2}, nil
+		},
+	}
+	server := &EntryServer{dockerClient: fake, authorizer: &FakeAuthorizer{Allow: true}, resolver: StaticResolver{"hello/web/1": "c1"},
+		closeGrace: 100 * time.Millisecond}
+	ts := httptest.NewServer(http.HandlerFunc(server.enter))
+	defer ts.Close()
+
+	ws := dialSession(t, ts, "", nil)
+	defer ws.Close()
+	var err error
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := message.ResponseMessage{}
+		protoUnmarshalFunc(data, &msg)
+		if msg.MsgType == message.ResponseMessage_CLOSE {
+			if string(msg.Content) != byebyeMsg || msg.Reason != closeReasonExited || msg.ExitCode != 2 {
+				t.Errorf("CLOSE on exit is %q for %q with code %d", msg.Content, msg.Reason, msg.ExitCode)
+			}
+			break
+		}
+	}
+	// The client staying is closed after the grace period, and the session is over.
+	if _, _, err = ws.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Errorf("Connection is not closed normally: %v", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		server.sessions.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Session is not over after the shell exited")
+	}
+}
+
+func TestEnterQuit(t *testing.T) {
+	events := make(chan SessionEvent, 10)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event := SessionEvent{}
+		json.NewDecoder(r.Body).Decode(&event)
+		events <- event
+	}))
+	defer hook.Close()
+	fake := &fakeDocker{
+		startExec: func(id string, opts docker.StartExecOptions) (docker.CloseWaiter, error) {
+			w := &fakeWaiter{done: make(chan struct{})}
+			go func() {
+				fmt.Fprint(opts.OutputStream, "$ ")
+				io.Copy(ioutil.Discard, opts.InputStream)
+				close(w.done)
+			}()
+			return w, nil
+		},
+	}
+	server := &EntryServer{dockerClient: fake, authorizer: &FakeAuthorizer{Allow: true}, resolver: StaticResolver{"hello/web/1": "c1"},
+		webhook: newWebhookEmitter(hook.URL, eventSessionEnd)}
+	ts := httptest.NewServer(http.HandlerFunc(server.enter))
+	defer ts.Close()
+
+	ws := dialSession(t, ts, "", nil)
+	defer ws.Close()
+	var err error
+	data, _ := protoMarshalFunc(&message.RequestMessage{MsgType: message.RequestMessage_QUIT})
+	if err := ws.WriteMessage(websocket.BinaryMessage, data); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		if _, data, err = ws.ReadMessage(); err != nil {
+			t.Fatal(err)
+		}
+		msg := message.ResponseMessage{}
+		if err = protoUnmarshalFunc(data, &msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.MsgType == message.ResponseMessage_CLOSE {
+			if string(msg.Content) != byebyeMsg {
+				t.Errorf("CLOSE on quit is %q", msg.Content)
+			}
+			break
+		}
+	}
+	select {
+	case event := <-events:
+		if event.Reason != reasonClientQuit {
+			t.Errorf("Session ended for %q", event.Reason)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Session end is not posted")
+	}
+}
+
+func TestEnterDisconnectReason(t *testing.T) {
+	events := make(chan SessionEvent, 10)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event := SessionEvent{}
+		json.NewDecoder(r.Body).Decode(&event)
+		events <- event
+	}))
+	defer hook.Close()
+	fake := &fakeDocker{
+		startExec: func(id string, opts docker.StartExecOptions) (docker.CloseWaiter, error) {
+			w := &fakeWaiter{done: make(chan struct{})}
+			go func() {
+				io.Copy(ioutil.Discard, opts.InputStream)
+				close(w.done)
+			}()
+			return w, nil
+		},
+	}
+	server := &EntryServer{dockerClient: fake, authorizer: &FakeAuthorizer{Allow: true}, resolver: StaticResolver{"hello/web/1": "c1"},
+		webhook: newWebhookEmitter(hook.URL, eventSessionEnd)}
+	ts := httptest.NewServer(http.HandlerFunc(server.enter))
+	defer ts.Close()
+
+	for i, c := range []struct {
+		closeFrame bool
+		reason     string
+	}{
+		{true, reasonClientDisconnected},
+		{false, reasonConnectionLost},
+	} {
+		ws := dialSession(t, ts, "", nil)
+		if c.closeFrame {
+			ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""))
+		}
+		// Without a close frame, the connection is dropped.
+		ws.Close()
+		select {
+		case event := <-events:
+			if event.Reason != c.reason {
+				t.Errorf("Case %d failed: session ended for %q", i+1, event.Reason)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Case %d failed: session end is not posted", i+1)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/laincloud/entry/log"
+)
+
+const (
+	traceQueueSize = 256
+	// traceBatchSize is the most spans exported at once.
+	traceBatchSize = 64
+	// traceFlushInterval is the longest a span waits to be exported.
+	traceFlushInterval = 5 * time.Second
+	traceServiceName   = "entry"
+
+	// The span kinds and status codes of OTLP.
+	spanKindInternal = 1
+	spanKindServer   = 2
+	statusCodeError  = 2
+)
+
+// parseTraceparent parses a W3C traceparent header like
+// "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", and returns the trace and the
+// span of the caller, and whether the caller samples it.
+func parseTraceparent(value string) (traceID [16]byte, spanID [8]byte, sampled bool, ok bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return traceID, spanID, false, false
+	}
+	// Later versions may add parts, version 00 has none.
+	if parts[0] == "00" && len(parts) != 4 {
+		return traceID, spanID, false, false
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		return traceID, spanID, false, false
+	}
+	if _, err = hex.Decode(traceID[:], []byte(parts[1])); err != nil || traceID == [16]byte{} {
+		return traceID, spanID, false, false
+	}
+	if _, err = hex.Decode(spanID[:], []byte(parts[2])); err != nil || spanID == [8]byte{} {
+		return traceID, spanID, false, false
+	}
+	return traceID, spanID, flags&1 == 1, true
+}
+
+// tracer exports the spans of sessions to an OTLP/HTTP endpoint in JSON, in background
+// batches. Spans are dropped when the queue is full, so that a slow collector never blocks
+// sessions. A nil *tracer traces nothing and costs nothing.
+type tracer struct {
+	endpoint   string
+	httpClient *http.Client
+	queue      chan otlpSpan
+	flush      time.Duration
+}
+
+// newTracer creates and runs a tracer exporting to endpoint, like
+// "http://collector:4318/v1/traces".
+func newTracer(endpoint string) *tracer {
+	t := &tracer{
+		endpoint:   endpoint,
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+		queue:      make(chan otlpSpan, traceQueueSize),
+		flush:      traceFlushInterval,
+	}
+	go t.run()
+	return t
+}
+
+// span is a span of the trace of a session. A nil *span records nothing.
+type span struct {
+	tracer   *tracer
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	kind     int
+	start    time.Time
+	attrs    []otlpAttribute
+	err      string
+}
+
+// start starts the root span of a session, in the trace of the caller if the request has a
+// traceparent header. The sessions of callers not sampling their trace are not traced.
+func (t *tracer) start(name string, header http.Header) *span {
+	if t == nil {
+		return nil
+	}
+	s := &span{tracer: t, name: name, kind: spanKindServer, start: time.Now()}
+	if traceparent := header.Get("traceparent"); traceparent != "" {
+		traceID, parentID, sampled, ok := parseTraceparent(traceparent)
+		if ok && !sampled {
+			return nil
+		}
+		if ok {
+			s.traceID, s.parentID = traceID, parentID
+		}
+	}
+	if s.traceID == [16]byte{} {
+		rand.Read(s.traceID[:])
+	}
+	rand.Read(s.spanID[:])
+	return s
+}
+
+// child starts a span of a step of s.
+func (s *span) child(name string) *span {
+	if s == nil {
+		return nil
+	}
+	c := &span{tracer: s.tracer, traceID: s.traceID, parentID: s.spanID, name: name, kind: spanKindInternal, start: time.Now()}
+	rand.Read(c.spanID[:])
+	return c
+}
+
+// set sets the attribute key of s to value, empty values are left out.
+func (s *span) set(key, value string) {
+	if s == nil || value == "" {
+		return
+	}
+	s.attrs = append(s.attrs, otlpAttribute{Key: key, Value: otlpValue{StringValue: value}})
+}
+
+// fail marks s failed by err, if err is not nil.
+func (s *span) fail(err error) {
+	if s != nil && err != nil {
+		s.err = err.Error()
+	}
+}
+
+// end ends s and queues it to be exported.
+func (s *span) end() {
+	if s == nil {
+		return
+	}
+	exported := otlpSpan{
+		TraceID:           hex.EncodeToString(s.traceID[:]),
+		SpanID:            hex.EncodeToString(s.spanID[:]),
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(time.Now().UnixNano(), 10),
+		Attributes:        s.attrs,
+	}
+	if s.parentID != [8]byte{} {
+		exported.ParentSpanID = hex.EncodeToString(s.parentID[:])
+	}
+	if s.err != "" {
+		exported.Status = &otlpStatus{Code: statusCodeError, Message: s.err}
+	}
+	select {
+	case s.tracer.queue <- exported:
+	default:
+		log.Warnf("Trace queue is full, span %s is dropped", s.name)
+	}
+}
+
+// endSession ends the root span of the session of info with its outcome.
+func (s *span) endSession(info sessionInfo, outcome string) {
+	if s == nil {
+		return
+	}
+	s.set("entry.app", info.appName)
+	s.set("entry.proc", info.procName)
+	s.set("entry.instance", info.instanceNo)
+	s.set("entry.container", info.containerID)
+	s.set("entry.user", info.user)
+	s.set("entry.outcome", outcome)
+	if outcome != outcomeNormal {
+		s.err = outcome
+	}
+	s.end()
+}
+
+func (t *tracer) run() {
+	ticker := time.NewTicker(t.flush)
+	defer ticker.Stop()
+	var batch []otlpSpan
+	for {
+		select {
+		case s := <-t.queue:
+			if batch = append(batch, s); len(batch) < traceBatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		if err := t.export(batch); err != nil {
+			log.Errorf("Export %d spans failed: %s", len(batch), err.Error())
+		}
+		batch = nil
+	}
+}
+
+func (t *tracer) export(spans []otlpSpan) error {
+	data, err := json.Marshal(otlpTraces{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpAttribute{{Key: "service.name", Value: otlpValue{StringValue: traceServiceName}}}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: traceServiceName},
+			Spans: spans,
+		}},
+	}}})
+	if err != nil {
+		return err
+	}
+	resp, err := t.httpClient.Post(t.endpoint, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("trace endpoint responded %s", resp.Status)
+	}
+	return nil
+}
+
+// The JSON encoding of the OTLP trace export request, of the fields entry sets.
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            *otlpStatus     `json:"status,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	cases := []struct {
+		value   string
+		traceID string
+		spanID  string
+		sampled bool
+		ok      bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", false, true},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future", "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future", "", "", false, false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "", "", false, false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", "", "", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", "", "", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e47zz-00f067aa0ba902b7-01", "", "", false, false},
+		{"garbage", "", "", false, false},
+	}
+	for i, c := range cases {
+		traceID, spanID, sampled, ok := parseTraceparent(c.value)
+		if ok != c.ok || sampled != c.sampled || (ok && (hex.EncodeToString(traceID[:]) != c.traceID || hex.EncodeToString(spanID[:]) != c.spanID)) {
+			t.Errorf("Case %d failed: actual is %x %x %v %v", i+1, traceID, spanID, sampled, ok)
+		}
+	}
+}
+
+func TestTraceSession(t *testing.T) {
+	exported := make(chan otlpTraces, 10)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traces := otlpTraces{}
+		json.NewDecoder(r.Body).Decode(&traces)
+		exported <- traces
+	}))
+	defer collector.Close()
+	tr := &tracer{endpoint: collector.URL, httpClient: http.DefaultClient, queue: make(chan otlpSpan, traceQueueSize), flush: 10 * time.Millisecond}
+	go tr.run()
+	server := &EntryServer{dockerClient: &fakeDocker{}, authorizer: &FakeAuthorizer{Allow: true, Role: "developer"},
+		resolver: StaticResolver{"hello/web/1": "c1"}, tracer: tr}
+	ts := httptest.NewServer(http.HandlerFunc(server.enter))
+	defer ts.Close()
+
+	dial := func(traceparent string) {
+		header := http.Header{}
+		header.Set("traceparent", traceparent)
+		ws := dialSession(t, ts, "", header)
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				break
+			}
+		}
+		ws.Close()
+		server.sessions.Wait()
+	}
+	// The sessions of callers not sampling their trace are not traced.
+	dial("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	dial("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	spans := make(map[string]otlpSpan)
+	timeout := time.After(5 * time.Second)
+	for len(spans) < 4 {
+		select {
+		case traces := <-exported:
+			for _, s := range traces.ResourceSpans[0].ScopeSpans[0].Spans {
+				if _, ok := spans[s.Name]; ok {
+					t.Fatalf("Span %s is exported twice", s.Name)
+				}
+				spans[s.Name] = s
+			}
+		case <-timeout:
+			t.Fatalf("Spans are not exported: %v", spans)
+		}
+	}
+	root := spans["entry.enter"]
+	if root.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || root.ParentSpanID != "00f067aa0ba902b7" || root.Kind != spanKindServer || root.Status != nil {
+		t.Errorf("Root span is %+v", root)
+	}
+	attrs := make(map[string]string)
+	for _, attr := range root.Attributes {
+		attrs[attr.Key] = attr.Value.StringValue
+	}
+	if attrs["entry.app"] != "hello" || attrs["entry.container"] != "c1" || attrs["entry.outcome"] != outcomeNormal {
+		t.Errorf("Root span attributes are %v", attrs)
+	}
+	for i, name := range []string{"auth", "resolve", "exec"} {
+		if s := spans[name]; s.TraceID != root.TraceID || s.ParentSpanID != root.SpanID || s.Kind != spanKindInternal {
+			t.Errorf("Case %d failed: span %s is %+v", i+1, name, s)
+		}
+	}
+}
+
+func TestNilTracer(t *testing.T) {
+	var tr *tracer
+	s := tr.start("entry.enter", http.Header{})
+	c := s.child("auth")
+	c.set("entry.role", "developer")
+	c.end()
+	s.endSession(sessionInfo{}, outcomeNormal)
+	if s != nil || c != nil {
+		t.Error("A nil tracer must trace nothing")
+	}
+}
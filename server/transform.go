@@ -0,0 +1,143 @@
+package server
+
+import (
+	"io"
+	"regexp"
+	"regexp/syntax"
+)
+
+// redactedMask replaces what's redacted from the output.
+const redactedMask = "********"
+
+// OutputTransform rewrites a chunk of the output of a session before it's sent to the
+// client. Chunks never end in the middle of a UTF8 sequence, and the transform must keep
+// it so. A match split over two chunks is seen in pieces, secrets are redacted before by
+// RedactPattern, see redactReader.
+type OutputTransform func(chunk []byte) []byte
+
+// redactor masks the matches of a pattern in the output of sessions.
+type redactor struct {
+	pattern *regexp.Regexp
+	// partial matches the end of the output from where a match of pattern may start, the
+	// empty end if none may.
+	partial *regexp.Regexp
+}
+
+// newRedactor returns the redactor of the matches of pattern.
+func newRedactor(pattern string) (*redactor, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil, err
+	}
+	partial, err := regexp.Compile(`(?:` + prefixes(parsed.Simplify()).String() + `)\z`)
+	if err != nil {
+		return nil, err
+	}
+	return &redactor{pattern: re, partial: partial}, nil
+}
+
+// prefixes returns a regexp matching the prefixes of what re matches, the empty one
+// included. The assertions in re are dropped from the last part, it matches more then,
+// which only holds more output.
+func prefixes(re *syntax.Regexp) *syntax.Regexp {
+	switch re.Op {
+	case syntax.OpLiteral:
+		// The prefixes of abc are (?:a(?:bc?)?)?.
+		prefix := &syntax.Regexp{Op: syntax.OpEmptyMatch}
+		for i := len(re.Rune) - 1; i >= 0; i-- {
+			char := &syntax.Regexp{Op: syntax.OpLiteral, Flags: re.Flags, Rune: re.Rune[i : i+1]}
+			prefix = newSyntax(syntax.OpQuest, newSyntax(syntax.OpConcat, char, prefix))
+		}
+		return prefix
+	case syntax.OpCharClass, syntax.OpAnyCharNotNL, syntax.OpAnyChar:
+		return newSyntax(syntax.OpQuest, re)
+	case syntax.OpCapture, syntax.OpQuest:
+		return prefixes(re.Sub[0])
+	case syntax.OpStar, syntax.OpPlus, syntax.OpRepeat:
+		return newSyntax(syntax.OpConcat, newSyntax(syntax.OpStar, re.Sub[0]), prefixes(re.Sub[0]))
+	case syntax.OpConcat:
+		// The prefixes of xyz are those of x, x and those of y, or xy and those of z.
+		alternatives := make([]*syntax.Regexp, len(re.Sub))
+		for i, sub := range re.Sub {
+			concat := append(append([]*syntax.Regexp(nil), re.Sub[:i]...), prefixes(sub))
+			alternatives[i] = newSyntax(syntax.OpConcat, concat...)
+		}
+		return newSyntax(syntax.OpAlternate, alternatives...)
+	case syntax.OpAlternate:
+		alternatives := make([]*syntax.Regexp, len(re.Sub))
+		for i, sub := range re.Sub {
+			alternatives[i] = prefixes(sub)
+		}
+		return newSyntax(syntax.OpAlternate, alternatives...)
+	case syntax.OpNoMatch:
+		return re
+	}
+	return &syntax.Regexp{Op: syntax.OpEmptyMatch}
+}
+
+func newSyntax(op syntax.Op, subs ...*syntax.Regexp) *syntax.Regexp {
+	return &syntax.Regexp{Op: op, Sub: subs}
+}
+
+// redact returns data up to end with the matches of the pattern masked, and where it ended:
+// a match across end moves it back to the start of the match, to be held with the rest.
+func (r *redactor) redact(data []byte, end int) ([]byte, int) {
+	var redacted []byte
+	last := 0
+	for _, match := range r.pattern.FindAllIndex(data, -1) {
+		if match[1] > end {
+			if match[0] < end {
+				end = match[0]
+			}
+			break
+		}
+		redacted = append(redacted, data[last:match[0]]...)
+		redacted = append(redacted, redactedMask...)
+		last = match[1]
+	}
+	return append(redacted, data[last:end]...), end
+}
+
+// redactReader masks the matches of a redactor in the output read from r. Output flows in
+// bursts of reads, and a secret may be split over two of them: the end of a read from
+// where a match may start is held, until the reads after break or complete the match, or
+// r ends. Nothing is released while it may still be a secret, a prompt which may start one
+// waits for the next output.
+type redactReader struct {
+	io.ReadCloser
+	redactor *redactor
+	buf      []byte
+	// err ends the reads once nothing is held or ready.
+	err error
+	// held is what may still be part of a secret, ready is redacted already.
+	held  []byte
+	ready []byte
+}
+
+func newRedactReader(r io.ReadCloser, redactor *redactor) *redactReader {
+	return &redactReader{ReadCloser: r, redactor: redactor, buf: make([]byte, writeBufferSize)}
+}
+
+func (rr *redactReader) Read(p []byte) (int, error) {
+	for len(rr.ready) == 0 {
+		if rr.err != nil {
+			return 0, rr.err
+		}
+		var n int
+		n, rr.err = rr.ReadCloser.Read(rr.buf)
+		data := append(rr.held, rr.buf[:n]...)
+		end := len(data)
+		if rr.err == nil {
+			end = rr.redactor.partial.FindIndex(data)[0]
+		}
+		rr.ready, end = rr.redactor.redact(data, end)
+		rr.held = append([]byte(nil), data[end:]...)
+	}
+	n := copy(p, rr.ready)
+	rr.ready = rr.ready[n:]
+	return n, nil
+}
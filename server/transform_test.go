@@ -0,0 +1,163 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/laincloud/entry/message"
+)
+
+// chunkReader returns one of its chunks by read, then io.EOF.
+type chunkReader []string
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if len(*r) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, (*r)[0])
+	*r = (*r)[1:]
+	return n, nil
+}
+
+func (r *chunkReader) Close() error { return nil }
+
+func TestRedactReader(t *testing.T) {
+	redactor, err := newRedactor(`(token|password)=\S+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	long := strings.Repeat("x", 300)
+	cases := []struct {
+		chunks   []string
+		expected []string
+	}{
+		{[]string{"nothing secret\n"}, []string{"nothing secret\n"}},
+		{[]string{"nothing secret"}, []string{"nothing secre", "t"}},
+		{[]string{"token=abc123 and password=世界\n"}, []string{"******** and ********\n"}},
+		{[]string{""}, nil},
+		// A secret split over reads is masked whole.
+		{[]string{"tok", "en=abc123\n"}, []string{"********\n"}},
+		{[]string{"token=abc", "123\n"}, []string{"********\n"}},
+		{[]string{long + "token=ab", "c123 " + long}, []string{long, "******** " + long}},
+		// Only what may start a secret is held, until the output breaks it or ends.
+		{[]string{"$ ", "pass", "word=abc", "\n$ "}, []string{"$ ", "********\n$ "}},
+		{[]string{"$ tok", "yo\n"}, []string{"$ ", "tokyo\n"}},
+		{[]string{"to", "ken", "=abc"}, []string{"********"}},
+	}
+	for i, c := range cases {
+		chunks := chunkReader(c.chunks)
+		r := newRedactReader(&chunks, redactor)
+		var actual []string
+		buf := make([]byte, 1024)
+		for {
+			n, err := r.Read(buf)
+			if err != nil {
+				if err != io.EOF {
+					t.Errorf("Case %d failed: %s", i+1, err.Error())
+				}
+				break
+			}
+			actual = append(actual, string(buf[:n]))
+		}
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Errorf("Case %d failed: %q", i+1, actual)
+		}
+	}
+}
+
+func TestRedactorPrefixes(t *testing.T) {
+	cases := []struct {
+		pattern  string
+		output   string
+		expected int
+	}{
+		{`token=\S+`, "nothing", 7},
+		{`token=\S+`, "a tok", 2},
+		{`token=\S+`, "a token=abc", 2},
+		{`token=\S+`, "a token=abc def", 15},
+		{`(?i)secret:\s*\d{4}`, "a SeCr", 2},
+		{`(?i)secret:\s*\d{4}`, "secret: 12", 0},
+		{`(?i)secret:\s*\d{4}`, "secret: 1234", 0},
+		{`^key-[a-f0-9]+$`, "key-1f", 0},
+		{`a(b|cd)e`, "xac", 1},
+		{`a(b|cd)e`, "xace", 4},
+	}
+	for i, c := range cases {
+		redactor, err := newRedactor(c.pattern)
+		if err != nil {
+			t.Errorf("Case %d failed: %s", i+1, err.Error())
+			continue
+		}
+		if actual := redactor.partial.FindIndex([]byte(c.output))[0]; actual != c.expected {
+			t.Errorf("Case %d failed: %d", i+1, actual)
+		}
+	}
+}
+
+func TestNewEntryServerRedactPattern(t *testing.T) {
+	// The config isn't validated by code building it.
+	if _, err := newEntryServer(Config{RedactPattern: "token=("}, &fakeDocker{}); err == nil {
+		t.Error("Invalid redact pattern is accepted")
+	}
+}
+
+func TestEnterRedactsOutput(t *testing.T) {
+	fake := &fakeDocker{
+		startExec: func(id string, opts docker.StartExecOptions) (docker.CloseWaiter, error) {
+			w := &fakeWaiter{done: make(chan struct{})}
+			go func() {
+				out := []byte("token=abc123 世界\n")
+				// The secret is split, and then 世, which must reach the client in one piece.
+				opts.OutputStream.Write(out[:8])
+				time.Sleep(5 * time.Millisecond)
+				opts.OutputStream.Write(out[8:14])
+				time.Sleep(10 * time.Millisecond)
+				opts.OutputStream.Write(out[14:])
+				close(w.done)
+			}()
+			return w, nil
+		},
+	}
+	redactor, err := newRedactor(`token=\S+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &EntryServer{dockerClient: fake, authorizer: &FakeAuthorizer{Allow: true}, resolver: StaticResolver{"hello/web/1": "c1"},
+		redact: redactor}
+	ts := httptest.NewServer(http.HandlerFunc(server.enter))
+	defer ts.Close()
+
+	ws := dialSession(t, ts, "", nil)
+	defer ws.Close()
+	var output string
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := message.ResponseMessage{}
+		if err = protoUnmarshalFunc(data, &msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.MsgType == message.ResponseMessage_CLOSE {
+			break
+		}
+		if !utf8.Valid(msg.Content) {
+			t.Errorf("Output %q is cut in a UTF8 sequence", msg.Content)
+		}
+		if strings.Contains(string(msg.Content), "abc") || strings.Contains(string(msg.Content), "123") {
+			t.Errorf("Output %q shows the secret", msg.Content)
+		}
+		output += string(msg.Content)
+	}
+	if output != "******** 世界\n" {
+		t.Errorf("Output is %q", output)
+	}
+}
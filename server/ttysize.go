@@ -0,0 +1,70 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/laincloud/entry/log"
+)
+
+// containerTTYSize returns the size of the tty of a container started with one. Docker
+// doesn't tell it by inspect, so it's asked to the terminal of the main process by
+// `stty size`, which answers "<rows> <cols>".
+func (server *EntryServer) containerTTYSize(containerID string) (termSize, bool, error) {
+	container, err := server.dockerClient.InspectContainer(containerID)
+	if err != nil {
+		return termSize{}, false, err
+	}
+	if container.Config == nil || !container.Config.Tty {
+		return termSize{}, false, nil
+	}
+	exec, err := server.dockerClient.CreateExec(docker.CreateExecOptions{
+		Container:    containerID,
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd:          []string{"sh", "-c", "stty size < /proc/1/fd/0"},
+	})
+	if err != nil {
+		return termSize{}, false, err
+	}
+	var stdout bytes.Buffer
+	if err = server.dockerClient.StartExec(exec.ID, docker.StartExecOptions{
+		OutputStream: &stdout,
+		ErrorStream:  ioutil.Discard,
+	}); err != nil {
+		return termSize{}, false, err
+	}
+	return parseSttySize(stdout.String())
+}
+
+func parseSttySize(output string) (termSize, bool, error) {
+	fields := strings.Fields(output)
+	if len(fields) != 2 {
+		return termSize{}, false, fmt.Errorf("unexpected stty size %q", output)
+	}
+	rows, rowsErr := strconv.Atoi(fields[0])
+	cols, colsErr := strconv.Atoi(fields[1])
+	if rowsErr != nil || colsErr != nil || rows <= 0 || cols <= 0 || rows > maxTermDimension || cols > maxTermDimension {
+		return termSize{}, false, fmt.Errorf("unexpected stty size %q", output)
+	}
+	return termSize{Width: cols, Height: rows}, true, nil
+}
+
+// suggestTTYSize tells the client attached to containerID the size of its tty, so that the
+// client can align its terminal and the full screen programs there render right.
+func (server *EntryServer) suggestTTYSize(ctx context.Context, ws *safeConn, containerID string, msgMarshaller Marshaler) {
+	size, ok, err := server.containerTTYSize(containerID)
+	if err != nil {
+		log.Warnf("Get tty size of container %s failed: %s", containerID, err.Error())
+		return
+	}
+	if !ok || ctx.Err() != nil {
+		return
+	}
+	server.sendNoticeMessage(ws, fmt.Sprintf("The terminal of container %s is %dx%d, resize yours to match.", containerID, size.Width, size.Height), msgMarshaller)
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gorilla/websocket"
+	"github.com/laincloud/entry/message"
+)
+
+func TestParseSttySize(t *testing.T) {
+	cases := []struct {
+		output   string
+		expected termSize
+		ok       bool
+	}{
+		{"24 80\n", termSize{Width: 80, Height: 24}, true},
+		{" 40 132 ", termSize{Width: 132, Height: 40}, true},
+		{"0 0\n", termSize{}, false},
+		{"stty: standard input: Not a tty\n", termSize{}, false},
+		{"24\n", termSize{}, false},
+		{"24 99999\n", termSize{}, false},
+	}
+	for i, c := range cases {
+		if actual, ok, err := parseSttySize(c.output); actual != c.expected || ok != c.ok || (err == nil) != c.ok {
+			t.Errorf("Case %d failed: actual is %+v, %t, %v", i+1, actual, ok, err)
+		}
+	}
+}
+
+func TestAttachTTYSize(t *testing.T) {
+	for i, c := range []struct {
+		token   string
+		tty     bool
+		notices []string
+		resized string
+	}{
+		{"admin", true, []string{"The terminal of container c1 is 132x40"}, "c1 100x50"},
+		{"dev", true, []string{"Your role may not resize", "The terminal of container c1 is 132x40"}, ""},
+		// A container without tty is not resized.
+		{"admin", false, []string{"Container c1 has no terminal to resize"}, ""},
+	} {
+		resized := make(chan string, 1)
+		fake := &fakeDocker{
+			inspectContainer: func(id string) (*docker.Container, error) {
+				return &docker.Container{ID: id, Config: &docker.Config{Tty: c.tty}, State: docker.State{Running: true}}, nil
+			},
+			startExec: func(id string, opts docker.StartExecOptions) (docker.CloseWaiter, error) {
+				fmt.Fprint(opts.OutputStream, "40 132\n")
+				return newFakeWaiter(nil), nil
+			},
+			attach: func(opts docker.AttachToContainerOptions) (docker.CloseWaiter, error) {
+				return &fakeWaiter{done: make(chan struct{})}, nil
+			},
+			resizeContainer: func(id string, height, width int) error {
+				resized <- fmt.Sprintf("%s %dx%d", id, width, height)
+				return nil
+			},
+		}
+		server := &EntryServer{dockerClient: fake, authorizer: &FakeAuthorizer{Tokens: map[string]string{"admin": "admin", "dev": "developer"}},
+			resolver: StaticResolver{"hello/web/1": "c1"}}
+		ts := httptest.NewServer(http.HandlerFunc(server.attach))
+
+		header := http.Header{}
+		header.Set("access-token", c.token)
+		ws := dialSession(t, ts, "?resize=true", header)
+		data, _ := protoMarshalFunc(&message.RequestMessage{MsgType: message.RequestMessage_WINCH, Content: []byte("100 50")})
+		ws.WriteMessage(websocket.BinaryMessage, data)
+		var notices []string
+		ws.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		for {
+			_, data, err := ws.ReadMessage()
+			if err != nil {
+				break
+			}
+			msg := message.ResponseMessage{}
+			if err = protoUnmarshalFunc(data, &msg); err == nil && msg.MsgType == message.ResponseMessage_NOTICE {
+				notices = append(notices, string(msg.Content))
+			}
+		}
+		if len(notices) != len(c.notices) {
+			t.Errorf("Case %d failed: notices are %q", i+1, notices)
+		}
+		for j := range c.notices {
+			if j < len(notices) && !strings.Contains(notices[j], c.notices[j]) {
+				t.Errorf("Case %d failed: notices are %q", i+1, notices)
+			}
+		}
+		select {
+		case actual := <-resized:
+			if actual != c.resized {
+				t.Errorf("Case %d failed: resized %s", i+1, actual)
+			}
+		default:
+			if c.resized != "" {
+				t.Errorf("Case %d failed: not resized", i+1)
+			}
+		}
+		ws.Close()
+		ts.Close()
+	}
+}
@@ -0,0 +1,227 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/laincloud/entry/log"
+)
+
+const (
+	eventSessionStart = "session_start"
+	eventSessionEnd   = "session_end"
+	eventAuthFailure  = "auth_failure"
+
+	// The reasons of session_end when the client ends the session.
+	reasonClientQuit         = "client quit"
+	reasonClientDisconnected = "client disconnected"
+	// reasonConnectionLost is the connection to the client failing, not closed by it.
+	reasonConnectionLost = "connection lost"
+	// reasonClosedByUser is the user closing the session from elsewhere, see sessionRegistry.
+	reasonClosedByUser = "closed by user"
+	// The reasons of session_end when a detached session ends without its client.
+	reasonDetachTimeout  = "detach timed out"
+	reasonExitedDetached = "exited while detached"
+	// reasonDetached is the client detaching from an attach session.
+	reasonDetached = "detached"
+	// reasonOutputLimit ends the sessions with too much output, see EntryServer.outputLimit.
+	reasonOutputLimit = "output limit reached"
+
+	webhookQueueSize = 256
+	webhookRetries   = 3
+	webhookBackoff   = time.Second
+)
+
+// SessionEvent is the JSON body posted to the webhook.
+type SessionEvent struct {
+	Type        string    `json:"type"`
+	Time        time.Time `json:"time"`
+	Kind        string    `json:"kind"`
+	App         string    `json:"app"`
+	Proc        string    `json:"proc"`
+	Instance    string    `json:"instance"`
+	ContainerID string    `json:"container_id,omitempty"`
+	User        string    `json:"user"`
+	Reason      string    `json:"reason,omitempty"`
+	// BreakGlass flags the events of sessions authorized by the break-glass token.
+	BreakGlass bool `json:"break_glass,omitempty"`
+	// Container is the container asked by name or ID prefix, and RemoteAddr the address of
+	// the client, in auth_failure events.
+	Container  string `json:"container,omitempty"`
+	RemoteAddr string `json:"remote_addr,omitempty"`
+	// Node is the host of the server of the session.
+	Node string `json:"node,omitempty"`
+}
+
+// sessionInfo describes who enters which container in a session.
+type sessionInfo struct {
+	kind        string
+	appName     string
+	procName    string
+	instanceNo  string
+	containerID string
+	// image is the image of the container, known once it's inspected.
+	image string
+	// user identifies the client by the fingerprint of its token, so the same user can be
+	// followed across sessions without the token being revealed.
+	user string
+	role string
+	// authorized is set once the client is authorized on appName.
+	authorized bool
+	// readonlyRootfs is set when the container has a read-only root filesystem.
+	readonlyRootfs bool
+	// tty is set when the container is started with a tty.
+	tty bool
+	// sessionKey is given by the client to make its retries idempotent, see sessionKeys.
+	sessionKey string
+	// exec is the exec asked by the client instead of the default shell, if any.
+	exec *ExecSpec
+	// logger logs the messages of the session with its application, proc, instance and
+	// container, see newLogger.
+	logger *log.Logger
+	// breakGlass is set when the client is authorized by the break-glass token.
+	breakGlass bool
+	// viaWeb is set for the web clients, whose messages are JSON.
+	viaWeb bool
+	// reattach is the ID of the detached session the client reattaches to, if any.
+	reattach string
+	// resumeOffset is the output the client reattaching received already, -1 if it asks no
+	// replay, see outputRing.
+	resumeOffset int64
+	// infra is set when the session is in the infra container of the pod, see enterInfra.
+	infra bool
+	// tokenGranted is set when the container is granted by a container token, the session
+	// is confined to it then, see containerTokens.
+	tokenGranted bool
+	// span is the root span of the trace of the session, nil if it's not traced.
+	span *span
+	// marshaler and protocol are how the messages of the session are framed, see
+	// getMarshalers and sessionProtocol, to tell the clients apart in logs and metrics.
+	marshaler string
+	protocol  string
+	// node is the host of the server, see EntryServer.node.
+	node string
+}
+
+// newLogger returns the logger of the session as it's known so far.
+func (info sessionInfo) newLogger() *log.Logger {
+	containerID := info.containerID
+	if len(containerID) > 12 {
+		containerID = containerID[:12]
+	}
+	logger := log.With("kind", info.kind, "app", info.appName, "proc", info.procName, "instance", info.instanceNo, "container", containerID,
+		"marshaler", info.marshaler, "protocol", info.protocol)
+	if info.breakGlass {
+		logger = logger.With("break_glass", "true")
+	}
+	if info.node != "" {
+		logger = logger.With("node", info.node)
+	}
+	return logger
+}
+
+func tokenFingerprint(token string) string {
+	if token == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:6])
+}
+
+func (info sessionInfo) event(eventType, reason string) SessionEvent {
+	return SessionEvent{
+		Type:        eventType,
+		Time:        time.Now(),
+		Kind:        info.kind,
+		App:         info.appName,
+		Proc:        info.procName,
+		Instance:    info.instanceNo,
+		ContainerID: info.containerID,
+		User:        info.user,
+		Reason:      reason,
+		BreakGlass:  info.breakGlass,
+		Node:        info.node,
+	}
+}
+
+// webhookEmitter posts session events to a webhook in background. Events are queued and
+// dropped when the queue is full, so that a slow webhook never blocks sessions.
+// A nil *webhookEmitter emits nothing.
+type webhookEmitter struct {
+	url        string
+	events     map[string]bool
+	httpClient *http.Client
+	queue      chan SessionEvent
+	backoff    time.Duration
+}
+
+// newWebhookEmitter creates and runs a webhookEmitter posting to url the events listed in
+// comma separated events, all events are sent if events is empty.
+func newWebhookEmitter(url, events string) *webhookEmitter {
+	e := &webhookEmitter{
+		url:        url,
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+		queue:      make(chan SessionEvent, webhookQueueSize),
+		backoff:    webhookBackoff,
+	}
+	if events = strings.TrimSpace(events); events != "" {
+		e.events = make(map[string]bool)
+		for _, event := range strings.Split(events, ",") {
+			e.events[strings.TrimSpace(event)] = true
+		}
+	}
+	go e.run()
+	return e
+}
+
+// emit queues event, the events of break-glass sessions are never filtered out.
+func (e *webhookEmitter) emit(event SessionEvent) {
+	if e == nil || (e.events != nil && !e.events[event.Type] && !event.BreakGlass) {
+		return
+	}
+	select {
+	case e.queue <- event:
+	default:
+		log.Warnf("Webhook queue is full, %s event of %s is dropped", event.Type, event.App)
+	}
+}
+
+func (e *webhookEmitter) run() {
+	for event := range e.queue {
+		backoff := e.backoff
+		for attempt := 0; ; attempt++ {
+			err := e.post(event)
+			if err == nil {
+				break
+			}
+			if attempt >= webhookRetries {
+				log.Errorf("Post %s event to webhook failed: %s", event.Type, err.Error())
+				break
+			}
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+}
+
+func (e *webhookEmitter) post(event SessionEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	resp, err := e.httpClient.Post(e.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return nil
+}
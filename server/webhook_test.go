@@ -0,0 +1,108 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/laincloud/entry/message"
+	swebLog "github.com/mijia/sweb/log"
+)
+
+func TestWebhookEmitter(t *testing.T) {
+	received := make(chan SessionEvent, 10)
+	failures := 1
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		event := SessionEvent{}
+		json.NewDecoder(r.Body).Decode(&event)
+		received <- event
+	}))
+	defer ts.Close()
+
+	e := newWebhookEmitter(ts.URL, "session_start, auth_failure")
+	e.backoff = time.Millisecond
+	info := sessionInfo{kind: "enter", appName: "hello", containerID: "c1", user: tokenFingerprint("token")}
+	e.emit(info.event(eventSessionEnd, "exited"))
+	e.emit(info.event(eventSessionStart, ""))
+
+	select {
+	case event := <-received:
+		if event.Type != eventSessionStart || event.App != "hello" || event.ContainerID != "c1" || event.User != info.user {
+			t.Errorf("Unexpected event: %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Event is not posted")
+	}
+	select {
+	case event := <-received:
+		t.Errorf("Filtered event is posted: %+v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	var nilEmitter *webhookEmitter
+	nilEmitter.emit(info.event(eventSessionStart, ""))
+}
+
+func TestTokenFingerprint(t *testing.T) {
+	if tokenFingerprint("") != "" {
+		t.Errorf("Case 1 failed: empty token has a fingerprint")
+	}
+	if actual := tokenFingerprint("token"); len(actual) != 12 || actual == tokenFingerprint("other") {
+		t.Errorf("Case 2 failed: actual is %q", actual)
+	}
+}
+
+func TestEnterNode(t *testing.T) {
+	logged := &lockedBuffer{}
+	logger := swebLog.Logger()
+	defer logger.SetOutput(logger.Writer())
+	logger.SetOutput(logged)
+	events := make(chan SessionEvent, 10)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event := SessionEvent{}
+		json.NewDecoder(r.Body).Decode(&event)
+		events <- event
+	}))
+	defer hook.Close()
+
+	for i, c := range []struct {
+		banner bool
+		first  message.ResponseMessage_ResponseType
+	}{
+		{true, message.ResponseMessage_NOTICE},
+		{false, message.ResponseMessage_CLOSE},
+	} {
+		server := &EntryServer{dockerClient: &fakeDocker{}, authorizer: &FakeAuthorizer{Allow: true}, resolver: StaticResolver{"hello/web/1": "c1"},
+			webhook: newWebhookEmitter(hook.URL, eventSessionStart), node: "node-7", nodeBanner: c.banner}
+		ts := httptest.NewServer(http.HandlerFunc(server.enter))
+		ws := dialSession(t, ts, "", nil)
+		_, data, err := ws.ReadMessage()
+		msg := &message.ResponseMessage{}
+		protoUnmarshalFunc(data, msg)
+		if err != nil || msg.MsgType != c.first || (c.banner && !strings.Contains(string(msg.Content), "This session is served by node node-7.")) {
+			t.Errorf("Case %d failed: %v %q, %v", i+1, msg.MsgType, msg.Content, err)
+		}
+		ws.Close()
+		server.sessions.Wait()
+		ts.Close()
+		select {
+		case event := <-events:
+			if event.Node != "node-7" {
+				t.Errorf("Case %d failed: node of the event is %q", i+1, event.Node)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Case %d failed: event is not posted", i+1)
+		}
+	}
+	if !strings.Contains(logged.String(), "protocol=plain node=node-7]") {
+		t.Errorf("Node is not logged:\n%s", logged.String())
+	}
+}